@@ -4,8 +4,21 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/rokzabukovec/clip/internal/health"
+	"github.com/rokzabukovec/clip/internal/hooks"
+	"github.com/rokzabukovec/clip/internal/peer"
+	"github.com/rokzabukovec/clip/internal/webhook"
+)
+
+// Transports that can carry probes and membership rumors between peers
+const (
+	TransportHTTP = "http"
+	TransportUDP  = "udp"
+	TransportBoth = "both"
 )
 
 // Config holds all configuration for the service
@@ -16,6 +29,54 @@ type Config struct {
 	AdvertiseAddr string
 	Port          int
 
+	// ClusterName keeps separate clusters on the same network apart. Peers
+	// only accept broadcasts, joins, probes and gossip from nodes with the
+	// same name; the empty name is a cluster of its own.
+	ClusterName string
+
+	// Tags are key/value pairs this node advertises to the cluster, such as
+	// role=worker or zone=rack3. They can be changed at runtime.
+	Tags map[string]string
+
+	// Services are the applications this node runs and advertises to the
+	// cluster. More can be registered at runtime.
+	Services []peer.Service
+
+	// Checks are health checks run against the local services. Instances
+	// whose check fails are left out of service queries. Checks run every
+	// CheckInterval with a CheckTimeout unless they set their own.
+	Checks        []health.Check
+	CheckInterval time.Duration
+	CheckTimeout  time.Duration
+	// EnableScriptChecks allows exec checks to be registered through the
+	// API. Exec checks from the configuration always run; from the API
+	// they would let anyone who reaches it run commands on this node.
+	EnableScriptChecks bool
+
+	// EventHandlers are commands run when members join, leave, fail or
+	// change. Handlers for the same event type run one at a time, and each
+	// is killed after EventHandlerTimeout.
+	EventHandlers       []hooks.Hook
+	EventHandlerTimeout time.Duration
+
+	// QueryHandlers answer queries sent with /v1/query/{name}. Each gets
+	// the query's payload on stdin and is killed when the query times out
+	// or after EventHandlerTimeout.
+	QueryHandlers []hooks.QueryHandler
+
+	// Webhooks are HTTP endpoints that membership events are POSTed to.
+	// Deliveries are signed with WebhookSecret unless an endpoint has its
+	// own, and up to WebhookQueueSize events wait per endpoint.
+	Webhooks         []webhook.Endpoint
+	WebhookSecret    string
+	WebhookQueueSize int
+
+	// DNSPort enables a DNS server on this port, over UDP and TCP, that
+	// resolves <node-id>.node.clip. to a member's address and
+	// <service>.service.clip. to the A and SRV records of its healthy
+	// instances. 0 disables it.
+	DNSPort int
+
 	// Discovery configuration
 	SeedNodes         []string
 	BroadcastPort     int
@@ -26,6 +87,52 @@ type Config struct {
 	PeerTimeout       time.Duration
 	GossipInterval    time.Duration
 
+	// Failure detection configuration. Every HeartbeatInterval one random
+	// peer is probed; if it doesn't answer within ProbeTimeout, IndirectChecks
+	// other peers are asked to probe it on our behalf.
+	ProbeTimeout   time.Duration
+	IndirectChecks int
+
+	// Dissemination configuration. Membership changes are piggybacked on
+	// gossip messages to GossipFanout random peers and retransmitted
+	// RetransmitMult * log(n) times; a full push-pull state exchange with one
+	// random peer runs every PushPullInterval.
+	GossipFanout     int
+	RetransmitMult   int
+	PushPullInterval time.Duration
+
+	// TombstoneRetention is how long peers that left gracefully are kept
+	// before they are removed from the peer list
+	TombstoneRetention time.Duration
+	// DeadPeerRetention is how long failed peers are kept before they are
+	// removed from the peer list
+	DeadPeerRetention time.Duration
+	// KVTombstoneRetention is how long deleted keys are kept as tombstones
+	// before they are removed from the key/value store. A node that was
+	// away for longer can bring a deleted key back.
+	KVTombstoneRetention time.Duration
+
+	// Transport selects how probes and rumors are sent: "http", "udp", or
+	// "both" (UDP with a fallback to HTTP). State sync and the API always
+	// use HTTP.
+	Transport string
+
+	// TLS configuration. When all three files are set, peers talk HTTPS
+	// with mutual authentication: each node presents TLSCertFile and only
+	// accepts peers whose certificates are signed by a CA in TLSCAFile. The
+	// files are reloaded when they change on disk.
+	TLSCertFile string
+	TLSKeyFile  string
+	TLSCAFile   string
+
+	// Encryption configuration. When a keyring file or key is set, all
+	// gossip between peers, including discovery broadcasts, is encrypted
+	// and authenticated with AES-GCM. KeyringFile holds a JSON array of
+	// base64 keys, the first of which is primary; EncryptKey is a single
+	// base64 key that is added to them.
+	KeyringFile string
+	EncryptKey  string
+
 	// Logging configuration
 	LogLevel  string
 	LogFormat string
@@ -34,15 +141,28 @@ type Config struct {
 // DefaultConfig returns a configuration with default values
 func DefaultConfig() *Config {
 	return &Config{
-		BindAddress:       "0.0.0.0",
-		Port:              8080,
-		BroadcastPort:     9999,
-		BroadcastInterval: 10 * time.Second,
-		HeartbeatInterval: 5 * time.Second,
-		PeerTimeout:       15 * time.Second,
-		GossipInterval:    10 * time.Second,
-		LogLevel:          "info",
-		LogFormat:         "text",
+		BindAddress:          "0.0.0.0",
+		Port:                 8080,
+		BroadcastPort:        9999,
+		BroadcastInterval:    10 * time.Second,
+		HeartbeatInterval:    5 * time.Second,
+		PeerTimeout:          15 * time.Second,
+		GossipInterval:       10 * time.Second,
+		ProbeTimeout:         1 * time.Second,
+		IndirectChecks:       3,
+		GossipFanout:         3,
+		RetransmitMult:       4,
+		PushPullInterval:     30 * time.Second,
+		TombstoneRetention:   5 * time.Minute,
+		DeadPeerRetention:    10 * time.Minute,
+		KVTombstoneRetention: 24 * time.Hour,
+		CheckInterval:        10 * time.Second,
+		CheckTimeout:         5 * time.Second,
+		EventHandlerTimeout:  hooks.DefaultTimeout,
+		WebhookQueueSize:     webhook.DefaultQueueSize,
+		Transport:            TransportHTTP,
+		LogLevel:             "info",
+		LogFormat:            "text",
 	}
 }
 
@@ -55,7 +175,31 @@ func LoadFromFlags() (*Config, error) {
 	address := flag.String("address", config.BindAddress, "IP address to bind to (0.0.0.0 for all interfaces)")
 	advertiseAddr := flag.String("advertise", "", "IP address to advertise to other peers (auto-detected if not specified)")
 	port := flag.Int("port", config.Port, "Port to listen on")
+	clusterName := flag.String("cluster", "", "Name of the cluster to join; nodes with other names are ignored")
+	tags := tagFlag{}
+	flag.Var(tags, "tag", "Tag to advertise as key=value (can be repeated)")
+	services := &serviceFlag{}
+	flag.Var(services, "service", "Service to advertise as name:port or name:port:tag1,tag2 (can be repeated)")
+	checks := &checkFlag{}
+	flag.Var(checks, "check", "Health check as service=target; the target is an http(s):// URL, a tcp://host:port or a command (can be repeated)")
+	enableScriptChecks := flag.Bool("enable-script-checks", false, "Allow exec health checks to be registered through the API")
+	eventHandlers := &eventHandlerFlag{}
+	flag.Var(eventHandlers, "event-handler", "Command to run on membership events, as [type,...=]command, e.g. member-join,member-leave=/usr/local/bin/reload-lb (can be repeated)")
+	queryHandlers := &queryHandlerFlag{}
+	flag.Var(queryHandlers, "query-handler", "Command that answers queries, as name=command, e.g. uptime=/usr/bin/uptime (can be repeated)")
+	webhooks := &webhookFlag{}
+	flag.Var(webhooks, "webhook", "URL to POST membership events to, as [type,...=]url, e.g. member-join,member-leave=https://lb.example.com/hooks (can be repeated)")
+	webhookSecret := flag.String("webhook-secret", "", "Secret used to sign webhook deliveries")
+	dnsPort := flag.Int("dns-port", 0, "Port to serve DNS lookups of members and services on (0 disables it)")
+	deadPeerRetention := flag.Duration("dead-peer-retention", config.DeadPeerRetention, "How long failed peers are kept before they are removed")
 	seeds := flag.String("seeds", "", "Comma-separated list of seed node addresses")
+	transport := flag.String("transport", config.Transport, "Transport for probes and gossip (http, udp, both)")
+	gossipFanout := flag.Int("gossip-fanout", config.GossipFanout, "Number of random peers each gossip message is sent to")
+	retransmitMult := flag.Int("retransmit-mult", config.RetransmitMult, "Multiplier for how many times a rumor is retransmitted, scaled by log(cluster size)")
+	tlsCert := flag.String("tls-cert", "", "Certificate file presented to peers (enables mutual TLS)")
+	tlsKey := flag.String("tls-key", "", "Private key file for the TLS certificate")
+	tlsCA := flag.String("tls-ca", "", "CA bundle used to verify peer certificates")
+	keyringFile := flag.String("keyring", "", "Keyring file with the cluster encryption keys (enables encryption)")
 	logLevel := flag.String("log-level", config.LogLevel, "Log level (debug, info, warn, error)")
 	logFormat := flag.String("log-format", config.LogFormat, "Log format (text, json)")
 
@@ -70,6 +214,26 @@ func LoadFromFlags() (*Config, error) {
 	config.BindAddress = *address
 	config.AdvertiseAddr = *advertiseAddr
 	config.Port = *port
+	config.ClusterName = *clusterName
+	if len(tags) > 0 {
+		config.Tags = tags
+	}
+	config.Services = services.services
+	config.Checks = checks.checks
+	config.EnableScriptChecks = *enableScriptChecks
+	config.EventHandlers = eventHandlers.hooks
+	config.QueryHandlers = queryHandlers.handlers
+	config.Webhooks = webhooks.endpoints
+	config.WebhookSecret = *webhookSecret
+	config.DNSPort = *dnsPort
+	config.DeadPeerRetention = *deadPeerRetention
+	config.Transport = *transport
+	config.GossipFanout = *gossipFanout
+	config.RetransmitMult = *retransmitMult
+	config.TLSCertFile = *tlsCert
+	config.TLSKeyFile = *tlsKey
+	config.TLSCAFile = *tlsCA
+	config.KeyringFile = *keyringFile
 	config.LogLevel = *logLevel
 	config.LogFormat = *logFormat
 
@@ -99,12 +263,108 @@ func (c *Config) LoadFromEnv() {
 		// Note: In production, you'd want to parse this properly
 		c.Port = 8080 // Default fallback
 	}
+	if clusterName := os.Getenv("CLIP_CLUSTER_NAME"); clusterName != "" {
+		c.ClusterName = clusterName
+	}
+	if tags := os.Getenv("CLIP_TAGS"); tags != "" {
+		c.Tags = make(map[string]string)
+		for _, tag := range strings.Split(tags, ",") {
+			key, value, _ := strings.Cut(strings.TrimSpace(tag), "=")
+			c.Tags[key] = value
+		}
+	}
+	if services := os.Getenv("CLIP_SERVICES"); services != "" {
+		c.Services = nil
+		for _, service := range strings.Split(services, ";") {
+			if svc, err := parseService(strings.TrimSpace(service)); err == nil {
+				c.Services = append(c.Services, svc)
+			}
+		}
+	}
+	if checks := os.Getenv("CLIP_CHECKS"); checks != "" {
+		c.Checks = nil
+		for _, check := range strings.Split(checks, ";") {
+			if parsed, err := health.ParseCheck(strings.TrimSpace(check)); err == nil {
+				c.Checks = append(c.Checks, parsed)
+			}
+		}
+	}
+	if enabled := os.Getenv("CLIP_ENABLE_SCRIPT_CHECKS"); enabled != "" {
+		if parsed, err := strconv.ParseBool(enabled); err == nil {
+			c.EnableScriptChecks = parsed
+		}
+	}
+	if handlers := os.Getenv("CLIP_EVENT_HANDLERS"); handlers != "" {
+		c.EventHandlers = nil
+		for _, handler := range strings.Split(handlers, ";") {
+			if hook, err := hooks.ParseHook(strings.TrimSpace(handler)); err == nil {
+				c.EventHandlers = append(c.EventHandlers, hook)
+			}
+		}
+	}
+	if handlers := os.Getenv("CLIP_QUERY_HANDLERS"); handlers != "" {
+		c.QueryHandlers = nil
+		for _, handler := range strings.Split(handlers, ";") {
+			if parsed, err := hooks.ParseQueryHandler(strings.TrimSpace(handler)); err == nil {
+				c.QueryHandlers = append(c.QueryHandlers, parsed)
+			}
+		}
+	}
+	if webhooks := os.Getenv("CLIP_WEBHOOKS"); webhooks != "" {
+		c.Webhooks = nil
+		for _, url := range strings.Split(webhooks, ";") {
+			if endpoint, err := webhook.ParseEndpoint(strings.TrimSpace(url)); err == nil {
+				c.Webhooks = append(c.Webhooks, endpoint)
+			}
+		}
+	}
+	if secret := os.Getenv("CLIP_WEBHOOK_SECRET"); secret != "" {
+		c.WebhookSecret = secret
+	}
+	if dnsPort := os.Getenv("CLIP_DNS_PORT"); dnsPort != "" {
+		if port, err := strconv.Atoi(dnsPort); err == nil {
+			c.DNSPort = port
+		}
+	}
+	if retention := os.Getenv("CLIP_DEAD_PEER_RETENTION"); retention != "" {
+		if parsed, err := time.ParseDuration(retention); err == nil {
+			c.DeadPeerRetention = parsed
+		}
+	}
 	if seeds := os.Getenv("CLIP_SEED_NODES"); seeds != "" {
 		c.SeedNodes = strings.Split(seeds, ",")
 		for i, seed := range c.SeedNodes {
 			c.SeedNodes[i] = strings.TrimSpace(seed)
 		}
 	}
+	if transport := os.Getenv("CLIP_TRANSPORT"); transport != "" {
+		c.Transport = transport
+	}
+	if fanout := os.Getenv("CLIP_GOSSIP_FANOUT"); fanout != "" {
+		if n, err := strconv.Atoi(fanout); err == nil {
+			c.GossipFanout = n
+		}
+	}
+	if mult := os.Getenv("CLIP_RETRANSMIT_MULT"); mult != "" {
+		if n, err := strconv.Atoi(mult); err == nil {
+			c.RetransmitMult = n
+		}
+	}
+	if certFile := os.Getenv("CLIP_TLS_CERT_FILE"); certFile != "" {
+		c.TLSCertFile = certFile
+	}
+	if keyFile := os.Getenv("CLIP_TLS_KEY_FILE"); keyFile != "" {
+		c.TLSKeyFile = keyFile
+	}
+	if caFile := os.Getenv("CLIP_TLS_CA_FILE"); caFile != "" {
+		c.TLSCAFile = caFile
+	}
+	if keyringFile := os.Getenv("CLIP_KEYRING_FILE"); keyringFile != "" {
+		c.KeyringFile = keyringFile
+	}
+	if encryptKey := os.Getenv("CLIP_ENCRYPT_KEY"); encryptKey != "" {
+		c.EncryptKey = encryptKey
+	}
 	if logLevel := os.Getenv("CLIP_LOG_LEVEL"); logLevel != "" {
 		c.LogLevel = logLevel
 	}
@@ -124,6 +384,9 @@ func (c *Config) Validate() error {
 	if c.BroadcastPort <= 0 || c.BroadcastPort > 65535 {
 		return fmt.Errorf("broadcast port must be between 1 and 65535")
 	}
+	if c.DNSPort < 0 || c.DNSPort > 65535 {
+		return fmt.Errorf("DNS port must be between 1 and 65535, or 0 to disable DNS")
+	}
 	if c.HeartbeatInterval <= 0 {
 		return fmt.Errorf("heartbeat interval must be positive")
 	}
@@ -133,10 +396,261 @@ func (c *Config) Validate() error {
 	if c.GossipInterval <= 0 {
 		return fmt.Errorf("gossip interval must be positive")
 	}
+	if c.ProbeTimeout < 0 {
+		return fmt.Errorf("probe timeout must not be negative")
+	}
+	if c.IndirectChecks < 0 {
+		return fmt.Errorf("indirect checks must not be negative")
+	}
+	if c.GossipFanout < 0 {
+		return fmt.Errorf("gossip fanout must not be negative")
+	}
+	if c.RetransmitMult < 0 {
+		return fmt.Errorf("retransmit multiplier must not be negative")
+	}
+	if c.PushPullInterval < 0 {
+		return fmt.Errorf("push-pull interval must not be negative")
+	}
+	if c.TombstoneRetention < 0 {
+		return fmt.Errorf("tombstone retention must not be negative")
+	}
+	if c.DeadPeerRetention < 0 {
+		return fmt.Errorf("dead peer retention must not be negative")
+	}
+	if c.KVTombstoneRetention < 0 {
+		return fmt.Errorf("key/value tombstone retention must not be negative")
+	}
+	switch c.Transport {
+	case "", TransportHTTP, TransportUDP, TransportBoth:
+	default:
+		return fmt.Errorf("transport must be one of http, udp, both")
+	}
+	if err := peer.ValidateTags(c.Tags); err != nil {
+		return err
+	}
+	if err := peer.ValidateServices(c.Services); err != nil {
+		return err
+	}
+	if c.Transport == TransportUDP || c.Transport == TransportBoth {
+		if err := peer.ValidateServicesSize(c.Services); err != nil {
+			return err
+		}
+	}
+	if c.CheckInterval < 0 || c.CheckTimeout < 0 {
+		return fmt.Errorf("check interval and timeout must not be negative")
+	}
+	for _, check := range c.Checks {
+		if err := check.Validate(); err != nil {
+			return err
+		}
+		if !c.hasService(check.ServiceID) {
+			return fmt.Errorf("check for unknown service: %s", check.ServiceID)
+		}
+	}
+	for _, hook := range c.EventHandlers {
+		if err := hook.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.EventHandlerTimeout < 0 {
+		return fmt.Errorf("event handler timeout must not be negative")
+	}
+	for _, handler := range c.QueryHandlers {
+		if err := handler.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, endpoint := range c.Webhooks {
+		if err := endpoint.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.WebhookQueueSize < 0 {
+		return fmt.Errorf("webhook queue size must not be negative")
+	}
+	if (c.TLSCertFile != "" || c.TLSKeyFile != "" || c.TLSCAFile != "") && !c.TLSEnabled() {
+		return fmt.Errorf("TLS requires a certificate, a key and a CA file")
+	}
 	return nil
 }
 
+// hasService reports whether a service with the given ID is configured
+func (c *Config) hasService(id string) bool {
+	for _, svc := range c.Services {
+		if svc.ID == id || (svc.ID == "" && svc.Name == id) {
+			return true
+		}
+	}
+	return false
+}
+
+// TLSEnabled reports whether peers talk mutual TLS
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != "" && c.TLSCAFile != ""
+}
+
+// EncryptionEnabled reports whether gossip between peers is encrypted
+func (c *Config) EncryptionEnabled() bool {
+	return c.KeyringFile != "" || c.EncryptKey != ""
+}
+
+// Scheme returns the URL scheme peers use to reach this service
+func (c *Config) Scheme() string {
+	if c.TLSEnabled() {
+		return "https"
+	}
+	return "http"
+}
+
 // GetFullAddress returns the full HTTP address for this service
 func (c *Config) GetFullAddress() string {
-	return fmt.Sprintf("http://%s:%d", c.AdvertiseAddr, c.Port)
+	return fmt.Sprintf("%s://%s:%d", c.Scheme(), c.AdvertiseAddr, c.Port)
+}
+
+// tagFlag collects repeated -tag key=value flags
+type tagFlag map[string]string
+
+func (f tagFlag) String() string {
+	tags := make([]string, 0, len(f))
+	for key, value := range f {
+		tags = append(tags, key+"="+value)
+	}
+	return strings.Join(tags, ",")
+}
+
+func (f tagFlag) Set(tag string) error {
+	key, value, ok := strings.Cut(tag, "=")
+	if !ok {
+		return fmt.Errorf("tag must be key=value, got %q", tag)
+	}
+	f[key] = value
+	return nil
+}
+
+// serviceFlag collects repeated -service flags
+type serviceFlag struct {
+	services []peer.Service
+}
+
+func (f *serviceFlag) String() string {
+	names := make([]string, 0, len(f.services))
+	for _, svc := range f.services {
+		names = append(names, fmt.Sprintf("%s:%d", svc.Name, svc.Port))
+	}
+	return strings.Join(names, ";")
+}
+
+func (f *serviceFlag) Set(service string) error {
+	svc, err := parseService(service)
+	if err != nil {
+		return err
+	}
+	f.services = append(f.services, svc)
+	return nil
+}
+
+// parseService parses a service given as name:port or name:port:tag1,tag2
+func parseService(service string) (peer.Service, error) {
+	parts := strings.SplitN(service, ":", 3)
+	if len(parts) < 2 || parts[0] == "" {
+		return peer.Service{}, fmt.Errorf("service must be name:port, got %q", service)
+	}
+	port, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return peer.Service{}, fmt.Errorf("invalid port for service %s: %q", parts[0], parts[1])
+	}
+
+	svc := peer.Service{Name: parts[0], Port: port}
+	if len(parts) == 3 && parts[2] != "" {
+		svc.Tags = strings.Split(parts[2], ",")
+	}
+	return svc, nil
+}
+
+// checkFlag collects repeated -check flags
+type checkFlag struct {
+	checks []health.Check
+}
+
+func (f *checkFlag) String() string {
+	checks := make([]string, 0, len(f.checks))
+	for _, check := range f.checks {
+		checks = append(checks, check.ServiceID+"="+check.Target())
+	}
+	return strings.Join(checks, ";")
+}
+
+func (f *checkFlag) Set(check string) error {
+	parsed, err := health.ParseCheck(check)
+	if err != nil {
+		return err
+	}
+	f.checks = append(f.checks, parsed)
+	return nil
+}
+
+// eventHandlerFlag collects repeated -event-handler flags
+type eventHandlerFlag struct {
+	hooks []hooks.Hook
+}
+
+func (f *eventHandlerFlag) String() string {
+	handlers := make([]string, 0, len(f.hooks))
+	for _, hook := range f.hooks {
+		handlers = append(handlers, strings.Join(hook.Command, " "))
+	}
+	return strings.Join(handlers, ";")
+}
+
+func (f *eventHandlerFlag) Set(handler string) error {
+	hook, err := hooks.ParseHook(handler)
+	if err != nil {
+		return err
+	}
+	f.hooks = append(f.hooks, hook)
+	return nil
+}
+
+// queryHandlerFlag collects repeated -query-handler flags
+type queryHandlerFlag struct {
+	handlers []hooks.QueryHandler
+}
+
+func (f *queryHandlerFlag) String() string {
+	handlers := make([]string, 0, len(f.handlers))
+	for _, handler := range f.handlers {
+		handlers = append(handlers, handler.Name+"="+strings.Join(handler.Command, " "))
+	}
+	return strings.Join(handlers, ";")
+}
+
+func (f *queryHandlerFlag) Set(handler string) error {
+	parsed, err := hooks.ParseQueryHandler(handler)
+	if err != nil {
+		return err
+	}
+	f.handlers = append(f.handlers, parsed)
+	return nil
+}
+
+// webhookFlag collects repeated -webhook flags
+type webhookFlag struct {
+	endpoints []webhook.Endpoint
+}
+
+func (f *webhookFlag) String() string {
+	urls := make([]string, 0, len(f.endpoints))
+	for _, endpoint := range f.endpoints {
+		urls = append(urls, endpoint.URL)
+	}
+	return strings.Join(urls, ";")
+}
+
+func (f *webhookFlag) Set(url string) error {
+	endpoint, err := webhook.ParseEndpoint(url)
+	if err != nil {
+		return err
+	}
+	f.endpoints = append(f.endpoints, endpoint)
+	return nil
 }
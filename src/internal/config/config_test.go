@@ -3,8 +3,14 @@ package config
 import (
 	"flag"
 	"os"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/rokzabukovec/clip/internal/health"
+	"github.com/rokzabukovec/clip/internal/hooks"
+	"github.com/rokzabukovec/clip/internal/peer"
+	"github.com/rokzabukovec/clip/internal/webhook"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -22,6 +28,10 @@ func TestDefaultConfig(t *testing.T) {
 		t.Errorf("Expected BroadcastPort to be 9999, got %d", cfg.BroadcastPort)
 	}
 
+	if cfg.EnableScriptChecks {
+		t.Error("Expected script checks to be disabled by default")
+	}
+
 	if cfg.BroadcastInterval != 10*time.Second {
 		t.Errorf("Expected BroadcastInterval to be 10s, got %v", cfg.BroadcastInterval)
 	}
@@ -38,6 +48,58 @@ func TestDefaultConfig(t *testing.T) {
 		t.Errorf("Expected GossipInterval to be 10s, got %v", cfg.GossipInterval)
 	}
 
+	if cfg.ProbeTimeout != 1*time.Second {
+		t.Errorf("Expected ProbeTimeout to be 1s, got %v", cfg.ProbeTimeout)
+	}
+
+	if cfg.IndirectChecks != 3 {
+		t.Errorf("Expected IndirectChecks to be 3, got %d", cfg.IndirectChecks)
+	}
+
+	if cfg.GossipFanout != 3 {
+		t.Errorf("Expected GossipFanout to be 3, got %d", cfg.GossipFanout)
+	}
+
+	if cfg.RetransmitMult != 4 {
+		t.Errorf("Expected RetransmitMult to be 4, got %d", cfg.RetransmitMult)
+	}
+
+	if cfg.PushPullInterval != 30*time.Second {
+		t.Errorf("Expected PushPullInterval to be 30s, got %v", cfg.PushPullInterval)
+	}
+
+	if cfg.TombstoneRetention != 5*time.Minute {
+		t.Errorf("Expected TombstoneRetention to be 5m, got %v", cfg.TombstoneRetention)
+	}
+
+	if cfg.DeadPeerRetention != 10*time.Minute {
+		t.Errorf("Expected DeadPeerRetention to be 10m, got %v", cfg.DeadPeerRetention)
+	}
+
+	if cfg.KVTombstoneRetention != 24*time.Hour {
+		t.Errorf("Expected KVTombstoneRetention to be 24h, got %v", cfg.KVTombstoneRetention)
+	}
+
+	if cfg.CheckInterval != 10*time.Second || cfg.CheckTimeout != 5*time.Second {
+		t.Errorf("Expected check interval 10s and timeout 5s, got %v and %v", cfg.CheckInterval, cfg.CheckTimeout)
+	}
+
+	if cfg.EventHandlerTimeout != 30*time.Second {
+		t.Errorf("Expected event handler timeout 30s, got %v", cfg.EventHandlerTimeout)
+	}
+
+	if cfg.WebhookQueueSize != 1000 {
+		t.Errorf("Expected webhook queue size 1000, got %d", cfg.WebhookQueueSize)
+	}
+
+	if cfg.Transport != TransportHTTP {
+		t.Errorf("Expected Transport to be 'http', got '%s'", cfg.Transport)
+	}
+
+	if cfg.TLSEnabled() || cfg.EncryptionEnabled() {
+		t.Error("Expected TLS and encryption to be disabled by default")
+	}
+
 	if cfg.LogLevel != "info" {
 		t.Errorf("Expected LogLevel to be 'info', got '%s'", cfg.LogLevel)
 	}
@@ -52,7 +114,11 @@ func TestLoadFromFlags(t *testing.T) {
 	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 
 	// Test with valid flags
-	os.Args = []string{"clip", "-id=test-node", "-port=9090", "-address=127.0.0.1"}
+	os.Args = []string{"clip", "-id=test-node", "-port=9090", "-address=127.0.0.1", "-cluster=staging",
+		"-tag=role=worker", "-tag=zone=rack3", "-service=web:80:v1,primary", "-service=api:9090",
+		"-check=web=http://localhost:80/health", "-enable-script-checks", "-dns-port=8600", "-dead-peer-retention=30m", "-event-handler=member-join=/bin/reload-lb", "-query-handler=uptime=/usr/bin/uptime",
+		"-webhook=member-join=https://lb.example.com/hooks", "-webhook-secret=s3cret",
+		"-gossip-fanout=5", "-retransmit-mult=6"}
 	cfg, err := LoadFromFlags()
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
@@ -69,6 +135,59 @@ func TestLoadFromFlags(t *testing.T) {
 	if cfg.BindAddress != "127.0.0.1" {
 		t.Errorf("Expected BindAddress to be '127.0.0.1', got '%s'", cfg.BindAddress)
 	}
+
+	if cfg.ClusterName != "staging" {
+		t.Errorf("Expected ClusterName to be 'staging', got '%s'", cfg.ClusterName)
+	}
+
+	if len(cfg.Tags) != 2 || cfg.Tags["role"] != "worker" || cfg.Tags["zone"] != "rack3" {
+		t.Errorf("Expected tags from flags, got %v", cfg.Tags)
+	}
+
+	if len(cfg.Services) != 2 || cfg.Services[0].Name != "web" || cfg.Services[0].Port != 80 ||
+		len(cfg.Services[0].Tags) != 2 || cfg.Services[1].Name != "api" {
+		t.Errorf("Expected services from flags, got %+v", cfg.Services)
+	}
+
+	if len(cfg.Checks) != 1 || cfg.Checks[0].ServiceID != "web" || cfg.Checks[0].HTTP != "http://localhost:80/health" {
+		t.Errorf("Expected a check from flags, got %+v", cfg.Checks)
+	}
+
+	if !cfg.EnableScriptChecks {
+		t.Error("Expected script checks to be enabled from flags")
+	}
+
+	if cfg.DNSPort != 8600 {
+		t.Errorf("Expected DNSPort to be 8600, got %d", cfg.DNSPort)
+	}
+
+	if cfg.DeadPeerRetention != 30*time.Minute {
+		t.Errorf("Expected DeadPeerRetention to be 30m, got %v", cfg.DeadPeerRetention)
+	}
+
+	if cfg.GossipFanout != 5 {
+		t.Errorf("Expected GossipFanout to be 5, got %d", cfg.GossipFanout)
+	}
+
+	if cfg.RetransmitMult != 6 {
+		t.Errorf("Expected RetransmitMult to be 6, got %d", cfg.RetransmitMult)
+	}
+
+	if len(cfg.EventHandlers) != 1 || cfg.EventHandlers[0].Command[0] != "/bin/reload-lb" || len(cfg.EventHandlers[0].Events) != 1 {
+		t.Errorf("Expected an event handler from flags, got %+v", cfg.EventHandlers)
+	}
+
+	if len(cfg.QueryHandlers) != 1 || cfg.QueryHandlers[0].Name != "uptime" || cfg.QueryHandlers[0].Command[0] != "/usr/bin/uptime" {
+		t.Errorf("Expected a query handler from flags, got %+v", cfg.QueryHandlers)
+	}
+
+	if len(cfg.Webhooks) != 1 || cfg.Webhooks[0].URL != "https://lb.example.com/hooks" || len(cfg.Webhooks[0].Events) != 1 {
+		t.Errorf("Expected a webhook from flags, got %+v", cfg.Webhooks)
+	}
+
+	if cfg.WebhookSecret != "s3cret" {
+		t.Errorf("Expected WebhookSecret to be 's3cret', got '%s'", cfg.WebhookSecret)
+	}
 }
 
 func TestLoadFromFlags_MissingID(t *testing.T) {
@@ -115,6 +234,25 @@ func TestLoadFromEnv(t *testing.T) {
 	os.Setenv("CLIP_ADVERTISE_ADDRESS", "192.168.1.100")
 	os.Setenv("CLIP_PORT", "9090")
 	os.Setenv("CLIP_SEED_NODES", "seed1:8080,seed2:8080")
+	os.Setenv("CLIP_CLUSTER_NAME", "dev")
+	os.Setenv("CLIP_TAGS", "role=worker, version=1.4")
+	os.Setenv("CLIP_SERVICES", "web:80:v1; api:9090")
+	os.Setenv("CLIP_CHECKS", "web=tcp://localhost:80; api=/bin/check-api")
+	os.Setenv("CLIP_ENABLE_SCRIPT_CHECKS", "true")
+	os.Setenv("CLIP_DNS_PORT", "8600")
+	os.Setenv("CLIP_DEAD_PEER_RETENTION", "1h")
+	os.Setenv("CLIP_EVENT_HANDLERS", "member-join=/bin/reload-lb; /bin/log-event")
+	os.Setenv("CLIP_QUERY_HANDLERS", "uptime=/usr/bin/uptime; load=cat /proc/loadavg")
+	os.Setenv("CLIP_WEBHOOKS", "https://lb.example.com/hooks; member-failed=http://pager.local/clip")
+	os.Setenv("CLIP_WEBHOOK_SECRET", "s3cret")
+	os.Setenv("CLIP_TRANSPORT", "udp")
+	os.Setenv("CLIP_GOSSIP_FANOUT", "5")
+	os.Setenv("CLIP_RETRANSMIT_MULT", "6")
+	os.Setenv("CLIP_TLS_CERT_FILE", "/etc/clip/node.crt")
+	os.Setenv("CLIP_TLS_KEY_FILE", "/etc/clip/node.key")
+	os.Setenv("CLIP_TLS_CA_FILE", "/etc/clip/ca.crt")
+	os.Setenv("CLIP_KEYRING_FILE", "/etc/clip/keyring.json")
+	os.Setenv("CLIP_ENCRYPT_KEY", "c2VjcmV0LWtleS0xNi1ieXRlcw==")
 	os.Setenv("CLIP_LOG_LEVEL", "debug")
 	os.Setenv("CLIP_LOG_FORMAT", "json")
 
@@ -124,6 +262,25 @@ func TestLoadFromEnv(t *testing.T) {
 		os.Unsetenv("CLIP_ADVERTISE_ADDRESS")
 		os.Unsetenv("CLIP_PORT")
 		os.Unsetenv("CLIP_SEED_NODES")
+		os.Unsetenv("CLIP_CLUSTER_NAME")
+		os.Unsetenv("CLIP_TAGS")
+		os.Unsetenv("CLIP_SERVICES")
+		os.Unsetenv("CLIP_CHECKS")
+		os.Unsetenv("CLIP_ENABLE_SCRIPT_CHECKS")
+		os.Unsetenv("CLIP_DNS_PORT")
+		os.Unsetenv("CLIP_DEAD_PEER_RETENTION")
+		os.Unsetenv("CLIP_EVENT_HANDLERS")
+		os.Unsetenv("CLIP_QUERY_HANDLERS")
+		os.Unsetenv("CLIP_WEBHOOKS")
+		os.Unsetenv("CLIP_WEBHOOK_SECRET")
+		os.Unsetenv("CLIP_TRANSPORT")
+		os.Unsetenv("CLIP_GOSSIP_FANOUT")
+		os.Unsetenv("CLIP_RETRANSMIT_MULT")
+		os.Unsetenv("CLIP_TLS_CERT_FILE")
+		os.Unsetenv("CLIP_TLS_KEY_FILE")
+		os.Unsetenv("CLIP_TLS_CA_FILE")
+		os.Unsetenv("CLIP_KEYRING_FILE")
+		os.Unsetenv("CLIP_ENCRYPT_KEY")
 		os.Unsetenv("CLIP_LOG_LEVEL")
 		os.Unsetenv("CLIP_LOG_FORMAT")
 	}()
@@ -151,6 +308,74 @@ func TestLoadFromEnv(t *testing.T) {
 		t.Errorf("Expected %d seed nodes, got %d", len(expectedSeeds), len(cfg.SeedNodes))
 	}
 
+	if cfg.ClusterName != "dev" {
+		t.Errorf("Expected ClusterName to be 'dev', got '%s'", cfg.ClusterName)
+	}
+
+	if len(cfg.Tags) != 2 || cfg.Tags["role"] != "worker" || cfg.Tags["version"] != "1.4" {
+		t.Errorf("Expected tags from env, got %v", cfg.Tags)
+	}
+
+	if len(cfg.Services) != 2 || cfg.Services[0].Port != 80 || cfg.Services[1].Name != "api" {
+		t.Errorf("Expected services from env, got %+v", cfg.Services)
+	}
+
+	if len(cfg.Checks) != 2 || cfg.Checks[0].TCP != "localhost:80" || cfg.Checks[1].Exec[0] != "/bin/check-api" {
+		t.Errorf("Expected checks from env, got %+v", cfg.Checks)
+	}
+
+	if !cfg.EnableScriptChecks {
+		t.Error("Expected script checks to be enabled from env")
+	}
+
+	if cfg.DNSPort != 8600 {
+		t.Errorf("Expected DNSPort to be 8600, got %d", cfg.DNSPort)
+	}
+
+	if cfg.DeadPeerRetention != time.Hour {
+		t.Errorf("Expected DeadPeerRetention to be 1h, got %v", cfg.DeadPeerRetention)
+	}
+
+	if len(cfg.EventHandlers) != 2 || cfg.EventHandlers[1].Command[0] != "/bin/log-event" || len(cfg.EventHandlers[1].Events) != 0 {
+		t.Errorf("Expected event handlers from env, got %+v", cfg.EventHandlers)
+	}
+
+	if len(cfg.QueryHandlers) != 2 || cfg.QueryHandlers[1].Name != "load" || len(cfg.QueryHandlers[1].Command) != 2 {
+		t.Errorf("Expected query handlers from env, got %+v", cfg.QueryHandlers)
+	}
+
+	if len(cfg.Webhooks) != 2 || cfg.Webhooks[1].URL != "http://pager.local/clip" || len(cfg.Webhooks[1].Events) != 1 {
+		t.Errorf("Expected webhooks from env, got %+v", cfg.Webhooks)
+	}
+
+	if cfg.WebhookSecret != "s3cret" {
+		t.Errorf("Expected WebhookSecret to be 's3cret', got '%s'", cfg.WebhookSecret)
+	}
+
+	if cfg.Transport != TransportUDP {
+		t.Errorf("Expected Transport to be 'udp', got '%s'", cfg.Transport)
+	}
+
+	if cfg.GossipFanout != 5 {
+		t.Errorf("Expected GossipFanout to be 5, got %d", cfg.GossipFanout)
+	}
+
+	if cfg.RetransmitMult != 6 {
+		t.Errorf("Expected RetransmitMult to be 6, got %d", cfg.RetransmitMult)
+	}
+
+	if cfg.TLSCertFile != "/etc/clip/node.crt" || cfg.TLSKeyFile != "/etc/clip/node.key" || cfg.TLSCAFile != "/etc/clip/ca.crt" {
+		t.Errorf("Expected TLS files from env, got %q, %q, %q", cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSCAFile)
+	}
+
+	if cfg.KeyringFile != "/etc/clip/keyring.json" || cfg.EncryptKey != "c2VjcmV0LWtleS0xNi1ieXRlcw==" {
+		t.Errorf("Expected keyring settings from env, got %q, %q", cfg.KeyringFile, cfg.EncryptKey)
+	}
+
+	if !cfg.EncryptionEnabled() {
+		t.Error("Expected encryption to be enabled")
+	}
+
 	if cfg.LogLevel != "debug" {
 		t.Errorf("Expected LogLevel to be 'debug', got '%s'", cfg.LogLevel)
 	}
@@ -261,6 +486,312 @@ func TestValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "negative probe timeout",
+			config: &Config{
+				ID:                "test-node",
+				Port:              8080,
+				BroadcastPort:     9999,
+				HeartbeatInterval: 5 * time.Second,
+				PeerTimeout:       15 * time.Second,
+				GossipInterval:    10 * time.Second,
+				ProbeTimeout:      -1 * time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative indirect checks",
+			config: &Config{
+				ID:                "test-node",
+				Port:              8080,
+				BroadcastPort:     9999,
+				HeartbeatInterval: 5 * time.Second,
+				PeerTimeout:       15 * time.Second,
+				GossipInterval:    10 * time.Second,
+				IndirectChecks:    -1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative gossip fanout",
+			config: &Config{
+				ID:                "test-node",
+				Port:              8080,
+				BroadcastPort:     9999,
+				HeartbeatInterval: 5 * time.Second,
+				PeerTimeout:       15 * time.Second,
+				GossipInterval:    10 * time.Second,
+				GossipFanout:      -1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative push-pull interval",
+			config: &Config{
+				ID:                "test-node",
+				Port:              8080,
+				BroadcastPort:     9999,
+				HeartbeatInterval: 5 * time.Second,
+				PeerTimeout:       15 * time.Second,
+				GossipInterval:    10 * time.Second,
+				PushPullInterval:  -1 * time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative tombstone retention",
+			config: &Config{
+				ID:                 "test-node",
+				Port:               8080,
+				BroadcastPort:      9999,
+				HeartbeatInterval:  5 * time.Second,
+				PeerTimeout:        15 * time.Second,
+				GossipInterval:     10 * time.Second,
+				TombstoneRetention: -1 * time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative dead peer retention",
+			config: &Config{
+				ID:                "test-node",
+				Port:              8080,
+				BroadcastPort:     9999,
+				HeartbeatInterval: 5 * time.Second,
+				PeerTimeout:       15 * time.Second,
+				GossipInterval:    10 * time.Second,
+				DeadPeerRetention: -1 * time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative key/value tombstone retention",
+			config: &Config{
+				ID:                   "test-node",
+				Port:                 8080,
+				BroadcastPort:        9999,
+				HeartbeatInterval:    5 * time.Second,
+				PeerTimeout:          15 * time.Second,
+				GossipInterval:       10 * time.Second,
+				KVTombstoneRetention: -1 * time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "udp transport",
+			config: &Config{
+				ID:                "test-node",
+				Port:              8080,
+				BroadcastPort:     9999,
+				HeartbeatInterval: 5 * time.Second,
+				PeerTimeout:       15 * time.Second,
+				GossipInterval:    10 * time.Second,
+				Transport:         TransportUDP,
+			},
+			wantErr: false,
+		},
+		{
+			name: "unknown transport",
+			config: &Config{
+				ID:                "test-node",
+				Port:              8080,
+				BroadcastPort:     9999,
+				HeartbeatInterval: 5 * time.Second,
+				PeerTimeout:       15 * time.Second,
+				GossipInterval:    10 * time.Second,
+				Transport:         "quic",
+			},
+			wantErr: true,
+		},
+		{
+			name: "complete TLS files",
+			config: &Config{
+				ID:                "test-node",
+				Port:              8080,
+				BroadcastPort:     9999,
+				HeartbeatInterval: 5 * time.Second,
+				PeerTimeout:       15 * time.Second,
+				GossipInterval:    10 * time.Second,
+				TLSCertFile:       "node.crt",
+				TLSKeyFile:        "node.key",
+				TLSCAFile:         "ca.crt",
+			},
+			wantErr: false,
+		},
+		{
+			name: "TLS without CA",
+			config: &Config{
+				ID:                "test-node",
+				Port:              8080,
+				BroadcastPort:     9999,
+				HeartbeatInterval: 5 * time.Second,
+				PeerTimeout:       15 * time.Second,
+				GossipInterval:    10 * time.Second,
+				TLSCertFile:       "node.crt",
+				TLSKeyFile:        "node.key",
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid tags",
+			config: &Config{
+				ID:                "test-node",
+				Port:              8080,
+				BroadcastPort:     9999,
+				HeartbeatInterval: 5 * time.Second,
+				PeerTimeout:       15 * time.Second,
+				GossipInterval:    10 * time.Second,
+				Tags:              map[string]string{"role": "worker", "zone": "rack3"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid service",
+			config: &Config{
+				ID:                "test-node",
+				Port:              8080,
+				BroadcastPort:     9999,
+				HeartbeatInterval: 5 * time.Second,
+				PeerTimeout:       15 * time.Second,
+				GossipInterval:    10 * time.Second,
+				Services:          []peer.Service{{Name: "web"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "large services over http",
+			config: &Config{
+				ID:                "test-node",
+				Port:              8080,
+				BroadcastPort:     9999,
+				HeartbeatInterval: 5 * time.Second,
+				PeerTimeout:       15 * time.Second,
+				GossipInterval:    10 * time.Second,
+				Transport:         TransportHTTP,
+				Services:          []peer.Service{{Name: strings.Repeat("x", peer.MaxServicesSize), Port: 80}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "large services over udp",
+			config: &Config{
+				ID:                "test-node",
+				Port:              8080,
+				BroadcastPort:     9999,
+				HeartbeatInterval: 5 * time.Second,
+				PeerTimeout:       15 * time.Second,
+				GossipInterval:    10 * time.Second,
+				Transport:         TransportUDP,
+				Services:          []peer.Service{{Name: strings.Repeat("x", peer.MaxServicesSize), Port: 80}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "check for a configured service",
+			config: &Config{
+				ID:                "test-node",
+				Port:              8080,
+				BroadcastPort:     9999,
+				HeartbeatInterval: 5 * time.Second,
+				PeerTimeout:       15 * time.Second,
+				GossipInterval:    10 * time.Second,
+				Services:          []peer.Service{{Name: "web", Port: 80}},
+				Checks:            []health.Check{{ServiceID: "web", TCP: "localhost:80"}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "check for an unknown service",
+			config: &Config{
+				ID:                "test-node",
+				Port:              8080,
+				BroadcastPort:     9999,
+				HeartbeatInterval: 5 * time.Second,
+				PeerTimeout:       15 * time.Second,
+				GossipInterval:    10 * time.Second,
+				Services:          []peer.Service{{Name: "web", Port: 80}},
+				Checks:            []health.Check{{ServiceID: "db", TCP: "localhost:5432"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid tag key",
+			config: &Config{
+				ID:                "test-node",
+				Port:              8080,
+				BroadcastPort:     9999,
+				HeartbeatInterval: 5 * time.Second,
+				PeerTimeout:       15 * time.Second,
+				GossipInterval:    10 * time.Second,
+				Tags:              map[string]string{"role:primary": "true"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "event handler with an unknown event",
+			config: &Config{
+				ID:                "test-node",
+				Port:              8080,
+				BroadcastPort:     9999,
+				HeartbeatInterval: 5 * time.Second,
+				PeerTimeout:       15 * time.Second,
+				GossipInterval:    10 * time.Second,
+				EventHandlers:     []hooks.Hook{{Events: []peer.EventType{"member-reap"}, Command: []string{"/bin/true"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "query handler without a command",
+			config: &Config{
+				ID:                "test-node",
+				Port:              8080,
+				BroadcastPort:     9999,
+				HeartbeatInterval: 5 * time.Second,
+				PeerTimeout:       15 * time.Second,
+				GossipInterval:    10 * time.Second,
+				QueryHandlers:     []hooks.QueryHandler{{Name: "uptime"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "webhook without a URL scheme",
+			config: &Config{
+				ID:                "test-node",
+				Port:              8080,
+				BroadcastPort:     9999,
+				HeartbeatInterval: 5 * time.Second,
+				PeerTimeout:       15 * time.Second,
+				GossipInterval:    10 * time.Second,
+				Webhooks:          []webhook.Endpoint{{URL: "lb.example.com/hooks"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "DNS port",
+			config: &Config{
+				ID:                "test-node",
+				Port:              8080,
+				BroadcastPort:     9999,
+				DNSPort:           8600,
+				HeartbeatInterval: 5 * time.Second,
+				PeerTimeout:       15 * time.Second,
+				GossipInterval:    10 * time.Second,
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid DNS port",
+			config: &Config{
+				ID:                "test-node",
+				Port:              8080,
+				BroadcastPort:     9999,
+				DNSPort:           70000,
+				HeartbeatInterval: 5 * time.Second,
+				PeerTimeout:       15 * time.Second,
+				GossipInterval:    10 * time.Second,
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -285,4 +816,50 @@ func TestGetFullAddress(t *testing.T) {
 	if result != expected {
 		t.Errorf("Expected GetFullAddress() to return '%s', got '%s'", expected, result)
 	}
+
+	cfg.TLSCertFile = "node.crt"
+	cfg.TLSKeyFile = "node.key"
+	cfg.TLSCAFile = "ca.crt"
+	if result := cfg.GetFullAddress(); result != "https://192.168.1.100:8080" {
+		t.Errorf("Expected https address with TLS, got '%s'", result)
+	}
+}
+
+func TestTagFlag(t *testing.T) {
+	tags := tagFlag{}
+	if err := tags.Set("endpoint=10.0.0.1:8080"); err != nil {
+		t.Fatalf("Expected tag to be accepted, got: %v", err)
+	}
+	if tags["endpoint"] != "10.0.0.1:8080" {
+		t.Errorf("Expected the value to keep everything after the first '=', got %q", tags["endpoint"])
+	}
+	if err := tags.Set("role"); err == nil {
+		t.Error("Expected a tag without '=' to be rejected")
+	}
+}
+
+func TestParseService(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    peer.Service
+		wantErr bool
+	}{
+		{"web:80", peer.Service{Name: "web", Port: 80}, false},
+		{"web:80:v1,primary", peer.Service{Name: "web", Port: 80, Tags: []string{"v1", "primary"}}, false},
+		{"web", peer.Service{}, true},
+		{":80", peer.Service{}, true},
+		{"web:http", peer.Service{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseService(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseService() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.Name != tt.want.Name || got.Port != tt.want.Port || len(got.Tags) != len(tt.want.Tags) {
+				t.Errorf("parseService() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
 }
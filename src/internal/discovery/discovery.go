@@ -7,6 +7,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/rokzabukovec/clip/internal/keyring"
 	"github.com/rokzabukovec/clip/pkg/network"
 )
 
@@ -22,6 +23,7 @@ type BroadcastMessage struct {
 	ID          string `json:"id"`
 	Address     string `json:"address"`
 	Port        int    `json:"port"`
+	Cluster     string `json:"cluster,omitempty"`
 }
 
 // DiscoveryService handles peer discovery via UDP broadcast
@@ -32,6 +34,8 @@ type DiscoveryService struct {
 	broadcastPort int
 	stopChan      chan struct{}
 	onPeerFound   func(id, address string)
+	keyring       *keyring.Keyring
+	clusterName   string
 }
 
 // NewDiscoveryService creates a new discovery service
@@ -46,6 +50,18 @@ func NewDiscoveryService(serviceID, serviceAddr string, servicePort, broadcastPo
 	}
 }
 
+// SetKeyring encrypts announcements with the cluster keyring and drops
+// received ones that fail to decrypt
+func (ds *DiscoveryService) SetKeyring(k *keyring.Keyring) {
+	ds.keyring = k
+}
+
+// SetClusterName announces this service as a member of the named cluster
+// and ignores announcements from other clusters
+func (ds *DiscoveryService) SetClusterName(name string) {
+	ds.clusterName = name
+}
+
 // StartBroadcastListener starts listening for broadcast messages from other peers
 func (ds *DiscoveryService) StartBroadcastListener() {
 	addr := net.UDPAddr{
@@ -118,6 +134,15 @@ func (ds *DiscoveryService) Stop() {
 
 // handleBroadcast processes incoming broadcast messages
 func (ds *DiscoveryService) handleBroadcast(data []byte, remoteAddr *net.UDPAddr) {
+	if ds.keyring != nil {
+		plaintext, err := ds.keyring.Decrypt(data)
+		if err != nil {
+			log.Printf("Dropping broadcast from %s: %v", remoteAddr, err)
+			return
+		}
+		data = plaintext
+	}
+
 	var msg BroadcastMessage
 	if err := json.Unmarshal(data, &msg); err != nil {
 		return
@@ -131,6 +156,11 @@ func (ds *DiscoveryService) handleBroadcast(data []byte, remoteAddr *net.UDPAddr
 		return
 	}
 
+	if msg.Cluster != ds.clusterName {
+		log.Printf("Ignoring broadcast from %s at %s: cluster %q does not match %q", msg.ID, remoteAddr, msg.Cluster, ds.clusterName)
+		return
+	}
+
 	log.Printf("Discovered new peer via broadcast: %s at %s", msg.ID, msg.Address)
 
 	if ds.onPeerFound != nil {
@@ -145,6 +175,7 @@ func (ds *DiscoveryService) sendBroadcast(broadcastAddr string) {
 		ID:          ds.serviceID,
 		Address:     ds.serviceAddr,
 		Port:        ds.servicePort,
+		Cluster:     ds.clusterName,
 	}
 
 	data, err := json.Marshal(msg)
@@ -152,6 +183,13 @@ func (ds *DiscoveryService) sendBroadcast(broadcastAddr string) {
 		return
 	}
 
+	if ds.keyring != nil {
+		if data, err = ds.keyring.Encrypt(data); err != nil {
+			log.Printf("Error encrypting broadcast: %v", err)
+			return
+		}
+	}
+
 	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", broadcastAddr, ds.broadcastPort))
 	if err != nil {
 		log.Printf("Error resolving broadcast address: %v", err)
@@ -1,10 +1,13 @@
 package discovery
 
 import (
+	"bytes"
 	"encoding/json"
 	"net"
 	"testing"
 	"time"
+
+	"github.com/rokzabukovec/clip/internal/keyring"
 )
 
 func TestNewDiscoveryService(t *testing.T) {
@@ -276,3 +279,67 @@ func TestBroadcastMessage_EdgeCases(t *testing.T) {
 		}
 	})
 }
+
+func TestDiscoveryService_handleBroadcastEncrypted(t *testing.T) {
+	var found []string
+	ds := NewDiscoveryService("test-service", "http://192.168.1.100:8080", 8080, 9999, func(id, address string) {
+		found = append(found, id)
+	})
+	k, _ := keyring.New(bytes.Repeat([]byte{1}, 32))
+	ds.SetKeyring(k)
+
+	remoteAddr := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 101), Port: 9999}
+	data, _ := json.Marshal(BroadcastMessage{
+		MessageType: DiscoveryMessage,
+		ID:          "other-peer",
+		Address:     "http://192.168.1.101:8080",
+		Port:        8080,
+	})
+
+	// Plaintext announcements are dropped and counted
+	ds.handleBroadcast(data, remoteAddr)
+	if len(found) != 0 {
+		t.Error("Expected unencrypted broadcast to be dropped")
+	}
+	if k.Failures() != 1 {
+		t.Errorf("Expected 1 failure, got %d", k.Failures())
+	}
+
+	// So are announcements encrypted with another key
+	other, _ := keyring.New(bytes.Repeat([]byte{2}, 32))
+	encrypted, _ := other.Encrypt(data)
+	ds.handleBroadcast(encrypted, remoteAddr)
+	if len(found) != 0 {
+		t.Error("Expected broadcast under another key to be dropped")
+	}
+
+	encrypted, _ = k.Encrypt(data)
+	ds.handleBroadcast(encrypted, remoteAddr)
+	if len(found) != 1 || found[0] != "other-peer" {
+		t.Errorf("Expected encrypted broadcast to be accepted, got %v", found)
+	}
+}
+
+func TestDiscoveryService_handleBroadcastCluster(t *testing.T) {
+	var found []string
+	ds := NewDiscoveryService("test-service", "http://192.168.1.100:8080", 8080, 9999, func(id, address string) {
+		found = append(found, id)
+	})
+	ds.SetClusterName("staging")
+
+	remoteAddr := &net.UDPAddr{IP: net.IPv4(192, 168, 1, 101), Port: 9999}
+	for _, cluster := range []string{"dev", "", "staging"} {
+		data, _ := json.Marshal(BroadcastMessage{
+			MessageType: DiscoveryMessage,
+			ID:          cluster + "-peer",
+			Address:     "http://192.168.1.101:8080",
+			Port:        8080,
+			Cluster:     cluster,
+		})
+		ds.handleBroadcast(data, remoteAddr)
+	}
+
+	if len(found) != 1 || found[0] != "staging-peer" {
+		t.Errorf("Expected only the peer from the same cluster, got %v", found)
+	}
+}
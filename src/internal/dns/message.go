@@ -0,0 +1,220 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// The subset of the RFC 1035 wire format the server needs: queries with a
+// single question, and answers with A, AAAA and SRV records. Names in
+// answers are written in full rather than compressed.
+const (
+	headerSize = 12
+
+	// maxUDPSize is the largest response sent over UDP; clients that need
+	// more retry over TCP when they see the truncated flag
+	maxUDPSize = 512
+
+	maxLabelSize = 63
+)
+
+// Record types and classes
+const (
+	typeA    uint16 = 1
+	typeAAAA uint16 = 28
+	typeSRV  uint16 = 33
+	typeANY  uint16 = 255
+
+	classIN  uint16 = 1
+	classANY uint16 = 255
+)
+
+// Response codes
+const (
+	rcodeSuccess        = 0
+	rcodeFormatError    = 1
+	rcodeNameError      = 3
+	rcodeNotImplemented = 4
+	rcodeRefused        = 5
+)
+
+// Header flags
+const (
+	flagResponse      uint16 = 1 << 15
+	flagAuthoritative uint16 = 1 << 10
+	flagTruncated     uint16 = 1 << 9
+	flagRecursion     uint16 = 1 << 8
+	opcodeMask        uint16 = 0xf << 11
+)
+
+var (
+	errTruncated = errors.New("truncated message")
+	errNotQuery  = errors.New("not a query")
+)
+
+// question is the name and type a query asks about
+type question struct {
+	labels []string
+	qtype  uint16
+	qclass uint16
+}
+
+// name returns the question's name in presentation form, e.g. "web.service.clip."
+func (q question) name() string {
+	return strings.Join(q.labels, ".") + "."
+}
+
+// record is a resource record with its data already encoded
+type record struct {
+	name  []string
+	rtype uint16
+	ttl   uint32
+	data  []byte
+}
+
+// message is a query or the response to one
+type message struct {
+	id         uint16
+	flags      uint16
+	question   question
+	answers    []record
+	additional []record
+}
+
+// opcode returns the kind of query, 0 for a standard one
+func (m message) opcode() uint16 {
+	return (m.flags & opcodeMask) >> 11
+}
+
+// parseQuery decodes a query. The header is decoded first so that a
+// malformed question can still be answered with a format error; the
+// returned message carries the header in that case.
+func parseQuery(packet []byte) (message, error) {
+	if len(packet) < headerSize {
+		return message{}, errTruncated
+	}
+
+	m := message{
+		id:    binary.BigEndian.Uint16(packet[0:2]),
+		flags: binary.BigEndian.Uint16(packet[2:4]),
+	}
+	if m.flags&flagResponse != 0 {
+		return m, errNotQuery
+	}
+	if count := binary.BigEndian.Uint16(packet[4:6]); count != 1 {
+		return m, errors.New("expected exactly one question")
+	}
+
+	labels, off, err := readName(packet, headerSize)
+	if err != nil {
+		return m, err
+	}
+	if len(packet) < off+4 {
+		return m, errTruncated
+	}
+	m.question = question{
+		labels: labels,
+		qtype:  binary.BigEndian.Uint16(packet[off : off+2]),
+		qclass: binary.BigEndian.Uint16(packet[off+2 : off+4]),
+	}
+	return m, nil
+}
+
+// readName decodes the name at off and returns its labels and the offset
+// after it. Queries have nothing to compress, so compression pointers are
+// rejected.
+func readName(packet []byte, off int) ([]string, int, error) {
+	labels := make([]string, 0, 4)
+	for {
+		if off >= len(packet) {
+			return nil, 0, errTruncated
+		}
+		size := int(packet[off])
+		off++
+		if size == 0 {
+			return labels, off, nil
+		}
+		if size > maxLabelSize {
+			return nil, 0, errors.New("compressed or invalid name")
+		}
+		if off+size > len(packet) {
+			return nil, 0, errTruncated
+		}
+		labels = append(labels, string(packet[off:off+size]))
+		off += size
+	}
+}
+
+// encode builds the wire form of a response. Answers that don't fit in
+// maxSize are dropped and the response is marked truncated; additional
+// records that don't fit are dropped silently. A question that failed to
+// parse has no labels and is left out of the response.
+func (m message) encode(rcode int, maxSize int) []byte {
+	flags := flagResponse | flagAuthoritative | m.flags&(opcodeMask|flagRecursion) | uint16(rcode)
+
+	packet := make([]byte, headerSize, maxUDPSize)
+	binary.BigEndian.PutUint16(packet[0:2], m.id)
+	if m.question.labels != nil {
+		binary.BigEndian.PutUint16(packet[4:6], 1)
+		packet = appendName(packet, m.question.labels)
+		packet = binary.BigEndian.AppendUint16(packet, m.question.qtype)
+		packet = binary.BigEndian.AppendUint16(packet, m.question.qclass)
+	}
+
+	answers := 0
+	for _, r := range m.answers {
+		next := r.append(packet)
+		if len(next) > maxSize {
+			flags |= flagTruncated
+			break
+		}
+		packet = next
+		answers++
+	}
+
+	additional := 0
+	if flags&flagTruncated == 0 {
+		for _, r := range m.additional {
+			next := r.append(packet)
+			if len(next) > maxSize {
+				break
+			}
+			packet = next
+			additional++
+		}
+	}
+
+	binary.BigEndian.PutUint16(packet[2:4], flags)
+	binary.BigEndian.PutUint16(packet[6:8], uint16(answers))
+	binary.BigEndian.PutUint16(packet[10:12], uint16(additional))
+	return packet
+}
+
+// append appends the wire form of the record to b
+func (r record) append(b []byte) []byte {
+	b = appendName(b, r.name)
+	b = binary.BigEndian.AppendUint16(b, r.rtype)
+	b = binary.BigEndian.AppendUint16(b, classIN)
+	b = binary.BigEndian.AppendUint32(b, r.ttl)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(r.data)))
+	return append(b, r.data...)
+}
+
+// appendName appends a name as length-prefixed labels ending in the root
+func appendName(b []byte, labels []string) []byte {
+	for _, label := range labels {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// srvData encodes the data of an SRV record pointing at port on target
+func srvData(port int, target []string) []byte {
+	data := make([]byte, 6, 6+len(strings.Join(target, "."))+2)
+	binary.BigEndian.PutUint16(data[0:2], 1) // priority
+	binary.BigEndian.PutUint16(data[2:4], 1) // weight
+	binary.BigEndian.PutUint16(data[4:6], uint16(port))
+	return appendName(data, target)
+}
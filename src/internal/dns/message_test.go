@@ -0,0 +1,117 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// query builds the wire form of a query for name and qtype
+func query(id uint16, name string, qtype uint16) []byte {
+	packet := make([]byte, headerSize)
+	binary.BigEndian.PutUint16(packet[0:2], id)
+	binary.BigEndian.PutUint16(packet[2:4], flagRecursion)
+	binary.BigEndian.PutUint16(packet[4:6], 1)
+	packet = appendName(packet, strings.Split(strings.TrimSuffix(name, "."), "."))
+	packet = binary.BigEndian.AppendUint16(packet, qtype)
+	return binary.BigEndian.AppendUint16(packet, classIN)
+}
+
+func TestParseQuery(t *testing.T) {
+	m, err := parseQuery(query(42, "web.service.clip.", typeSRV))
+	if err != nil {
+		t.Fatalf("Expected parse to succeed, got: %v", err)
+	}
+	if m.id != 42 || m.opcode() != 0 || m.flags&flagRecursion == 0 {
+		t.Errorf("Unexpected header: id %d flags %x", m.id, m.flags)
+	}
+	if m.question.name() != "web.service.clip." || m.question.qtype != typeSRV || m.question.qclass != classIN {
+		t.Errorf("Unexpected question: %+v", m.question)
+	}
+}
+
+func TestParseQuery_Invalid(t *testing.T) {
+	valid := query(1, "node1.node.clip.", typeA)
+
+	response := append([]byte(nil), valid...)
+	response[2] |= 0x80
+
+	twoQuestions := append([]byte(nil), valid...)
+	twoQuestions[5] = 2
+
+	compressed := append(append([]byte(nil), valid[:headerSize]...), 0xc0, 0x0c, 0, 1, 0, 1)
+
+	tests := []struct {
+		name   string
+		packet []byte
+	}{
+		{"too short", valid[:4]},
+		{"response", response},
+		{"two questions", twoQuestions},
+		{"truncated name", valid[:headerSize+3]},
+		{"truncated type", valid[:len(valid)-2]},
+		{"compressed name", compressed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseQuery(tt.packet); err == nil {
+				t.Error("Expected parse to fail")
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	m, _ := parseQuery(query(7, "web.service.clip.", typeSRV))
+	target := []string{"node1", "node", "clip"}
+	m.answers = []record{{name: m.question.labels, rtype: typeSRV, data: srvData(8080, target)}}
+	m.additional = []record{{name: target, rtype: typeA, data: []byte{10, 0, 0, 1}}}
+
+	packet := m.encode(rcodeSuccess, maxUDPSize)
+	flags := binary.BigEndian.Uint16(packet[2:4])
+	if binary.BigEndian.Uint16(packet[0:2]) != 7 {
+		t.Error("Expected the response to carry the query ID")
+	}
+	if flags&flagResponse == 0 || flags&flagAuthoritative == 0 || flags&flagRecursion == 0 || flags&flagTruncated != 0 {
+		t.Errorf("Unexpected flags: %x", flags)
+	}
+	if an, ar := binary.BigEndian.Uint16(packet[6:8]), binary.BigEndian.Uint16(packet[10:12]); an != 1 || ar != 1 {
+		t.Errorf("Expected 1 answer and 1 additional record, got %d and %d", an, ar)
+	}
+
+	// The SRV data is priority, weight, port and the uncompressed target
+	srv := srvData(8080, target)
+	if binary.BigEndian.Uint16(srv[4:6]) != 8080 || string(srv[6:]) != "\x05node1\x04node\x04clip\x00" {
+		t.Errorf("Unexpected SRV data: %q", srv)
+	}
+
+	if rcode := binary.BigEndian.Uint16(m.encode(rcodeNameError, maxUDPSize)[2:4]) & 0xf; rcode != rcodeNameError {
+		t.Errorf("Expected rcode %d, got %d", rcodeNameError, rcode)
+	}
+}
+
+func TestEncode_Truncated(t *testing.T) {
+	m, _ := parseQuery(query(1, "web.service.clip.", typeSRV))
+	for i := 0; i < 50; i++ {
+		target := []string{fmt.Sprintf("node-%d", i), "node", "clip"}
+		m.answers = append(m.answers, record{name: m.question.labels, rtype: typeSRV, data: srvData(80, target)})
+		m.additional = append(m.additional, record{name: target, rtype: typeA, data: []byte{10, 0, 0, byte(i)}})
+	}
+
+	packet := m.encode(rcodeSuccess, maxUDPSize)
+	if len(packet) > maxUDPSize {
+		t.Errorf("Expected the response to fit in %d bytes, got %d", maxUDPSize, len(packet))
+	}
+	if binary.BigEndian.Uint16(packet[2:4])&flagTruncated == 0 {
+		t.Error("Expected the response to be marked truncated")
+	}
+	if ar := binary.BigEndian.Uint16(packet[10:12]); ar != 0 {
+		t.Errorf("Expected no additional records in a truncated response, got %d", ar)
+	}
+
+	if full := m.encode(rcodeSuccess, 65535); binary.BigEndian.Uint16(full[6:8]) != 50 {
+		t.Error("Expected all answers without a size limit")
+	}
+}
@@ -0,0 +1,262 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+// Domain is the zone the server answers for. Members resolve as
+// <node-id>.node.clip. and services as <service>.service.clip. or, in RFC
+// 2782 form, _<service>._tcp.service.clip.
+const Domain = "clip"
+
+// tcpIdleTimeout is how long a TCP connection may sit between queries
+const tcpIdleTimeout = 10 * time.Second
+
+// Server answers DNS queries for cluster members and their services over UDP
+// and TCP. Answers are built from the current state on every query and are
+// not cached, so they carry a TTL of 0.
+type Server struct {
+	state func() []*peer.Peer
+	udp   *net.UDPConn
+	tcp   *net.TCPListener
+}
+
+// NewServer binds UDP and TCP sockets on bindAddress:port. Queries are
+// answered from the peers state returns once Start is called. With port 0
+// both sockets share the port picked for UDP.
+func NewServer(bindAddress string, port int, state func() []*peer.Peer) (*Server, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(bindAddress, fmt.Sprint(port)))
+	if err != nil {
+		return nil, err
+	}
+	udp, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	tcpAddr := &net.TCPAddr{IP: udpAddr.IP, Port: udp.LocalAddr().(*net.UDPAddr).Port}
+	tcp, err := net.ListenTCP("tcp", tcpAddr)
+	if err != nil {
+		udp.Close()
+		return nil, err
+	}
+
+	return &Server{state: state, udp: udp, tcp: tcp}, nil
+}
+
+// Start starts answering queries
+func (s *Server) Start() {
+	go s.serveUDP()
+	go s.serveTCP()
+}
+
+// Close closes both sockets, which also stops serving
+func (s *Server) Close() error {
+	return errors.Join(s.udp.Close(), s.tcp.Close())
+}
+
+// LocalAddr returns the address the server is bound to
+func (s *Server) LocalAddr() *net.UDPAddr {
+	return s.udp.LocalAddr().(*net.UDPAddr)
+}
+
+// serveUDP answers datagrams until the socket is closed
+func (s *Server) serveUDP() {
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := s.udp.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if response := s.handle(buf[:n], maxUDPSize); response != nil {
+			s.udp.WriteToUDP(response, from)
+		}
+	}
+}
+
+// serveTCP accepts connections until the listener is closed
+func (s *Server) serveTCP() {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn answers length-prefixed queries on a TCP connection until the
+// client closes it or goes idle
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		conn.SetDeadline(time.Now().Add(tcpIdleTimeout))
+
+		var size uint16
+		if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+			return
+		}
+		query := make([]byte, size)
+		if _, err := io.ReadFull(conn, query); err != nil {
+			return
+		}
+
+		response := s.handle(query, 65535)
+		if response == nil {
+			return
+		}
+		framed := binary.BigEndian.AppendUint16(nil, uint16(len(response)))
+		if _, err := conn.Write(append(framed, response...)); err != nil {
+			return
+		}
+	}
+}
+
+// handle answers one query, or returns nil if it can't be answered at all
+func (s *Server) handle(packet []byte, maxSize int) []byte {
+	m, err := parseQuery(packet)
+	switch {
+	case errors.Is(err, errNotQuery):
+		return nil
+	case err != nil && len(packet) < headerSize:
+		return nil
+	case err != nil:
+		log.Printf("Invalid DNS query: %v", err)
+		return m.encode(rcodeFormatError, maxSize)
+	case m.opcode() != 0:
+		return m.encode(rcodeNotImplemented, maxSize)
+	}
+
+	rcode := s.answer(&m)
+	return m.encode(rcode, maxSize)
+}
+
+// answer fills in the records for the question and returns the response code
+func (s *Server) answer(m *message) int {
+	q := m.question
+	if q.qclass != classIN && q.qclass != classANY {
+		return rcodeNotImplemented
+	}
+
+	labels := q.labels
+	n := len(labels)
+	if n < 3 || !strings.EqualFold(labels[n-1], Domain) {
+		return rcodeRefused
+	}
+
+	switch strings.ToLower(labels[n-2]) {
+	case "node":
+		p := s.node(strings.Join(labels[:n-2], "."))
+		if p == nil {
+			return rcodeNameError
+		}
+		if r, ok := addressRecord(q.labels, p); ok && wants(q.qtype, r.rtype) {
+			m.answers = append(m.answers, r)
+		}
+		return rcodeSuccess
+
+	case "service":
+		name := serviceName(labels[:n-2])
+		instances := peer.ServiceInstances(s.state(), name)
+		if len(instances) == 0 {
+			return rcodeNameError
+		}
+		s.answerService(m, instances)
+		return rcodeSuccess
+	}
+	return rcodeNameError
+}
+
+// answerService adds an address record per node for A and AAAA queries, and
+// an SRV record per instance with the node addresses as additional records
+// for SRV queries
+func (s *Server) answerService(m *message, instances []peer.Instance) {
+	q := m.question
+	seen := make(map[string]bool)
+	for _, inst := range instances {
+		if wants(q.qtype, typeSRV) {
+			if target, ok := nodeName(inst.Node.ID); ok {
+				m.answers = append(m.answers, record{name: q.labels, rtype: typeSRV, data: srvData(inst.Service.Port, target)})
+				if r, ok := addressRecord(target, inst.Node); ok && !seen[inst.Node.ID] {
+					m.additional = append(m.additional, r)
+				}
+			}
+		} else if r, ok := addressRecord(q.labels, inst.Node); ok && wants(q.qtype, r.rtype) && !seen[inst.Node.ID] {
+			m.answers = append(m.answers, r)
+		}
+		seen[inst.Node.ID] = true
+	}
+}
+
+// node returns the alive member with the given ID, ignoring case as DNS does
+func (s *Server) node(id string) *peer.Peer {
+	for _, p := range s.state() {
+		if p.State == peer.StateAlive && strings.EqualFold(p.ID, id) {
+			return p
+		}
+	}
+	return nil
+}
+
+// serviceName returns the service a name asks about, given the labels before
+// "service": either <service> or _<service>._tcp
+func serviceName(labels []string) string {
+	if len(labels) == 2 && strings.HasPrefix(labels[0], "_") && strings.EqualFold(labels[1], "_tcp") {
+		return strings.TrimPrefix(labels[0], "_")
+	}
+	return strings.Join(labels, ".")
+}
+
+// nodeName returns the labels of <id>.node.clip., or false if the ID can't
+// be written as a DNS name
+func nodeName(id string) ([]string, bool) {
+	labels := strings.Split(id, ".")
+	for _, label := range labels {
+		if label == "" || len(label) > maxLabelSize {
+			return nil, false
+		}
+	}
+	return append(labels, "node", Domain), true
+}
+
+// addressRecord returns an A or AAAA record for the IP a member advertises.
+// Members that advertise a hostname rather than an IP have no record.
+func addressRecord(name []string, p *peer.Peer) (record, bool) {
+	u, err := url.Parse(p.Address)
+	if err != nil {
+		return record{}, false
+	}
+
+	ip := net.ParseIP(u.Hostname())
+	switch {
+	case ip == nil:
+		return record{}, false
+	case ip.To4() != nil:
+		return record{name: name, rtype: typeA, data: ip.To4()}, true
+	default:
+		return record{name: name, rtype: typeAAAA, data: ip.To16()}, true
+	}
+}
+
+// wants reports whether a query of type qtype is answered by records of
+// type rtype
+func wants(qtype, rtype uint16) bool {
+	return qtype == rtype || qtype == typeANY
+}
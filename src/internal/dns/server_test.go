@@ -0,0 +1,168 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+func testPeers() []*peer.Peer {
+	return []*peer.Peer{
+		{ID: "node1", Address: "http://10.0.0.1:8080", State: peer.StateAlive, Services: []peer.Service{
+			{ID: "web", Name: "web", Port: 80},
+			{ID: "web-admin", Name: "web", Port: 8000},
+		}},
+		{ID: "node2", Address: "https://10.0.0.2:8080", State: peer.StateAlive, Services: []peer.Service{
+			{ID: "web", Name: "web", Port: 81},
+			{ID: "db", Name: "db", Port: 5432, Health: peer.HealthCritical},
+		}},
+		{ID: "node3", Address: "http://10.0.0.3:8080", State: peer.StateDead, Services: []peer.Service{
+			{ID: "web", Name: "web", Port: 82},
+		}},
+		{ID: "node6", Address: "http://[fd00::6]:8080", State: peer.StateAlive},
+		{ID: "named", Address: "http://localhost:8080", State: peer.StateAlive},
+	}
+}
+
+// newTestServer starts a server on a random port and returns a resolver
+// that sends its queries there over network
+func newTestServer(t *testing.T, network string) *net.Resolver {
+	t.Helper()
+	s, err := NewServer("127.0.0.1", 0, testPeers)
+	if err != nil {
+		t.Fatalf("Failed to create DNS server: %v", err)
+	}
+	s.Start()
+	t.Cleanup(func() { s.Close() })
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, s.LocalAddr().String())
+		},
+	}
+}
+
+func lookupContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestServer_Node(t *testing.T) {
+	for _, network := range []string{"udp", "tcp"} {
+		t.Run(network, func(t *testing.T) {
+			r := newTestServer(t, network)
+			ctx := lookupContext(t)
+
+			addrs, err := r.LookupHost(ctx, "node1.node.clip.")
+			if err != nil {
+				t.Fatalf("Expected lookup to succeed, got: %v", err)
+			}
+			if len(addrs) != 1 || addrs[0] != "10.0.0.1" {
+				t.Errorf("Expected 10.0.0.1, got %v", addrs)
+			}
+
+			if addrs, err := r.LookupHost(ctx, "NODE6.node.clip."); err != nil || len(addrs) != 1 || addrs[0] != "fd00::6" {
+				t.Errorf("Expected an IPv6 address for node6, got %v, %v", addrs, err)
+			}
+
+			for _, name := range []string{"node3.node.clip.", "missing.node.clip."} {
+				if _, err := r.LookupHost(ctx, name); err == nil {
+					t.Errorf("Expected %s not to resolve", name)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_Service(t *testing.T) {
+	for _, network := range []string{"udp", "tcp"} {
+		t.Run(network, func(t *testing.T) {
+			r := newTestServer(t, network)
+			ctx := lookupContext(t)
+
+			addrs, err := r.LookupHost(ctx, "web.service.clip.")
+			if err != nil {
+				t.Fatalf("Expected lookup to succeed, got: %v", err)
+			}
+			sort.Strings(addrs)
+			if len(addrs) != 2 || addrs[0] != "10.0.0.1" || addrs[1] != "10.0.0.2" {
+				t.Errorf("Expected one address per alive node, got %v", addrs)
+			}
+
+			_, srvs, err := r.LookupSRV(ctx, "web", "tcp", "service.clip.")
+			if err != nil {
+				t.Fatalf("Expected SRV lookup to succeed, got: %v", err)
+			}
+			got := make(map[string]bool)
+			for _, srv := range srvs {
+				got[fmt.Sprintf("%s:%d", srv.Target, srv.Port)] = true
+			}
+			if len(srvs) != 3 || !got["node1.node.clip.:80"] || !got["node1.node.clip.:8000"] || !got["node2.node.clip.:81"] {
+				t.Errorf("Expected an SRV record per healthy instance, got %+v", srvs)
+			}
+
+			if _, srvs, err := r.LookupSRV(ctx, "", "", "web.service.clip."); err != nil || len(srvs) != 3 {
+				t.Errorf("Expected the plain service name to resolve too, got %v, %v", srvs, err)
+			}
+
+			// The only db instance is failing its health check
+			if _, err := r.LookupHost(ctx, "db.service.clip."); err == nil {
+				t.Error("Expected a service without healthy instances not to resolve")
+			}
+		})
+	}
+}
+
+func TestServer_Handle(t *testing.T) {
+	s := &Server{state: testPeers}
+
+	rcode := func(packet []byte) int {
+		return int(binary.BigEndian.Uint16(packet[2:4]) & 0xf)
+	}
+
+	if got := rcode(s.handle(query(1, "node1.node.clip.", typeA), maxUDPSize)); got != rcodeSuccess {
+		t.Errorf("Expected success, got rcode %d", got)
+	}
+	if got := rcode(s.handle(query(1, "example.com.", typeA), maxUDPSize)); got != rcodeRefused {
+		t.Errorf("Expected names outside the domain to be refused, got rcode %d", got)
+	}
+	if got := rcode(s.handle(query(1, "node1.other.clip.", typeA), maxUDPSize)); got != rcodeNameError {
+		t.Errorf("Expected an unknown name to be NXDOMAIN, got rcode %d", got)
+	}
+
+	notify := query(1, "node1.node.clip.", typeA)
+	notify[2] |= 4 << 3
+	if got := rcode(s.handle(notify, maxUDPSize)); got != rcodeNotImplemented {
+		t.Errorf("Expected other opcodes not to be implemented, got rcode %d", got)
+	}
+
+	malformed := query(1, "node1.node.clip.", typeA)[:headerSize+2]
+	formErr := s.handle(malformed, maxUDPSize)
+	if got := rcode(formErr); got != rcodeFormatError {
+		t.Errorf("Expected a malformed query to be a format error, got rcode %d", got)
+	}
+	if len(formErr) != headerSize || binary.BigEndian.Uint16(formErr[4:6]) != 0 {
+		t.Errorf("Expected a format error to carry no question, got %d bytes", len(formErr))
+	}
+
+	response := query(1, "node1.node.clip.", typeA)
+	response[2] |= 0x80
+	if s.handle(response, maxUDPSize) != nil {
+		t.Error("Expected responses not to be answered")
+	}
+
+	// A member that advertises a hostname exists but has no address
+	packet := s.handle(query(1, "named.node.clip.", typeA), maxUDPSize)
+	if rcode(packet) != rcodeSuccess || binary.BigEndian.Uint16(packet[6:8]) != 0 {
+		t.Error("Expected no answers for a member without an IP")
+	}
+}
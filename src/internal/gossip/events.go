@@ -0,0 +1,12 @@
+package gossip
+
+import "github.com/rokzabukovec/clip/internal/peer"
+
+// NewEventQueue creates a queue of user events waiting to be disseminated.
+// Like rumors, each event is handed out a limited number of times and then
+// dropped; a copy of a queued event keeps its retransmit count.
+func NewEventQueue(retransmitMult int) *Queue[*peer.UserEvent] {
+	return NewQueue(retransmitMult,
+		func(e *peer.UserEvent) string { return e.ID },
+		func(e, queued *peer.UserEvent) bool { return false })
+}
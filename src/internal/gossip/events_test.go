@@ -0,0 +1,32 @@
+package gossip
+
+import (
+	"testing"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+func TestEventQueue_Next(t *testing.T) {
+	q := NewEventQueue(1)
+
+	if events := q.Next(3); len(events) != 0 {
+		t.Errorf("Expected no events from empty queue, got %d", len(events))
+	}
+
+	q.Enqueue(&peer.UserEvent{ID: "a1", Name: "deploy", LTime: 1})
+	q.Enqueue(&peer.UserEvent{ID: "b2", Name: "flush-cache", LTime: 2})
+	// A copy of a queued event doesn't reset its count
+	q.Enqueue(&peer.UserEvent{ID: "a1", Name: "deploy", LTime: 1})
+
+	if q.Len() != 2 {
+		t.Fatalf("Expected 2 queued events, got %d", q.Len())
+	}
+
+	// With 3 nodes the limit is 1 * ceil(log10(4)) = 1 transmission
+	if events := q.Next(3); len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+	if q.Len() != 0 {
+		t.Errorf("Expected events to be dropped after reaching the limit, got %d", q.Len())
+	}
+}
@@ -0,0 +1,13 @@
+package gossip
+
+import "github.com/rokzabukovec/clip/internal/kv"
+
+// NewKVQueue creates a queue of key/value writes waiting to be
+// disseminated. Like rumors, each write is handed out a limited number of
+// times and then dropped; a newer write to a queued key replaces it and
+// starts over.
+func NewKVQueue(retransmitMult int) *Queue[*kv.Entry] {
+	return NewQueue(retransmitMult,
+		func(e *kv.Entry) string { return e.Key },
+		func(e, queued *kv.Entry) bool { return e.Digest().Supersedes(queued.Digest()) })
+}
@@ -0,0 +1,38 @@
+package gossip
+
+import (
+	"testing"
+
+	"github.com/rokzabukovec/clip/internal/kv"
+)
+
+func TestKVQueue_Next(t *testing.T) {
+	q := NewKVQueue(1)
+
+	if entries := q.Next(3); len(entries) != 0 {
+		t.Errorf("Expected no entries from empty queue, got %d", len(entries))
+	}
+
+	q.Enqueue(&kv.Entry{Key: "flag", Value: []byte("on"), LTime: 2, Node: "node1"})
+	q.Enqueue(&kv.Entry{Key: "config/web", Value: []byte("8080"), LTime: 3, Node: "node1"})
+	// An older write doesn't replace the queued one
+	q.Enqueue(&kv.Entry{Key: "flag", Value: []byte("off"), LTime: 1, Node: "node2"})
+
+	if q.Len() != 2 {
+		t.Fatalf("Expected 2 queued entries, got %d", q.Len())
+	}
+
+	// With 3 nodes the limit is 1 * ceil(log10(4)) = 1 transmission
+	entries := q.Next(3)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	for _, e := range entries {
+		if e.Key == "flag" && string(e.Value) != "on" {
+			t.Errorf("Expected the newer write to stay queued, got %q", e.Value)
+		}
+	}
+	if q.Len() != 0 {
+		t.Errorf("Expected entries to be dropped after reaching the limit, got %d", q.Len())
+	}
+}
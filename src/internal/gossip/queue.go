@@ -0,0 +1,107 @@
+package gossip
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+// queued is a queued item and how often it has been sent
+type queued[T any] struct {
+	item      T
+	transmits int
+}
+
+// Queue holds items waiting to be disseminated, such as membership
+// changes. Each item is handed out a limited number of times, scaled by the
+// log of the cluster size, and then dropped. Items are keyed, so at most
+// one item per key is queued.
+type Queue[T any] struct {
+	mu             sync.Mutex
+	items          map[string]*queued[T]
+	retransmitMult int
+	key            func(item T) string
+	replaces       func(item, queued T) bool
+}
+
+// NewQueue creates a queue that retransmits each item
+// retransmitMult * log(n) times. An item replaces a queued item with the
+// same key, and starts with a fresh retransmit count, if replaces reports so.
+func NewQueue[T any](retransmitMult int, key func(item T) string, replaces func(item, queued T) bool) *Queue[T] {
+	return &Queue[T]{
+		items:          make(map[string]*queued[T]),
+		retransmitMult: retransmitMult,
+		key:            key,
+		replaces:       replaces,
+	}
+}
+
+// NewBroadcastQueue creates a queue of membership changes. A newer rumor
+// about a peer always replaces the older one.
+func NewBroadcastQueue(retransmitMult int) *Queue[*peer.Peer] {
+	return NewQueue(retransmitMult,
+		func(p *peer.Peer) string { return p.ID },
+		func(p, queued *peer.Peer) bool { return true })
+}
+
+// Enqueue queues an item unless an item with the same key is queued that it
+// doesn't replace
+func (q *Queue[T]) Enqueue(item T) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	key := q.key(item)
+	if existing, ok := q.items[key]; ok && !q.replaces(item, existing.item) {
+		return
+	}
+	q.items[key] = &queued[T]{item: item}
+}
+
+// Next returns the items to send in the next gossip round, least
+// transmitted first, and drops those that reached the retransmit limit.
+// numNodes is the current cluster size including this node.
+func (q *Queue[T]) Next(numNodes int) []T {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items) == 0 {
+		return nil
+	}
+
+	pending := make([]*queued[T], 0, len(q.items))
+	for _, i := range q.items {
+		pending = append(pending, i)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].transmits < pending[j].transmits
+	})
+
+	limit := RetransmitLimit(q.retransmitMult, numNodes)
+	items := make([]T, 0, len(pending))
+	for _, i := range pending {
+		items = append(items, i.item)
+		i.transmits++
+		if i.transmits >= limit {
+			delete(q.items, q.key(i.item))
+		}
+	}
+	return items
+}
+
+// Len returns the number of queued items
+func (q *Queue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// RetransmitLimit returns how many times a rumor is sent in a cluster of
+// numNodes: mult * ceil(log10(numNodes + 1)), and at least once
+func RetransmitLimit(mult, numNodes int) int {
+	limit := mult * int(math.Ceil(math.Log10(float64(numNodes+1))))
+	if limit < 1 {
+		return 1
+	}
+	return limit
+}
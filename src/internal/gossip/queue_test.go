@@ -0,0 +1,69 @@
+package gossip
+
+import (
+	"testing"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+func TestRetransmitLimit(t *testing.T) {
+	tests := []struct {
+		mult     int
+		numNodes int
+		want     int
+	}{
+		{mult: 4, numNodes: 1, want: 4},
+		{mult: 4, numNodes: 9, want: 4},
+		{mult: 4, numNodes: 10, want: 8},
+		{mult: 4, numNodes: 150, want: 12},
+		{mult: 0, numNodes: 10, want: 1},
+	}
+
+	for _, tt := range tests {
+		if got := RetransmitLimit(tt.mult, tt.numNodes); got != tt.want {
+			t.Errorf("RetransmitLimit(%d, %d) = %d, want %d", tt.mult, tt.numNodes, got, tt.want)
+		}
+	}
+}
+
+func TestBroadcastQueue_Next(t *testing.T) {
+	q := NewBroadcastQueue(2)
+
+	if rumors := q.Next(3); len(rumors) != 0 {
+		t.Errorf("Expected no rumors from empty queue, got %d", len(rumors))
+	}
+
+	q.Enqueue(&peer.Peer{ID: "peer1", State: peer.StateSuspect})
+	q.Enqueue(&peer.Peer{ID: "peer2", State: peer.StateAlive})
+
+	// With 3 nodes the limit is 2 * ceil(log10(4)) = 2 transmissions
+	if rumors := q.Next(3); len(rumors) != 2 {
+		t.Fatalf("Expected 2 rumors, got %d", len(rumors))
+	}
+	if q.Len() != 2 {
+		t.Errorf("Expected rumors to be kept after first transmission, got %d", q.Len())
+	}
+
+	if rumors := q.Next(3); len(rumors) != 2 {
+		t.Fatalf("Expected 2 rumors on retransmission, got %d", len(rumors))
+	}
+	if q.Len() != 0 {
+		t.Errorf("Expected rumors to be dropped after reaching the limit, got %d", q.Len())
+	}
+}
+
+func TestBroadcastQueue_EnqueueReplaces(t *testing.T) {
+	q := NewBroadcastQueue(1)
+
+	q.Enqueue(&peer.Peer{ID: "peer1", State: peer.StateSuspect, Incarnation: 1})
+	q.Enqueue(&peer.Peer{ID: "peer1", State: peer.StateAlive, Incarnation: 2})
+
+	if q.Len() != 1 {
+		t.Fatalf("Expected newer rumor to replace older one, got %d rumors", q.Len())
+	}
+
+	rumors := q.Next(1)
+	if len(rumors) != 1 || rumors[0].Incarnation != 2 {
+		t.Errorf("Expected only the newer rumor, got %v", rumors)
+	}
+}
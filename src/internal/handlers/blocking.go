@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Blocking queries hold a request until the data it reads changes. The
+// client passes the index from the X-Clip-Index header of its last response
+// as ?index=, and optionally how long to wait as ?wait=.
+const (
+	indexHeader = "X-Clip-Index"
+
+	defaultWait = 5 * time.Minute
+	maxWait     = 10 * time.Minute
+)
+
+// blockingQuery is the index and wait time of a blocking query
+type blockingQuery struct {
+	index uint64
+	wait  time.Duration
+}
+
+// parseBlockingQuery reads the index and wait parameters. It returns nil
+// if the request doesn't ask to block.
+func parseBlockingQuery(r *http.Request) (*blockingQuery, error) {
+	query := r.URL.Query()
+	if !query.Has("index") {
+		return nil, nil
+	}
+
+	index, err := strconv.ParseUint(query.Get("index"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid index: %s", query.Get("index"))
+	}
+
+	wait := defaultWait
+	if w := query.Get("wait"); w != "" {
+		if wait, err = time.ParseDuration(w); err != nil || wait <= 0 {
+			return nil, fmt.Errorf("invalid wait: %s", w)
+		}
+	}
+	if wait > maxWait {
+		wait = maxWait
+	}
+	return &blockingQuery{index: index, wait: wait}, nil
+}
+
+// block waits with waitFn until the index moves past the query's index,
+// the wait times out, or the client goes away, and returns the index
+func (q *blockingQuery) block(r *http.Request, waitFn func(ctx context.Context, index uint64) uint64) uint64 {
+	ctx, cancel := context.WithTimeout(r.Context(), q.wait)
+	defer cancel()
+	return waitFn(ctx, q.index)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+func TestHandler_HandlePeersBlocking(t *testing.T) {
+	peerList := peer.NewPeerList()
+	h := NewHandler(peerList, "test-service", nil)
+	peerList.Add(&peer.Peer{ID: "peer1", Address: "http://192.168.1.101:8080"})
+
+	// Without an index the request returns right away with the index
+	w := httptest.NewRecorder()
+	h.HandlePeers(w, httptest.NewRequest(http.MethodGet, "/peers", nil))
+	index := w.Header().Get(indexHeader)
+	if index != strconv.FormatUint(peerList.Index(), 10) {
+		t.Fatalf("Expected index %d, got %q", peerList.Index(), index)
+	}
+
+	// An unchanged list holds the request until the wait runs out
+	start := time.Now()
+	w = httptest.NewRecorder()
+	h.HandlePeers(w, httptest.NewRequest(http.MethodGet, "/peers?index="+index+"&wait=50ms", nil))
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the request to block for the wait time, returned after %v", elapsed)
+	}
+	if w.Code != http.StatusOK || w.Header().Get(indexHeader) != index {
+		t.Errorf("Expected the same index after a timeout, got %d %q", w.Code, w.Header().Get(indexHeader))
+	}
+
+	// A change releases the request
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		h.HandlePeers(w, httptest.NewRequest(http.MethodGet, "/peers?index="+index+"&wait=5s", nil))
+		done <- w
+	}()
+	time.Sleep(20 * time.Millisecond)
+	peerList.Add(&peer.Peer{ID: "peer2", Address: "http://192.168.1.102:8080"})
+
+	select {
+	case w := <-done:
+		if w.Header().Get(indexHeader) == index {
+			t.Error("Expected a new index after the change")
+		}
+		var peers []*peer.Peer
+		json.NewDecoder(w.Body).Decode(&peers)
+		if len(peers) != 2 {
+			t.Errorf("Expected the changed list, got %d peers", len(peers))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the change to release the blocked request")
+	}
+}
+
+func TestParseBlockingQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantNil bool
+		wait    time.Duration
+		wantErr bool
+	}{
+		{"", true, 0, false},
+		{"?index=5", false, defaultWait, false},
+		{"?index=5&wait=30s", false, 30 * time.Second, false},
+		{"?index=5&wait=1h", false, maxWait, false},
+		{"?index=x", false, 0, true},
+		{"?index=5&wait=soon", false, 0, true},
+		{"?index=5&wait=-1s", false, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := parseBlockingQuery(httptest.NewRequest(http.MethodGet, "/peers"+tt.query, nil))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBlockingQuery() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (q == nil) != tt.wantNil {
+				t.Fatalf("Expected nil query: %v, got %+v", tt.wantNil, q)
+			}
+			if q != nil && (q.index != 5 || q.wait != tt.wait) {
+				t.Errorf("Expected index 5 and wait %v, got %+v", tt.wait, q)
+			}
+		})
+	}
+}
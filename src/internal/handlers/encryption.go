@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/rokzabukovec/clip/internal/keyring"
+	"github.com/rokzabukovec/clip/internal/transport"
+)
+
+// SetKeyring requires peer-to-peer requests to be encrypted with the
+// cluster keyring and encrypts the responses to them
+func (h *Handler) SetKeyring(k *keyring.Keyring) {
+	h.keyring = k
+}
+
+// encrypted wraps a peer-to-peer endpoint so that it only accepts request
+// bodies that decrypt with the keyring, and encrypts whatever it answers.
+// Requests that fail to decrypt are dropped and counted by the keyring.
+func (h *Handler) encrypted(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.keyring == nil {
+			next(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		plaintext, err := h.keyring.Decrypt(body)
+		if err != nil {
+			log.Printf("Dropping %s request from %s: %v", r.URL.Path, r.RemoteAddr, err)
+			http.Error(w, "Message authentication failed", http.StatusForbidden)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(plaintext))
+
+		resp := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		next(resp, r)
+
+		out, err := h.keyring.Encrypt(resp.body.Bytes())
+		if err != nil {
+			http.Error(w, "Failed to encrypt response", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", transport.EncryptedContentType)
+		w.WriteHeader(resp.status)
+		w.Write(out)
+	}
+}
+
+// bufferedResponse collects a response so it can be encrypted as a whole
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	return b.body.Write(data)
+}
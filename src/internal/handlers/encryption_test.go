@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rokzabukovec/clip/internal/keyring"
+	"github.com/rokzabukovec/clip/internal/peer"
+	"github.com/rokzabukovec/clip/internal/transport"
+)
+
+func newEncryptedHandler(t *testing.T) (*Handler, *keyring.Keyring, http.Handler) {
+	t.Helper()
+	k, err := keyring.New(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("Failed to create keyring: %v", err)
+	}
+	h := NewHandler(peer.NewPeerList(), "test-service", nil)
+	h.SetKeyring(k)
+	return h, k, h.SetupRoutes()
+}
+
+func TestHandler_EncryptedJoin(t *testing.T) {
+	h, k, mux := newEncryptedHandler(t)
+
+	data, _ := json.Marshal(&peer.Peer{ID: "new-peer", Address: "http://192.168.1.101:8080"})
+	body, _ := k.Encrypt(data)
+	req := httptest.NewRequest(http.MethodPost, "/join", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != transport.EncryptedContentType {
+		t.Errorf("Expected encrypted content type, got %q", ct)
+	}
+
+	plaintext, err := k.Decrypt(w.Body.Bytes())
+	if err != nil {
+		t.Fatalf("Expected response to decrypt, got: %v", err)
+	}
+	var peers []*peer.Peer
+	if err := json.Unmarshal(plaintext, &peers); err != nil {
+		t.Errorf("Expected a peer list in the response, got: %v", err)
+	}
+	if !h.peerList.Exists("new-peer") {
+		t.Error("Expected encrypted join to add the peer")
+	}
+}
+
+func TestHandler_EncryptedRejectsPlaintext(t *testing.T) {
+	h, k, mux := newEncryptedHandler(t)
+
+	other, _ := keyring.New(bytes.Repeat([]byte{2}, 32))
+	data, _ := json.Marshal([]*peer.Peer{{ID: "fake-peer", Address: "http://10.0.0.66:8080", State: peer.StateAlive}})
+	wrongKey, _ := other.Encrypt(data)
+
+	for name, body := range map[string][]byte{"plaintext": data, "wrong key": wrongKey} {
+		for _, path := range []string{"/broadcast", "/gossip", "/join", "/heartbeat"} {
+			req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != http.StatusForbidden {
+				t.Errorf("%s %s: expected status 403, got %d", name, path, w.Code)
+			}
+		}
+	}
+
+	if h.peerList.Count() != 0 {
+		t.Errorf("Expected no peers to be injected, got %d", h.peerList.Count())
+	}
+	if k.Failures() != 8 {
+		t.Errorf("Expected 8 dropped messages, got %d", k.Failures())
+	}
+
+	// The API stays readable and reports the drops
+	req := httptest.NewRequest(http.MethodGet, "/status", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	var status map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&status)
+	if status["dropped_messages"] != float64(8) {
+		t.Errorf("Expected dropped_messages to be 8, got %v", status["dropped_messages"])
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+// eventKeepAlive is how often an idle event stream sends a comment, so
+// clients and proxies don't time the connection out
+const eventKeepAlive = 15 * time.Second
+
+// HandleEvents streams membership events as Server-Sent Events, or with
+// ?user the user events, which have their own IDs and history. A client
+// that reconnects with the Last-Event-ID header first receives the events
+// it missed that are still in the history. The stream ends if the client
+// falls too far behind; it can reconnect to catch up.
+func (h *Handler) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	bus := h.peerList.Events()
+	if r.URL.Query().Has("user") {
+		if h.userEvents == nil {
+			http.Error(w, "User events are not supported", http.StatusNotImplemented)
+			return
+		}
+		bus = h.userEvents.Events()
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	var lastID uint64
+	resume := r.Header.Get("Last-Event-ID")
+	if resume != "" {
+		id, err := strconv.ParseUint(resume, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		lastID = id
+	}
+
+	replay, events, cancel := bus.Subscribe(lastID)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	// Without Last-Event-ID the stream starts with the next event
+	if resume != "" {
+		for _, event := range replay {
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes one event in the Server-Sent Events format
+func writeEvent(w http.ResponseWriter, event peer.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+// sseEvent is an event as read off the stream
+type sseEvent struct {
+	id    string
+	event string
+	data  string
+}
+
+// readEvents reads n events from an SSE stream, skipping comments
+func readEvents(t *testing.T, r *bufio.Reader, n int) []sseEvent {
+	t.Helper()
+	events := make([]sseEvent, 0, n)
+	var current sseEvent
+	for len(events) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if current.event != "" {
+				events = append(events, current)
+			}
+			current = sseEvent{}
+		case strings.HasPrefix(line, "id: "):
+			current.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			current.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			current.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	return events
+}
+
+// openEvents opens the event stream at url, resuming after lastID if it is
+// set
+func openEvents(t *testing.T, url, lastID string) *bufio.Reader {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+func TestHandler_HandleEvents(t *testing.T) {
+	peerList := peer.NewPeerList()
+	h := NewHandler(peerList, "test-service", nil)
+	server := httptest.NewServer(h.SetupRoutes())
+	// Registered before the stream is opened, so the stream is closed first
+	t.Cleanup(server.Close)
+
+	peerList.Add(&peer.Peer{ID: "before", Address: "http://192.168.1.100:8080"})
+
+	stream := openEvents(t, server.URL+"/v1/events", "")
+	peerList.Add(&peer.Peer{ID: "peer1", Address: "http://192.168.1.101:8080"})
+	peerList.MarkSuspect("peer1", 0)
+
+	events := readEvents(t, stream, 2)
+	if events[0].id != "2" || events[0].event != "member-join" || events[1].id != "3" || events[1].event != "member-suspect" {
+		t.Errorf("Expected join and suspect events after the stream opened, got %+v", events)
+	}
+
+	var event peer.Event
+	if err := json.Unmarshal([]byte(events[0].data), &event); err != nil {
+		t.Fatalf("Failed to decode event data: %v", err)
+	}
+	if event.ID != 2 || event.Type != peer.EventJoin || event.Peer == nil || event.Peer.ID != "peer1" {
+		t.Errorf("Unexpected event data: %+v", event)
+	}
+}
+
+func TestHandler_HandleEventsResume(t *testing.T) {
+	peerList := peer.NewPeerList()
+	h := NewHandler(peerList, "test-service", nil)
+	server := httptest.NewServer(h.SetupRoutes())
+	t.Cleanup(server.Close)
+
+	peerList.Add(&peer.Peer{ID: "peer1", Address: "http://192.168.1.101:8080"})
+	peerList.MarkSuspect("peer1", 0)
+	peerList.MarkDead("peer1", 0)
+
+	stream := openEvents(t, server.URL+"/v1/events", "1")
+	events := readEvents(t, stream, 2)
+	if events[0].id != "2" || events[1].id != "3" || events[1].event != "member-failed" {
+		t.Errorf("Expected the missed events 2 and 3, got %+v", events)
+	}
+
+	// New events follow the replayed ones
+	done := make(chan []sseEvent)
+	go func() { done <- readEvents(t, stream, 1) }()
+	peerList.Merge(&peer.Peer{ID: "peer1", State: peer.StateLeft})
+	select {
+	case events := <-done:
+		if events[0].id != "4" || events[0].event != "member-leave" {
+			t.Errorf("Expected a leave event, got %+v", events)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a live event after the replay")
+	}
+}
+
+func TestHandler_HandleEventsUser(t *testing.T) {
+	h := newUserEventHandler()
+	server := httptest.NewServer(h.SetupRoutes())
+	t.Cleanup(server.Close)
+
+	members := openEvents(t, server.URL+"/v1/events", "")
+	users := openEvents(t, server.URL+"/v1/events?user", "")
+	h.userEvents.Fire(&peer.UserEvent{Name: "deploy"})
+	h.peerList.Add(&peer.Peer{ID: "peer1", Address: "http://192.168.1.101:8080"})
+
+	// User events have their own stream and IDs, and stay out of the
+	// membership stream and its history
+	if events := readEvents(t, users, 1); events[0].id != "1" || events[0].event != "user" {
+		t.Errorf("Expected the user event on the user stream, got %+v", events)
+	}
+	if events := readEvents(t, members, 1); events[0].id != "1" || events[0].event != "member-join" {
+		t.Errorf("Expected only the join on the membership stream, got %+v", events)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/events?user", nil)
+	w := httptest.NewRecorder()
+	NewHandler(peer.NewPeerList(), "test-service", nil).HandleEvents(w, req)
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status 501 without user events, got %d", w.Code)
+	}
+}
+
+func TestHandler_HandleEventsInvalid(t *testing.T) {
+	h := NewHandler(peer.NewPeerList(), "test-service", nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/events", nil)
+	req.Header.Set("Last-Event-ID", "abc")
+	w := httptest.NewRecorder()
+	h.HandleEvents(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/events", nil)
+	w = httptest.NewRecorder()
+	h.HandleEvents(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", w.Code)
+	}
+}
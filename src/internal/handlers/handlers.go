@@ -2,17 +2,48 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
 
+	"github.com/rokzabukovec/clip/internal/health"
+	"github.com/rokzabukovec/clip/internal/hooks"
+	"github.com/rokzabukovec/clip/internal/keyring"
+	"github.com/rokzabukovec/clip/internal/kv"
 	"github.com/rokzabukovec/clip/internal/peer"
+	"github.com/rokzabukovec/clip/internal/transport"
+	"github.com/rokzabukovec/clip/internal/userevent"
+	"github.com/rokzabukovec/clip/internal/webhook"
 )
 
+// errNoProbe is returned for indirect probe requests when no probe function is set
+var errNoProbe = errors.New("indirect probes not supported")
+
+// errClusterMismatch is returned for messages from peers in another cluster
+var errClusterMismatch = errors.New("cluster name mismatch")
+
+// ProbeFunc probes the peer at the given address and returns nil if it answered
+type ProbeFunc func(address string) error
+
 // Handler holds dependencies for HTTP handlers
 type Handler struct {
 	peerList   *peer.PeerList
 	serviceID  string
 	onPeerJoin func(peer *peer.Peer)
+	probe      ProbeFunc
+	local      *peer.LocalNode
+	keyring    *keyring.Keyring
+	cluster    string
+	checks     *health.Checker
+	webhooks   *webhook.Dispatcher
+	userEvents *userevent.Log
+	kv         *kv.Store
+
+	scriptChecks   bool
+	keyringFanout  KeyringFunc
+	queryFanout    QueryFunc
+	queryResponder *hooks.Responder
 }
 
 // NewHandler creates a new handler instance
@@ -24,6 +55,38 @@ func NewHandler(peerList *peer.PeerList, serviceID string, onPeerJoin func(peer
 	}
 }
 
+// SetProbeFunc sets the function used to answer indirect probe requests
+func (h *Handler) SetProbeFunc(probe ProbeFunc) {
+	h.probe = probe
+}
+
+// SetLocalNode sets the local membership record used to refute suspicions
+func (h *Handler) SetLocalNode(local *peer.LocalNode) {
+	h.local = local
+}
+
+// SetClusterName sets the cluster this node belongs to. Messages from peers
+// in other clusters are rejected.
+func (h *Handler) SetClusterName(name string) {
+	h.cluster = name
+}
+
+// SetWebhooks sets the dispatcher whose delivery status is reported in
+// /status
+func (h *Handler) SetWebhooks(d *webhook.Dispatcher) {
+	h.webhooks = d
+}
+
+// sameCluster reports whether a message for the given cluster is meant for
+// this node's cluster, and logs the rejected message if not
+func (h *Handler) sameCluster(message, cluster string) bool {
+	if cluster == h.cluster {
+		return true
+	}
+	log.Printf("Rejecting %s: cluster %q does not match %q", message, cluster, h.cluster)
+	return false
+}
+
 // HandleJoin handles join requests from new peers
 func (h *Handler) HandleJoin(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -37,20 +100,34 @@ func (h *Handler) HandleJoin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	peers, err := h.Join(&newPeer)
+	if err != nil {
+		http.Error(w, "Cluster name mismatch", http.StatusForbidden)
+		return
+	}
+
+	// Return our current peer list to the new peer
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(peers)
+}
+
+// Join adds a new peer and returns our full state for it to start from
+func (h *Handler) Join(newPeer *peer.Peer) ([]*peer.Peer, error) {
+	if !h.sameCluster("join from "+newPeer.ID, newPeer.Cluster) {
+		return nil, errClusterMismatch
+	}
+
 	log.Printf("New peer joining: %s at %s", newPeer.ID, newPeer.Address)
 
 	// Add the new peer to our list
-	h.peerList.Add(&newPeer)
+	h.peerList.Add(newPeer)
 
 	// Notify about the new peer
 	if h.onPeerJoin != nil {
-		h.onPeerJoin(&newPeer)
+		h.onPeerJoin(newPeer)
 	}
 
-	// Return our current peer list to the new peer
-	peers := h.peerList.GetAll()
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(peers)
+	return h.FullState(), nil
 }
 
 // HandleHeartbeat handles heartbeat messages from peers
@@ -60,71 +137,306 @@ func (h *Handler) HandleHeartbeat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var heartbeat map[string]string
+	var heartbeat peer.Peer
 	if err := json.NewDecoder(r.Body).Decode(&heartbeat); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	peerID := heartbeat["id"]
-	peerAddress := heartbeat["address"]
+	view, err := h.Heartbeat(&heartbeat)
+	if err != nil {
+		http.Error(w, "Cluster name mismatch", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(view)
+}
+
+// Heartbeat records a probe from a peer and returns our view of the sender,
+// which the ack carries so the sender can refute a suspicion
+func (h *Handler) Heartbeat(from *peer.Peer) (*peer.Peer, error) {
+	if !h.sameCluster("heartbeat from "+from.ID, from.Cluster) {
+		return nil, errClusterMismatch
+	}
+
+	// A heartbeat is the sender speaking for itself, so it is always alive
+	from.State = peer.StateAlive
+	exists := h.peerList.Exists(from.ID)
+	h.peerList.Merge(from)
+	h.peerList.UpdateLastSeen(from.ID)
+
+	if !exists {
+		log.Printf("Discovered new peer through heartbeat: %s at %s", from.ID, from.Address)
+	}
+
+	view, _ := h.peerList.Get(from.ID)
+	return view, nil
+}
+
+// HandlePingReq handles indirect probe requests. The target is probed from
+// this node and the result is reported back to the requester.
+func (h *Handler) HandlePingReq(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req transport.PingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TargetAddress == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.PingReq(req); err != nil {
+		if errors.Is(err, errNoProbe) {
+			http.Error(w, "Indirect probes not supported", http.StatusNotImplemented)
+			return
+		}
+		http.Error(w, "Target did not respond", http.StatusGatewayTimeout)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// PingReq probes a target on behalf of another peer and returns nil if the
+// target answered
+func (h *Handler) PingReq(req transport.PingRequest) error {
+	if h.probe == nil {
+		return errNoProbe
+	}
+
+	if err := h.probe(req.TargetAddress); err != nil {
+		log.Printf("Indirect probe of %s failed: %v", req.TargetID, err)
+		return err
+	}
+	return nil
+}
+
+// HandleLeave handles intent-to-leave messages from peers shutting down
+func (h *Handler) HandleLeave(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var leaving peer.Peer
+	if err := json.NewDecoder(r.Body).Decode(&leaving); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	h.Leave(&leaving)
+	w.WriteHeader(http.StatusOK)
+}
+
+// Leave marks a peer that announced it is shutting down as left
+func (h *Handler) Leave(leaving *peer.Peer) {
+	if !h.sameCluster("leave from "+leaving.ID, leaving.Cluster) {
+		return
+	}
+
+	leaving.State = peer.StateLeft
+	if h.peerList.Merge(leaving) {
+		log.Printf("Peer %s left the cluster", leaving.ID)
+	}
+}
 
-	// Update or add the peer
-	if _, exists := h.peerList.Get(peerID); exists {
-		h.peerList.UpdateLastSeen(peerID)
-	} else {
-		// Add new peer discovered through heartbeat
-		h.peerList.Add(&peer.Peer{
-			ID:      peerID,
-			Address: peerAddress,
-		})
-		log.Printf("Discovered new peer through heartbeat: %s at %s", peerID, peerAddress)
+// HandleBroadcast handles rumors about membership changes piggybacked on
+// gossip messages. Changes that are new to us are queued to be passed on.
+func (h *Handler) HandleBroadcast(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
+	var rumors []*peer.Peer
+	if err := json.NewDecoder(r.Body).Decode(&rumors); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	h.MergePeers(rumors)
 	w.WriteHeader(http.StatusOK)
 }
 
-// HandleGossip handles gossip messages containing peer information
+// HandleGossip handles push-pull state exchanges. The sender opens with a
+// digest of its state and gets back the records it is missing plus the IDs
+// of the records we want from it. Nodes that predate digests send their full
+// peer list as a JSON array; that is merged and answered with our full state.
 func (h *Handler) HandleGossip(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var peers []*peer.Peer
-	if err := json.NewDecoder(r.Body).Decode(&peers); err != nil {
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	// Merge the received peer list with ours
-	for _, peer := range peers {
-		if peer.ID != h.serviceID {
-			// Only add if we don't know about this peer or update if we do
-			if existing, exists := h.peerList.Get(peer.ID); exists {
-				// Update only if the received info is newer
-				if peer.LastSeen.After(existing.LastSeen) {
-					h.peerList.Add(peer)
-				}
-			} else {
-				h.peerList.Add(peer)
-				log.Printf("Discovered new peer through gossip: %s at %s", peer.ID, peer.Address)
+	if len(body) > 0 && body[0] == '[' {
+		var peers []*peer.Peer
+		if err := json.Unmarshal(body, &peers); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		h.MergePeers(peers)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.FullState())
+		return
+	}
+
+	var req transport.GossipRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := h.PushPull(req)
+	if err != nil {
+		http.Error(w, "Cluster name mismatch", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// PushPull compares a remote digest with our state and returns the records
+// the remote side is missing or has older copies of, and the IDs of records
+// it has newer copies of. Key/value entries are compared the same way.
+func (h *Handler) PushPull(req transport.GossipRequest) (transport.GossipResponse, error) {
+	if !h.sameCluster("push-pull", req.Cluster) {
+		return transport.GossipResponse{}, errClusterMismatch
+	}
+
+	remote := make(map[string]peer.Digest, len(req.Digest))
+	for _, d := range req.Digest {
+		remote[d.ID] = d
+	}
+
+	resp := transport.GossipResponse{
+		Peers: make([]*peer.Peer, 0),
+		Want:  make([]string, 0),
+	}
+
+	local := make(map[string]bool)
+	for _, p := range h.FullState() {
+		local[p.ID] = true
+		d, known := remote[p.ID]
+		if !known {
+			// Unknown dead or left peers would be ignored by the other side
+			if p.State == peer.StateAlive || p.State == peer.StateSuspect {
+				resp.Peers = append(resp.Peers, p)
 			}
+			continue
+		}
+		if p.Digest().Supersedes(d) {
+			resp.Peers = append(resp.Peers, p)
+		}
+		if d.Supersedes(p.Digest()) {
+			resp.Want = append(resp.Want, p.ID)
 		}
 	}
 
-	w.WriteHeader(http.StatusOK)
+	for _, d := range req.Digest {
+		if !local[d.ID] && (d.State == peer.StateAlive || d.State == peer.StateSuspect) {
+			resp.Want = append(resp.Want, d.ID)
+		}
+	}
+
+	if h.kv != nil {
+		resp.KV, resp.WantKV = h.kv.Diff(req.KV)
+	}
+
+	return resp, nil
 }
 
-// HandlePeers returns the list of all known peers
+// MergePeers merges a remote peer list into ours, refuting any rumor that
+// this node is suspect or dead. Records of peers in other clusters are
+// dropped.
+func (h *Handler) MergePeers(peers []*peer.Peer) {
+	for _, p := range peers {
+		if !h.sameCluster("gossip about "+p.ID, p.Cluster) {
+			continue
+		}
+
+		if p.ID == h.serviceID {
+			h.refute(p)
+			continue
+		}
+
+		exists := h.peerList.Exists(p.ID)
+		if h.peerList.Merge(p) {
+			if !exists {
+				log.Printf("Discovered new peer through gossip: %s at %s", p.ID, p.Address)
+			} else if p.State != peer.StateAlive && p.State != "" {
+				log.Printf("Peer %s is %s (incarnation %d) according to gossip", p.ID, p.State, p.Incarnation)
+			}
+		}
+	}
+}
+
+// FullState returns all known peers plus this node's own record
+func (h *Handler) FullState() []*peer.Peer {
+	peers := h.peerList.GetAll()
+	if h.local != nil {
+		peers = append(peers, h.local.Peer())
+	}
+	return peers
+}
+
+// refute answers a rumor that this node is suspect or dead by bumping its
+// incarnation; the next message we send carries the new incarnation
+func (h *Handler) refute(rumor *peer.Peer) {
+	if h.local == nil {
+		return
+	}
+
+	if incarnation, refuted := h.local.Refute(rumor); refuted {
+		log.Printf("Refuting %s rumor about this node, new incarnation: %d, version: %d", rumor.State, incarnation, h.local.Version())
+	}
+}
+
+// HandlePeers returns the list of all known peers. Repeated tag query
+// parameters narrow it down to peers that have all the given tags, e.g.
+// ?tag=role:worker&tag=zone:rack3; a tag without a value matches any value.
+// With ?index=N the request blocks until the peer list's modification index
+// moves past N or ?wait= runs out; the index is returned in X-Clip-Index.
 func (h *Handler) HandlePeers(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	peers := h.peerList.GetAll()
+	filters, err := parseTagFilters(r.URL.Query()["tag"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	blocking, err := parseBlockingQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	index := h.peerList.Index()
+	if blocking != nil {
+		index = blocking.block(r, h.peerList.WaitForChange)
+	}
+
+	peers := make([]*peer.Peer, 0)
+	for _, p := range h.peerList.GetAll() {
+		if matchTags(p, filters) {
+			peers = append(peers, p)
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(indexHeader, strconv.FormatUint(index, 10))
 	json.NewEncoder(w).Encode(peers)
 }
 
@@ -139,11 +451,27 @@ func (h *Handler) HandleStatus(w http.ResponseWriter, r *http.Request) {
 	allPeers := h.peerList.GetAll()
 
 	status := map[string]interface{}{
-		"id":          h.serviceID,
-		"address":     "", // This will be set by the service
-		"total_peers": len(allPeers),
-		"alive_peers": len(alivePeers),
-		"peers":       allPeers,
+		"id":            h.serviceID,
+		"address":       "", // This will be set by the service
+		"total_peers":   len(allPeers),
+		"alive_peers":   len(alivePeers),
+		"suspect_peers": h.peerList.CountByState(peer.StateSuspect),
+		"dead_peers":    h.peerList.CountByState(peer.StateDead),
+		"left_peers":    h.peerList.CountByState(peer.StateLeft),
+		"reaped_peers":  h.peerList.Reaped(),
+		"peers":         allPeers,
+	}
+	if h.local != nil {
+		self := h.local.Peer()
+		status["address"] = self.Address
+		status["incarnation"] = self.Incarnation
+		status["tags"] = self.Tags
+	}
+	if h.keyring != nil {
+		status["dropped_messages"] = h.keyring.Failures()
+	}
+	if h.webhooks != nil {
+		status["webhooks"] = h.webhooks.Status()
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -154,11 +482,30 @@ func (h *Handler) HandleStatus(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) SetupRoutes() *http.ServeMux {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("/join", h.HandleJoin)
-	mux.HandleFunc("/heartbeat", h.HandleHeartbeat)
-	mux.HandleFunc("/gossip", h.HandleGossip)
+	// Peer-to-peer endpoints are encrypted when a keyring is set
+	mux.HandleFunc("/join", h.encrypted(h.HandleJoin))
+	mux.HandleFunc("/heartbeat", h.encrypted(h.HandleHeartbeat))
+	mux.HandleFunc("/ping-req", h.encrypted(h.HandlePingReq))
+	mux.HandleFunc("/leave", h.encrypted(h.HandleLeave))
+	mux.HandleFunc("/broadcast", h.encrypted(h.HandleBroadcast))
+	mux.HandleFunc("/gossip", h.encrypted(h.HandleGossip))
+	mux.HandleFunc("/user-events", h.encrypted(h.HandleUserEvents))
+	mux.HandleFunc("/query", h.encrypted(h.HandleQuery))
+	mux.HandleFunc("/kv-updates", h.encrypted(h.HandleKVUpdates))
+	mux.HandleFunc("/keyring", h.encrypted(h.HandleKeyringOp))
 	mux.HandleFunc("/peers", h.HandlePeers)
 	mux.HandleFunc("/status", h.HandleStatus)
+	mux.HandleFunc("/v1/events", h.HandleEvents)
+	mux.HandleFunc("/v1/event/", h.HandleFireEvent)
+	mux.HandleFunc("/v1/query/", h.HandleFireQuery)
+	mux.HandleFunc("/v1/kv/", h.HandleKV)
+	mux.HandleFunc("/v1/agent/tags", h.HandleAgentTags)
+	mux.HandleFunc("/v1/agent/services", h.HandleAgentServices)
+	mux.HandleFunc("/v1/agent/services/", h.HandleAgentService)
+	mux.HandleFunc("/v1/agent/checks", h.HandleAgentChecks)
+	mux.HandleFunc("/v1/services/", h.HandleService)
+	mux.HandleFunc("/v1/keyring", operatorOnly(h.HandleKeyringList))
+	mux.HandleFunc("/v1/keyring/", operatorOnly(h.HandleKeyringUpdate))
 
 	return mux
 }
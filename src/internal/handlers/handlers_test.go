@@ -3,11 +3,14 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/rokzabukovec/clip/internal/peer"
+	"github.com/rokzabukovec/clip/internal/transport"
+	"github.com/rokzabukovec/clip/internal/webhook"
 )
 
 func TestNewHandler(t *testing.T) {
@@ -172,6 +175,37 @@ func TestHandler_HandleHeartbeat(t *testing.T) {
 		}
 	})
 
+	t.Run("ack carries view of suspect sender", func(t *testing.T) {
+		peerList.MarkSuspect("existing-peer", 0)
+
+		heartbeat := peer.Peer{ID: "existing-peer", Address: "http://192.168.1.100:8080"}
+		jsonData, _ := json.Marshal(heartbeat)
+		req := httptest.NewRequest("POST", "/heartbeat", bytes.NewBuffer(jsonData))
+		w := httptest.NewRecorder()
+
+		h.HandleHeartbeat(w, req)
+
+		var view peer.Peer
+		if err := json.NewDecoder(w.Body).Decode(&view); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if view.State != peer.StateSuspect {
+			t.Errorf("Expected ack to report sender as suspect, got %s", view.State)
+		}
+
+		// A heartbeat with a higher incarnation refutes the suspicion
+		heartbeat.Incarnation = 1
+		jsonData, _ = json.Marshal(heartbeat)
+		req = httptest.NewRequest("POST", "/heartbeat", bytes.NewBuffer(jsonData))
+		w = httptest.NewRecorder()
+
+		h.HandleHeartbeat(w, req)
+
+		if p, _ := peerList.Get("existing-peer"); p.State != peer.StateAlive {
+			t.Errorf("Expected refuted peer to be alive, got %s", p.State)
+		}
+	})
+
 	t.Run("invalid method", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "/heartbeat", nil)
 		w := httptest.NewRecorder()
@@ -196,6 +230,233 @@ func TestHandler_HandleHeartbeat(t *testing.T) {
 	})
 }
 
+func TestHandler_HandlePingReq(t *testing.T) {
+	peerList := peer.NewPeerList()
+	serviceID := "test-service"
+	h := NewHandler(peerList, serviceID, nil)
+
+	pingReq := transport.PingRequest{TargetID: "target", TargetAddress: "http://192.168.1.100:8080"}
+	jsonData, _ := json.Marshal(pingReq)
+
+	t.Run("no probe function", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/ping-req", bytes.NewBuffer(jsonData))
+		w := httptest.NewRecorder()
+
+		h.HandlePingReq(w, req)
+
+		if w.Code != http.StatusNotImplemented {
+			t.Errorf("Expected status %d, got %d", http.StatusNotImplemented, w.Code)
+		}
+	})
+
+	t.Run("target responds", func(t *testing.T) {
+		var probed string
+		h.SetProbeFunc(func(address string) error {
+			probed = address
+			return nil
+		})
+
+		req := httptest.NewRequest("POST", "/ping-req", bytes.NewBuffer(jsonData))
+		w := httptest.NewRecorder()
+
+		h.HandlePingReq(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		if probed != pingReq.TargetAddress {
+			t.Errorf("Expected target '%s' to be probed, got '%s'", pingReq.TargetAddress, probed)
+		}
+	})
+
+	t.Run("target does not respond", func(t *testing.T) {
+		h.SetProbeFunc(func(address string) error {
+			return errors.New("timeout")
+		})
+
+		req := httptest.NewRequest("POST", "/ping-req", bytes.NewBuffer(jsonData))
+		w := httptest.NewRecorder()
+
+		h.HandlePingReq(w, req)
+
+		if w.Code != http.StatusGatewayTimeout {
+			t.Errorf("Expected status %d, got %d", http.StatusGatewayTimeout, w.Code)
+		}
+	})
+
+	t.Run("invalid method", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/ping-req", nil)
+		w := httptest.NewRecorder()
+
+		h.HandlePingReq(w, req)
+
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+		}
+	})
+
+	t.Run("missing target", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/ping-req", bytes.NewBufferString("{}"))
+		w := httptest.NewRecorder()
+
+		h.HandlePingReq(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		}
+	})
+}
+
+func TestHandler_HandleLeave(t *testing.T) {
+	peerList := peer.NewPeerList()
+	serviceID := "test-service"
+	h := NewHandler(peerList, serviceID, nil)
+
+	peerList.Add(&peer.Peer{ID: "leaving-peer", Address: "http://192.168.1.100:8080"})
+
+	t.Run("valid leave", func(t *testing.T) {
+		leaving := peer.Peer{ID: "leaving-peer", Address: "http://192.168.1.100:8080"}
+		jsonData, _ := json.Marshal(leaving)
+		req := httptest.NewRequest("POST", "/leave", bytes.NewBuffer(jsonData))
+		w := httptest.NewRecorder()
+
+		h.HandleLeave(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+
+		p, exists := peerList.Get("leaving-peer")
+		if !exists {
+			t.Fatal("Expected left peer to be kept as a tombstone")
+		}
+		if p.State != peer.StateLeft {
+			t.Errorf("Expected peer to be left, got %s", p.State)
+		}
+	})
+
+	t.Run("gossip does not revive left peer", func(t *testing.T) {
+		peers := []*peer.Peer{
+			{ID: "leaving-peer", Address: "http://192.168.1.100:8080", State: peer.StateAlive},
+		}
+		jsonData, _ := json.Marshal(peers)
+		req := httptest.NewRequest("POST", "/gossip", bytes.NewBuffer(jsonData))
+		w := httptest.NewRecorder()
+
+		h.HandleGossip(w, req)
+
+		if p, _ := peerList.Get("leaving-peer"); p.State != peer.StateLeft {
+			t.Errorf("Expected peer to stay left, got %s", p.State)
+		}
+	})
+
+	t.Run("restarted peer refutes leave", func(t *testing.T) {
+		peers := []*peer.Peer{
+			{ID: "leaving-peer", Address: "http://192.168.1.100:8080", State: peer.StateAlive, Incarnation: 1},
+		}
+		jsonData, _ := json.Marshal(peers)
+		req := httptest.NewRequest("POST", "/gossip", bytes.NewBuffer(jsonData))
+		w := httptest.NewRecorder()
+
+		h.HandleGossip(w, req)
+
+		if p, _ := peerList.Get("leaving-peer"); p.State != peer.StateAlive {
+			t.Errorf("Expected peer to be alive again, got %s", p.State)
+		}
+	})
+
+	t.Run("invalid method", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/leave", nil)
+		w := httptest.NewRecorder()
+
+		h.HandleLeave(w, req)
+
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+		}
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/leave", bytes.NewBufferString("invalid json"))
+		w := httptest.NewRecorder()
+
+		h.HandleLeave(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		}
+	})
+}
+
+func TestHandler_HandleBroadcast(t *testing.T) {
+	peerList := peer.NewPeerList()
+	serviceID := "test-service"
+	h := NewHandler(peerList, serviceID, nil)
+
+	var changes []*peer.Peer
+	peerList.SetOnChange(func(p *peer.Peer) {
+		changes = append(changes, p)
+	})
+
+	t.Run("new rumor is merged and passed on", func(t *testing.T) {
+		rumors := []*peer.Peer{
+			{ID: "peer1", Address: "http://192.168.1.100:8080", State: peer.StateAlive},
+		}
+		jsonData, _ := json.Marshal(rumors)
+		req := httptest.NewRequest("POST", "/broadcast", bytes.NewBuffer(jsonData))
+		w := httptest.NewRecorder()
+
+		h.HandleBroadcast(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		if !peerList.Exists("peer1") {
+			t.Error("Expected peer1 to be added")
+		}
+		if len(changes) != 1 {
+			t.Errorf("Expected 1 change to be passed on, got %d", len(changes))
+		}
+	})
+
+	t.Run("known rumor is not passed on again", func(t *testing.T) {
+		rumors := []*peer.Peer{
+			{ID: "peer1", Address: "http://192.168.1.100:8080", State: peer.StateAlive},
+		}
+		jsonData, _ := json.Marshal(rumors)
+		req := httptest.NewRequest("POST", "/broadcast", bytes.NewBuffer(jsonData))
+		w := httptest.NewRecorder()
+
+		h.HandleBroadcast(w, req)
+
+		if len(changes) != 1 {
+			t.Errorf("Expected no new changes, got %d", len(changes))
+		}
+	})
+
+	t.Run("invalid method", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/broadcast", nil)
+		w := httptest.NewRecorder()
+
+		h.HandleBroadcast(w, req)
+
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
+		}
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/broadcast", bytes.NewBufferString("invalid json"))
+		w := httptest.NewRecorder()
+
+		h.HandleBroadcast(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		}
+	})
+}
+
 func TestHandler_HandleGossip(t *testing.T) {
 	peerList := peer.NewPeerList()
 	serviceID := "test-service"
@@ -225,6 +486,16 @@ func TestHandler_HandleGossip(t *testing.T) {
 		if !peerList.Exists("peer2") {
 			t.Error("Expected peer2 to be added")
 		}
+
+		// Check response contains our state for the push-pull exchange
+		var responsePeers []*peer.Peer
+		if err := json.NewDecoder(w.Body).Decode(&responsePeers); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+
+		if len(responsePeers) != 2 {
+			t.Errorf("Expected 2 peers in response, got %d", len(responsePeers))
+		}
 	})
 
 	t.Run("gossip with self should be ignored", func(t *testing.T) {
@@ -254,6 +525,111 @@ func TestHandler_HandleGossip(t *testing.T) {
 		}
 	})
 
+	t.Run("gossip updates peer state", func(t *testing.T) {
+		peers := []*peer.Peer{
+			{ID: "peer1", Address: "http://192.168.1.100:8080", State: peer.StateSuspect},
+		}
+
+		jsonData, _ := json.Marshal(peers)
+		req := httptest.NewRequest("POST", "/gossip", bytes.NewBuffer(jsonData))
+		w := httptest.NewRecorder()
+
+		h.HandleGossip(w, req)
+
+		p, _ := peerList.Get("peer1")
+		if p.State != peer.StateSuspect {
+			t.Errorf("Expected peer1 to be suspect, got %s", p.State)
+		}
+	})
+
+	t.Run("suspicion about self is refuted", func(t *testing.T) {
+		local := peer.NewLocalNode(serviceID, "http://192.168.1.1:8080")
+		h.SetLocalNode(local)
+		defer h.SetLocalNode(nil)
+
+		peers := []*peer.Peer{
+			{ID: serviceID, Address: "http://192.168.1.1:8080", State: peer.StateSuspect, Incarnation: 2},
+		}
+
+		jsonData, _ := json.Marshal(peers)
+		req := httptest.NewRequest("POST", "/gossip", bytes.NewBuffer(jsonData))
+		w := httptest.NewRecorder()
+
+		h.HandleGossip(w, req)
+
+		if local.Incarnation() != 3 {
+			t.Errorf("Expected incarnation to be bumped to 3, got %d", local.Incarnation())
+		}
+		if peerList.Exists(serviceID) {
+			t.Error("Expected self to not be added")
+		}
+	})
+
+	t.Run("digest exchange", func(t *testing.T) {
+		// peer1 is newer on the sender, "remote-only" is unknown to us,
+		// and peer2 is missing from the sender's digest entirely
+		gossipReq := transport.GossipRequest{
+			Digest: []peer.Digest{
+				{ID: "peer1", State: peer.StateSuspect, Version: 9},
+				{ID: "remote-only", State: peer.StateAlive, Version: 1},
+			},
+		}
+
+		jsonData, _ := json.Marshal(gossipReq)
+		req := httptest.NewRequest("POST", "/gossip", bytes.NewBuffer(jsonData))
+		w := httptest.NewRecorder()
+
+		h.HandleGossip(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+
+		var resp transport.GossipResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+
+		want := make(map[string]bool)
+		for _, id := range resp.Want {
+			want[id] = true
+		}
+		if !want["peer1"] || !want["remote-only"] || len(resp.Want) != 2 {
+			t.Errorf("Expected to want peer1 and remote-only, got %v", resp.Want)
+		}
+
+		sent := make(map[string]bool)
+		for _, p := range resp.Peers {
+			sent[p.ID] = true
+		}
+		if !sent["peer2"] {
+			t.Error("Expected peer2 to be sent to the remote side")
+		}
+		if sent["peer1"] {
+			t.Error("Expected peer1 to not be sent since the remote copy is newer")
+		}
+
+		// A digest exchange alone doesn't add records
+		if peerList.Exists("remote-only") {
+			t.Error("Expected digest to not add peers")
+		}
+	})
+
+	t.Run("digest of identical state", func(t *testing.T) {
+		digest := make([]peer.Digest, 0)
+		for _, p := range h.FullState() {
+			digest = append(digest, p.Digest())
+		}
+
+		resp, err := h.PushPull(transport.GossipRequest{Digest: digest})
+		if err != nil {
+			t.Fatalf("Expected push-pull to succeed, got: %v", err)
+		}
+		if len(resp.Peers) != 0 || len(resp.Want) != 0 {
+			t.Errorf("Expected nothing to exchange, got %d peers and %d wants", len(resp.Peers), len(resp.Want))
+		}
+	})
+
 	t.Run("invalid method", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "/gossip", nil)
 		w := httptest.NewRecorder()
@@ -278,6 +654,54 @@ func TestHandler_HandleGossip(t *testing.T) {
 	})
 }
 
+func TestHandler_ClusterName(t *testing.T) {
+	peerList := peer.NewPeerList()
+	h := NewHandler(peerList, "test-service", nil)
+	h.SetClusterName("staging")
+	mux := h.SetupRoutes()
+
+	post := func(path string, body interface{}) int {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	dev := &peer.Peer{ID: "dev-peer", Address: "http://192.168.1.101:8080", Cluster: "dev"}
+	if code := post("/join", dev); code != http.StatusForbidden {
+		t.Errorf("Expected join from another cluster to be rejected, got %d", code)
+	}
+	if code := post("/heartbeat", dev); code != http.StatusForbidden {
+		t.Errorf("Expected heartbeat from another cluster to be rejected, got %d", code)
+	}
+	if code := post("/gossip", transport.GossipRequest{Cluster: "dev"}); code != http.StatusForbidden {
+		t.Errorf("Expected push-pull from another cluster to be rejected, got %d", code)
+	}
+	if code := post("/gossip", transport.GossipRequest{}); code != http.StatusForbidden {
+		t.Errorf("Expected push-pull without a cluster name to be rejected, got %d", code)
+	}
+
+	post("/broadcast", []*peer.Peer{
+		{ID: "dev-peer", Address: "http://192.168.1.101:8080", State: peer.StateAlive, Cluster: "dev"},
+		{ID: "staging-peer", Address: "http://192.168.1.102:8080", State: peer.StateAlive, Cluster: "staging"},
+	})
+	if peerList.Exists("dev-peer") {
+		t.Error("Expected gossip about a peer in another cluster to be dropped")
+	}
+	if !peerList.Exists("staging-peer") {
+		t.Error("Expected gossip about a peer in the same cluster to be merged")
+	}
+
+	staging := &peer.Peer{ID: "new-peer", Address: "http://192.168.1.103:8080", Cluster: "staging"}
+	if code := post("/join", staging); code != http.StatusOK {
+		t.Errorf("Expected join from the same cluster to succeed, got %d", code)
+	}
+	if code := post("/gossip", transport.GossipRequest{Cluster: "staging"}); code != http.StatusOK {
+		t.Errorf("Expected push-pull from the same cluster to succeed, got %d", code)
+	}
+}
+
 func TestHandler_HandlePeers(t *testing.T) {
 	peerList := peer.NewPeerList()
 	serviceID := "test-service"
@@ -338,7 +762,8 @@ func TestHandler_HandleStatus(t *testing.T) {
 		peerList.Add(peer2)
 
 		// Mark one peer as dead
-		peerList.MarkDead("dead-peer")
+		peerList.MarkSuspect("dead-peer", 0)
+		peerList.MarkDead("dead-peer", 0)
 
 		req := httptest.NewRequest("GET", "/status", nil)
 		w := httptest.NewRecorder()
@@ -369,6 +794,41 @@ func TestHandler_HandleStatus(t *testing.T) {
 		if status["alive_peers"] != float64(1) {
 			t.Errorf("Expected alive_peers to be 1, got %v", status["alive_peers"])
 		}
+
+		if status["dead_peers"] != float64(1) {
+			t.Errorf("Expected dead_peers to be 1, got %v", status["dead_peers"])
+		}
+
+		if status["suspect_peers"] != float64(0) {
+			t.Errorf("Expected suspect_peers to be 0, got %v", status["suspect_peers"])
+		}
+
+		if status["reaped_peers"] != float64(0) {
+			t.Errorf("Expected reaped_peers to be 0, got %v", status["reaped_peers"])
+		}
+
+		if _, ok := status["webhooks"]; ok {
+			t.Error("Expected no webhooks without a dispatcher")
+		}
+	})
+
+	t.Run("webhooks", func(t *testing.T) {
+		h := NewHandler(peer.NewPeerList(), "test-service", nil)
+		h.SetWebhooks(webhook.NewDispatcher([]webhook.Endpoint{{URL: "http://lb.example.com/hooks"}}, "", 0))
+
+		req := httptest.NewRequest("GET", "/status", nil)
+		w := httptest.NewRecorder()
+		h.HandleStatus(w, req)
+
+		var status struct {
+			Webhooks []webhook.Status `json:"webhooks"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(status.Webhooks) != 1 || status.Webhooks[0].URL != "http://lb.example.com/hooks" {
+			t.Errorf("Expected the webhook delivery status, got %+v", status.Webhooks)
+		}
 	})
 
 	t.Run("invalid method", func(t *testing.T) {
@@ -399,7 +859,7 @@ func TestHandler_SetupRoutes(t *testing.T) {
 	}
 
 	// Test that all routes are registered by making requests
-	routes := []string{"/join", "/heartbeat", "/gossip", "/peers", "/status"}
+	routes := []string{"/join", "/heartbeat", "/ping-req", "/leave", "/broadcast", "/gossip", "/peers", "/status"}
 
 	for _, route := range routes {
 		req := httptest.NewRequest("GET", route, nil)
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/rokzabukovec/clip/internal/keyring"
+	"github.com/rokzabukovec/clip/internal/peer"
+	"github.com/rokzabukovec/clip/internal/transport"
+)
+
+var errNoKeyring = errors.New("encryption is not enabled")
+
+// KeyringFunc sends a keyring change to all other members and returns the
+// errors of those that failed to apply it, keyed by peer ID
+type KeyringFunc func(req transport.KeyringRequest) map[string]error
+
+// KeyringResponse reports the outcome of a cluster-wide keyring change
+type KeyringResponse struct {
+	Op        string            `json:"op"`
+	NumNodes  int               `json:"num_nodes"`
+	NumErrors int               `json:"num_errors"`
+	Errors    map[string]string `json:"errors"`
+}
+
+// KeyStatus reports which members hold a key and which use it as primary
+type KeyStatus struct {
+	Fingerprint string   `json:"fingerprint"`
+	Nodes       []string `json:"nodes"`
+	PrimaryOn   []string `json:"primary_on"`
+}
+
+// KeyringStatus reports the keys held across the cluster
+type KeyringStatus struct {
+	NumNodes int          `json:"num_nodes"`
+	Keys     []*KeyStatus `json:"keys"`
+}
+
+// SetKeyringFunc sets the function used to fan keyring changes out to the cluster
+func (h *Handler) SetKeyringFunc(fanout KeyringFunc) {
+	h.keyringFanout = fanout
+}
+
+// KeyringOp applies a keyring change to this node and gossips the new set
+// of key fingerprints
+func (h *Handler) KeyringOp(req transport.KeyringRequest) error {
+	if h.keyring == nil {
+		return errNoKeyring
+	}
+
+	key, err := keyring.DecodeKey(req.Key)
+	if err != nil {
+		return err
+	}
+
+	switch req.Op {
+	case transport.KeyringInstall:
+		err = h.keyring.Install(key)
+	case transport.KeyringUse:
+		err = h.keyring.Use(key)
+	case transport.KeyringRemove:
+		err = h.keyring.Remove(key)
+	default:
+		return fmt.Errorf("unknown keyring operation: %s", req.Op)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Keyring %s of key %s", req.Op, keyring.Fingerprint(key))
+	if h.local != nil {
+		h.local.SetKeys(h.keyring.Fingerprints())
+	}
+	return nil
+}
+
+// HandleKeyringOp handles keyring changes fanned out by another member
+func (h *Handler) HandleKeyringOp(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req transport.KeyringRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.KeyringOp(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// operatorOnly wraps an endpoint that changes or reveals the keyring so that
+// it only serves clients on this machine, or clients that authenticated with
+// a certificate when mutual TLS is enabled. Everyone else gets a 403.
+func operatorOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			next(w, r)
+			return
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+			log.Printf("Refusing %s request from %s: keyring API is only served on localhost or over mutual TLS", r.URL.Path, r.RemoteAddr)
+			http.Error(w, "Keyring API is only served on localhost or over mutual TLS", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// HandleKeyringList reports which keys each member holds, so an operator
+// can tell when every member uses a new primary key and the old one can
+// be removed
+func (h *Handler) HandleKeyringList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.keyring == nil {
+		http.Error(w, "Encryption is not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.KeyringStatus())
+}
+
+// KeyringStatus collects the key fingerprints gossiped by all live members
+func (h *Handler) KeyringStatus() KeyringStatus {
+	status := KeyringStatus{Keys: make([]*KeyStatus, 0)}
+	keys := make(map[string]*KeyStatus)
+
+	for _, p := range h.FullState() {
+		if p.State != peer.StateAlive && p.State != peer.StateSuspect {
+			continue
+		}
+		status.NumNodes++
+
+		for i, fingerprint := range p.Keys {
+			key, ok := keys[fingerprint]
+			if !ok {
+				key = &KeyStatus{Fingerprint: fingerprint, Nodes: make([]string, 0), PrimaryOn: make([]string, 0)}
+				keys[fingerprint] = key
+				status.Keys = append(status.Keys, key)
+			}
+			key.Nodes = append(key.Nodes, p.ID)
+			if i == 0 {
+				key.PrimaryOn = append(key.PrimaryOn, p.ID)
+			}
+		}
+	}
+
+	for _, key := range status.Keys {
+		sort.Strings(key.Nodes)
+		sort.Strings(key.PrimaryOn)
+	}
+	sort.Slice(status.Keys, func(i, j int) bool {
+		if len(status.Keys[i].Nodes) != len(status.Keys[j].Nodes) {
+			return len(status.Keys[i].Nodes) > len(status.Keys[j].Nodes)
+		}
+		return status.Keys[i].Fingerprint < status.Keys[j].Fingerprint
+	})
+	return status
+}
+
+// HandleKeyringUpdate applies a keyring change to this node and then to
+// all other members. The operation is taken from the path:
+// /v1/keyring/install, /v1/keyring/use or /v1/keyring/remove.
+func (h *Handler) HandleKeyringUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	op := strings.TrimPrefix(r.URL.Path, "/v1/keyring/")
+	switch op {
+	case transport.KeyringInstall, transport.KeyringUse, transport.KeyringRemove:
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	var body struct {
+		Key string `json:"key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Key == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req := transport.KeyringRequest{Op: op, Key: body.Key}
+	if err := h.KeyringOp(req); err != nil {
+		if errors.Is(err, errNoKeyring) {
+			http.Error(w, "Encryption is not enabled", http.StatusNotImplemented)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := KeyringResponse{Op: op, NumNodes: 1, Errors: make(map[string]string)}
+	if h.keyringFanout != nil {
+		errs := h.keyringFanout(req)
+		for id, err := range errs {
+			resp.Errors[id] = err.Error()
+		}
+		resp.NumNodes += h.peerList.CountAlive()
+		resp.NumErrors = len(errs)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if resp.NumErrors > 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
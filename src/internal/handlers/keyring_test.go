@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rokzabukovec/clip/internal/keyring"
+	"github.com/rokzabukovec/clip/internal/peer"
+	"github.com/rokzabukovec/clip/internal/transport"
+)
+
+func TestHandler_KeyringOp(t *testing.T) {
+	h, k, _ := newEncryptedHandler(t)
+	local := peer.NewLocalNode("test-service", "http://192.168.1.1:8080")
+	h.SetLocalNode(local)
+
+	newKey := bytes.Repeat([]byte{3}, 32)
+	encoded := base64.StdEncoding.EncodeToString(newKey)
+
+	if err := h.KeyringOp(transport.KeyringRequest{Op: transport.KeyringInstall, Key: encoded}); err != nil {
+		t.Fatalf("Expected install to succeed, got: %v", err)
+	}
+	if err := h.KeyringOp(transport.KeyringRequest{Op: transport.KeyringUse, Key: encoded}); err != nil {
+		t.Fatalf("Expected use to succeed, got: %v", err)
+	}
+	if keys := local.Peer().Keys; len(keys) != 2 || keys[0] != keyring.Fingerprint(newKey) {
+		t.Errorf("Expected the local record to list the new primary first, got %v", keys)
+	}
+	if fingerprints := k.Fingerprints(); fingerprints[0] != keyring.Fingerprint(newKey) {
+		t.Errorf("Expected the new key to be primary, got %v", fingerprints)
+	}
+
+	if err := h.KeyringOp(transport.KeyringRequest{Op: "rotate", Key: encoded}); err == nil {
+		t.Error("Expected an unknown operation to fail")
+	}
+	if err := h.KeyringOp(transport.KeyringRequest{Op: transport.KeyringInstall, Key: "not-base64"}); err == nil {
+		t.Error("Expected an invalid key to fail")
+	}
+
+	plain := NewHandler(peer.NewPeerList(), "test-service", nil)
+	if err := plain.KeyringOp(transport.KeyringRequest{Op: transport.KeyringInstall, Key: encoded}); err == nil {
+		t.Error("Expected keyring operations to fail without encryption")
+	}
+}
+
+// localRequest creates a request to the keyring API from this machine
+func localRequest(method, path, body string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.RemoteAddr = "127.0.0.1:51234"
+	return req
+}
+
+func TestHandler_KeyringAPIRequiresOperator(t *testing.T) {
+	h, k, mux := newEncryptedHandler(t)
+	encoded := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{3}, 32))
+
+	for _, path := range []string{"/v1/keyring/install", "/v1/keyring"} {
+		method := http.MethodPost
+		if path == "/v1/keyring" {
+			method = http.MethodGet
+		}
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"key":"`+encoded+`"}`))
+		req.RemoteAddr = "203.0.113.7:40000"
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected a remote %s %s to be refused, got %d", method, path, w.Code)
+		}
+	}
+	if len(k.Fingerprints()) != 1 {
+		t.Errorf("Expected no key to be installed, got %v", k.Fingerprints())
+	}
+
+	// A client certificate verified by mutual TLS is trusted from anywhere
+	req := httptest.NewRequest(http.MethodPost, "/v1/keyring/install", strings.NewReader(`{"key":"`+encoded+`"}`))
+	req.RemoteAddr = "203.0.113.7:40000"
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || len(h.keyring.Fingerprints()) != 2 {
+		t.Errorf("Expected an install over mutual TLS to succeed, got %d", w.Code)
+	}
+}
+
+func TestHandler_HandleKeyringUpdate(t *testing.T) {
+	h, k, mux := newEncryptedHandler(t)
+	h.peerList.Add(&peer.Peer{ID: "peer1", Address: "http://192.168.1.101:8080"})
+	h.peerList.Add(&peer.Peer{ID: "peer2", Address: "http://192.168.1.102:8080"})
+
+	var fannedOut []transport.KeyringRequest
+	h.SetKeyringFunc(func(req transport.KeyringRequest) map[string]error {
+		fannedOut = append(fannedOut, req)
+		return map[string]error{"peer2": errors.New("unreachable")}
+	})
+
+	encoded := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{3}, 32))
+	req := localRequest(http.MethodPost, "/v1/keyring/install", `{"key":"`+encoded+`"}`)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500 when a node fails, got %d", w.Code)
+	}
+	var resp KeyringResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.NumNodes != 3 || resp.NumErrors != 1 || resp.Errors["peer2"] != "unreachable" {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+	if len(k.Fingerprints()) != 2 {
+		t.Error("Expected the key to be installed locally")
+	}
+	if len(fannedOut) != 1 || fannedOut[0].Op != transport.KeyringInstall {
+		t.Errorf("Expected the install to be fanned out, got %+v", fannedOut)
+	}
+
+	t.Run("unknown operation", func(t *testing.T) {
+		req := localRequest(http.MethodPost, "/v1/keyring/rotate", `{"key":"`+encoded+`"}`)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("removing the primary key", func(t *testing.T) {
+		primary := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+		req := localRequest(http.MethodPost, "/v1/keyring/remove", `{"key":"`+primary+`"}`)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("encryption disabled", func(t *testing.T) {
+		plain := NewHandler(peer.NewPeerList(), "test-service", nil).SetupRoutes()
+		req := localRequest(http.MethodPost, "/v1/keyring/install", `{"key":"`+encoded+`"}`)
+		w := httptest.NewRecorder()
+		plain.ServeHTTP(w, req)
+		if w.Code != http.StatusNotImplemented {
+			t.Errorf("Expected status 501, got %d", w.Code)
+		}
+	})
+}
+
+func TestHandler_HandleKeyringList(t *testing.T) {
+	h, _, mux := newEncryptedHandler(t)
+	local := peer.NewLocalNode("test-service", "http://192.168.1.1:8080")
+	local.SetKeys([]string{"aaaa", "bbbb"})
+	h.SetLocalNode(local)
+	h.peerList.Add(&peer.Peer{ID: "peer1", Address: "http://192.168.1.101:8080", Keys: []string{"aaaa"}})
+	h.peerList.Add(&peer.Peer{ID: "peer2", Address: "http://192.168.1.102:8080", Keys: []string{"bbbb", "aaaa"}})
+	h.peerList.Add(&peer.Peer{ID: "gone", Address: "http://192.168.1.103:8080", Keys: []string{"cccc"}})
+	h.peerList.MarkSuspect("gone", 0)
+	h.peerList.MarkDead("gone", 0)
+
+	req := localRequest(http.MethodGet, "/v1/keyring", "")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var status KeyringStatus
+	json.NewDecoder(w.Body).Decode(&status)
+	if status.NumNodes != 3 || len(status.Keys) != 2 {
+		t.Fatalf("Expected 2 keys across 3 nodes, got %+v", status)
+	}
+	if status.Keys[0].Fingerprint != "aaaa" || len(status.Keys[0].Nodes) != 3 || len(status.Keys[0].PrimaryOn) != 2 {
+		t.Errorf("Unexpected status for key aaaa: %+v", status.Keys[0])
+	}
+	if status.Keys[1].Fingerprint != "bbbb" || len(status.Keys[1].Nodes) != 2 || status.Keys[1].PrimaryOn[0] != "peer2" {
+		t.Errorf("Unexpected status for key bbbb: %+v", status.Keys[1])
+	}
+}
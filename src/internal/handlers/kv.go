@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rokzabukovec/clip/internal/kv"
+	"github.com/rokzabukovec/clip/internal/transport"
+)
+
+// SetKV sets the key/value store served on /v1/kv/ and replicated through
+// push-pull and /kv-updates
+func (h *Handler) SetKV(s *kv.Store) {
+	h.kv = s
+}
+
+// HandleKV reads and writes the key named in the path, /v1/kv/{key}. GET
+// returns the entry, or with ?recurse all entries under the key as a
+// prefix, and with ?raw just the value. Like /peers, GET blocks with
+// ?index=N until the key, or any key under it, changes past N. PUT sets
+// the key to the request body and DELETE removes it; both are gossiped to
+// the cluster and return the written entry.
+func (h *Handler) HandleKV(w http.ResponseWriter, r *http.Request) {
+	if h.kv == nil {
+		http.Error(w, "Key/value store is not supported", http.StatusNotImplemented)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	switch r.Method {
+	case http.MethodGet:
+		h.getKV(w, r, key)
+	case http.MethodPut:
+		value, err := io.ReadAll(io.LimitReader(r.Body, kv.MaxValueSize+1))
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if len(value) == 0 {
+			value = nil
+		}
+		h.writeKV(w, func() (*kv.Entry, error) { return h.kv.Put(key, value) })
+	case http.MethodDelete:
+		h.writeKV(w, func() (*kv.Entry, error) { return h.kv.Delete(key) })
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// getKV serves GET /v1/kv/{key}
+func (h *Handler) getKV(w http.ResponseWriter, r *http.Request, key string) {
+	query := r.URL.Query()
+	recurse := query.Has("recurse")
+	if !recurse {
+		if err := kv.ValidateKey(key); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	blocking, err := parseBlockingQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	index := h.kv.KeyIndex(key, recurse)
+	if blocking != nil {
+		index = blocking.block(r, func(ctx context.Context, index uint64) uint64 {
+			return h.kv.WaitForChange(ctx, key, recurse, index)
+		})
+	}
+	w.Header().Set(indexHeader, strconv.FormatUint(index, 10))
+
+	if recurse {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.kv.List(key))
+		return
+	}
+
+	entry, ok := h.kv.Get(key)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if query.Has("raw") {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(entry.Value)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+// writeKV applies a write and responds with the written entry
+func (h *Handler) writeKV(w http.ResponseWriter, write func() (*kv.Entry, error)) {
+	entry, err := write()
+	if err != nil {
+		status := http.StatusBadRequest
+		switch {
+		case errors.Is(err, kv.ErrTooLarge):
+			status = http.StatusRequestEntityTooLarge
+		case errors.Is(err, kv.ErrNotSynced):
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(indexHeader, strconv.FormatUint(entry.ModifyIndex, 10))
+	json.NewEncoder(w).Encode(entry)
+}
+
+// HandleKVUpdates handles key/value writes gossiped or pushed by peers
+func (h *Handler) HandleKVUpdates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var update transport.KVUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.MergeKV(update); err != nil {
+		http.Error(w, "Cluster name mismatch", http.StatusForbidden)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// MergeKV merges key/value writes from a peer; the newer write of each key
+// wins. Only gossiped writes are passed on.
+func (h *Handler) MergeKV(update transport.KVUpdate) error {
+	if !h.sameCluster("key/value update", update.Cluster) {
+		return errClusterMismatch
+	}
+	if h.kv == nil {
+		return nil
+	}
+	if update.Sync {
+		h.kv.Sync(update.Entries)
+	} else {
+		h.kv.Merge(update.Entries)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rokzabukovec/clip/internal/kv"
+	"github.com/rokzabukovec/clip/internal/peer"
+	"github.com/rokzabukovec/clip/internal/transport"
+)
+
+func newKVHandler() *Handler {
+	h := NewHandler(peer.NewPeerList(), "node1", nil)
+	h.SetClusterName("staging")
+	h.SetKV(kv.NewStore("node1"))
+	return h
+}
+
+func TestHandler_HandleKV(t *testing.T) {
+	h := newKVHandler()
+	mux := h.SetupRoutes()
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(http.MethodPut, "/v1/kv/feature/dark-mode", "on")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var written kv.Entry
+	json.NewDecoder(w.Body).Decode(&written)
+	if written.Key != "feature/dark-mode" || string(written.Value) != "on" || written.Node != "node1" || written.LTime != 1 {
+		t.Errorf("Unexpected entry: %+v", written)
+	}
+	serve(http.MethodPut, "/v1/kv/feature/beta", "off")
+
+	w = serve(http.MethodGet, "/v1/kv/feature/dark-mode", "")
+	var entry kv.Entry
+	json.NewDecoder(w.Body).Decode(&entry)
+	if w.Code != http.StatusOK || string(entry.Value) != "on" {
+		t.Errorf("Expected the entry, got %d %+v", w.Code, entry)
+	}
+	if got := w.Header().Get(indexHeader); got != strconv.FormatUint(entry.ModifyIndex, 10) {
+		t.Errorf("Expected the key's index in %s, got %q", indexHeader, got)
+	}
+
+	if w = serve(http.MethodGet, "/v1/kv/feature/dark-mode?raw", ""); w.Body.String() != "on" {
+		t.Errorf("Expected the raw value, got %q", w.Body.String())
+	}
+
+	w = serve(http.MethodGet, "/v1/kv/feature/?recurse", "")
+	var entries []*kv.Entry
+	json.NewDecoder(w.Body).Decode(&entries)
+	if len(entries) != 2 || entries[0].Key != "feature/beta" {
+		t.Errorf("Expected 2 entries under feature/, got %+v", entries)
+	}
+
+	if w = serve(http.MethodDelete, "/v1/kv/feature/dark-mode", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected delete to succeed, got %d", w.Code)
+	}
+	if w = serve(http.MethodGet, "/v1/kv/feature/dark-mode", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a deleted key, got %d", w.Code)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"no key", http.MethodGet, "/v1/kv/", "", http.StatusBadRequest},
+		{"empty segment", http.MethodPut, "/v1/kv/feature//beta", "on", http.StatusBadRequest},
+		{"too large", http.MethodPut, "/v1/kv/big", strings.Repeat("x", kv.MaxValueSize+1), http.StatusRequestEntityTooLarge},
+		{"bad index", http.MethodGet, "/v1/kv/feature/beta?index=abc", "", http.StatusBadRequest},
+		{"wrong method", http.MethodPost, "/v1/kv/feature/beta", "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Call the handler directly so the mux doesn't clean up the path
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			h.HandleKV(w, req)
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+
+	t.Run("not supported", func(t *testing.T) {
+		h := NewHandler(peer.NewPeerList(), "node1", nil)
+		req := httptest.NewRequest(http.MethodGet, "/v1/kv/feature/beta", nil)
+		w := httptest.NewRecorder()
+		h.HandleKV(w, req)
+		if w.Code != http.StatusNotImplemented {
+			t.Errorf("Expected status 501, got %d", w.Code)
+		}
+	})
+}
+
+func TestHandler_HandleKV_Watch(t *testing.T) {
+	h := newKVHandler()
+	mux := h.SetupRoutes()
+	entry, _ := h.kv.Put("config/web", []byte("8080"))
+	h.kv.Put("other", []byte("x"))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		path := "/v1/kv/config/web?index=" + strconv.FormatUint(entry.ModifyIndex, 10) + "&wait=2s"
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		done <- w
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	h.kv.Merge([]*kv.Entry{{Key: "config/web", Value: []byte("9090"), LTime: 10, Node: "node2"}})
+
+	select {
+	case w := <-done:
+		var got kv.Entry
+		json.NewDecoder(w.Body).Decode(&got)
+		if string(got.Value) != "9090" {
+			t.Errorf("Expected the watch to return the new value, got %+v", got)
+		}
+		if index, _ := strconv.ParseUint(w.Header().Get(indexHeader), 10, 64); index <= entry.ModifyIndex {
+			t.Errorf("Expected the index to move past %d, got %d", entry.ModifyIndex, index)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the watch to return after the key changed")
+	}
+}
+
+func TestHandler_HandleKVUpdates(t *testing.T) {
+	h := newKVHandler()
+
+	update := transport.KVUpdate{Cluster: "staging", Entries: []*kv.Entry{{Key: "flag", Value: []byte("on"), LTime: 3, Node: "node2"}}}
+	body, _ := json.Marshal(update)
+	w := httptest.NewRecorder()
+	h.HandleKVUpdates(w, httptest.NewRequest(http.MethodPost, "/kv-updates", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if e, ok := h.kv.Get("flag"); !ok || string(e.Value) != "on" {
+		t.Errorf("Expected the write to be merged, got %+v", e)
+	}
+
+	update = transport.KVUpdate{Cluster: "production", Entries: []*kv.Entry{{Key: "flag", Value: []byte("off"), LTime: 9, Node: "node3"}}}
+	body, _ = json.Marshal(update)
+	w = httptest.NewRecorder()
+	h.HandleKVUpdates(w, httptest.NewRequest(http.MethodPost, "/kv-updates", bytes.NewReader(body)))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for another cluster, got %d", w.Code)
+	}
+	if e, _ := h.kv.Get("flag"); string(e.Value) != "on" {
+		t.Errorf("Expected the write from another cluster to be dropped, got %+v", e)
+	}
+
+	// Gossiped writes are passed on, writes asked for during push-pull
+	// are not
+	var changes []string
+	h.kv.SetOnChange(func(e *kv.Entry) { changes = append(changes, e.Key) })
+	h.MergeKV(transport.KVUpdate{Cluster: "staging", Entries: []*kv.Entry{{Key: "gossiped", LTime: 4, Node: "node2"}}})
+	h.MergeKV(transport.KVUpdate{Cluster: "staging", Entries: []*kv.Entry{{Key: "synced", LTime: 5, Node: "node2"}}, Sync: true})
+	if len(changes) != 1 || changes[0] != "gossiped" {
+		t.Errorf("Expected only the gossiped write to be passed on, got %v", changes)
+	}
+	if _, ok := h.kv.Get("synced"); !ok {
+		t.Error("Expected the synced write to be merged")
+	}
+}
+
+func TestHandler_PushPull_KV(t *testing.T) {
+	h := newKVHandler()
+	h.kv.Put("only-here", []byte("1"))
+	h.kv.Put("shared", []byte("old"))
+
+	resp, err := h.PushPull(transport.GossipRequest{
+		Cluster: "staging",
+		KV: []kv.Digest{
+			{Key: "shared", LTime: 7, Node: "node2"},
+			{Key: "only-there", LTime: 1, Node: "node2"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Expected push-pull to succeed, got: %v", err)
+	}
+	if len(resp.KV) != 1 || resp.KV[0].Key != "only-here" {
+		t.Errorf("Expected the entry the sender is missing, got %+v", resp.KV)
+	}
+	if len(resp.WantKV) != 2 {
+		t.Errorf("Expected to want the newer and the unknown key, got %v", resp.WantKV)
+	}
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rokzabukovec/clip/internal/hooks"
+	"github.com/rokzabukovec/clip/internal/peer"
+	"github.com/rokzabukovec/clip/internal/transport"
+)
+
+// Queries wait for answers for ?timeout=, up to a limit
+const (
+	defaultQueryTimeout = 10 * time.Second
+	maxQueryTimeout     = time.Minute
+)
+
+// maxQuerySize limits the payload of a query
+const maxQuerySize = 16 * 1024
+
+// QueryFunc sends a query to the target members and returns the responses
+// that arrived before the query's timeout
+type QueryFunc func(req transport.QueryRequest, targets []*peer.Peer) []transport.QueryResponse
+
+// QueryResult collects the answers to a query. NoResponse lists the
+// targeted members that did not answer in time.
+type QueryResult struct {
+	Name       string                    `json:"name"`
+	NumNodes   int                       `json:"num_nodes"`
+	Responses  []transport.QueryResponse `json:"responses"`
+	NoResponse []string                  `json:"no_response"`
+}
+
+// SetQueryFunc sets the function used to send queries to the cluster
+func (h *Handler) SetQueryFunc(query QueryFunc) {
+	h.queryFanout = query
+}
+
+// SetQueryResponder sets the responder that answers queries sent to this
+// node. Without one, queries are only acknowledged.
+func (h *Handler) SetQueryResponder(r *hooks.Responder) {
+	h.queryResponder = r
+}
+
+// Query answers a query with the output of the local query handler for
+// it. Queries without a handler are acknowledged with an empty response.
+func (h *Handler) Query(req transport.QueryRequest) (transport.QueryResponse, error) {
+	if !h.sameCluster("query "+req.Name+" from "+req.Origin, req.Cluster) {
+		return transport.QueryResponse{}, errClusterMismatch
+	}
+
+	resp := transport.QueryResponse{Node: h.serviceID}
+	if h.queryResponder == nil {
+		return resp, nil
+	}
+
+	timeout := req.Timeout
+	if timeout <= 0 || timeout > maxQueryTimeout {
+		timeout = maxQueryTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	payload, _, err := h.queryResponder.Respond(ctx, req.Name, req.Origin, req.Payload)
+	if err != nil {
+		log.Printf("Failed to answer query %s from %s: %v", req.Name, req.Origin, err)
+		resp.Error = err.Error()
+		return resp, nil
+	}
+	if len(payload) > 0 {
+		resp.Payload = payload
+	}
+	return resp, nil
+}
+
+// HandleQuery handles queries sent by other members
+func (h *Handler) HandleQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req transport.QueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := h.Query(req)
+	if err != nil {
+		http.Error(w, "Cluster name mismatch", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// HandleFireQuery sends the query named in the path, /v1/query/{name},
+// with the request body as its payload to the live members and collects
+// their answers until the timeout. Members can be narrowed down with
+// ?node= and ?tag= filters.
+func (h *Handler) HandleFireQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.queryFanout == nil {
+		http.Error(w, "Queries are not supported", http.StatusNotImplemented)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/v1/query/")
+	if name == "" || strings.Contains(name, "/") {
+		http.Error(w, "Query name must be non-empty and must not contain '/'", http.StatusBadRequest)
+		return
+	}
+
+	params := r.URL.Query()
+	filters, err := parseTagFilters(params["tag"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	timeout := defaultQueryTimeout
+	if t := params.Get("timeout"); t != "" {
+		if timeout, err = time.ParseDuration(t); err != nil || timeout <= 0 {
+			http.Error(w, fmt.Sprintf("invalid timeout: %s", t), http.StatusBadRequest)
+			return
+		}
+	}
+	if timeout > maxQueryTimeout {
+		timeout = maxQueryTimeout
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(r.Body, maxQuerySize+1))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(payload) > maxQuerySize {
+		http.Error(w, fmt.Sprintf("query payload exceeds %d bytes", maxQuerySize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	nodes := make(map[string]bool)
+	for _, id := range params["node"] {
+		nodes[id] = true
+	}
+	var targets []*peer.Peer
+	for _, p := range h.FullState() {
+		if p.State != peer.StateAlive && p.State != peer.StateSuspect {
+			continue
+		}
+		if len(nodes) > 0 && !nodes[p.ID] {
+			continue
+		}
+		if matchTags(p, filters) {
+			targets = append(targets, p)
+		}
+	}
+
+	req := transport.QueryRequest{
+		Name:    name,
+		Origin:  h.serviceID,
+		Cluster: h.cluster,
+		Timeout: timeout,
+	}
+	if len(payload) > 0 {
+		req.Payload = payload
+	}
+
+	result := QueryResult{
+		Name:       name,
+		NumNodes:   len(targets),
+		Responses:  make([]transport.QueryResponse, 0, len(targets)),
+		NoResponse: make([]string, 0),
+	}
+	answered := make(map[string]bool)
+	if len(targets) > 0 {
+		for _, resp := range h.queryFanout(req, targets) {
+			if !answered[resp.Node] {
+				answered[resp.Node] = true
+				result.Responses = append(result.Responses, resp)
+			}
+		}
+	}
+	for _, p := range targets {
+		if !answered[p.ID] {
+			result.NoResponse = append(result.NoResponse, p.ID)
+		}
+	}
+	sort.Slice(result.Responses, func(i, j int) bool {
+		return result.Responses[i].Node < result.Responses[j].Node
+	})
+	sort.Strings(result.NoResponse)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rokzabukovec/clip/internal/hooks"
+	"github.com/rokzabukovec/clip/internal/peer"
+	"github.com/rokzabukovec/clip/internal/transport"
+)
+
+func TestHandler_Query(t *testing.T) {
+	h := NewHandler(peer.NewPeerList(), "node1", nil)
+	h.SetClusterName("staging")
+
+	req := transport.QueryRequest{Name: "uptime", Origin: "node2", Cluster: "staging", Timeout: time.Second}
+	resp, err := h.Query(req)
+	if err != nil || resp.Node != "node1" || resp.Payload != nil || resp.Error != "" {
+		t.Errorf("Expected an acknowledgement without a responder, got %+v, %v", resp, err)
+	}
+
+	h.SetQueryResponder(hooks.NewResponder([]hooks.QueryHandler{
+		{Name: "uptime", Command: []string{"sh", "-c", `printf 'up %s' "$(cat)"`}},
+		{Name: "broken", Command: []string{"false"}},
+	}, time.Second, nil))
+
+	req.Payload = []byte("3d")
+	if resp, err = h.Query(req); err != nil || string(resp.Payload) != "up 3d" {
+		t.Errorf("Expected the handler's output, got %+v, %v", resp, err)
+	}
+
+	req.Name = "broken"
+	if resp, err = h.Query(req); err != nil || resp.Error == "" {
+		t.Errorf("Expected the handler's error in the response, got %+v, %v", resp, err)
+	}
+
+	req.Name = "unknown"
+	if resp, err = h.Query(req); err != nil || resp.Payload != nil || resp.Error != "" {
+		t.Errorf("Expected an acknowledgement for a query without a handler, got %+v, %v", resp, err)
+	}
+
+	req.Cluster = "production"
+	if _, err = h.Query(req); err != errClusterMismatch {
+		t.Errorf("Expected a cluster mismatch, got %v", err)
+	}
+}
+
+func TestHandler_HandleQuery(t *testing.T) {
+	h := NewHandler(peer.NewPeerList(), "node1", nil)
+
+	body, _ := json.Marshal(transport.QueryRequest{Name: "uptime", Origin: "node2", Timeout: time.Second})
+	req := httptest.NewRequest(http.MethodPost, "/query", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	h.SetupRoutes().ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var resp transport.QueryResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Node != "node1" {
+		t.Errorf("Expected an acknowledgement from node1, got %+v, %v", resp, err)
+	}
+
+	body, _ = json.Marshal(transport.QueryRequest{Name: "uptime", Origin: "node2", Cluster: "production"})
+	req = httptest.NewRequest(http.MethodPost, "/query", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	h.HandleQuery(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for another cluster, got %d", w.Code)
+	}
+}
+
+func TestHandler_HandleFireQuery(t *testing.T) {
+	h := NewHandler(peer.NewPeerList(), "node1", nil)
+	h.SetClusterName("staging")
+	h.SetLocalNode(peer.NewLocalNode("node1", "http://192.168.1.1:8080"))
+	h.peerList.Add(&peer.Peer{ID: "web1", Address: "http://192.168.1.101:8080", Tags: map[string]string{"role": "web"}})
+	h.peerList.Add(&peer.Peer{ID: "web2", Address: "http://192.168.1.102:8080", Tags: map[string]string{"role": "web"}})
+	h.peerList.Add(&peer.Peer{ID: "db1", Address: "http://192.168.1.103:8080", Tags: map[string]string{"role": "db"}})
+	h.peerList.Add(&peer.Peer{ID: "db2", Address: "http://192.168.1.104:8080", Tags: map[string]string{"role": "db"}})
+	h.peerList.MarkSuspect("db2", 0)
+	h.peerList.MarkDead("db2", 0)
+
+	var sent transport.QueryRequest
+	var targeted []string
+	h.SetQueryFunc(func(req transport.QueryRequest, targets []*peer.Peer) []transport.QueryResponse {
+		sent = req
+		var responses []transport.QueryResponse
+		for _, p := range targets {
+			targeted = append(targeted, p.ID)
+			// web2 does not answer in time
+			if p.ID != "web2" {
+				responses = append(responses, transport.QueryResponse{Node: p.ID, Payload: []byte("ok")})
+			}
+		}
+		return responses
+	})
+
+	mux := h.SetupRoutes()
+	req := httptest.NewRequest(http.MethodPost, "/v1/query/uptime?timeout=2s", strings.NewReader("ping"))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var result QueryResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if sent.Name != "uptime" || string(sent.Payload) != "ping" || sent.Origin != "node1" ||
+		sent.Cluster != "staging" || sent.Timeout != 2*time.Second {
+		t.Errorf("Unexpected query: %+v", sent)
+	}
+	// The dead member is not asked
+	if result.NumNodes != 4 || len(result.Responses) != 3 {
+		t.Fatalf("Expected 3 of 4 members to answer, got %+v", result)
+	}
+	for i, id := range []string{"db1", "node1", "web1"} {
+		if result.Responses[i].Node != id {
+			t.Errorf("Expected response %d from %s, got %s", i, id, result.Responses[i].Node)
+		}
+	}
+	if len(result.NoResponse) != 1 || result.NoResponse[0] != "web2" {
+		t.Errorf("Expected web2 to be listed as not responding, got %v", result.NoResponse)
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"tag=role:web", "web1,web2"},
+		{"node=node1&node=db1", "db1,node1"},
+		{"node=web1&tag=role:db", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			targeted = nil
+			req := httptest.NewRequest(http.MethodPost, "/v1/query/uptime?"+tt.query, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", w.Code)
+			}
+			sort.Strings(targeted)
+			if got := strings.Join(targeted, ","); got != tt.want {
+				t.Errorf("Expected targets %q, got %q", tt.want, got)
+			}
+			if sent.Timeout != defaultQueryTimeout {
+				t.Errorf("Expected the default timeout, got %v", sent.Timeout)
+			}
+		})
+	}
+}
+
+func TestHandler_HandleFireQuery_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"wrong method", http.MethodGet, "/v1/query/uptime", "", http.StatusMethodNotAllowed},
+		{"no name", http.MethodPost, "/v1/query/", "", http.StatusBadRequest},
+		{"nested name", http.MethodPost, "/v1/query/uptime/web", "", http.StatusBadRequest},
+		{"bad timeout", http.MethodPost, "/v1/query/uptime?timeout=soon", "", http.StatusBadRequest},
+		{"bad tag", http.MethodPost, "/v1/query/uptime?tag=:web", "", http.StatusBadRequest},
+		{"too large", http.MethodPost, "/v1/query/uptime", strings.Repeat("x", maxQuerySize+1), http.StatusRequestEntityTooLarge},
+	}
+
+	h := NewHandler(peer.NewPeerList(), "node1", nil)
+	h.SetQueryFunc(func(transport.QueryRequest, []*peer.Peer) []transport.QueryResponse { return nil })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			h.HandleFireQuery(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+
+	t.Run("not supported", func(t *testing.T) {
+		h := NewHandler(peer.NewPeerList(), "node1", nil)
+		req := httptest.NewRequest(http.MethodPost, "/v1/query/uptime", nil)
+		w := httptest.NewRecorder()
+		h.HandleFireQuery(w, req)
+
+		if w.Code != http.StatusNotImplemented {
+			t.Errorf("Expected status 501, got %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/rokzabukovec/clip/internal/health"
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+// serviceRegistration is a service with an optional health check
+type serviceRegistration struct {
+	peer.Service
+	Check *health.Check `json:"check,omitempty"`
+}
+
+// errScriptChecksDisabled is returned for exec checks registered through
+// the API unless script checks are enabled
+var errScriptChecksDisabled = errors.New("exec checks can only be registered through the API with script checks enabled")
+
+// SetChecker sets the checker that runs the health checks of services
+// registered through the API
+func (h *Handler) SetChecker(checks *health.Checker) {
+	h.checks = checks
+}
+
+// SetScriptChecks allows services registered through the API to carry exec
+// checks, which run arbitrary commands on this node
+func (h *Handler) SetScriptChecks(enabled bool) {
+	h.scriptChecks = enabled
+}
+
+// ServiceInstance is one instance of a service in the cluster
+type ServiceInstance struct {
+	Node    string   `json:"node"`
+	Address string   `json:"address"`
+	Port    int      `json:"port"`
+	ID      string   `json:"id"`
+	Service string   `json:"service"`
+	Tags    []string `json:"tags"`
+}
+
+// ServiceInstances returns the healthy instances of the named service on
+// all alive members, including this node. Instances that fail their health
+// check are left out.
+func (h *Handler) ServiceInstances(name string) []ServiceInstance {
+	found := peer.ServiceInstances(h.FullState(), name)
+	instances := make([]ServiceInstance, 0, len(found))
+	for _, inst := range found {
+		tags := inst.Service.Tags
+		if tags == nil {
+			tags = make([]string, 0)
+		}
+		instances = append(instances, ServiceInstance{
+			Node:    inst.Node.ID,
+			Address: hostname(inst.Node.Address),
+			Port:    inst.Service.Port,
+			ID:      inst.Service.ID,
+			Service: inst.Service.Name,
+			Tags:    tags,
+		})
+	}
+	return instances
+}
+
+// HandleService returns the healthy instances of the service named in the
+// path, /v1/services/{name}. Repeated tag query parameters narrow them down
+// to instances that have all the given tags.
+func (h *Handler) HandleService(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/v1/services/")
+	if name == "" || strings.Contains(name, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	tags := r.URL.Query()["tag"]
+	instances := make([]ServiceInstance, 0)
+	for _, instance := range h.ServiceInstances(name) {
+		if hasAll(instance.Tags, tags) {
+			instances = append(instances, instance)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(instances)
+}
+
+// HandleAgentServices lists the services this node advertises (GET) and
+// registers a new one or replaces the one with the same ID (POST). A
+// service registered with a check is critical until the check first passes.
+func (h *Handler) HandleAgentServices(w http.ResponseWriter, r *http.Request) {
+	if h.local == nil {
+		http.Error(w, "Local node not configured", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var reg serviceRegistration
+		if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := h.registerService(reg); err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, errScriptChecksDisabled) {
+				status = http.StatusForbidden
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	services := h.local.Peer().Services
+	if services == nil {
+		services = make([]peer.Service, 0)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(services)
+}
+
+// HandleAgentService deregisters the service with the ID given in the path,
+// /v1/agent/services/{id}
+func (h *Handler) HandleAgentService(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.local == nil {
+		http.Error(w, "Local node not configured", http.StatusNotImplemented)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/v1/agent/services/")
+	if h.checks != nil {
+		h.checks.Remove(id)
+	}
+	if !h.local.DeregisterService(id) {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// registerService registers a service and starts or stops its health check
+func (h *Handler) registerService(reg serviceRegistration) error {
+	svc := reg.Service
+	if svc.ID == "" {
+		svc.ID = svc.Name
+	}
+
+	// Health is only ever set by the check
+	svc.Health = ""
+	if reg.Check != nil {
+		if h.checks == nil {
+			return errors.New("health checks are not supported")
+		}
+		reg.Check.ServiceID = svc.ID
+		if err := reg.Check.Validate(); err != nil {
+			return err
+		}
+		if len(reg.Check.Exec) > 0 && !h.scriptChecks {
+			return errScriptChecksDisabled
+		}
+		svc.Health = peer.HealthCritical
+	}
+
+	if err := h.local.RegisterService(svc); err != nil {
+		return err
+	}
+
+	if h.checks == nil {
+		return nil
+	}
+	if reg.Check != nil {
+		if err := h.checks.Add(*reg.Check); err != nil {
+			// Don't leave a service behind that nothing checks
+			h.checks.Remove(svc.ID)
+			h.local.DeregisterService(svc.ID)
+			return err
+		}
+		return nil
+	}
+	h.checks.Remove(svc.ID)
+	return nil
+}
+
+// HandleAgentChecks returns the latest result of every local health check
+func (h *Handler) HandleAgentChecks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	results := make([]health.Result, 0)
+	if h.checks != nil {
+		results = h.checks.Results()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// hostname returns the host part of a peer's advertised address
+func hostname(address string) string {
+	u, err := url.Parse(address)
+	if err != nil {
+		return address
+	}
+	return u.Hostname()
+}
+
+// hasAll reports whether tags contains every tag in want
+func hasAll(tags, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, tag := range tags {
+			if tag == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rokzabukovec/clip/internal/health"
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+func TestHandler_HandleService(t *testing.T) {
+	peerList := peer.NewPeerList()
+	h := NewHandler(peerList, "node1", nil)
+	local := peer.NewLocalNode("node1", "http://192.168.1.100:8080")
+	local.RegisterService(peer.Service{Name: "web", Port: 80, Tags: []string{"v1"}})
+	h.SetLocalNode(local)
+
+	peerList.Add(&peer.Peer{ID: "node2", Address: "http://192.168.1.101:8080", Services: []peer.Service{
+		{ID: "web-1", Name: "web", Port: 8081, Tags: []string{"v2"}},
+		{ID: "web-2", Name: "web", Port: 8082, Tags: []string{"v2"}},
+		{ID: "db", Name: "db", Port: 5432},
+		{ID: "web-3", Name: "web", Port: 8083, Health: peer.HealthCritical},
+	}})
+	peerList.Add(&peer.Peer{ID: "node3", Address: "http://192.168.1.102:8080", Services: []peer.Service{
+		{ID: "web", Name: "web", Port: 80},
+	}})
+	peerList.MarkSuspect("node3", 0)
+	mux := h.SetupRoutes()
+
+	get := func(path string) (int, []ServiceInstance) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		var instances []ServiceInstance
+		json.NewDecoder(w.Body).Decode(&instances)
+		return w.Code, instances
+	}
+
+	code, instances := get("/v1/services/web")
+	if code != http.StatusOK || len(instances) != 3 {
+		t.Fatalf("Expected 3 healthy web instances, leaving out suspect and critical ones, got %d: %+v", code, instances)
+	}
+	first := instances[0]
+	if first.Node != "node1" || first.Address != "192.168.1.100" || first.Port != 80 || first.ID != "web" {
+		t.Errorf("Unexpected local instance: %+v", first)
+	}
+	if instances[1].ID != "web-1" || instances[2].ID != "web-2" {
+		t.Errorf("Expected instances sorted by node and ID, got %+v", instances)
+	}
+
+	if _, instances := get("/v1/services/WEB"); len(instances) != 3 {
+		t.Errorf("Expected service names to match ignoring case, as in DNS, got %+v", instances)
+	}
+	if _, instances := get("/v1/services/web?tag=v2"); len(instances) != 2 {
+		t.Errorf("Expected 2 instances tagged v2, got %+v", instances)
+	}
+	if code, instances := get("/v1/services/cache"); code != http.StatusOK || len(instances) != 0 {
+		t.Errorf("Expected an empty list for an unknown service, got %d: %+v", code, instances)
+	}
+	if code, _ := get("/v1/services/"); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without a name, got %d", code)
+	}
+}
+
+func TestHandler_HandleAgentServices(t *testing.T) {
+	h := NewHandler(peer.NewPeerList(), "node1", nil)
+	local := peer.NewLocalNode("node1", "http://192.168.1.100:8080")
+	h.SetLocalNode(local)
+	mux := h.SetupRoutes()
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send(http.MethodPost, "/v1/agent/services", `{"name": "web", "port": 80, "tags": ["v1"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected registration to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	var services []peer.Service
+	json.NewDecoder(w.Body).Decode(&services)
+	if len(services) != 1 || services[0].ID != "web" || local.Version() != 2 {
+		t.Errorf("Expected web to be registered, got %+v", services)
+	}
+
+	if w := send(http.MethodPost, "/v1/agent/services", `{"name": "web"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a service without a port to be rejected, got %d", w.Code)
+	}
+	if w := send(http.MethodPost, "/v1/agent/services", `not json`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid JSON to be rejected, got %d", w.Code)
+	}
+
+	if w := send(http.MethodDelete, "/v1/agent/services/web", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected deregistration to succeed, got %d", w.Code)
+	}
+	if w := send(http.MethodDelete, "/v1/agent/services/web", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown service, got %d", w.Code)
+	}
+
+	w = send(http.MethodGet, "/v1/agent/services", "")
+	services = nil
+	json.NewDecoder(w.Body).Decode(&services)
+	if w.Code != http.StatusOK || services == nil || len(services) != 0 {
+		t.Errorf("Expected an empty list, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandler_RegisterServiceWithCheck(t *testing.T) {
+	var healthy atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	h := NewHandler(peer.NewPeerList(), "node1", nil)
+	local := peer.NewLocalNode("node1", "http://192.168.1.100:8080")
+	h.SetLocalNode(local)
+	checks := health.NewChecker(10*time.Millisecond, time.Second, func(id, status string) {
+		local.SetServiceHealth(id, status)
+	})
+	defer checks.Stop()
+	h.SetChecker(checks)
+	mux := h.SetupRoutes()
+
+	body := `{"name": "web", "port": 80, "health": "passing", "check": {"http": "` + server.URL + `"}}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/agent/services", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected registration to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if instances := h.ServiceInstances("web"); len(instances) != 0 {
+		t.Errorf("Expected a failing instance to be left out, got %+v", instances)
+	}
+
+	healthy.Store(true)
+	time.Sleep(30 * time.Millisecond)
+	if instances := h.ServiceInstances("web"); len(instances) != 1 {
+		t.Errorf("Expected the instance once its check passes, got %+v", instances)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/agent/checks", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	var results []health.Result
+	json.NewDecoder(w.Body).Decode(&results)
+	if len(results) != 1 || results[0].ServiceID != "web" || results[0].Status != peer.HealthPassing {
+		t.Errorf("Unexpected check results: %+v", results)
+	}
+
+	// Deregistering the service stops its check
+	req = httptest.NewRequest(http.MethodDelete, "/v1/agent/services/web", nil)
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+	if len(checks.Results()) != 0 {
+		t.Error("Expected the check to be removed with its service")
+	}
+
+	body = `{"name": "db", "port": 5432, "check": {"http": "http://localhost", "tcp": "localhost:5432"}}`
+	req = httptest.NewRequest(http.MethodPost, "/v1/agent/services", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest || len(local.Peer().Services) != 0 {
+		t.Errorf("Expected an invalid check to reject the registration, got %d", w.Code)
+	}
+}
+
+func TestHandler_RegisterServiceWithExecCheck(t *testing.T) {
+	h := NewHandler(peer.NewPeerList(), "node1", nil)
+	local := peer.NewLocalNode("node1", "http://192.168.1.100:8080")
+	h.SetLocalNode(local)
+	checks := health.NewChecker(time.Hour, time.Second, func(id, status string) {})
+	defer checks.Stop()
+	h.SetChecker(checks)
+	mux := h.SetupRoutes()
+
+	register := func() int {
+		body := `{"name": "web", "port": 80, "check": {"exec": ["true"]}}`
+		req := httptest.NewRequest(http.MethodPost, "/v1/agent/services", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := register(); code != http.StatusForbidden {
+		t.Errorf("Expected an exec check to be refused by default, got %d", code)
+	}
+	if len(local.Peer().Services) != 0 || len(checks.Results()) != 0 {
+		t.Error("Expected a refused registration to leave no service or check behind")
+	}
+
+	h.SetScriptChecks(true)
+	if code := register(); code != http.StatusOK {
+		t.Errorf("Expected an exec check to be accepted with script checks enabled, got %d", code)
+	}
+}
+
+func TestHandler_RegisterServiceRollsBack(t *testing.T) {
+	h := NewHandler(peer.NewPeerList(), "node1", nil)
+	local := peer.NewLocalNode("node1", "http://192.168.1.100:8080")
+	h.SetLocalNode(local)
+	checks := health.NewChecker(time.Hour, time.Second, func(id, status string) {})
+	h.SetChecker(checks)
+	mux := h.SetupRoutes()
+
+	// A stopped checker refuses new checks after the service is registered
+	checks.Stop()
+	body := `{"name": "web", "port": 80, "check": {"tcp": "localhost:80"}}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/agent/services", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code == http.StatusOK {
+		t.Fatal("Expected the registration to fail when its check can't be added")
+	}
+	if len(local.Peer().Services) != 0 || len(checks.Results()) != 0 {
+		t.Errorf("Expected a failed registration to leave no service or check behind, got %+v", local.Peer().Services)
+	}
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+// tagFilter matches peers by tag. A filter without a value matches every
+// peer that has the tag.
+type tagFilter struct {
+	key      string
+	value    string
+	hasValue bool
+}
+
+// parseTagFilters parses tag query parameters of the form key:value or key
+func parseTagFilters(params []string) ([]tagFilter, error) {
+	filters := make([]tagFilter, 0, len(params))
+	for _, param := range params {
+		key, value, hasValue := strings.Cut(param, ":")
+		if key == "" {
+			return nil, fmt.Errorf("invalid tag filter: %q", param)
+		}
+		filters = append(filters, tagFilter{key: key, value: value, hasValue: hasValue})
+	}
+	return filters, nil
+}
+
+// matchTags reports whether a peer matches all filters
+func matchTags(p *peer.Peer, filters []tagFilter) bool {
+	for _, f := range filters {
+		if f.hasValue {
+			if !p.HasTag(f.key, f.value) {
+				return false
+			}
+		} else if _, ok := p.Tags[f.key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// HandleAgentTags reads and updates the tags this node advertises. PUT
+// replaces all tags; PATCH merges the given tags into the current ones and
+// deletes those set to null. Changes reach the cluster through gossip.
+func (h *Handler) HandleAgentTags(w http.ResponseWriter, r *http.Request) {
+	if h.local == nil {
+		http.Error(w, "Local node not configured", http.StatusNotImplemented)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPatch:
+		var update map[string]*string
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		tags := make(map[string]string)
+		if r.Method == http.MethodPatch {
+			for key, value := range h.local.Peer().Tags {
+				tags[key] = value
+			}
+		}
+		for key, value := range update {
+			if value == nil {
+				delete(tags, key)
+				continue
+			}
+			tags[key] = *value
+		}
+
+		if err := peer.ValidateTags(tags); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.local.SetTags(tags)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tags := h.local.Peer().Tags
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+func TestHandler_HandlePeersTagFilter(t *testing.T) {
+	peerList := peer.NewPeerList()
+	h := NewHandler(peerList, "test-service", nil)
+	peerList.Add(&peer.Peer{ID: "worker1", Address: "http://192.168.1.101:8080", Tags: map[string]string{"role": "worker", "zone": "rack3"}})
+	peerList.Add(&peer.Peer{ID: "worker2", Address: "http://192.168.1.102:8080", Tags: map[string]string{"role": "worker", "zone": "rack4"}})
+	peerList.Add(&peer.Peer{ID: "db1", Address: "http://192.168.1.103:8080", Tags: map[string]string{"role": "db"}})
+	peerList.Add(&peer.Peer{ID: "untagged", Address: "http://192.168.1.104:8080"})
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", 4},
+		{"?tag=role:worker", 2},
+		{"?tag=role:worker&tag=zone:rack3", 1},
+		{"?tag=zone", 2},
+		{"?tag=role:cache", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/peers"+tt.query, nil)
+			w := httptest.NewRecorder()
+			h.HandlePeers(w, req)
+
+			var peers []*peer.Peer
+			if err := json.NewDecoder(w.Body).Decode(&peers); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if len(peers) != tt.want {
+				t.Errorf("Expected %d peers, got %d", tt.want, len(peers))
+			}
+		})
+	}
+
+	t.Run("invalid filter", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/peers?tag=:worker", nil)
+		w := httptest.NewRecorder()
+		h.HandlePeers(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", w.Code)
+		}
+	})
+}
+
+func TestHandler_HandleAgentTags(t *testing.T) {
+	h := NewHandler(peer.NewPeerList(), "test-service", nil)
+	local := peer.NewLocalNode("test-service", "http://192.168.1.100:8080")
+	local.SetTags(map[string]string{"role": "worker", "zone": "rack3"})
+	h.SetLocalNode(local)
+	mux := h.SetupRoutes()
+
+	send := func(method, body string) (int, map[string]string) {
+		req := httptest.NewRequest(method, "/v1/agent/tags", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		var tags map[string]string
+		json.NewDecoder(w.Body).Decode(&tags)
+		return w.Code, tags
+	}
+
+	if code, tags := send(http.MethodGet, ""); code != http.StatusOK || tags["role"] != "worker" {
+		t.Errorf("Expected current tags, got %d: %v", code, tags)
+	}
+
+	version := local.Version()
+	code, tags := send(http.MethodPatch, `{"version": "1.4", "zone": null}`)
+	if code != http.StatusOK || len(tags) != 2 || tags["version"] != "1.4" || tags["role"] != "worker" {
+		t.Errorf("Expected tags to be merged, got %d: %v", code, tags)
+	}
+	if local.Version() != version+1 {
+		t.Error("Expected the tag change to bump the local version")
+	}
+
+	code, tags = send(http.MethodPut, `{"role": "db"}`)
+	if code != http.StatusOK || len(tags) != 1 || tags["role"] != "db" {
+		t.Errorf("Expected tags to be replaced, got %d: %v", code, tags)
+	}
+
+	if code, _ := send(http.MethodPut, `{"role:x": "db"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid tag to be rejected, got %d", code)
+	}
+	if code, _ := send(http.MethodPut, `not json`); code != http.StatusBadRequest {
+		t.Errorf("Expected invalid JSON to be rejected, got %d", code)
+	}
+	if code, _ := send(http.MethodDelete, ""); code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", code)
+	}
+	if !local.Peer().HasTag("role", "db") || len(local.Peer().Tags) != 1 {
+		t.Errorf("Expected rejected updates to leave the tags alone, got %v", local.Peer().Tags)
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+	"github.com/rokzabukovec/clip/internal/userevent"
+)
+
+// SetUserEvents sets the log that user events are fired into and that
+// user events from peers are delivered through
+func (h *Handler) SetUserEvents(l *userevent.Log) {
+	h.userEvents = l
+}
+
+// HandleFireEvent fires the user event named in the path,
+// /v1/event/{name}, with the request body as its payload. The event is
+// delivered here and gossiped to every member of the cluster.
+func (h *Handler) HandleFireEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.userEvents == nil {
+		http.Error(w, "User events are not supported", http.StatusNotImplemented)
+		return
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(r.Body, userevent.MaxSize+1))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	event := &peer.UserEvent{
+		Name:    strings.TrimPrefix(r.URL.Path, "/v1/event/"),
+		Payload: payload,
+		Origin:  h.serviceID,
+		Cluster: h.cluster,
+	}
+	if len(payload) == 0 {
+		event.Payload = nil
+	}
+	if err := h.userEvents.Fire(event); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, userevent.ErrTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(event)
+}
+
+// HandleUserEvents handles user events gossiped by peers
+func (h *Handler) HandleUserEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var events []*peer.UserEvent
+	if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	h.MergeUserEvents(events)
+	w.WriteHeader(http.StatusOK)
+}
+
+// MergeUserEvents delivers user events gossiped by peers. Events from
+// other clusters are dropped.
+func (h *Handler) MergeUserEvents(events []*peer.UserEvent) {
+	if h.userEvents == nil {
+		return
+	}
+	for _, e := range events {
+		if e == nil || !h.sameCluster("user event "+e.Name, e.Cluster) {
+			continue
+		}
+		h.userEvents.Receive(e)
+	}
+}
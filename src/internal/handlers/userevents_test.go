@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+	"github.com/rokzabukovec/clip/internal/userevent"
+)
+
+func newUserEventHandler() *Handler {
+	peerList := peer.NewPeerList()
+	h := NewHandler(peerList, "node1", nil)
+	h.SetClusterName("staging")
+	h.SetUserEvents(userevent.NewLog(peer.NewEventBus(peer.DefaultEventHistory), 0))
+	return h
+}
+
+func TestHandler_HandleFireEvent(t *testing.T) {
+	h := newUserEventHandler()
+	_, events, cancel := h.userEvents.Events().Subscribe(0)
+	defer cancel()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/event/deploy", strings.NewReader("v1.4.2"))
+	w := httptest.NewRecorder()
+	h.SetupRoutes().ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var fired peer.UserEvent
+	if err := json.NewDecoder(w.Body).Decode(&fired); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if fired.ID == "" || fired.Name != "deploy" || string(fired.Payload) != "v1.4.2" ||
+		fired.LTime != 1 || fired.Origin != "node1" || fired.Cluster != "staging" {
+		t.Errorf("Unexpected event: %+v", fired)
+	}
+
+	event := <-events
+	if event.Type != peer.EventUser || event.User.ID != fired.ID {
+		t.Errorf("Expected the event to be delivered locally, got %+v", event)
+	}
+}
+
+func TestHandler_HandleFireEvent_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"wrong method", http.MethodGet, "/v1/event/deploy", "", http.StatusMethodNotAllowed},
+		{"no name", http.MethodPost, "/v1/event/", "", http.StatusBadRequest},
+		{"nested name", http.MethodPost, "/v1/event/deploy/web", "", http.StatusBadRequest},
+		{"too large", http.MethodPost, "/v1/event/deploy", strings.Repeat("x", userevent.MaxSize), http.StatusRequestEntityTooLarge},
+	}
+
+	h := newUserEventHandler()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			h.HandleFireEvent(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+
+	t.Run("not supported", func(t *testing.T) {
+		h := NewHandler(peer.NewPeerList(), "node1", nil)
+		req := httptest.NewRequest(http.MethodPost, "/v1/event/deploy", nil)
+		w := httptest.NewRecorder()
+		h.HandleFireEvent(w, req)
+
+		if w.Code != http.StatusNotImplemented {
+			t.Errorf("Expected status 501, got %d", w.Code)
+		}
+	})
+}
+
+func TestHandler_HandleUserEvents(t *testing.T) {
+	h := newUserEventHandler()
+
+	events := []*peer.UserEvent{
+		{ID: "a1", Name: "deploy", LTime: 4, Origin: "node2", Cluster: "staging"},
+		{ID: "a1", Name: "deploy", LTime: 4, Origin: "node2", Cluster: "staging"},
+		{ID: "b2", Name: "deploy", LTime: 5, Origin: "node3", Cluster: "production"},
+	}
+	body, _ := json.Marshal(events)
+	req := httptest.NewRequest(http.MethodPost, "/user-events", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	h.HandleUserEvents(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	// The duplicate and the event from another cluster are dropped
+	if got := h.userEvents.Events().LastID(); got != 1 {
+		t.Errorf("Expected 1 event to be delivered, got %d", got)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/user-events", strings.NewReader("{"))
+	w = httptest.NewRecorder()
+	h.HandleUserEvents(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid body, got %d", w.Code)
+	}
+}
@@ -0,0 +1,180 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+// maxOutput limits how much of a check's output is kept
+const maxOutput = 512
+
+// Duration is a time.Duration that reads and writes JSON as a string such
+// as "10s"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"10s\"")
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Check is a health check the agent runs against one of its own services.
+// Exactly one of HTTP, TCP and Exec is set:
+//   - HTTP: a GET of the URL passes with Status, or any 2xx if Status is unset
+//   - TCP: a connection to the host:port passes
+//   - Exec: the command passes when it exits with 0
+type Check struct {
+	ServiceID string   `json:"service_id"`
+	HTTP      string   `json:"http,omitempty"`
+	Status    int      `json:"status,omitempty"`
+	TCP       string   `json:"tcp,omitempty"`
+	Exec      []string `json:"exec,omitempty"`
+
+	// Interval and Timeout default to the agent's settings when unset
+	Interval Duration `json:"interval,omitempty"`
+	Timeout  Duration `json:"timeout,omitempty"`
+}
+
+// Validate checks that exactly one kind of check is configured
+func (c Check) Validate() error {
+	if c.ServiceID == "" {
+		return fmt.Errorf("check must name a service")
+	}
+	kinds := 0
+	for _, set := range []bool{c.HTTP != "", c.TCP != "", len(c.Exec) > 0} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return fmt.Errorf("check for %s must set exactly one of http, tcp and exec", c.ServiceID)
+	}
+	if c.Interval < 0 || c.Timeout < 0 {
+		return fmt.Errorf("check for %s: interval and timeout must not be negative", c.ServiceID)
+	}
+	return nil
+}
+
+// Type returns the kind of the check: http, tcp or exec
+func (c Check) Type() string {
+	switch {
+	case c.HTTP != "":
+		return "http"
+	case c.TCP != "":
+		return "tcp"
+	default:
+		return "exec"
+	}
+}
+
+// Target returns what the check probes
+func (c Check) Target() string {
+	switch {
+	case c.HTTP != "":
+		return c.HTTP
+	case c.TCP != "":
+		return c.TCP
+	default:
+		return strings.Join(c.Exec, " ")
+	}
+}
+
+// ParseCheck parses a check given as service=target. The target is an
+// http:// or https:// URL, a tcp://host:port address, or a command.
+func ParseCheck(s string) (Check, error) {
+	id, target, ok := strings.Cut(s, "=")
+	if !ok || id == "" || target == "" {
+		return Check{}, fmt.Errorf("check must be service=target, got %q", s)
+	}
+
+	check := Check{ServiceID: id}
+	switch {
+	case strings.HasPrefix(target, "http://"), strings.HasPrefix(target, "https://"):
+		check.HTTP = target
+	case strings.HasPrefix(target, "tcp://"):
+		check.TCP = strings.TrimPrefix(target, "tcp://")
+	default:
+		check.Exec = strings.Fields(target)
+	}
+	return check, nil
+}
+
+// run runs the check once and returns the resulting health and output
+func (c Check) run(timeout time.Duration) (string, string) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	switch c.Type() {
+	case "http":
+		return c.runHTTP(ctx)
+	case "tcp":
+		return c.runTCP(ctx)
+	default:
+		return c.runExec(ctx)
+	}
+}
+
+func (c Check) runHTTP(ctx context.Context) (string, string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.HTTP, nil)
+	if err != nil {
+		return peer.HealthCritical, err.Error()
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return peer.HealthCritical, err.Error()
+	}
+	resp.Body.Close()
+
+	output := fmt.Sprintf("HTTP GET %s: %s", c.HTTP, resp.Status)
+	if c.Status != 0 && resp.StatusCode != c.Status {
+		return peer.HealthCritical, fmt.Sprintf("%s, expected %d", output, c.Status)
+	}
+	if c.Status == 0 && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		return peer.HealthCritical, output
+	}
+	return peer.HealthPassing, output
+}
+
+func (c Check) runTCP(ctx context.Context) (string, string) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", c.TCP)
+	if err != nil {
+		return peer.HealthCritical, err.Error()
+	}
+	conn.Close()
+	return peer.HealthPassing, fmt.Sprintf("TCP connect %s: success", c.TCP)
+}
+
+func (c Check) runExec(ctx context.Context) (string, string) {
+	out, err := exec.CommandContext(ctx, c.Exec[0], c.Exec[1:]...).CombinedOutput()
+	output := strings.TrimSpace(string(out))
+	if len(output) > maxOutput {
+		output = output[:maxOutput]
+	}
+	if err != nil {
+		if output == "" {
+			output = err.Error()
+		}
+		return peer.HealthCritical, output
+	}
+	return peer.HealthPassing, output
+}
@@ -0,0 +1,146 @@
+package health
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+func TestParseCheck(t *testing.T) {
+	tests := []struct {
+		input    string
+		wantType string
+		target   string
+		wantErr  bool
+	}{
+		{"web=http://localhost:80/health", "http", "http://localhost:80/health", false},
+		{"web=https://localhost/health", "http", "https://localhost/health", false},
+		{"db=tcp://localhost:5432", "tcp", "localhost:5432", false},
+		{"app=/usr/local/bin/check.sh --quick", "exec", "/usr/local/bin/check.sh --quick", false},
+		{"web", "", "", true},
+		{"=http://localhost", "", "", true},
+		{"web=", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			check, err := ParseCheck(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if check.Type() != tt.wantType || check.Target() != tt.target {
+				t.Errorf("Expected %s check of %q, got %s check of %q", tt.wantType, tt.target, check.Type(), check.Target())
+			}
+		})
+	}
+}
+
+func TestCheck_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		check   Check
+		wantErr bool
+	}{
+		{"http", Check{ServiceID: "web", HTTP: "http://localhost"}, false},
+		{"no service", Check{HTTP: "http://localhost"}, true},
+		{"no kind", Check{ServiceID: "web"}, true},
+		{"two kinds", Check{ServiceID: "web", HTTP: "http://localhost", TCP: "localhost:80"}, true},
+		{"negative interval", Check{ServiceID: "web", TCP: "localhost:80", Interval: Duration(-time.Second)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.check.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheck_JSON(t *testing.T) {
+	var check Check
+	if err := json.Unmarshal([]byte(`{"service_id": "web", "http": "http://localhost", "interval": "5s"}`), &check); err != nil {
+		t.Fatalf("Expected check to decode, got: %v", err)
+	}
+	if time.Duration(check.Interval) != 5*time.Second {
+		t.Errorf("Expected a 5s interval, got %v", time.Duration(check.Interval))
+	}
+
+	data, _ := json.Marshal(check)
+	if !strings.Contains(string(data), `"interval":"5s"`) {
+		t.Errorf("Expected the interval to be encoded as a string, got %s", data)
+	}
+
+	if err := json.Unmarshal([]byte(`{"interval": 5}`), &check); err == nil {
+		t.Error("Expected a numeric interval to be rejected")
+	}
+}
+
+func TestCheck_RunHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name  string
+		check Check
+		want  string
+	}{
+		{"2xx", Check{ServiceID: "web", HTTP: server.URL + "/up"}, peer.HealthPassing},
+		{"5xx", Check{ServiceID: "web", HTTP: server.URL + "/down"}, peer.HealthCritical},
+		{"expected status", Check{ServiceID: "web", HTTP: server.URL + "/down", Status: 503}, peer.HealthPassing},
+		{"unexpected status", Check{ServiceID: "web", HTTP: server.URL + "/up", Status: 204}, peer.HealthCritical},
+		{"unreachable", Check{ServiceID: "web", HTTP: "http://127.0.0.1:1"}, peer.HealthCritical},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, output := tt.check.run(time.Second); status != tt.want {
+				t.Errorf("Expected %s, got %s: %s", tt.want, status, output)
+			}
+		})
+	}
+}
+
+func TestCheck_RunTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := ln.Addr().String()
+
+	if status, output := (Check{ServiceID: "db", TCP: addr}).run(time.Second); status != peer.HealthPassing {
+		t.Errorf("Expected passing, got %s: %s", status, output)
+	}
+
+	ln.Close()
+	if status, _ := (Check{ServiceID: "db", TCP: addr}).run(time.Second); status != peer.HealthCritical {
+		t.Errorf("Expected critical after the listener closed, got %s", status)
+	}
+}
+
+func TestCheck_RunExec(t *testing.T) {
+	if status, output := (Check{ServiceID: "app", Exec: []string{"sh", "-c", "echo ok"}}).run(time.Second); status != peer.HealthPassing || output != "ok" {
+		t.Errorf("Expected passing with output ok, got %s: %q", status, output)
+	}
+	if status, _ := (Check{ServiceID: "app", Exec: []string{"sh", "-c", "exit 2"}}).run(time.Second); status != peer.HealthCritical {
+		t.Errorf("Expected critical for a non-zero exit, got %s", status)
+	}
+	if status, _ := (Check{ServiceID: "app", Exec: []string{"sleep", "5"}}).run(50 * time.Millisecond); status != peer.HealthCritical {
+		t.Errorf("Expected critical after the timeout, got %s", status)
+	}
+}
@@ -0,0 +1,193 @@
+package health
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Defaults for checkers created without an interval or timeout
+const (
+	DefaultInterval = 10 * time.Second
+	DefaultTimeout  = 5 * time.Second
+)
+
+// ErrStopped is returned when a check is added to a stopped checker
+var ErrStopped = errors.New("health checker is stopped")
+
+// UpdateFunc receives the health of a service after each check run. It is
+// called with the check locked, so it must not call back into the checker.
+type UpdateFunc func(serviceID, health string)
+
+// Result is the latest outcome of a check
+type Result struct {
+	ServiceID   string    `json:"service_id"`
+	Type        string    `json:"type"`
+	Target      string    `json:"target"`
+	Status      string    `json:"status"`
+	Output      string    `json:"output"`
+	LastChecked time.Time `json:"last_checked"`
+}
+
+// runner runs one check on its interval until stopped
+type runner struct {
+	check    Check
+	interval time.Duration
+	timeout  time.Duration
+	stop     chan struct{}
+
+	mu      sync.Mutex
+	stopped bool
+	result  Result
+}
+
+// halt stops the runner. A run that is reporting its result is waited for,
+// so once halt returns the service's health is no longer updated.
+func (r *runner) halt() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.stopped {
+		r.stopped = true
+		close(r.stop)
+	}
+}
+
+// Checker runs the health checks of the local services, one per service
+type Checker struct {
+	mu       sync.Mutex
+	runners  map[string]*runner
+	interval time.Duration
+	timeout  time.Duration
+	onUpdate UpdateFunc
+	stopped  bool
+}
+
+// NewChecker creates a checker that runs checks every interval with the
+// given timeout, unless a check sets its own, and reports each result to
+// onUpdate
+func NewChecker(interval, timeout time.Duration, onUpdate UpdateFunc) *Checker {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Checker{
+		runners:  make(map[string]*runner),
+		interval: interval,
+		timeout:  timeout,
+		onUpdate: onUpdate,
+	}
+}
+
+// Add starts running a check, replacing the service's previous check. The
+// first run starts right away. Checks can't be added once the checker is
+// stopped.
+func (c *Checker) Add(check Check) error {
+	if err := check.Validate(); err != nil {
+		return err
+	}
+
+	r := &runner{
+		check:    check,
+		interval: time.Duration(check.Interval),
+		timeout:  time.Duration(check.Timeout),
+		stop:     make(chan struct{}),
+		result: Result{
+			ServiceID: check.ServiceID,
+			Type:      check.Type(),
+			Target:    check.Target(),
+		},
+	}
+	if r.interval == 0 {
+		r.interval = c.interval
+	}
+	if r.timeout == 0 {
+		r.timeout = c.timeout
+	}
+
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		return ErrStopped
+	}
+	if old, ok := c.runners[check.ServiceID]; ok {
+		old.halt()
+	}
+	c.runners[check.ServiceID] = r
+	c.mu.Unlock()
+
+	go c.run(r)
+	return nil
+}
+
+// Remove stops the check of a service
+func (c *Checker) Remove(serviceID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if r, ok := c.runners[serviceID]; ok {
+		r.halt()
+		delete(c.runners, serviceID)
+	}
+}
+
+// Stop stops all checks
+func (c *Checker) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stopped = true
+	for id, r := range c.runners {
+		r.halt()
+		delete(c.runners, id)
+	}
+}
+
+// Results returns the latest result of every check, ordered by service
+func (c *Checker) Results() []Result {
+	c.mu.Lock()
+	results := make([]Result, 0, len(c.runners))
+	for _, r := range c.runners {
+		r.mu.Lock()
+		results = append(results, r.result)
+		r.mu.Unlock()
+	}
+	c.mu.Unlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].ServiceID < results[j].ServiceID
+	})
+	return results
+}
+
+// run runs a check on its interval until it is stopped or replaced
+func (c *Checker) run(r *runner) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		status, output := r.check.run(r.timeout)
+
+		r.mu.Lock()
+		if r.stopped {
+			// Results of a removed or replaced check are dropped
+			r.mu.Unlock()
+			return
+		}
+		r.result.Status = status
+		r.result.Output = output
+		r.result.LastChecked = time.Now().UTC()
+		if c.onUpdate != nil {
+			c.onUpdate(r.check.ServiceID, status)
+		}
+		r.mu.Unlock()
+
+		select {
+		case <-ticker.C:
+		case <-r.stop:
+			return
+		}
+	}
+}
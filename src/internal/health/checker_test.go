@@ -0,0 +1,153 @@
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+// updates records the results a checker reports
+type updates struct {
+	mu     sync.Mutex
+	health map[string]string
+	count  int
+}
+
+func (u *updates) record(serviceID, health string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.health[serviceID] = health
+	u.count++
+}
+
+func (u *updates) get(serviceID string) (string, int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.health[serviceID], u.count
+}
+
+func TestChecker(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	u := &updates{health: make(map[string]string)}
+	c := NewChecker(20*time.Millisecond, time.Second, u.record)
+	defer c.Stop()
+
+	if err := c.Add(Check{ServiceID: "web"}); err == nil {
+		t.Error("Expected an invalid check to be rejected")
+	}
+	if err := c.Add(Check{ServiceID: "web", HTTP: server.URL}); err != nil {
+		t.Fatalf("Expected check to be added, got: %v", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if health, _ := u.get("web"); health != peer.HealthPassing {
+		t.Errorf("Expected the first run to start right away and pass, got %q", health)
+	}
+
+	healthy.Store(false)
+	time.Sleep(50 * time.Millisecond)
+	if health, _ := u.get("web"); health != peer.HealthCritical {
+		t.Errorf("Expected the check to turn critical, got %q", health)
+	}
+
+	results := c.Results()
+	if len(results) != 1 || results[0].Type != "http" || results[0].Status != peer.HealthCritical || results[0].LastChecked.IsZero() {
+		t.Errorf("Unexpected results: %+v", results)
+	}
+
+	c.Remove("web")
+	_, count := u.get("web")
+	time.Sleep(50 * time.Millisecond)
+	if _, after := u.get("web"); after != count {
+		t.Error("Expected a removed check to stop running")
+	}
+	if len(c.Results()) != 0 {
+		t.Error("Expected no results after the check was removed")
+	}
+}
+
+func TestChecker_Replace(t *testing.T) {
+	u := &updates{health: make(map[string]string)}
+	c := NewChecker(time.Hour, time.Second, u.record)
+	defer c.Stop()
+
+	c.Add(Check{ServiceID: "app", Exec: []string{"false"}})
+	c.Add(Check{ServiceID: "app", Exec: []string{"true"}, Interval: Duration(10 * time.Millisecond)})
+	time.Sleep(50 * time.Millisecond)
+
+	results := c.Results()
+	if len(results) != 1 || results[0].Target != "true" {
+		t.Fatalf("Expected the new check to replace the old one, got %+v", results)
+	}
+	if health, _ := u.get("app"); health != peer.HealthPassing {
+		t.Errorf("Expected the new check's result, got %q", health)
+	}
+}
+
+func TestNewChecker_Defaults(t *testing.T) {
+	c := NewChecker(0, 0, nil)
+	if c.interval != DefaultInterval || c.timeout != DefaultTimeout {
+		t.Errorf("Expected default interval and timeout, got %v and %v", c.interval, c.timeout)
+	}
+}
+
+func TestChecker_RemoveWaitsForUpdate(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	var count atomic.Int32
+	c := NewChecker(10*time.Millisecond, time.Second, func(serviceID, health string) {
+		if count.Add(1) == 1 {
+			close(entered)
+			<-release
+		}
+	})
+	defer c.Stop()
+
+	c.Add(Check{ServiceID: "web", Exec: []string{"true"}})
+	<-entered
+
+	// The first result is being reported while the check is removed
+	removed := make(chan struct{})
+	go func() {
+		c.Remove("web")
+		close(removed)
+	}()
+
+	select {
+	case <-removed:
+		t.Fatal("Expected Remove to wait for the update in flight")
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	close(release)
+	<-removed
+	time.Sleep(50 * time.Millisecond)
+	if n := count.Load(); n != 1 {
+		t.Errorf("Expected no updates after the check was removed, got %d", n)
+	}
+}
+
+func TestChecker_AddAfterStop(t *testing.T) {
+	c := NewChecker(time.Hour, time.Second, nil)
+	c.Stop()
+
+	if err := c.Add(Check{ServiceID: "web", Exec: []string{"true"}}); err != ErrStopped {
+		t.Errorf("Expected ErrStopped, got %v", err)
+	}
+	if len(c.Results()) != 0 {
+		t.Error("Expected no check to run on a stopped checker")
+	}
+}
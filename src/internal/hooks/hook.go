@@ -0,0 +1,74 @@
+package hooks
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+// Hook is an event handler: a command the agent runs when members change.
+// It runs for the listed event types, or for all membership events if none
+// are listed; user events only reach hooks that list them.
+type Hook struct {
+	Events  []peer.EventType `json:"events,omitempty"`
+	Command []string         `json:"command"`
+}
+
+// Validate checks that the hook has a command and known event types
+func (h Hook) Validate() error {
+	if len(h.Command) == 0 {
+		return fmt.Errorf("event handler must have a command")
+	}
+	for _, t := range h.Events {
+		if !t.Valid() {
+			return fmt.Errorf("unknown event type for event handler %s: %s", h.Command[0], t)
+		}
+	}
+	return nil
+}
+
+// Handles reports whether the hook runs for events of the given type
+func (h Hook) Handles(t peer.EventType) bool {
+	if len(h.Events) == 0 {
+		return t != peer.EventUser
+	}
+	for _, e := range h.Events {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseHook parses a hook given as [type[,type...]=]command, e.g.
+// "member-join,member-leave=/usr/local/bin/reload-lb". A command without
+// event types runs for all of them.
+func ParseHook(s string) (Hook, error) {
+	var hook Hook
+	command := s
+	if types, rest, ok := strings.Cut(s, "="); ok && eventTypes(types) != nil {
+		hook.Events = eventTypes(types)
+		command = rest
+	}
+
+	hook.Command = strings.Fields(command)
+	if len(hook.Command) == 0 {
+		return Hook{}, fmt.Errorf("event handler must be [type,...=]command, got %q", s)
+	}
+	return hook, nil
+}
+
+// eventTypes parses a comma-separated list of event types, or returns nil
+// if any of them is not an event type
+func eventTypes(s string) []peer.EventType {
+	types := make([]peer.EventType, 0)
+	for _, t := range strings.Split(s, ",") {
+		t := peer.EventType(strings.TrimSpace(t))
+		if !t.Valid() {
+			return nil
+		}
+		types = append(types, t)
+	}
+	return types
+}
@@ -0,0 +1,77 @@
+package hooks
+
+import (
+	"testing"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+func TestParseHook(t *testing.T) {
+	tests := []struct {
+		input   string
+		events  []peer.EventType
+		command []string
+		wantErr bool
+	}{
+		{"/usr/local/bin/reload-lb", nil, []string{"/usr/local/bin/reload-lb"}, false},
+		{"member-join=/bin/notify joined", []peer.EventType{peer.EventJoin}, []string{"/bin/notify", "joined"}, false},
+		{"member-join, member-leave=/bin/reload", []peer.EventType{peer.EventJoin, peer.EventLeave}, []string{"/bin/reload"}, false},
+		{"env FOO=bar /bin/reload", nil, []string{"env", "FOO=bar", "/bin/reload"}, false},
+		{"member-join=", nil, nil, true},
+		{"", nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			hook, err := ParseHook(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseHook() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(hook.Events) != len(tt.events) || len(hook.Command) != len(tt.command) {
+				t.Fatalf("Expected events %v and command %v, got %+v", tt.events, tt.command, hook)
+			}
+			for i := range tt.events {
+				if hook.Events[i] != tt.events[i] {
+					t.Errorf("Expected event %s, got %s", tt.events[i], hook.Events[i])
+				}
+			}
+			for i := range tt.command {
+				if hook.Command[i] != tt.command[i] {
+					t.Errorf("Expected argument %q, got %q", tt.command[i], hook.Command[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHook_Validate(t *testing.T) {
+	if err := (Hook{Command: []string{"/bin/true"}}).Validate(); err != nil {
+		t.Errorf("Expected a hook with a command to be valid, got: %v", err)
+	}
+	if err := (Hook{}).Validate(); err == nil {
+		t.Error("Expected a hook without a command to be invalid")
+	}
+	if err := (Hook{Events: []peer.EventType{"member-reap"}, Command: []string{"/bin/true"}}).Validate(); err == nil {
+		t.Error("Expected an unknown event type to be invalid")
+	}
+}
+
+func TestHook_Handles(t *testing.T) {
+	all := Hook{Command: []string{"/bin/true"}}
+	joins := Hook{Events: []peer.EventType{peer.EventJoin}, Command: []string{"/bin/true"}}
+
+	for _, eventType := range peer.EventTypes {
+		if all.Handles(eventType) != (eventType != peer.EventUser) {
+			t.Errorf("Expected a hook without events to handle all membership events, got %v for %s", all.Handles(eventType), eventType)
+		}
+	}
+	if !joins.Handles(peer.EventJoin) || joins.Handles(peer.EventLeave) {
+		t.Error("Expected a hook to handle only its own events")
+	}
+	if user := (Hook{Events: []peer.EventType{peer.EventUser}, Command: []string{"/bin/true"}}); !user.Handles(peer.EventUser) {
+		t.Error("Expected a hook that lists user events to handle them")
+	}
+}
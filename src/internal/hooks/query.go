@@ -0,0 +1,110 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// MaxResponseSize limits the output of a query handler
+const MaxResponseSize = 16 * 1024
+
+// QueryHandler is a command that answers queries with the given name. It
+// gets the query's payload on stdin, and its standard output is the
+// response.
+type QueryHandler struct {
+	Name    string   `json:"name"`
+	Command []string `json:"command"`
+}
+
+// Validate checks that the query handler has a name and a command
+func (q QueryHandler) Validate() error {
+	if q.Name == "" || strings.Contains(q.Name, "/") {
+		return fmt.Errorf("query handler name must be non-empty and must not contain '/', got %q", q.Name)
+	}
+	if len(q.Command) == 0 {
+		return fmt.Errorf("query handler for %s must have a command", q.Name)
+	}
+	return nil
+}
+
+// ParseQueryHandler parses a query handler given as name=command, e.g.
+// "uptime=/usr/bin/uptime"
+func ParseQueryHandler(s string) (QueryHandler, error) {
+	name, command, ok := strings.Cut(s, "=")
+	handler := QueryHandler{Name: strings.TrimSpace(name), Command: strings.Fields(command)}
+	if !ok || handler.Validate() != nil {
+		return QueryHandler{}, fmt.Errorf("query handler must be name=command, got %q", s)
+	}
+	return handler, nil
+}
+
+// Responder answers queries by running the query handler for their name
+type Responder struct {
+	handlers map[string]QueryHandler
+	timeout  time.Duration
+	env      []string
+}
+
+// NewResponder creates a responder for query handlers that each may run
+// for timeout. If several handlers have the same name, the first one
+// answers. The handlers run with the agent's environment plus env and the
+// details of the query.
+func NewResponder(handlers []QueryHandler, timeout time.Duration, env []string) *Responder {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	r := &Responder{
+		handlers: make(map[string]QueryHandler, len(handlers)),
+		timeout:  timeout,
+		env:      env,
+	}
+	for _, handler := range handlers {
+		if _, ok := r.handlers[handler.Name]; !ok {
+			r.handlers[handler.Name] = handler
+		}
+	}
+	return r
+}
+
+// Respond runs the handler for the named query with the payload on stdin
+// and the query's name and origin in CLIP_QUERY and CLIP_QUERY_ORIGIN, and
+// returns its output. ok is false if no handler answers the query. The
+// handler is killed when ctx is done or its timeout runs out.
+func (r *Responder) Respond(ctx context.Context, name, origin string, payload []byte) (out []byte, ok bool, err error) {
+	handler, ok := r.handlers[name]
+	if !ok {
+		return nil, false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, handler.Command[0], handler.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.WaitDelay = time.Second
+	cmd.Env = append(os.Environ(), r.env...)
+	cmd.Env = append(cmd.Env, "CLIP_QUERY="+name, "CLIP_QUERY_ORIGIN="+origin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return nil, true, fmt.Errorf("query handler %s timed out", handler.Command[0])
+	case err != nil:
+		output := strings.TrimSpace(stderr.String())
+		if len(output) > maxOutput {
+			output = output[:maxOutput] + "..."
+		}
+		return nil, true, fmt.Errorf("query handler %s failed: %v: %s", handler.Command[0], err, output)
+	case stdout.Len() > MaxResponseSize:
+		return nil, true, fmt.Errorf("query handler %s wrote more than %d bytes", handler.Command[0], MaxResponseSize)
+	}
+	return stdout.Bytes(), true, nil
+}
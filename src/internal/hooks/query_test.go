@@ -0,0 +1,73 @@
+package hooks
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQueryHandler(t *testing.T) {
+	tests := []struct {
+		input   string
+		name    string
+		command []string
+		wantErr bool
+	}{
+		{"uptime=/usr/bin/uptime", "uptime", []string{"/usr/bin/uptime"}, false},
+		{"load = cat /proc/loadavg", "load", []string{"cat", "/proc/loadavg"}, false},
+		{"/usr/bin/uptime", "", nil, true},
+		{"uptime=", "", nil, true},
+		{"a/b=/bin/true", "", nil, true},
+		{"", "", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			handler, err := ParseQueryHandler(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseQueryHandler() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if handler.Name != tt.name || strings.Join(handler.Command, " ") != strings.Join(tt.command, " ") {
+				t.Errorf("Expected %s=%v, got %+v", tt.name, tt.command, handler)
+			}
+		})
+	}
+}
+
+func TestResponder_Respond(t *testing.T) {
+	echo := QueryHandler{Name: "echo", Command: []string{"sh", "-c", `printf '%s %s %s ' "$CLIP_QUERY" "$CLIP_QUERY_ORIGIN" "$CLIP_SELF_ID"; cat`}}
+	fail := QueryHandler{Name: "fail", Command: []string{"sh", "-c", "echo broken >&2; exit 3"}}
+	slow := QueryHandler{Name: "slow", Command: []string{"sleep", "10"}}
+	shadowed := QueryHandler{Name: "echo", Command: []string{"false"}}
+	r := NewResponder([]QueryHandler{echo, fail, slow, shadowed}, 100*time.Millisecond, []string{"CLIP_SELF_ID=node1"})
+
+	out, ok, err := r.Respond(context.Background(), "echo", "node2", []byte("ping"))
+	if err != nil || !ok {
+		t.Fatalf("Expected the echo handler to answer, got ok=%v err=%v", ok, err)
+	}
+	if string(out) != "echo node2 node1 ping" {
+		t.Errorf("Unexpected response %q", out)
+	}
+
+	if _, ok, err := r.Respond(context.Background(), "missing", "node2", nil); ok || err != nil {
+		t.Errorf("Expected no handler for an unknown query, got ok=%v err=%v", ok, err)
+	}
+
+	_, ok, err = r.Respond(context.Background(), "fail", "node2", nil)
+	if !ok || err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("Expected the handler's error output, got ok=%v err=%v", ok, err)
+	}
+
+	start := time.Now()
+	_, _, err = r.Respond(context.Background(), "slow", "node2", nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the slow handler to be killed, took %v", elapsed)
+	}
+}
@@ -0,0 +1,204 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+// DefaultTimeout is how long a hook may run when no timeout is given
+const DefaultTimeout = 30 * time.Second
+
+// queueSize is how many events of one type may wait for the hooks before
+// new ones are dropped
+const queueSize = 256
+
+// maxOutput limits how much of a hook's output is logged
+const maxOutput = 4096
+
+// Runner runs hooks for the events published on a peer list's event bus,
+// and for user events if any hook handles them.
+// Each event type has its own queue, so the hooks for one type run one
+// after another while other types are not held up. Membership events that
+// queue up while the hooks are running are handed to the next run
+// together; user events each get a run of their own.
+type Runner struct {
+	hooks   []Hook
+	timeout time.Duration
+	env     []string
+
+	queues map[peer.EventType]chan peer.Event
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRunner creates a runner for hooks that each may run for timeout. The
+// hooks run with the agent's environment plus env and the details of the
+// event.
+func NewRunner(hooks []Hook, timeout time.Duration, env []string) *Runner {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Runner{
+		hooks:   hooks,
+		timeout: timeout,
+		env:     env,
+		queues:  make(map[peer.EventType]chan peer.Event),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	for _, t := range peer.EventTypes {
+		for _, hook := range hooks {
+			if hook.Handles(t) {
+				r.queues[t] = make(chan peer.Event, queueSize)
+				break
+			}
+		}
+	}
+	return r
+}
+
+// Start starts running hooks for the membership events published on bus
+// and the user events published on users from now on
+func (r *Runner) Start(bus, users *peer.EventBus) {
+	for t, queue := range r.queues {
+		r.wg.Add(1)
+		go r.work(t, queue)
+	}
+	r.wg.Add(1)
+	go r.read(bus, bus.LastID())
+	if _, ok := r.queues[peer.EventUser]; ok && users != nil {
+		r.wg.Add(1)
+		go r.read(users, users.LastID())
+	}
+}
+
+// Stop stops handling events and kills hooks that are still running
+func (r *Runner) Stop() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+// read queues the events after lastID from the bus by type
+func (r *Runner) read(bus *peer.EventBus, lastID uint64) {
+	defer r.wg.Done()
+	bus.Follow(r.ctx, lastID, r.enqueue)
+}
+
+// enqueue hands an event to the queue for its type, if any hook handles it
+func (r *Runner) enqueue(event peer.Event) {
+	queue, ok := r.queues[event.Type]
+	if !ok {
+		return
+	}
+	select {
+	case queue <- event:
+	default:
+		log.Printf("Dropping %s event %d: event handlers are falling behind", event.Type, event.ID)
+	}
+}
+
+// work runs the hooks for one event type, with all events that are
+// waiting at the time
+func (r *Runner) work(t peer.EventType, queue chan peer.Event) {
+	defer r.wg.Done()
+
+	for {
+		select {
+		case event := <-queue:
+			batch := []peer.Event{event}
+		drain:
+			for {
+				select {
+				case event := <-queue:
+					batch = append(batch, event)
+				default:
+					break drain
+				}
+			}
+			for _, hook := range r.hooks {
+				if !hook.Handles(t) {
+					continue
+				}
+				if t != peer.EventUser {
+					r.run(hook, t, batch)
+					continue
+				}
+				for _, event := range batch {
+					r.run(hook, t, []peer.Event{event})
+				}
+			}
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// run runs a hook for a batch of events of type t. The hook gets the
+// affected members as a JSON array on stdin and the event details in
+// CLIP_EVENT, CLIP_EVENT_ID and CLIP_EVENT_COUNT; its output is logged. For
+// a user event it gets the payload on stdin instead, and the event's name
+// and Lamport time in CLIP_USER_EVENT and CLIP_USER_LTIME.
+func (r *Runner) run(hook Hook, t peer.EventType, batch []peer.Event) {
+	var input []byte
+	var env []string
+	if user := batch[0].User; t == peer.EventUser && user != nil {
+		input = user.Payload
+		env = []string{
+			"CLIP_USER_EVENT=" + user.Name,
+			fmt.Sprintf("CLIP_USER_LTIME=%d", user.LTime),
+		}
+	} else {
+		members := make([]*peer.Peer, len(batch))
+		for i, event := range batch {
+			members[i] = event.Peer
+		}
+		var err error
+		if input, err = json.Marshal(members); err != nil {
+			log.Printf("Failed to encode members for event handler %s: %v", hook.Command[0], err)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, r.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	// Don't wait for children the hook left behind once it is killed
+	cmd.WaitDelay = time.Second
+	cmd.Env = append(os.Environ(), r.env...)
+	cmd.Env = append(cmd.Env,
+		"CLIP_EVENT="+string(t),
+		fmt.Sprintf("CLIP_EVENT_ID=%d", batch[len(batch)-1].ID),
+		fmt.Sprintf("CLIP_EVENT_COUNT=%d", len(batch)),
+	)
+	cmd.Env = append(cmd.Env, env...)
+
+	start := time.Now()
+	out, err := cmd.CombinedOutput()
+	output := strings.TrimSpace(string(out))
+	if len(output) > maxOutput {
+		output = output[:maxOutput] + "..."
+	}
+
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		log.Printf("Event handler %s for %s timed out after %v: %s", hook.Command[0], t, r.timeout, output)
+	case err != nil:
+		log.Printf("Event handler %s for %s failed: %v: %s", hook.Command[0], t, err, output)
+	default:
+		log.Printf("Event handler %s for %s finished in %v: %s", hook.Command[0], t, time.Since(start).Round(time.Millisecond), output)
+	}
+}
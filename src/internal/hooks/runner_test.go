@@ -0,0 +1,135 @@
+package hooks
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+// recordingHook returns a hook that appends the event details and the
+// members it was given to a file, one line per run
+func recordingHook(t *testing.T, events ...peer.EventType) (Hook, string) {
+	t.Helper()
+	out := filepath.Join(t.TempDir(), "runs")
+	script := `printf '%s %s %s %s ' "$CLIP_EVENT" "$CLIP_EVENT_ID" "$CLIP_EVENT_COUNT" "$CLIP_SELF_ID" >> "$0"; cat >> "$0"; echo >> "$0"`
+	return Hook{Events: events, Command: []string{"sh", "-c", script, out}}, out
+}
+
+// readRuns returns the lines recorded by a recording hook
+func readRuns(path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+// waitForRuns waits until a recording hook has run n times and returns
+// the recorded lines
+func waitForRuns(t *testing.T, path string, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if runs := readRuns(path); len(runs) >= n {
+			return runs
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d runs of the event handler, got %q", n, readRuns(path))
+	return nil
+}
+
+func TestRunner(t *testing.T) {
+	hook, out := recordingHook(t, peer.EventJoin, peer.EventLeave)
+	pl := peer.NewPeerList()
+	r := NewRunner([]Hook{hook}, time.Second, []string{"CLIP_SELF_ID=node1"})
+	r.Start(pl.Events(), nil)
+	defer r.Stop()
+
+	pl.Add(&peer.Peer{ID: "peer1", Address: "http://127.0.0.1:8081"})
+	waitForRuns(t, out, 1)
+
+	// Suspect events have no handler
+	pl.MarkSuspect("peer1", 0)
+	pl.Merge(&peer.Peer{ID: "peer1", State: peer.StateLeft})
+	runs := waitForRuns(t, out, 2)
+	for i, want := range []string{"member-join 1 1 node1 ", "member-leave 3 1 node1 "} {
+		if !strings.HasPrefix(runs[i], want) {
+			t.Errorf("Expected run %d to start with %q, got %q", i+1, want, runs[i])
+		}
+	}
+
+	var members []*peer.Peer
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(runs[0], "member-join 1 1 node1 ")), &members); err != nil {
+		t.Fatalf("Expected the members as JSON on stdin, got %q: %v", runs[0], err)
+	}
+	if len(members) != 1 || members[0].ID != "peer1" || members[0].State != peer.StateAlive {
+		t.Errorf("Unexpected members: %+v", members)
+	}
+}
+
+func TestRunner_Batches(t *testing.T) {
+	hook, out := recordingHook(t, peer.EventJoin)
+	// The first run holds up the queue so the following joins pile up
+	slow := Hook{Events: []peer.EventType{peer.EventJoin}, Command: []string{"sleep", "0.2"}}
+	pl := peer.NewPeerList()
+	r := NewRunner([]Hook{slow, hook}, time.Second, nil)
+	r.Start(pl.Events(), nil)
+	defer r.Stop()
+
+	pl.Add(&peer.Peer{ID: "peer1", Address: "http://127.0.0.1:8081"})
+	time.Sleep(50 * time.Millisecond)
+	pl.Add(&peer.Peer{ID: "peer2", Address: "http://127.0.0.1:8082"})
+	pl.Add(&peer.Peer{ID: "peer3", Address: "http://127.0.0.1:8083"})
+
+	runs := waitForRuns(t, out, 2)
+	if !strings.HasPrefix(runs[0], "member-join 1 1 ") || !strings.HasPrefix(runs[1], "member-join 3 2 ") {
+		t.Errorf("Expected the second run to handle the two queued joins, got %q", runs)
+	}
+}
+
+func TestRunner_Timeout(t *testing.T) {
+	hook, out := recordingHook(t, peer.EventFailed)
+	slow := Hook{Events: []peer.EventType{peer.EventFailed}, Command: []string{"sleep", "10"}}
+	pl := peer.NewPeerList()
+	r := NewRunner([]Hook{slow, hook}, 50*time.Millisecond, nil)
+	r.Start(pl.Events(), nil)
+	defer r.Stop()
+
+	pl.Add(&peer.Peer{ID: "peer1", Address: "http://127.0.0.1:8081"})
+	pl.MarkSuspect("peer1", 0)
+	pl.MarkDead("peer1", 0)
+
+	// The hung handler is killed, so the next one still runs
+	waitForRuns(t, out, 1)
+}
+
+func TestRunner_UserEvents(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "runs")
+	script := `printf '%s %s %s ' "$CLIP_EVENT" "$CLIP_USER_EVENT" "$CLIP_USER_LTIME" >> "$0"; cat >> "$0"; echo >> "$0"`
+	hook := Hook{Events: []peer.EventType{peer.EventUser}, Command: []string{"sh", "-c", script, out}}
+	// The first run holds up the queue so the following events pile up
+	slow := Hook{Events: []peer.EventType{peer.EventUser}, Command: []string{"sleep", "0.2"}}
+	bus := peer.NewEventBus(peer.DefaultEventHistory)
+	r := NewRunner([]Hook{slow, hook}, time.Second, nil)
+	r.Start(peer.NewEventBus(0), bus)
+	defer r.Stop()
+
+	bus.PublishUser(&peer.UserEvent{ID: "a1", Name: "deploy", Payload: []byte("v1"), LTime: 1})
+	time.Sleep(50 * time.Millisecond)
+	bus.PublishUser(&peer.UserEvent{ID: "b2", Name: "deploy", Payload: []byte("v2"), LTime: 2})
+	bus.PublishUser(&peer.UserEvent{ID: "c3", Name: "flush-cache", LTime: 3})
+
+	// Queued user events are not batched, each keeps its own payload
+	runs := waitForRuns(t, out, 3)
+	for i, want := range []string{"user deploy 1 v1", "user deploy 2 v2", "user flush-cache 3"} {
+		if runs[i] != want {
+			t.Errorf("Expected run %d to be %q, got %q", i+1, want, runs[i])
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package keyring
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Encrypted messages are laid out as
+//
+//	version (1 byte) | nonce (12 bytes) | ciphertext and GCM tag
+const (
+	version   byte = 1
+	nonceSize      = 12
+
+	// Overhead is how many bytes encryption adds to a message
+	Overhead = 1 + nonceSize + 16
+)
+
+var (
+	errEmpty         = errors.New("keyring has no keys")
+	errNoKeyFound    = errors.New("no key in the keyring could decrypt the message")
+	errNotInstalled  = errors.New("key is not installed")
+	errRemovePrimary = errors.New("the primary key can't be removed")
+)
+
+// Keyring holds the symmetric keys shared by the cluster. Messages are
+// encrypted with the primary key and AES-GCM, which also authenticates
+// them; any key in the keyring is accepted when decrypting, so keys can be
+// rotated without downtime.
+type Keyring struct {
+	mu       sync.RWMutex
+	keys     [][]byte // keys[0] is the primary key; never modified in place
+	file     string
+	saveMu   sync.Mutex
+	failures atomic.Uint64
+}
+
+// New creates a keyring with the given keys; the first one is primary. Keys
+// must be 16, 24 or 32 bytes long to select AES-128, AES-192 or AES-256.
+func New(keys ...[]byte) (*Keyring, error) {
+	k := &Keyring{}
+	for _, key := range keys {
+		if err := k.install(key); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Load installs the keys from a keyring file and an encoded key, as read
+// from the config. The file holds a JSON array of base64 keys, the first of
+// which is primary; the encoded key is added after them. Later changes to
+// the keyring are saved back to the file.
+func (k *Keyring) Load(file, encodedKey string) error {
+	var encoded []string
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read keyring file: %w", err)
+		}
+		if err := json.Unmarshal(data, &encoded); err != nil {
+			return fmt.Errorf("failed to parse keyring file: %w", err)
+		}
+	}
+	if encodedKey != "" {
+		encoded = append(encoded, encodedKey)
+	}
+	if len(encoded) == 0 {
+		return errEmpty
+	}
+
+	for _, e := range encoded {
+		key, err := DecodeKey(e)
+		if err != nil {
+			return err
+		}
+		if err := k.install(key); err != nil {
+			return err
+		}
+	}
+
+	k.mu.Lock()
+	k.file = file
+	k.mu.Unlock()
+	return nil
+}
+
+// DecodeKey decodes a base64 key and checks its length
+func DecodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key encoding: %w", err)
+	}
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func validateKey(key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+		return nil
+	}
+	return fmt.Errorf("key must be 16, 24 or 32 bytes, got %d", len(key))
+}
+
+// install adds a key unless it is already in the keyring
+func (k *Keyring) install(key []byte) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.index(key) >= 0 {
+		return nil
+	}
+	keys := make([][]byte, 0, len(k.keys)+1)
+	keys = append(keys, k.keys...)
+	k.keys = append(keys, append([]byte(nil), key...))
+	return nil
+}
+
+// index returns the position of key in the keyring, or -1; the caller must
+// hold the lock
+func (k *Keyring) index(key []byte) int {
+	for i, existing := range k.keys {
+		if bytes.Equal(existing, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Install adds a key to the keyring. It is accepted for decryption right
+// away but only used for encryption once it is made primary with Use.
+func (k *Keyring) Install(key []byte) error {
+	if err := k.install(key); err != nil {
+		return err
+	}
+	return k.save()
+}
+
+// Use makes an installed key the primary key
+func (k *Keyring) Use(key []byte) error {
+	k.mu.Lock()
+	i := k.index(key)
+	if i < 0 {
+		k.mu.Unlock()
+		return errNotInstalled
+	}
+	keys := make([][]byte, 0, len(k.keys))
+	keys = append(keys, k.keys[i])
+	keys = append(keys, k.keys[:i]...)
+	k.keys = append(keys, k.keys[i+1:]...)
+	k.mu.Unlock()
+
+	return k.save()
+}
+
+// Remove removes a key from the keyring. Removing a key that isn't
+// installed is not an error, but the primary key can't be removed.
+func (k *Keyring) Remove(key []byte) error {
+	k.mu.Lock()
+	i := k.index(key)
+	if i < 0 {
+		k.mu.Unlock()
+		return nil
+	}
+	if i == 0 {
+		k.mu.Unlock()
+		return errRemovePrimary
+	}
+	keys := make([][]byte, 0, len(k.keys)-1)
+	keys = append(keys, k.keys[:i]...)
+	k.keys = append(keys, k.keys[i+1:]...)
+	k.mu.Unlock()
+
+	return k.save()
+}
+
+// Fingerprints returns the fingerprints of the installed keys, primary first
+func (k *Keyring) Fingerprints() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	fingerprints := make([]string, len(k.keys))
+	for i, key := range k.keys {
+		fingerprints[i] = Fingerprint(key)
+	}
+	return fingerprints
+}
+
+// Fingerprint identifies a key without revealing it
+func Fingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// save writes the keyring back to the file it was loaded from, if any
+func (k *Keyring) save() error {
+	k.saveMu.Lock()
+	defer k.saveMu.Unlock()
+
+	k.mu.RLock()
+	file := k.file
+	encoded := make([]string, len(k.keys))
+	for i, key := range k.keys {
+		encoded[i] = base64.StdEncoding.EncodeToString(key)
+	}
+	k.mu.RUnlock()
+
+	if file == "" {
+		return nil
+	}
+	data, err := json.Marshal(encoded)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		return fmt.Errorf("failed to save keyring file: %w", err)
+	}
+	return nil
+}
+
+// Encrypt encrypts and authenticates a message with the primary key
+func (k *Keyring) Encrypt(plaintext []byte) ([]byte, error) {
+	k.mu.RLock()
+	if len(k.keys) == 0 {
+		k.mu.RUnlock()
+		return nil, errEmpty
+	}
+	key := k.keys[0]
+	k.mu.RUnlock()
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 1+nonceSize, Overhead+len(plaintext))
+	out[0] = version
+	if _, err := rand.Read(out[1:]); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(out, out[1:], plaintext, nil), nil
+}
+
+// Decrypt verifies and decrypts a message with any key in the keyring.
+// Failures are counted; see Failures.
+func (k *Keyring) Decrypt(msg []byte) ([]byte, error) {
+	plaintext, err := k.decrypt(msg)
+	if err != nil {
+		k.failures.Add(1)
+	}
+	return plaintext, err
+}
+
+func (k *Keyring) decrypt(msg []byte) ([]byte, error) {
+	if len(msg) < Overhead {
+		return nil, errors.New("message too short to be encrypted")
+	}
+	if msg[0] != version {
+		return nil, fmt.Errorf("unsupported encryption version: %d", msg[0])
+	}
+
+	k.mu.RLock()
+	keys := k.keys
+	k.mu.RUnlock()
+
+	nonce, ciphertext := msg[1:1+nonceSize], msg[1+nonceSize:]
+	for _, key := range keys {
+		gcm, err := newGCM(key)
+		if err != nil {
+			continue
+		}
+		if plaintext, err := gcm.Open(nil, nonce, ciphertext, nil); err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, errNoKeyFound
+}
+
+// Failures returns how many messages failed to decrypt
+func (k *Keyring) Failures() uint64 {
+	return k.failures.Load()
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
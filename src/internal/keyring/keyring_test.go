@@ -0,0 +1,227 @@
+package keyring
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(testKey(1), bytes.Repeat([]byte{2}, 16)); err != nil {
+		t.Errorf("Expected valid keys to be accepted, got: %v", err)
+	}
+	if _, err := New([]byte("short")); err == nil {
+		t.Error("Expected a 5 byte key to be rejected")
+	}
+}
+
+func TestKeyring_EncryptDecrypt(t *testing.T) {
+	k, _ := New(testKey(1))
+	plaintext := []byte(`{"id":"node1"}`)
+
+	msg, err := k.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("Expected Encrypt to succeed, got: %v", err)
+	}
+	if len(msg) != len(plaintext)+Overhead {
+		t.Errorf("Expected %d bytes, got %d", len(plaintext)+Overhead, len(msg))
+	}
+	if bytes.Contains(msg, plaintext) {
+		t.Error("Expected the plaintext not to appear in the message")
+	}
+
+	got, err := k.Decrypt(msg)
+	if err != nil {
+		t.Fatalf("Expected Decrypt to succeed, got: %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("Expected %q, got %q", plaintext, got)
+	}
+	if k.Failures() != 0 {
+		t.Errorf("Expected no failures, got %d", k.Failures())
+	}
+}
+
+func TestKeyring_DecryptFailures(t *testing.T) {
+	k, _ := New(testKey(1))
+	other, _ := New(testKey(2))
+
+	msg, _ := other.Encrypt([]byte("hello"))
+	tampered, _ := k.Encrypt([]byte("hello"))
+	tampered[len(tampered)-1] ^= 0xff
+
+	for name, msg := range map[string][]byte{
+		"wrong key": msg,
+		"tampered":  tampered,
+		"plaintext": []byte(`{"type":"CLIP_PEER_DISCOVERY","id":"rogue"}`),
+		"too short": {version},
+	} {
+		if _, err := k.Decrypt(msg); err == nil {
+			t.Errorf("%s: expected Decrypt to fail", name)
+		}
+	}
+
+	if k.Failures() != 4 {
+		t.Errorf("Expected 4 failures, got %d", k.Failures())
+	}
+}
+
+func TestKeyring_AcceptsAnyKey(t *testing.T) {
+	old, _ := New(testKey(1))
+	k, _ := New(testKey(2), testKey(1))
+
+	msg, _ := old.Encrypt([]byte("hello"))
+	if _, err := k.Decrypt(msg); err != nil {
+		t.Errorf("Expected a message under a secondary key to decrypt, got: %v", err)
+	}
+
+	// Messages are encrypted with the primary key only
+	msg, _ = k.Encrypt([]byte("hello"))
+	if _, err := old.Decrypt(msg); err == nil {
+		t.Error("Expected a node without the primary key to fail")
+	}
+}
+
+func TestKeyring_Empty(t *testing.T) {
+	k, _ := New()
+	if _, err := k.Encrypt([]byte("hello")); err == nil {
+		t.Error("Expected Encrypt to fail without keys")
+	}
+	if _, err := k.Decrypt(make([]byte, 64)); err == nil {
+		t.Error("Expected Decrypt to fail without keys")
+	}
+}
+
+func TestKeyring_Load(t *testing.T) {
+	key1 := base64.StdEncoding.EncodeToString(testKey(1))
+	key2 := base64.StdEncoding.EncodeToString(testKey(2))
+	key3 := base64.StdEncoding.EncodeToString(testKey(3))
+
+	file := filepath.Join(t.TempDir(), "keyring.json")
+	os.WriteFile(file, []byte(`["`+key1+`", "`+key2+`"]`), 0600)
+
+	k, _ := New()
+	if err := k.Load(file, key3); err != nil {
+		t.Fatalf("Expected Load to succeed, got: %v", err)
+	}
+
+	// The first key in the file is primary
+	primary, _ := New(testKey(1))
+	msg, _ := k.Encrypt([]byte("hello"))
+	if _, err := primary.Decrypt(msg); err != nil {
+		t.Errorf("Expected the first key in the file to be primary, got: %v", err)
+	}
+	for _, key := range [][]byte{testKey(2), testKey(3)} {
+		sender, _ := New(key)
+		msg, _ := sender.Encrypt([]byte("hello"))
+		if _, err := k.Decrypt(msg); err != nil {
+			t.Errorf("Expected all loaded keys to be accepted, got: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		file string
+		key  string
+	}{
+		{"nothing configured", "", ""},
+		{"missing file", filepath.Join(t.TempDir(), "missing.json"), ""},
+		{"invalid encoding", "", "not base64!"},
+		{"wrong key length", "", base64.StdEncoding.EncodeToString([]byte("short"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, _ := New()
+			if err := k.Load(tt.file, tt.key); err == nil {
+				t.Error("Expected Load to fail")
+			}
+		})
+	}
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	k, _ := New(testKey(1))
+	old, _ := New(testKey(1))
+	rotated, _ := New(testKey(2))
+
+	if err := k.Use(testKey(2)); err == nil {
+		t.Error("Expected Use to fail for a key that isn't installed")
+	}
+
+	// Installing a key accepts it but keeps encrypting with the old one
+	if err := k.Install(testKey(2)); err != nil {
+		t.Fatalf("Expected Install to succeed, got: %v", err)
+	}
+	msg, _ := rotated.Encrypt([]byte("hello"))
+	if _, err := k.Decrypt(msg); err != nil {
+		t.Errorf("Expected an installed key to be accepted, got: %v", err)
+	}
+	msg, _ = k.Encrypt([]byte("hello"))
+	if _, err := old.Decrypt(msg); err != nil {
+		t.Errorf("Expected the old key to stay primary, got: %v", err)
+	}
+
+	if err := k.Use(testKey(2)); err != nil {
+		t.Fatalf("Expected Use to succeed, got: %v", err)
+	}
+	msg, _ = k.Encrypt([]byte("hello"))
+	if _, err := rotated.Decrypt(msg); err != nil {
+		t.Errorf("Expected the new key to be primary, got: %v", err)
+	}
+
+	if err := k.Remove(testKey(2)); err == nil {
+		t.Error("Expected removing the primary key to fail")
+	}
+	if err := k.Remove(testKey(1)); err != nil {
+		t.Fatalf("Expected Remove to succeed, got: %v", err)
+	}
+	msg, _ = old.Encrypt([]byte("hello"))
+	if _, err := k.Decrypt(msg); err == nil {
+		t.Error("Expected a removed key to be rejected")
+	}
+	if err := k.Remove(testKey(1)); err != nil {
+		t.Errorf("Expected removing a missing key to be a no-op, got: %v", err)
+	}
+
+	fingerprints := k.Fingerprints()
+	if len(fingerprints) != 1 || fingerprints[0] != Fingerprint(testKey(2)) {
+		t.Errorf("Expected only the new key's fingerprint, got %v", fingerprints)
+	}
+}
+
+func TestKeyring_SavesChanges(t *testing.T) {
+	key1 := base64.StdEncoding.EncodeToString(testKey(1))
+	file := filepath.Join(t.TempDir(), "keyring.json")
+	os.WriteFile(file, []byte(`["`+key1+`"]`), 0600)
+
+	k, _ := New()
+	if err := k.Load(file, ""); err != nil {
+		t.Fatalf("Expected Load to succeed, got: %v", err)
+	}
+	k.Install(testKey(2))
+	k.Use(testKey(2))
+
+	reloaded, _ := New()
+	if err := reloaded.Load(file, ""); err != nil {
+		t.Fatalf("Expected the saved file to load, got: %v", err)
+	}
+	got := reloaded.Fingerprints()
+	if len(got) != 2 || got[0] != Fingerprint(testKey(2)) || got[1] != Fingerprint(testKey(1)) {
+		t.Errorf("Expected the rotated keyring to be saved, got %v", got)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	if Fingerprint(testKey(1)) == Fingerprint(testKey(2)) {
+		t.Error("Expected different keys to have different fingerprints")
+	}
+	if len(Fingerprint(testKey(1))) != 16 {
+		t.Errorf("Expected a 16 character fingerprint, got %q", Fingerprint(testKey(1)))
+	}
+}
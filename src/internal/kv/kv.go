@@ -0,0 +1,417 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+// Limits that keep entries small enough to gossip
+const (
+	MaxKeySize   = 512
+	MaxValueSize = 64 * 1024
+)
+
+// ErrTooLarge is returned for values larger than MaxValueSize
+var ErrTooLarge = errors.New("value too large")
+
+// ErrNotSynced is returned for local writes before the store has caught up
+// with the cluster
+var ErrNotSynced = errors.New("key/value store is not synced with the cluster yet")
+
+// Entry is a key's value and the version that wrote it. Versions are
+// ordered by Lamport time, with the writing node's ID breaking ties, and
+// the latest write wins everywhere. Deletes are kept as tombstones for a
+// retention period so they win over older writes that are still being
+// gossiped.
+type Entry struct {
+	Key     string `json:"key"`
+	Value   []byte `json:"value,omitempty"`
+	LTime   uint64 `json:"ltime"`
+	Node    string `json:"node"`
+	Deleted bool   `json:"deleted,omitempty"`
+
+	// ModifyIndex is the store index of the last change to the key on
+	// this node. It is local and ignored in entries from other nodes.
+	ModifyIndex uint64 `json:"modify_index"`
+
+	// deletedAt is when this node stored the tombstone
+	deletedAt time.Time
+}
+
+// removal records the version of a reaped tombstone, so that older writes
+// still being gossiped don't bring the key back
+type removal struct {
+	digest Digest
+	at     time.Time
+}
+
+// Digest identifies the version of an entry without its value
+type Digest struct {
+	Key   string `json:"key"`
+	LTime uint64 `json:"ltime"`
+	Node  string `json:"node"`
+}
+
+// Digest returns the digest of the entry
+func (e *Entry) Digest() Digest {
+	return Digest{Key: e.Key, LTime: e.LTime, Node: e.Node}
+}
+
+// Supersedes reports whether the version d was written after other
+func (d Digest) Supersedes(other Digest) bool {
+	if d.LTime != other.LTime {
+		return d.LTime > other.LTime
+	}
+	return d.Node > other.Node
+}
+
+// ValidateKey checks that a key is non-empty, at most MaxKeySize bytes and
+// free of empty path segments
+func ValidateKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("key must not be empty")
+	}
+	if len(key) > MaxKeySize {
+		return fmt.Errorf("key exceeds %d bytes", MaxKeySize)
+	}
+	if strings.HasPrefix(key, "/") || strings.Contains(key, "//") {
+		return fmt.Errorf("key must not have empty path segments: %q", key)
+	}
+	return nil
+}
+
+// Store is this node's replica of the cluster's key/value map. Local
+// writes are stamped with the store's Lamport clock; writes from other
+// nodes are merged in and move the clock past their time.
+type Store struct {
+	node     string
+	clock    peer.LamportClock
+	onChange func(e *Entry)
+
+	mu      sync.RWMutex
+	entries map[string]*Entry
+	index   uint64
+	changed chan struct{}
+	synced  bool
+	removed map[string]removal
+}
+
+// NewStore creates an empty, synced store for the node with the given ID
+func NewStore(node string) *Store {
+	return &Store{
+		node:    node,
+		entries: make(map[string]*Entry),
+		index:   1,
+		changed: make(chan struct{}),
+		synced:  true,
+		removed: make(map[string]removal),
+	}
+}
+
+// SetSynced sets whether the store has caught up with the cluster. Until it
+// has, local writes fail with ErrNotSynced: the clock of a store that starts
+// empty, e.g. after a restart, is behind the times the cluster has seen, so
+// its writes would lose to older ones.
+func (s *Store) SetSynced(synced bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.synced = synced
+}
+
+// Synced reports whether the store takes local writes
+func (s *Store) Synced() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.synced
+}
+
+// SetOnChange sets a callback invoked with a copy of every entry that
+// changes, by a local write or a gossiped merge, so it can be passed on.
+// It must be set before the store is shared between goroutines.
+func (s *Store) SetOnChange(onChange func(e *Entry)) {
+	s.onChange = onChange
+}
+
+// Get returns the entry for a key, or false if the key is not set
+func (s *Store) Get(key string) (*Entry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.entries[key]
+	if !ok || e.Deleted {
+		return nil, false
+	}
+	copied := *e
+	return &copied, true
+}
+
+// List returns the entries whose keys start with prefix, sorted by key
+func (s *Store) List(prefix string) []*Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := make([]*Entry, 0)
+	for key, e := range s.entries {
+		if !e.Deleted && strings.HasPrefix(key, prefix) {
+			copied := *e
+			entries = append(entries, &copied)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
+}
+
+// Put sets a key to value and returns the new entry
+func (s *Store) Put(key string, value []byte) (*Entry, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+	if len(value) > MaxValueSize {
+		return nil, fmt.Errorf("%w: %d bytes, at most %d", ErrTooLarge, len(value), MaxValueSize)
+	}
+	return s.write(&Entry{Key: key, Value: value})
+}
+
+// Delete removes a key and returns its tombstone. Deleting a key that is
+// not set still writes a tombstone, which wins over concurrent older
+// writes.
+func (s *Store) Delete(key string) (*Entry, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+	return s.write(&Entry{Key: key, Deleted: true})
+}
+
+// write stamps a local write with a new Lamport time and applies it
+func (s *Store) write(e *Entry) (*Entry, error) {
+	s.mu.Lock()
+	if !s.synced {
+		s.mu.Unlock()
+		return nil, ErrNotSynced
+	}
+	e.LTime = s.clock.Increment()
+	e.Node = s.node
+	written := s.apply(e)
+	s.mu.Unlock()
+
+	s.notify(written)
+	return written, nil
+}
+
+// Merge applies entries gossiped by other nodes and returns copies of
+// those that were newer than ours
+func (s *Store) Merge(entries []*Entry) []*Entry {
+	applied := s.merge(entries)
+	for _, e := range applied {
+		s.notify(e)
+	}
+	return applied
+}
+
+// Sync applies entries learned in a full state exchange like Merge, but
+// doesn't invoke the change callback for them: the exchange brings both
+// sides up to date, so they need not be gossiped on
+func (s *Store) Sync(entries []*Entry) []*Entry {
+	return s.merge(entries)
+}
+
+// merge applies entries from other nodes and returns copies of those that
+// were newer than ours
+func (s *Store) merge(entries []*Entry) []*Entry {
+	applied := make([]*Entry, 0)
+
+	s.mu.Lock()
+	for _, e := range entries {
+		if e == nil || ValidateKey(e.Key) != nil || len(e.Value) > MaxValueSize {
+			continue
+		}
+		s.clock.Witness(e.LTime)
+		if current, ok := s.entries[e.Key]; ok && !e.Digest().Supersedes(current.Digest()) {
+			continue
+		}
+		if r, ok := s.removed[e.Key]; ok && !e.Digest().Supersedes(r.digest) {
+			continue
+		}
+		incoming := *e
+		if incoming.Deleted {
+			incoming.Value = nil
+		}
+		applied = append(applied, s.apply(&incoming))
+	}
+	s.mu.Unlock()
+	return applied
+}
+
+// apply stores an entry, bumps the index and wakes up everyone waiting
+// for a change, and returns a copy of the stored entry; the caller must
+// hold the write lock
+func (s *Store) apply(e *Entry) *Entry {
+	s.index++
+	e.ModifyIndex = s.index
+	if e.Deleted {
+		e.deletedAt = time.Now()
+	}
+	delete(s.removed, e.Key)
+	s.entries[e.Key] = e
+	close(s.changed)
+	s.changed = make(chan struct{})
+
+	copied := *e
+	return &copied
+}
+
+// notify invokes the change callback; the caller must not hold the lock
+func (s *Store) notify(e *Entry) {
+	if s.onChange != nil {
+		s.onChange(e)
+	}
+}
+
+// Reap removes tombstones stored more than retention ago and returns their
+// keys. The removal is remembered for another retention period so that
+// older writes still being gossiped don't bring the key back.
+func (s *Store) Reap(retention time.Duration) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, r := range s.removed {
+		if now.Sub(r.at) > retention {
+			delete(s.removed, key)
+		}
+	}
+
+	reaped := make([]string, 0)
+	for key, e := range s.entries {
+		if e.Deleted && now.Sub(e.deletedAt) > retention {
+			delete(s.entries, key)
+			s.removed[key] = removal{digest: e.Digest(), at: now}
+			reaped = append(reaped, key)
+		}
+	}
+	return reaped
+}
+
+// Digest returns the versions of all entries, tombstones included
+func (s *Store) Digest() []Digest {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	digest := make([]Digest, 0, len(s.entries))
+	for _, e := range s.entries {
+		digest = append(digest, e.Digest())
+	}
+	return digest
+}
+
+// Diff compares a remote digest with our entries and returns the entries
+// the remote side is missing or has older versions of, and the keys it
+// has newer versions of
+func (s *Store) Diff(remote []Digest) (newer []*Entry, want []string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	newer = make([]*Entry, 0)
+	want = make([]string, 0)
+	known := make(map[string]bool, len(remote))
+	for _, d := range remote {
+		known[d.Key] = true
+		e, ok := s.entries[d.Key]
+		if r, removed := s.removed[d.Key]; !ok && removed && !d.Supersedes(r.digest) {
+			continue
+		}
+		if !ok || d.Supersedes(e.Digest()) {
+			want = append(want, d.Key)
+			continue
+		}
+		if e.Digest().Supersedes(d) {
+			copied := *e
+			newer = append(newer, &copied)
+		}
+	}
+	for key, e := range s.entries {
+		if !known[key] {
+			copied := *e
+			newer = append(newer, &copied)
+		}
+	}
+	return newer, want
+}
+
+// Entries returns the entries for the given keys, tombstones included
+func (s *Store) Entries(keys []string) []*Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := make([]*Entry, 0, len(keys))
+	for _, key := range keys {
+		if e, ok := s.entries[key]; ok {
+			copied := *e
+			entries = append(entries, &copied)
+		}
+	}
+	return entries
+}
+
+// Index returns the modification index of the store. It starts at 1 and
+// grows with every write, local or merged.
+func (s *Store) Index() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.index
+}
+
+// KeyIndex returns the modification index of a key, or with recurse the
+// highest one among the keys under it as a prefix. Deletes count as
+// modifications. For keys that were never written it is the store's index.
+func (s *Store) KeyIndex(key string, recurse bool) uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keyIndex(key, recurse)
+}
+
+// keyIndex implements KeyIndex; the caller must hold the lock
+func (s *Store) keyIndex(key string, recurse bool) uint64 {
+	if !recurse {
+		if e, ok := s.entries[key]; ok {
+			return e.ModifyIndex
+		}
+		return s.index
+	}
+
+	var index uint64
+	for k, e := range s.entries {
+		if strings.HasPrefix(k, key) && e.ModifyIndex > index {
+			index = e.ModifyIndex
+		}
+	}
+	if index == 0 {
+		return s.index
+	}
+	return index
+}
+
+// WaitForChange blocks until the modification index of a key, or of the
+// keys under it with recurse, is greater than index or ctx is done, and
+// returns that index
+func (s *Store) WaitForChange(ctx context.Context, key string, recurse bool, index uint64) uint64 {
+	for {
+		s.mu.RLock()
+		current, changed := s.keyIndex(key, recurse), s.changed
+		s.mu.RUnlock()
+
+		if current > index {
+			return current
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return current
+		}
+	}
+}
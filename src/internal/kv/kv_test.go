@@ -0,0 +1,250 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateKey(t *testing.T) {
+	tests := []struct {
+		key     string
+		wantErr bool
+	}{
+		{"feature/dark-mode", false},
+		{"config/", false},
+		{"", true},
+		{"/config", true},
+		{"config//web", true},
+		{strings.Repeat("k", MaxKeySize+1), true},
+	}
+
+	for _, tt := range tests {
+		if err := ValidateKey(tt.key); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateKey(%q) error = %v, wantErr %v", tt.key, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDigest_Supersedes(t *testing.T) {
+	older := Digest{Key: "k", LTime: 3, Node: "node2"}
+	newer := Digest{Key: "k", LTime: 4, Node: "node1"}
+	tie := Digest{Key: "k", LTime: 4, Node: "node2"}
+
+	if !newer.Supersedes(older) || older.Supersedes(newer) {
+		t.Error("Expected the higher Lamport time to win")
+	}
+	if !tie.Supersedes(newer) || newer.Supersedes(tie) {
+		t.Error("Expected the higher node ID to break ties")
+	}
+	if newer.Supersedes(newer) {
+		t.Error("Expected a version not to supersede itself")
+	}
+}
+
+func TestStore_PutGetDelete(t *testing.T) {
+	s := NewStore("node1")
+	var changes []*Entry
+	s.SetOnChange(func(e *Entry) { changes = append(changes, e) })
+
+	e, err := s.Put("feature/dark-mode", []byte("on"))
+	if err != nil {
+		t.Fatalf("Expected put to succeed, got: %v", err)
+	}
+	if e.LTime != 1 || e.Node != "node1" || e.ModifyIndex != 2 {
+		t.Errorf("Unexpected entry: %+v", e)
+	}
+
+	got, ok := s.Get("feature/dark-mode")
+	if !ok || string(got.Value) != "on" {
+		t.Errorf("Expected the value to be set, got %+v", got)
+	}
+
+	s.Put("feature/beta", []byte("off"))
+	s.Put("config/web", []byte("8080"))
+	if list := s.List("feature/"); len(list) != 2 || list[0].Key != "feature/beta" {
+		t.Errorf("Expected 2 sorted entries under feature/, got %+v", list)
+	}
+
+	tombstone, err := s.Delete("feature/dark-mode")
+	if err != nil || !tombstone.Deleted || tombstone.LTime != 4 {
+		t.Fatalf("Expected a tombstone, got %+v, %v", tombstone, err)
+	}
+	if _, ok := s.Get("feature/dark-mode"); ok {
+		t.Error("Expected the key to be deleted")
+	}
+	if len(s.List("")) != 2 {
+		t.Errorf("Expected tombstones to be left out of lists, got %+v", s.List(""))
+	}
+	if len(changes) != 4 {
+		t.Errorf("Expected 4 changes to be reported, got %d", len(changes))
+	}
+
+	if _, err := s.Put("big", make([]byte, MaxValueSize+1)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+	if _, err := s.Put("", nil); err == nil {
+		t.Error("Expected an empty key to fail")
+	}
+}
+
+func TestStore_SetSynced(t *testing.T) {
+	s := NewStore("node1")
+	s.SetSynced(false)
+
+	if _, err := s.Put("flag", []byte("on")); !errors.Is(err, ErrNotSynced) {
+		t.Errorf("Expected ErrNotSynced for a put, got %v", err)
+	}
+	if _, err := s.Delete("flag"); !errors.Is(err, ErrNotSynced) {
+		t.Errorf("Expected ErrNotSynced for a delete, got %v", err)
+	}
+	// Merges still apply, and move the clock
+	s.Sync([]*Entry{{Key: "flag", Value: []byte("remote"), LTime: 7, Node: "node2"}})
+
+	s.SetSynced(true)
+	if e, err := s.Put("flag", []byte("on")); err != nil || e.LTime <= 7 {
+		t.Errorf("Expected a write past the synced times, got %+v, %v", e, err)
+	}
+}
+
+func TestStore_Merge(t *testing.T) {
+	s := NewStore("node1")
+	s.Put("flag", []byte("local"))
+
+	applied := s.Merge([]*Entry{
+		{Key: "flag", Value: []byte("remote"), LTime: 5, Node: "node2", ModifyIndex: 99},
+		{Key: "other", Value: []byte("x"), LTime: 2, Node: "node3"},
+		{Key: "other", Value: []byte("stale"), LTime: 1, Node: "node3"},
+		{Key: "//bad", LTime: 9, Node: "node3"},
+		nil,
+	})
+	if len(applied) != 2 {
+		t.Fatalf("Expected 2 entries to be applied, got %+v", applied)
+	}
+	if e, _ := s.Get("flag"); string(e.Value) != "remote" || e.ModifyIndex == 99 {
+		t.Errorf("Expected the newer remote value with a local index, got %+v", e)
+	}
+
+	// The clock moved past the remote write, so a local write wins
+	if e, _ := s.Put("flag", []byte("again")); e.LTime <= 5 {
+		t.Errorf("Expected the clock to move past merged times, got %d", e.LTime)
+	}
+
+	// An older delete loses, a newer one wins
+	s.Merge([]*Entry{{Key: "other", LTime: 1, Node: "node4", Deleted: true}})
+	if _, ok := s.Get("other"); !ok {
+		t.Error("Expected an older delete to lose")
+	}
+	s.Merge([]*Entry{{Key: "other", Value: []byte("x"), LTime: 20, Node: "node4", Deleted: true}})
+	if _, ok := s.Get("other"); ok {
+		t.Error("Expected a newer delete to win")
+	}
+	if e := s.Entries([]string{"other"}); len(e) != 1 || e[0].Value != nil {
+		t.Errorf("Expected a tombstone without a value, got %+v", e)
+	}
+}
+
+func TestStore_Sync(t *testing.T) {
+	s := NewStore("node1")
+	var changes []*Entry
+	s.SetOnChange(func(e *Entry) { changes = append(changes, e) })
+
+	applied := s.Sync([]*Entry{{Key: "flag", Value: []byte("remote"), LTime: 5, Node: "node2"}})
+	if len(applied) != 1 {
+		t.Fatalf("Expected the entry to be applied, got %+v", applied)
+	}
+	if len(changes) != 0 {
+		t.Errorf("Expected synced entries not to be passed on, got %+v", changes)
+	}
+	if e, _ := s.Put("flag", []byte("local")); e.LTime <= 5 {
+		t.Errorf("Expected the clock to move past synced times, got %d", e.LTime)
+	}
+}
+
+func TestStore_Reap(t *testing.T) {
+	s := NewStore("node1")
+	s.Put("kept", []byte("1"))
+	s.Put("gone", []byte("2"))
+	s.Delete("gone")
+
+	if reaped := s.Reap(time.Hour); len(reaped) != 0 {
+		t.Errorf("Expected recent tombstones to be kept, got %v", reaped)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if reaped := s.Reap(10 * time.Millisecond); len(reaped) != 1 || reaped[0] != "gone" {
+		t.Fatalf("Expected the old tombstone to be reaped, got %v", reaped)
+	}
+	if e := s.Entries([]string{"gone", "kept"}); len(e) != 1 || e[0].Key != "kept" {
+		t.Errorf("Expected only the live key to remain, got %+v", e)
+	}
+
+	// Older writes still being gossiped don't bring the key back, and the
+	// key isn't asked for in push-pull
+	if applied := s.Merge([]*Entry{{Key: "gone", Value: []byte("2"), LTime: 2, Node: "node1"}}); len(applied) != 0 {
+		t.Errorf("Expected a write older than the reaped delete to be dropped, got %+v", applied)
+	}
+	if _, want := s.Diff([]Digest{{Key: "gone", LTime: 2, Node: "node1"}}); len(want) != 0 {
+		t.Errorf("Expected not to want an older version of a reaped key, got %v", want)
+	}
+	if applied := s.Merge([]*Entry{{Key: "gone", Value: []byte("3"), LTime: 9, Node: "node2"}}); len(applied) != 1 {
+		t.Errorf("Expected a newer write to set the key again, got %+v", applied)
+	}
+}
+
+func TestStore_Diff(t *testing.T) {
+	a := NewStore("node1")
+	b := NewStore("node2")
+	a.Put("only-a", []byte("1"))
+	a.Put("shared", []byte("a"))
+	b.Merge(a.Entries([]string{"shared"}))
+	b.Put("shared", []byte("b"))
+	b.Put("only-b", []byte("2"))
+
+	// a asks b: b returns what a is missing or has older, and wants only-a
+	newer, want := b.Diff(a.Digest())
+	if len(want) != 1 || want[0] != "only-a" {
+		t.Errorf("Expected b to want only-a, got %v", want)
+	}
+	a.Merge(newer)
+	b.Merge(a.Entries(want))
+
+	for _, key := range []string{"only-a", "only-b", "shared"} {
+		ea, _ := a.Get(key)
+		eb, _ := b.Get(key)
+		if ea == nil || eb == nil || string(ea.Value) != string(eb.Value) {
+			t.Errorf("Expected both stores to agree on %s, got %+v and %+v", key, ea, eb)
+		}
+	}
+	if newer, want := b.Diff(a.Digest()); len(newer) != 0 || len(want) != 0 {
+		t.Errorf("Expected no difference after a round, got %+v and %v", newer, want)
+	}
+}
+
+func TestStore_WaitForChange(t *testing.T) {
+	s := NewStore("node1")
+	s.Put("config/web", []byte("1"))
+	index := s.KeyIndex("config/web", false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if got := s.WaitForChange(ctx, "config/web", false, index); got != index {
+		t.Errorf("Expected the wait to time out at index %d, got %d", index, got)
+	}
+
+	done := make(chan uint64)
+	go func() {
+		done <- s.WaitForChange(context.Background(), "config/", true, index)
+	}()
+	s.Put("config/db", []byte("2"))
+
+	select {
+	case got := <-done:
+		if got <= index {
+			t.Errorf("Expected the index to move past %d, got %d", index, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the watch on the prefix to wake up")
+	}
+}
@@ -0,0 +1,32 @@
+package peer
+
+import "sync/atomic"
+
+// LamportClock is a logical clock that orders things that happen across the
+// cluster without relying on wall clocks. Each node increments it for the
+// things it starts and moves it past every time it hears from others, so
+// a later event always carries a higher time than the events its origin
+// had seen. The zero value is ready to use.
+type LamportClock struct {
+	counter atomic.Uint64
+}
+
+// Time returns the current time
+func (c *LamportClock) Time() uint64 {
+	return c.counter.Load()
+}
+
+// Increment advances the clock and returns the new time
+func (c *LamportClock) Increment() uint64 {
+	return c.counter.Add(1)
+}
+
+// Witness moves the clock past a time seen in a message from another node
+func (c *LamportClock) Witness(t uint64) {
+	for {
+		current := c.counter.Load()
+		if t < current || c.counter.CompareAndSwap(current, t+1) {
+			return
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package peer
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestLamportClock(t *testing.T) {
+	var c LamportClock
+	if c.Time() != 0 {
+		t.Fatalf("Expected a new clock to start at 0, got %d", c.Time())
+	}
+	if got := c.Increment(); got != 1 {
+		t.Errorf("Expected Increment to return 1, got %d", got)
+	}
+
+	c.Witness(10)
+	if c.Time() != 11 {
+		t.Errorf("Expected the clock to move past a witnessed time, got %d", c.Time())
+	}
+
+	// Older times don't move it back
+	c.Witness(5)
+	if c.Time() != 11 {
+		t.Errorf("Expected an older time to be ignored, got %d", c.Time())
+	}
+}
+
+func TestLamportClock_Concurrent(t *testing.T) {
+	var c LamportClock
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			c.Increment()
+		}()
+		go func(i int) {
+			defer wg.Done()
+			c.Witness(uint64(i))
+		}(i)
+	}
+	wg.Wait()
+
+	if c.Time() < 50 {
+		t.Errorf("Expected every increment to count, got %d", c.Time())
+	}
+}
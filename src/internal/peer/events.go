@@ -0,0 +1,212 @@
+package peer
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// EventType describes how a member changed, or that a user event arrived
+type EventType string
+
+const (
+	// EventJoin is published when a member is added, or comes back after
+	// it failed or left
+	EventJoin EventType = "member-join"
+	// EventUpdate is published when a member changes its metadata or
+	// refutes a suspicion
+	EventUpdate EventType = "member-update"
+	// EventSuspect is published when a member becomes suspect
+	EventSuspect EventType = "member-suspect"
+	// EventFailed is published when a member is declared dead
+	EventFailed EventType = "member-failed"
+	// EventLeave is published when a member leaves gracefully
+	EventLeave EventType = "member-leave"
+	// EventUser is published when a user event fired anywhere in the
+	// cluster reaches this node
+	EventUser EventType = "user"
+)
+
+// EventTypes lists every event type
+var EventTypes = []EventType{EventJoin, EventUpdate, EventSuspect, EventFailed, EventLeave, EventUser}
+
+// Valid reports whether t is one of the event types
+func (t EventType) Valid() bool {
+	for _, known := range EventTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// eventType returns the event for a member that went from state prev to
+// its current record; prev is empty for a member that was not known
+func eventType(prev State, p *Peer) EventType {
+	switch {
+	case p.State == StateLeft:
+		return EventLeave
+	case p.State == StateDead && prev != StateDead:
+		return EventFailed
+	case p.State == StateSuspect && prev != StateSuspect:
+		return EventSuspect
+	case p.State == StateAlive && (prev == "" || prev == StateDead || prev == StateLeft):
+		return EventJoin
+	default:
+		return EventUpdate
+	}
+}
+
+// Event is a change to a member of the peer list, or a user event. IDs
+// increase by one with every event, so a subscriber can tell which events
+// it has seen.
+type Event struct {
+	ID   uint64     `json:"id"`
+	Type EventType  `json:"type"`
+	Time time.Time  `json:"time"`
+	Peer *Peer      `json:"peer,omitempty"`
+	User *UserEvent `json:"user,omitempty"`
+}
+
+// UserEvent is a custom event with a small payload that a member fires to
+// the whole cluster. ID and LTime, the Lamport time it was fired at,
+// identify it, so copies that arrive over several gossip paths are
+// delivered once.
+type UserEvent struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Payload []byte `json:"payload,omitempty"`
+	LTime   uint64 `json:"ltime"`
+	Origin  string `json:"origin"`
+	Cluster string `json:"cluster,omitempty"`
+}
+
+// DefaultEventHistory is the number of recent events an event bus keeps for
+// subscribers that resume after a disconnect
+const DefaultEventHistory = 1024
+
+// subscriberBuffer is how many events a subscriber may fall behind before
+// it is dropped
+const subscriberBuffer = 64
+
+// EventBus fans membership events out to subscribers and keeps the most
+// recent ones in a ring buffer so subscribers can resume where they left off
+type EventBus struct {
+	mu          sync.Mutex
+	history     []Event
+	next        int
+	lastID      uint64
+	subscribers map[chan Event]struct{}
+}
+
+// NewEventBus creates an event bus that keeps the last history events
+func NewEventBus(history int) *EventBus {
+	return &EventBus{
+		history:     make([]Event, 0, history),
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Publish assigns the next ID to an event of the given type and delivers it
+// to all subscribers. A subscriber that has fallen too far behind is
+// dropped by closing its channel; it can resubscribe from the last ID it saw.
+func (b *EventBus) Publish(eventType EventType, p *Peer) {
+	b.publish(Event{Type: eventType, Peer: p})
+}
+
+// PublishUser publishes a user event
+func (b *EventBus) PublishUser(e *UserEvent) {
+	b.publish(Event{Type: EventUser, User: e})
+}
+
+// publish assigns the next ID and the current time to an event, keeps it in
+// the history and delivers it to all subscribers
+func (b *EventBus) publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	event.ID = b.lastID
+	event.Time = time.Now().UTC()
+	if len(b.history) < cap(b.history) {
+		b.history = append(b.history, event)
+	} else if cap(b.history) > 0 {
+		b.history[b.next] = event
+		b.next = (b.next + 1) % cap(b.history)
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns the retained events after the given ID and a channel
+// that receives every event published from now on. Events that have
+// already dropped out of the history are not replayed. The channel is
+// closed when cancel is called or the subscriber falls behind.
+func (b *EventBus) Subscribe(after uint64) (replay []Event, events <-chan Event, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	replay = make([]Event, 0)
+	for i := range b.history {
+		event := b.history[(b.next+i)%len(b.history)]
+		if event.ID > after {
+			replay = append(replay, event)
+		}
+	}
+
+	ch := make(chan Event, subscriberBuffer)
+	b.subscribers[ch] = struct{}{}
+	cancel = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return replay, ch, cancel
+}
+
+// Follow calls handle for every event after the given ID, in order, until
+// ctx is done. Unlike a plain subscription it never misses an event that is
+// still in the history: when the bus drops it for falling behind, it
+// resubscribes from the last event it handled.
+func (b *EventBus) Follow(ctx context.Context, after uint64, handle func(Event)) {
+	for {
+		replay, events, cancel := b.Subscribe(after)
+		for _, event := range replay {
+			handle(event)
+			after = event.ID
+		}
+
+	live:
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					break live
+				}
+				handle(event)
+				after = event.ID
+			case <-ctx.Done():
+				cancel()
+				return
+			}
+		}
+		cancel()
+	}
+}
+
+// LastID returns the ID of the most recent event, or 0 if there is none
+func (b *EventBus) LastID() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lastID
+}
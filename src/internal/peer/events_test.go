@@ -0,0 +1,165 @@
+package peer
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEventBus_Subscribe(t *testing.T) {
+	bus := NewEventBus(10)
+	bus.Publish(EventJoin, &Peer{ID: "peer1"})
+
+	replay, events, cancel := bus.Subscribe(bus.LastID())
+	defer cancel()
+	if len(replay) != 0 {
+		t.Errorf("Expected nothing to replay, got %+v", replay)
+	}
+
+	bus.Publish(EventSuspect, &Peer{ID: "peer1"})
+	event := <-events
+	if event.ID != 2 || event.Type != EventSuspect || event.Peer.ID != "peer1" || event.Time.IsZero() {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Error("Expected the channel to be closed after cancel")
+	}
+	cancel()
+}
+
+func TestEventBus_Replay(t *testing.T) {
+	bus := NewEventBus(3)
+	for i := 0; i < 5; i++ {
+		bus.Publish(EventUpdate, &Peer{ID: "peer1"})
+	}
+
+	replay, _, cancel := bus.Subscribe(3)
+	defer cancel()
+	if len(replay) != 2 || replay[0].ID != 4 || replay[1].ID != 5 {
+		t.Errorf("Expected events 4 and 5, got %+v", replay)
+	}
+
+	// Only the last 3 events are kept
+	replay, _, cancel = bus.Subscribe(0)
+	defer cancel()
+	if len(replay) != 3 || replay[0].ID != 3 || replay[2].ID != 5 {
+		t.Errorf("Expected events 3 to 5, got %+v", replay)
+	}
+}
+
+func TestEventBus_SlowSubscriber(t *testing.T) {
+	bus := NewEventBus(0)
+	_, events, cancel := bus.Subscribe(0)
+	defer cancel()
+
+	for i := 0; i < subscriberBuffer+1; i++ {
+		bus.Publish(EventUpdate, &Peer{ID: "peer1"})
+	}
+
+	received := 0
+	for range events {
+		received++
+	}
+	if received != subscriberBuffer {
+		t.Errorf("Expected %d buffered events before the channel was closed, got %d", subscriberBuffer, received)
+	}
+}
+
+func TestPeerList_Events(t *testing.T) {
+	pl := NewPeerList()
+	_, events, cancel := pl.Events().Subscribe(0)
+	defer cancel()
+
+	pl.Add(&Peer{ID: "peer1", Address: "http://127.0.0.1:8081"})
+	pl.Merge(&Peer{ID: "peer1", Version: 2, Tags: map[string]string{"role": "worker"}})
+	pl.MarkSuspect("peer1", 0)
+	pl.Merge(&Peer{ID: "peer1", State: StateAlive, Incarnation: 1})
+	pl.MarkSuspect("peer1", 1)
+	pl.MarkDead("peer1", 1)
+	pl.Merge(&Peer{ID: "peer1", State: StateAlive, Incarnation: 2})
+	pl.Merge(&Peer{ID: "peer1", State: StateLeft, Incarnation: 2})
+	pl.Merge(&Peer{ID: "peer1", State: StateLeft, Incarnation: 2})
+
+	want := []EventType{
+		EventJoin, EventUpdate, EventSuspect, EventUpdate, EventSuspect,
+		EventFailed, EventJoin, EventLeave,
+	}
+	for i, w := range want {
+		event := <-events
+		if event.Type != w || event.ID != uint64(i+1) {
+			t.Errorf("Expected event %d to be %s, got %s (id %d)", i+1, w, event.Type, event.ID)
+		}
+	}
+	select {
+	case event := <-events:
+		t.Errorf("Expected no event for an unchanged record, got %+v", event)
+	default:
+	}
+}
+
+func TestEventBus_Follow(t *testing.T) {
+	bus := NewEventBus(DefaultEventHistory)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// A handler slower than the subscriber buffer still sees every event
+	seen := make(chan uint64, 2*subscriberBuffer)
+	done := make(chan struct{})
+	go func() {
+		bus.Follow(ctx, 0, func(event Event) {
+			if event.ID == 1 {
+				time.Sleep(50 * time.Millisecond)
+			}
+			seen <- event.ID
+		})
+		close(done)
+	}()
+
+	for i := 0; i < 2*subscriberBuffer; i++ {
+		bus.Publish(EventUpdate, &Peer{ID: "peer1"})
+	}
+	for want := uint64(1); want <= 2*subscriberBuffer; want++ {
+		select {
+		case id := <-seen:
+			if id != want {
+				t.Fatalf("Expected event %d, got %d", want, id)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected event %d to be handled", want)
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Follow to return when the context is done")
+	}
+}
+
+func TestEventType_Valid(t *testing.T) {
+	for _, eventType := range EventTypes {
+		if !eventType.Valid() {
+			t.Errorf("Expected %s to be valid", eventType)
+		}
+	}
+	if EventType("member-reap").Valid() || EventType("").Valid() {
+		t.Error("Expected unknown event types to be invalid")
+	}
+}
+
+func TestEventBus_PublishUser(t *testing.T) {
+	bus := NewEventBus(DefaultEventHistory)
+	_, events, cancel := bus.Subscribe(0)
+	defer cancel()
+
+	bus.Publish(EventJoin, &Peer{ID: "peer1"})
+	bus.PublishUser(&UserEvent{ID: "a1", Name: "deploy", LTime: 3})
+
+	<-events
+	event := <-events
+	if event.ID != 2 || event.Type != EventUser || event.Peer != nil || event.User == nil || event.User.Name != "deploy" {
+		t.Errorf("Expected user event 2 without a peer, got %+v", event)
+	}
+}
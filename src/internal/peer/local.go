@@ -0,0 +1,269 @@
+package peer
+
+import "sync"
+
+// LocalNode holds the membership record this node advertises about itself
+type LocalNode struct {
+	mu       sync.RWMutex
+	self     Peer
+	onChange func(peer *Peer)
+
+	// limitServices enforces MaxServicesSize
+	limitServices bool
+}
+
+// NewLocalNode creates the local membership record for this node
+func NewLocalNode(id, address string) *LocalNode {
+	return &LocalNode{
+		self: Peer{
+			ID:      id,
+			Address: address,
+			State:   StateAlive,
+			IsAlive: true,
+			Version: 1,
+		},
+	}
+}
+
+// SetCluster sets the name of the cluster this node belongs to. It must be
+// called before the record is advertised.
+func (ln *LocalNode) SetCluster(name string) {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+	ln.self.Cluster = name
+}
+
+// LimitServicesSize makes RegisterService keep the services within
+// MaxServicesSize, for records that are gossiped over UDP. It must be called
+// before services are registered.
+func (ln *LocalNode) LimitServicesSize() {
+	ln.limitServices = true
+}
+
+// SetOnChange sets a callback invoked with a copy of the local record
+// whenever this node changes it
+func (ln *LocalNode) SetOnChange(onChange func(peer *Peer)) {
+	ln.onChange = onChange
+}
+
+// notify invokes the change callback; the caller must not hold the lock
+func (ln *LocalNode) notify() {
+	if ln.onChange != nil {
+		ln.onChange(ln.Peer())
+	}
+}
+
+// Peer returns a copy of the local record
+func (ln *LocalNode) Peer() *Peer {
+	ln.mu.RLock()
+	defer ln.mu.RUnlock()
+
+	p := ln.self
+	return &p
+}
+
+// Version returns the current version of the local record
+func (ln *LocalNode) Version() uint64 {
+	ln.mu.RLock()
+	defer ln.mu.RUnlock()
+	return ln.self.Version
+}
+
+// Incarnation returns the current incarnation number of this node
+func (ln *LocalNode) Incarnation() uint64 {
+	ln.mu.RLock()
+	defer ln.mu.RUnlock()
+	return ln.self.Incarnation
+}
+
+// Refute answers a rumor that this node is suspect, dead or has left by
+// bumping the incarnation above the rumor's, so that the next alive message
+// from this node overrides it. A left rumor is stale when the node restarted
+// with the same ID after leaving; it is only accepted while this node is
+// actually leaving. A record with a newer version than ours, or other
+// metadata at the same version, was written before a restart, so the version
+// is bumped past it too or peers would keep the old metadata. It returns the
+// new incarnation and whether a bump was needed.
+func (ln *LocalNode) Refute(rumor *Peer) (uint64, bool) {
+	ln.mu.Lock()
+	refutable := rumor.State == StateSuspect || rumor.State == StateDead ||
+		(rumor.State == StateLeft && ln.self.State != StateLeft)
+	refuteState := refutable && rumor.Incarnation >= ln.self.Incarnation
+	refuteVersion := rumor.Version > ln.self.Version ||
+		(rumor.Version == ln.self.Version && !sameMetadata(rumor, &ln.self))
+	if !refuteState && !refuteVersion {
+		incarnation := ln.self.Incarnation
+		ln.mu.Unlock()
+		return incarnation, false
+	}
+
+	if refuteState {
+		ln.self.Incarnation = rumor.Incarnation + 1
+	}
+	if rumor.Version > ln.self.Version {
+		ln.self.Version = rumor.Version
+	}
+	ln.self.Version++
+	incarnation := ln.self.Incarnation
+	ln.mu.Unlock()
+
+	ln.notify()
+	return incarnation, true
+}
+
+// SetTags replaces the tags this node advertises. Tags are copied, never
+// modified in place, so copies of the record can share them safely.
+func (ln *LocalNode) SetTags(tags map[string]string) {
+	ln.mu.Lock()
+	if equalTags(ln.self.Tags, tags) {
+		ln.mu.Unlock()
+		return
+	}
+	ln.self.Tags = make(map[string]string, len(tags))
+	for key, value := range tags {
+		ln.self.Tags[key] = value
+	}
+	ln.self.Version++
+	ln.mu.Unlock()
+
+	ln.notify()
+}
+
+// RegisterService adds a service to the ones this node advertises, or
+// replaces the service with the same ID. Like tags, the list is replaced
+// rather than modified in place.
+func (ln *LocalNode) RegisterService(svc Service) error {
+	svc = svc.normalize()
+
+	ln.mu.Lock()
+	services := make([]Service, 0, len(ln.self.Services)+1)
+	replaced := false
+	for _, existing := range ln.self.Services {
+		if existing.ID == svc.ID {
+			existing = svc
+			replaced = true
+		}
+		services = append(services, existing)
+	}
+	if !replaced {
+		services = append(services, svc)
+	}
+	err := ValidateServices(services)
+	if err == nil && ln.limitServices {
+		err = ValidateServicesSize(services)
+	}
+	if err != nil {
+		ln.mu.Unlock()
+		return err
+	}
+	ln.self.Services = services
+	ln.self.Version++
+	ln.mu.Unlock()
+
+	ln.notify()
+	return nil
+}
+
+// DeregisterService removes the service with the given ID and reports
+// whether it was registered
+func (ln *LocalNode) DeregisterService(id string) bool {
+	ln.mu.Lock()
+	services := make([]Service, 0, len(ln.self.Services))
+	for _, existing := range ln.self.Services {
+		if existing.ID != id {
+			services = append(services, existing)
+		}
+	}
+	if len(services) == len(ln.self.Services) {
+		ln.mu.Unlock()
+		return false
+	}
+	ln.self.Services = services
+	ln.self.Version++
+	ln.mu.Unlock()
+
+	ln.notify()
+	return true
+}
+
+// SetServiceHealth records the result of a service's health check and
+// reports whether the service is registered
+func (ln *LocalNode) SetServiceHealth(id, health string) bool {
+	ln.mu.Lock()
+	index := -1
+	for i, svc := range ln.self.Services {
+		if svc.ID == id {
+			index = i
+		}
+	}
+	if index < 0 {
+		ln.mu.Unlock()
+		return false
+	}
+	if ln.self.Services[index].Health == health {
+		ln.mu.Unlock()
+		return true
+	}
+	services := append([]Service(nil), ln.self.Services...)
+	services[index].Health = health
+	ln.self.Services = services
+	ln.self.Version++
+	ln.mu.Unlock()
+
+	ln.notify()
+	return true
+}
+
+// SetKeys records the fingerprints of the encryption keys this node holds
+// so that they are gossiped to the cluster
+func (ln *LocalNode) SetKeys(keys []string) {
+	ln.mu.Lock()
+	if equalStrings(ln.self.Keys, keys) {
+		ln.mu.Unlock()
+		return
+	}
+	ln.self.Keys = append([]string(nil), keys...)
+	ln.self.Version++
+	ln.mu.Unlock()
+
+	ln.notify()
+}
+
+// sameMetadata reports whether two records carry the same owner-set fields
+func sameMetadata(a, b *Peer) bool {
+	if a.Address != b.Address || !equalTags(a.Tags, b.Tags) ||
+		!equalStrings(a.Keys, b.Keys) || len(a.Services) != len(b.Services) {
+		return false
+	}
+	for i := range a.Services {
+		x, y := a.Services[i], b.Services[i]
+		if x.ID != y.ID || x.Name != y.Name || x.Port != y.Port || x.Health != y.Health || !equalStrings(x.Tags, y.Tags) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Leave marks this node as having left the cluster and returns the record
+// to announce to other peers
+func (ln *LocalNode) Leave() *Peer {
+	ln.mu.Lock()
+	ln.self.setState(StateLeft)
+	ln.self.Version++
+	ln.mu.Unlock()
+
+	ln.notify()
+	return ln.Peer()
+}
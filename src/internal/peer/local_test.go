@@ -0,0 +1,331 @@
+package peer
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNewLocalNode(t *testing.T) {
+	ln := NewLocalNode("local", "http://192.168.1.100:8080")
+
+	p := ln.Peer()
+	if p.ID != "local" {
+		t.Errorf("Expected ID to be 'local', got '%s'", p.ID)
+	}
+	if p.Address != "http://192.168.1.100:8080" {
+		t.Errorf("Expected address to be 'http://192.168.1.100:8080', got '%s'", p.Address)
+	}
+	if p.State != StateAlive || !p.IsAlive {
+		t.Errorf("Expected local node to be alive, got %s", p.State)
+	}
+	if ln.Incarnation() != 0 {
+		t.Errorf("Expected initial incarnation to be 0, got %d", ln.Incarnation())
+	}
+	if ln.Version() != 1 {
+		t.Errorf("Expected initial version to be 1, got %d", ln.Version())
+	}
+}
+
+func TestLocalNode_Refute(t *testing.T) {
+	ln := NewLocalNode("local", "http://192.168.1.100:8080")
+
+	t.Run("alive rumor is not refuted", func(t *testing.T) {
+		if _, refuted := ln.Refute(&Peer{ID: "local", State: StateAlive}); refuted {
+			t.Error("Expected alive rumor to not be refuted")
+		}
+	})
+
+	t.Run("suspect rumor bumps incarnation", func(t *testing.T) {
+		incarnation, refuted := ln.Refute(&Peer{ID: "local", State: StateSuspect, Incarnation: 3})
+		if !refuted {
+			t.Fatal("Expected suspect rumor to be refuted")
+		}
+		if incarnation != 4 || ln.Incarnation() != 4 {
+			t.Errorf("Expected incarnation to be 4, got %d", ln.Incarnation())
+		}
+		if ln.Version() != 2 {
+			t.Errorf("Expected refutation to bump version to 2, got %d", ln.Version())
+		}
+	})
+
+	t.Run("stale rumor is ignored", func(t *testing.T) {
+		if _, refuted := ln.Refute(&Peer{ID: "local", State: StateDead, Incarnation: 2}); refuted {
+			t.Error("Expected stale rumor to not be refuted")
+		}
+		if ln.Incarnation() != 4 {
+			t.Errorf("Expected incarnation to stay 4, got %d", ln.Incarnation())
+		}
+	})
+
+	t.Run("local peer carries incarnation", func(t *testing.T) {
+		if ln.Peer().Incarnation != 4 {
+			t.Errorf("Expected Peer() to carry incarnation 4, got %d", ln.Peer().Incarnation)
+		}
+	})
+}
+
+func TestLocalNode_RefuteAfterRestart(t *testing.T) {
+	// Peers still hold the record this node advertised before restarting
+	before := NewLocalNode("local", "http://192.168.1.100:8080")
+	before.SetTags(map[string]string{"role": "web"})
+	before.SetTags(map[string]string{"role": "worker"})
+	old := before.Peer()
+
+	ln := NewLocalNode("local", "http://192.168.1.100:8080")
+	ln.SetTags(map[string]string{"role": "web"})
+
+	var changes []*Peer
+	ln.SetOnChange(func(p *Peer) { changes = append(changes, p) })
+
+	if _, refuted := ln.Refute(old); !refuted {
+		t.Fatal("Expected a newer record from before the restart to be refuted")
+	}
+	if ln.Version() != old.Version+1 {
+		t.Errorf("Expected version %d, got %d", old.Version+1, ln.Version())
+	}
+	if ln.Incarnation() != 0 {
+		t.Errorf("Expected the incarnation to stay 0, got %d", ln.Incarnation())
+	}
+	if len(changes) != 1 || !changes[0].HasTag("role", "web") {
+		t.Errorf("Expected the current tags to be announced, got %+v", changes)
+	}
+
+	// Other metadata at the same version is from before the restart too
+	if _, refuted := ln.Refute(&Peer{ID: "local", State: StateAlive, Version: ln.Version()}); !refuted {
+		t.Error("Expected other metadata at the same version to be refuted")
+	}
+
+	// Our own record echoed back is not
+	if _, refuted := ln.Refute(ln.Peer()); refuted {
+		t.Error("Expected the current record not to be refuted")
+	}
+}
+
+func TestLocalNode_RefuteLeft(t *testing.T) {
+	ln := NewLocalNode("local", "http://192.168.1.100:8080")
+
+	// A restarted node hears that it left before the restart
+	incarnation, refuted := ln.Refute(&Peer{ID: "local", State: StateLeft, Incarnation: 2})
+	if !refuted || incarnation != 3 {
+		t.Fatalf("Expected a stale left rumor to be refuted with incarnation 3, got %d (%v)", incarnation, refuted)
+	}
+	if ln.Peer().State != StateAlive {
+		t.Errorf("Expected the node to stay alive, got %s", ln.Peer().State)
+	}
+
+	// While actually leaving, the left record is our own
+	ln.Leave()
+	if _, refuted := ln.Refute(&Peer{ID: "local", State: StateLeft, Incarnation: 3}); refuted {
+		t.Error("Expected a leaving node not to refute its own leave")
+	}
+}
+
+func TestLocalNode_Leave(t *testing.T) {
+	ln := NewLocalNode("local", "http://192.168.1.100:8080")
+	ln.Refute(&Peer{ID: "local", State: StateSuspect, Incarnation: 1})
+
+	p := ln.Leave()
+	if p.State != StateLeft || p.IsAlive {
+		t.Errorf("Expected leave record to be left, got %s", p.State)
+	}
+	if p.Incarnation != 2 {
+		t.Errorf("Expected leave record to keep incarnation 2, got %d", p.Incarnation)
+	}
+	if ln.Peer().State != StateLeft {
+		t.Errorf("Expected local node to be left, got %s", ln.Peer().State)
+	}
+}
+
+func TestLocalNode_SetKeys(t *testing.T) {
+	ln := NewLocalNode("local", "http://192.168.1.100:8080")
+
+	var changes int
+	ln.SetOnChange(func(p *Peer) {
+		changes++
+	})
+
+	keys := []string{"aaaa", "bbbb"}
+	ln.SetKeys(keys)
+	keys[0] = "changed"
+
+	p := ln.Peer()
+	if len(p.Keys) != 2 || p.Keys[0] != "aaaa" {
+		t.Errorf("Expected the keys to be copied, got %v", p.Keys)
+	}
+	if p.Version != 2 || changes != 1 {
+		t.Errorf("Expected one version bump, got version %d and %d changes", p.Version, changes)
+	}
+
+	ln.SetKeys([]string{"aaaa", "bbbb"})
+	if ln.Version() != 2 || changes != 1 {
+		t.Error("Expected setting the same keys to be a no-op")
+	}
+}
+
+func TestLocalNode_SetCluster(t *testing.T) {
+	ln := NewLocalNode("local", "http://192.168.1.100:8080")
+
+	var changes int
+	ln.SetOnChange(func(p *Peer) {
+		changes++
+	})
+
+	ln.SetCluster("staging")
+	if p := ln.Peer(); p.Cluster != "staging" || p.Version != 1 || changes != 0 {
+		t.Errorf("Expected the cluster to be set without a change, got %+v and %d changes", p, changes)
+	}
+}
+
+func TestLocalNode_SetTags(t *testing.T) {
+	ln := NewLocalNode("local", "http://192.168.1.100:8080")
+
+	var changes int
+	ln.SetOnChange(func(p *Peer) {
+		changes++
+	})
+
+	tags := map[string]string{"role": "worker"}
+	ln.SetTags(tags)
+	tags["role"] = "changed"
+
+	p := ln.Peer()
+	if !p.HasTag("role", "worker") {
+		t.Errorf("Expected the tags to be copied, got %v", p.Tags)
+	}
+	if p.Version != 2 || changes != 1 {
+		t.Errorf("Expected one version bump, got version %d and %d changes", p.Version, changes)
+	}
+
+	ln.SetTags(map[string]string{"role": "worker"})
+	if ln.Version() != 2 || changes != 1 {
+		t.Error("Expected setting the same tags to be a no-op")
+	}
+
+	// Earlier copies of the record keep the tags they were taken with
+	ln.SetTags(map[string]string{"role": "db"})
+	if !p.HasTag("role", "worker") || !ln.Peer().HasTag("role", "db") {
+		t.Error("Expected the update not to change earlier copies")
+	}
+}
+
+func TestLocalNode_RegisterService(t *testing.T) {
+	ln := NewLocalNode("local", "http://192.168.1.100:8080")
+
+	var changes int
+	ln.SetOnChange(func(p *Peer) {
+		changes++
+	})
+
+	if err := ln.RegisterService(Service{Name: "web", Port: 80}); err != nil {
+		t.Fatalf("Expected registration to succeed, got: %v", err)
+	}
+	before := ln.Peer()
+	if len(before.Services) != 1 || before.Services[0].ID != "web" {
+		t.Fatalf("Expected the service ID to default to its name, got %+v", before.Services)
+	}
+
+	// Registering the same ID again replaces the service
+	if err := ln.RegisterService(Service{Name: "web", Port: 8080, Tags: []string{"v2"}}); err != nil {
+		t.Fatalf("Expected re-registration to succeed, got: %v", err)
+	}
+	if err := ln.RegisterService(Service{Name: "api", Port: 9090}); err != nil {
+		t.Fatalf("Expected registration to succeed, got: %v", err)
+	}
+	p := ln.Peer()
+	if len(p.Services) != 2 || p.Services[0].Port != 8080 || p.Services[1].Name != "api" {
+		t.Errorf("Unexpected services: %+v", p.Services)
+	}
+	if before.Services[0].Port != 80 {
+		t.Error("Expected earlier copies of the record to keep their services")
+	}
+	if p.Version != 4 || changes != 3 {
+		t.Errorf("Expected three version bumps, got version %d and %d changes", p.Version, changes)
+	}
+
+	if err := ln.RegisterService(Service{Name: "bad"}); err == nil {
+		t.Error("Expected a service without a port to be rejected")
+	}
+	if ln.Version() != 4 {
+		t.Error("Expected a rejected service to leave the record alone")
+	}
+
+	if !ln.DeregisterService("web") {
+		t.Error("Expected web to be deregistered")
+	}
+	if ln.DeregisterService("web") {
+		t.Error("Expected deregistering an unknown service to report false")
+	}
+	if p := ln.Peer(); len(p.Services) != 1 || p.Version != 5 {
+		t.Errorf("Expected one service at version 5, got %+v", p)
+	}
+}
+
+func TestLocalNode_LimitServicesSize(t *testing.T) {
+	ln := NewLocalNode("local", "http://192.168.1.100:8080")
+	for i := 0; i < 20; i++ {
+		if err := ln.RegisterService(Service{ID: fmt.Sprintf("web-%d", i), Name: "web", Port: 8000 + i}); err != nil {
+			t.Fatalf("Expected services not to be limited by default, got: %v", err)
+		}
+	}
+
+	ln = NewLocalNode("local", "http://192.168.1.100:8080")
+	ln.LimitServicesSize()
+	var err error
+	for i := 0; i < 20 && err == nil; i++ {
+		err = ln.RegisterService(Service{ID: fmt.Sprintf("web-%d", i), Name: "web", Port: 8000 + i})
+	}
+	if err == nil {
+		t.Error("Expected services to be limited to MaxServicesSize")
+	}
+	if ValidateServicesSize(ln.Peer().Services) != nil {
+		t.Error("Expected the registered services to stay within the limit")
+	}
+}
+
+func TestLocalNode_SetServiceHealth(t *testing.T) {
+	ln := NewLocalNode("local", "http://192.168.1.100:8080")
+	ln.RegisterService(Service{Name: "web", Port: 80, Health: HealthCritical})
+	before := ln.Peer()
+
+	if !ln.SetServiceHealth("web", HealthPassing) {
+		t.Fatal("Expected web to be registered")
+	}
+	p := ln.Peer()
+	if !p.Services[0].Healthy() || p.Version != 3 {
+		t.Errorf("Expected web to pass at version 3, got %+v at version %d", p.Services[0], p.Version)
+	}
+	if before.Services[0].Healthy() {
+		t.Error("Expected earlier copies of the record to keep the old health")
+	}
+
+	ln.SetServiceHealth("web", HealthPassing)
+	if ln.Version() != 3 {
+		t.Error("Expected an unchanged result not to bump the version")
+	}
+	if ln.SetServiceHealth("db", HealthPassing) {
+		t.Error("Expected an unknown service to report false")
+	}
+}
+
+func TestLocalNode_SetOnChange(t *testing.T) {
+	ln := NewLocalNode("local", "http://192.168.1.100:8080")
+
+	var changes []*Peer
+	ln.SetOnChange(func(p *Peer) {
+		changes = append(changes, p)
+	})
+
+	ln.Refute(&Peer{ID: "local", State: StateAlive})
+	ln.Refute(&Peer{ID: "local", State: StateSuspect})
+	ln.Leave()
+
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes, got %d", len(changes))
+	}
+	if changes[0].State != StateAlive || changes[0].Incarnation != 1 {
+		t.Errorf("Expected refutation to announce alive with incarnation 1, got %s/%d", changes[0].State, changes[0].Incarnation)
+	}
+	if changes[1].State != StateLeft {
+		t.Errorf("Expected leave to announce left, got %s", changes[1].State)
+	}
+}
@@ -1,69 +1,366 @@
 package peer
 
 import (
+	"context"
 	"sync"
 	"time"
 )
 
+// State is the membership state of a peer
+type State string
+
+const (
+	// StateAlive means the peer is answering probes
+	StateAlive State = "alive"
+	// StateSuspect means a probe failed and the peer has a chance to refute it
+	StateSuspect State = "suspect"
+	// StateDead means the peer did not refute the suspicion in time
+	StateDead State = "dead"
+	// StateLeft means the peer announced that it is leaving the cluster
+	StateLeft State = "left"
+)
+
+// rank orders states for the same incarnation; a higher rank overrides a lower one
+func (s State) rank() int {
+	switch s {
+	case StateSuspect:
+		return 1
+	case StateDead:
+		return 2
+	case StateLeft:
+		return 3
+	default:
+		return 0
+	}
+}
+
 // Peer represents a peer in the network
 type Peer struct {
-	ID       string    `json:"id"`
-	Address  string    `json:"address"`
-	LastSeen time.Time `json:"last_seen"`
-	IsAlive  bool      `json:"is_alive"`
+	ID          string    `json:"id"`
+	Address     string    `json:"address"`
+	LastSeen    time.Time `json:"last_seen"`
+	IsAlive     bool      `json:"is_alive"`
+	State       State     `json:"state"`
+	Incarnation uint64    `json:"incarnation"`
+
+	// Version is a monotonic counter set by the peer itself and bumped
+	// whenever it changes its own record. LastSeen is the local time this
+	// node last heard from the peer and is never compared across nodes.
+	Version uint64 `json:"version"`
+
+	// Cluster is the name of the cluster the peer belongs to. It is set
+	// once by the peer itself and never changes.
+	Cluster string `json:"cluster,omitempty"`
+
+	// Tags are arbitrary key/value pairs the peer advertises about itself,
+	// such as its role or zone
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Services lists the applications the peer runs
+	Services []Service `json:"services,omitempty"`
+
+	// Keys lists the fingerprints of the encryption keys the peer holds,
+	// primary first
+	Keys []string `json:"keys,omitempty"`
+
+	// StateChanged records when this node last saw the state change. It is
+	// local bookkeeping and is not exchanged with other peers.
+	StateChanged time.Time `json:"-"`
+}
+
+// setState updates the state and keeps IsAlive in sync with it
+func (p *Peer) setState(state State) {
+	if p.State != state {
+		p.StateChanged = time.Now().UTC()
+	}
+	p.State = state
+	p.IsAlive = state == StateAlive || state == StateSuspect
+}
+
+// Digest is a compact summary of a peer record used to find out which
+// records two nodes need to exchange
+type Digest struct {
+	ID          string `json:"id"`
+	State       State  `json:"state"`
+	Incarnation uint64 `json:"incarnation"`
+	Version     uint64 `json:"version"`
+}
+
+// Digest returns the digest of this peer record
+func (p *Peer) Digest() Digest {
+	return Digest{
+		ID:          p.ID,
+		State:       p.State,
+		Incarnation: p.Incarnation,
+		Version:     p.Version,
+	}
+}
+
+// Supersedes reports whether a record with digest d carries information a
+// record with digest other lacks: a newer version, a higher incarnation, or
+// a stronger state for the same incarnation
+func (d Digest) Supersedes(other Digest) bool {
+	if d.Version > other.Version || d.Incarnation > other.Incarnation {
+		return true
+	}
+	return d.Incarnation == other.Incarnation && d.State.rank() > other.State.rank()
+}
+
+// applyMetadata copies the owner-set fields from remote if it carries a
+// newer version and reports whether anything changed
+func (p *Peer) applyMetadata(remote *Peer) bool {
+	if remote.Version <= p.Version {
+		return false
+	}
+	p.Version = remote.Version
+	p.Address = remote.Address
+	p.Tags = remote.Tags
+	p.Services = remote.Services
+	p.Keys = remote.Keys
+	return true
+}
+
+// removal records that a peer was reaped, so stale gossip can't reinsert it
+type removal struct {
+	incarnation uint64
+	at          time.Time
 }
 
 // PeerList manages a thread-safe collection of peers
 type PeerList struct {
-	mu    sync.RWMutex
-	peers map[string]*Peer
+	mu       sync.RWMutex
+	peers    map[string]*Peer
+	removed  map[string]removal
+	reaped   int
+	onChange func(peer *Peer)
+	events   *EventBus
+
+	// index is bumped on every change to the list; changed is closed and
+	// replaced at the same time to wake up waiters
+	index   uint64
+	changed chan struct{}
 }
 
 // NewPeerList creates a new peer list
 func NewPeerList() *PeerList {
 	return &PeerList{
-		peers: make(map[string]*Peer),
+		peers:   make(map[string]*Peer),
+		removed: make(map[string]removal),
+		events:  NewEventBus(DefaultEventHistory),
+		index:   1,
+		changed: make(chan struct{}),
+	}
+}
+
+// SetOnChange sets a callback invoked with a copy of a peer whenever its
+// membership state or metadata changes. It must be set before the list is
+// shared between goroutines.
+func (pl *PeerList) SetOnChange(onChange func(peer *Peer)) {
+	pl.onChange = onChange
+}
+
+// Events returns the bus that membership events are published on
+func (pl *PeerList) Events() *EventBus {
+	return pl.events
+}
+
+// notify invokes the change callback; the caller must not hold the lock
+func (pl *PeerList) notify(peer *Peer) {
+	if pl.onChange != nil {
+		pl.onChange(peer)
+	}
+}
+
+// publish publishes the event for a peer that went from state prev to the
+// given record. The caller must hold the write lock, so events are
+// published in the order the changes were made.
+func (pl *PeerList) publish(prev State, peer *Peer) {
+	pl.events.Publish(eventType(prev, peer), peer)
+	pl.touch()
+}
+
+// touch bumps the modification index and wakes up everyone waiting for a
+// change; the caller must hold the write lock
+func (pl *PeerList) touch() {
+	pl.index++
+	close(pl.changed)
+	pl.changed = make(chan struct{})
+}
+
+// Index returns the modification index of the list. It starts at 1 and
+// grows with every change to a peer's membership state or metadata, and
+// with every removal. Updates to LastSeen alone don't count as changes.
+func (pl *PeerList) Index() uint64 {
+	pl.mu.RLock()
+	defer pl.mu.RUnlock()
+	return pl.index
+}
+
+// WaitForChange blocks until the modification index is greater than index
+// or ctx is done, and returns the index at that point
+func (pl *PeerList) WaitForChange(ctx context.Context, index uint64) uint64 {
+	for {
+		pl.mu.RLock()
+		current, changed := pl.index, pl.changed
+		pl.mu.RUnlock()
+
+		if current > index {
+			return current
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return current
+		}
+	}
+}
+
+// state returns the state of a peer, or "" if it is unknown; the caller
+// must hold the lock
+func (pl *PeerList) state(id string) State {
+	if p, exists := pl.peers[id]; exists {
+		return p.State
 	}
+	return ""
+}
+
+// snapshot returns a copy of a peer; the caller must hold the lock
+func (pl *PeerList) snapshot(id string) *Peer {
+	p := *pl.peers[id]
+	return &p
 }
 
 // Add adds a peer to the list
 func (pl *PeerList) Add(peer *Peer) {
 	pl.mu.Lock()
-	defer pl.mu.Unlock()
-
+	prev := pl.state(peer.ID)
 	peer.LastSeen = time.Now().UTC()
-	peer.IsAlive = true
+	peer.setState(StateAlive)
 	pl.peers[peer.ID] = peer
+	delete(pl.removed, peer.ID)
+	added := pl.snapshot(peer.ID)
+	pl.publish(prev, added)
+	pl.mu.Unlock()
+
+	pl.notify(added)
+}
+
+// Merge applies a remote view of a peer. The state follows SWIM precedence
+// rules: a higher incarnation always wins, and for the same incarnation
+// left > dead > suspect > alive. The owner's metadata is taken from whichever
+// record has the higher version, so no wall-clock time is compared. Unknown
+// peers are only added when they are reported alive or suspect, and a peer
+// that left is only brought back by an alive record with a higher
+// incarnation, which it sends after restarting and refuting the leave. A reaped
+// peer is only re-added with a higher incarnation than it had when it was
+// removed. It returns true if the local view changed.
+func (pl *PeerList) Merge(remote *Peer) bool {
+	pl.mu.Lock()
+	prev := pl.state(remote.ID)
+	changed := pl.merge(remote)
+	var updated *Peer
+	if changed {
+		updated = pl.snapshot(remote.ID)
+		pl.publish(prev, updated)
+	}
+	pl.mu.Unlock()
+
+	if changed {
+		pl.notify(updated)
+	}
+	return changed
+}
+
+// merge implements Merge; the caller must hold the write lock
+func (pl *PeerList) merge(remote *Peer) bool {
+	state := remote.State
+	if state == "" {
+		state = StateAlive
+	}
+
+	existing, exists := pl.peers[remote.ID]
+	if !exists {
+		if state != StateAlive && state != StateSuspect {
+			return false
+		}
+		if r, wasRemoved := pl.removed[remote.ID]; wasRemoved {
+			if remote.Incarnation <= r.incarnation {
+				return false
+			}
+			delete(pl.removed, remote.ID)
+		}
+		p := &Peer{
+			ID:          remote.ID,
+			Address:     remote.Address,
+			Incarnation: remote.Incarnation,
+			Version:     remote.Version,
+			Cluster:     remote.Cluster,
+			Tags:        remote.Tags,
+			Services:    remote.Services,
+			Keys:        remote.Keys,
+			LastSeen:    time.Now().UTC(),
+		}
+		p.setState(state)
+		pl.peers[p.ID] = p
+		return true
+	}
+
+	// Only the node itself can take back a leave, by coming back alive
+	// with a higher incarnation after a restart
+	if existing.State == StateLeft && (state != StateAlive || remote.Incarnation <= existing.Incarnation) {
+		return false
+	}
+
+	changed := existing.applyMetadata(remote)
+
+	if remote.Incarnation < existing.Incarnation {
+		return changed
+	}
+	if remote.Incarnation == existing.Incarnation && state.rank() <= existing.State.rank() {
+		return changed
+	}
+
+	existing.Incarnation = remote.Incarnation
+	existing.setState(state)
+	return true
 }
 
 // Remove removes a peer from the list
 func (pl *PeerList) Remove(id string) {
 	pl.mu.Lock()
 	defer pl.mu.Unlock()
-	delete(pl.peers, id)
+	if _, exists := pl.peers[id]; exists {
+		delete(pl.peers, id)
+		pl.touch()
+	}
 }
 
-// Get retrieves a peer by ID
+// Get retrieves a copy of a peer by ID
 func (pl *PeerList) Get(id string) (*Peer, bool) {
 	pl.mu.RLock()
 	defer pl.mu.RUnlock()
 	peer, exists := pl.peers[id]
-	return peer, exists
+	if !exists {
+		return nil, false
+	}
+	p := *peer
+	return &p, true
 }
 
-// GetAll returns all peers
+// GetAll returns copies of all peers
 func (pl *PeerList) GetAll() []*Peer {
 	pl.mu.RLock()
 	defer pl.mu.RUnlock()
 
 	peers := make([]*Peer, 0, len(pl.peers))
 	for _, peer := range pl.peers {
-		peers = append(peers, peer)
+		p := *peer
+		peers = append(peers, &p)
 	}
 	return peers
 }
 
-// GetAlive returns only alive peers
+// GetAlive returns copies of peers that are alive or suspect
 func (pl *PeerList) GetAlive() []*Peer {
 	pl.mu.RLock()
 	defer pl.mu.RUnlock()
@@ -71,30 +368,118 @@ func (pl *PeerList) GetAlive() []*Peer {
 	peers := make([]*Peer, 0)
 	for _, peer := range pl.peers {
 		if peer.IsAlive {
-			peers = append(peers, peer)
+			p := *peer
+			peers = append(peers, &p)
+		}
+	}
+	return peers
+}
+
+// GetByState returns copies of peers in the given state
+func (pl *PeerList) GetByState(state State) []*Peer {
+	pl.mu.RLock()
+	defer pl.mu.RUnlock()
+
+	peers := make([]*Peer, 0)
+	for _, peer := range pl.peers {
+		if peer.State == state {
+			p := *peer
+			peers = append(peers, &p)
 		}
 	}
 	return peers
 }
 
-// MarkDead marks a peer as dead
-func (pl *PeerList) MarkDead(id string) {
+// MarkSuspect marks a peer as suspect if the given incarnation is not older
+// than the one we know. It returns true if the peer became suspect.
+func (pl *PeerList) MarkSuspect(id string, incarnation uint64) bool {
+	pl.mu.Lock()
+	peer, exists := pl.peers[id]
+	if !exists || peer.State != StateAlive || incarnation < peer.Incarnation {
+		pl.mu.Unlock()
+		return false
+	}
+	peer.Incarnation = incarnation
+	peer.setState(StateSuspect)
+	suspect := pl.snapshot(id)
+	pl.publish(StateAlive, suspect)
+	pl.mu.Unlock()
+
+	pl.notify(suspect)
+	return true
+}
+
+// MarkDead marks a suspect peer as dead if it is still suspected at the
+// given incarnation. A peer that refuted the suspicion, which takes a higher
+// incarnation, or that left or failed in the meantime is left alone. It
+// returns true if the peer became dead.
+func (pl *PeerList) MarkDead(id string, incarnation uint64) bool {
+	pl.mu.Lock()
+	peer, exists := pl.peers[id]
+	if !exists || peer.State != StateSuspect || peer.Incarnation != incarnation {
+		pl.mu.Unlock()
+		return false
+	}
+	peer.setState(StateDead)
+	dead := pl.snapshot(id)
+	pl.publish(StateSuspect, dead)
+	pl.mu.Unlock()
+
+	pl.notify(dead)
+	return true
+}
+
+// Reap removes peers that have been in the given state for longer than
+// retention and returns their IDs. The removal is remembered so that gossip
+// carrying the old incarnation does not bring the peer back.
+func (pl *PeerList) Reap(state State, retention time.Duration) []string {
 	pl.mu.Lock()
 	defer pl.mu.Unlock()
 
-	if peer, exists := pl.peers[id]; exists {
-		peer.IsAlive = false
+	now := time.Now()
+	reaped := make([]string, 0)
+	for id, peer := range pl.peers {
+		if peer.State == state && now.Sub(peer.StateChanged) > retention {
+			delete(pl.peers, id)
+			pl.removed[id] = removal{incarnation: peer.Incarnation, at: now}
+			pl.reaped++
+			reaped = append(reaped, id)
+		}
+	}
+	if len(reaped) > 0 {
+		pl.touch()
+	}
+	return reaped
+}
+
+// ForgetRemoved drops removal records older than retention
+func (pl *PeerList) ForgetRemoved(retention time.Duration) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+
+	now := time.Now()
+	for id, r := range pl.removed {
+		if now.Sub(r.at) > retention {
+			delete(pl.removed, id)
+		}
 	}
 }
 
-// UpdateLastSeen updates the last seen time for a peer
+// Reaped returns the total number of peers removed by Reap
+func (pl *PeerList) Reaped() int {
+	pl.mu.RLock()
+	defer pl.mu.RUnlock()
+	return pl.reaped
+}
+
+// UpdateLastSeen updates the last seen time for a peer. It does not clear a
+// suspicion; only the suspected peer can do that by refuting it.
 func (pl *PeerList) UpdateLastSeen(id string) {
 	pl.mu.Lock()
 	defer pl.mu.Unlock()
 
 	if peer, exists := pl.peers[id]; exists {
-		peer.LastSeen = time.Now()
-		peer.IsAlive = true
+		peer.LastSeen = time.Now().UTC()
 	}
 }
 
@@ -119,6 +504,20 @@ func (pl *PeerList) CountAlive() int {
 	return count
 }
 
+// CountByState returns the number of peers in the given state
+func (pl *PeerList) CountByState(state State) int {
+	pl.mu.RLock()
+	defer pl.mu.RUnlock()
+
+	count := 0
+	for _, peer := range pl.peers {
+		if peer.State == state {
+			count++
+		}
+	}
+	return count
+}
+
 // Exists checks if a peer exists
 func (pl *PeerList) Exists(id string) bool {
 	pl.mu.RLock()
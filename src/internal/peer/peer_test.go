@@ -1,6 +1,7 @@
 package peer
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"testing"
@@ -153,7 +154,8 @@ func TestPeerList_GetAlive(t *testing.T) {
 	pl.Add(peer3)
 
 	// Mark one peer as dead
-	pl.MarkDead("dead-peer")
+	pl.MarkSuspect("dead-peer", 0)
+	pl.MarkDead("dead-peer", 0)
 
 	alivePeers := pl.GetAlive()
 	if len(alivePeers) != 2 {
@@ -178,8 +180,14 @@ func TestPeerList_MarkDead(t *testing.T) {
 
 	pl.Add(peer)
 
-	// Mark peer as dead
-	pl.MarkDead("test-peer")
+	// Only a suspect peer can be marked dead
+	if pl.MarkDead("test-peer", 0) {
+		t.Error("Expected an alive peer not to be marked dead")
+	}
+	pl.MarkSuspect("test-peer", 0)
+	if !pl.MarkDead("test-peer", 0) {
+		t.Error("Expected the suspect peer to be marked dead")
+	}
 
 	retrieved, exists := pl.Get("test-peer")
 	if !exists {
@@ -191,7 +199,411 @@ func TestPeerList_MarkDead(t *testing.T) {
 	}
 
 	// Marking non-existent peer as dead should not panic
-	pl.MarkDead("non-existent")
+	pl.MarkDead("non-existent", 0)
+}
+
+func TestPeerList_MarkDeadAfterRefute(t *testing.T) {
+	pl := NewPeerList()
+	pl.Add(&Peer{ID: "refuted", Address: "http://192.168.1.100:8080"})
+	pl.Add(&Peer{ID: "leaving", Address: "http://192.168.1.101:8080"})
+	pl.MarkSuspect("refuted", 0)
+	pl.MarkSuspect("leaving", 0)
+
+	// The suspects are picked, then refute or leave before they expire
+	suspects := pl.GetByState(StateSuspect)
+	pl.Merge(&Peer{ID: "refuted", State: StateAlive, Incarnation: 1})
+	pl.Merge(&Peer{ID: "leaving", State: StateLeft, Incarnation: 0})
+
+	for _, p := range suspects {
+		if pl.MarkDead(p.ID, p.Incarnation) {
+			t.Errorf("Expected %s not to be marked dead", p.ID)
+		}
+	}
+	if p, _ := pl.Get("refuted"); p.State != StateAlive {
+		t.Errorf("Expected the refuted peer to stay alive, got %s", p.State)
+	}
+	if p, _ := pl.Get("leaving"); p.State != StateLeft {
+		t.Errorf("Expected the peer that left to stay left, got %s", p.State)
+	}
+
+	// A new suspicion at the higher incarnation can still expire
+	pl.MarkSuspect("refuted", 1)
+	if pl.MarkDead("refuted", 0) || !pl.MarkDead("refuted", 1) {
+		t.Error("Expected only the current suspicion to expire")
+	}
+}
+
+func TestPeerList_MarkSuspect(t *testing.T) {
+	pl := NewPeerList()
+	pl.Add(&Peer{ID: "test-peer", Address: "http://192.168.1.100:8080", Incarnation: 2})
+
+	// An older incarnation must not suspect the peer
+	if pl.MarkSuspect("test-peer", 1) {
+		t.Error("Expected MarkSuspect() with stale incarnation to be ignored")
+	}
+
+	if !pl.MarkSuspect("test-peer", 2) {
+		t.Fatal("Expected MarkSuspect() to succeed")
+	}
+
+	retrieved, _ := pl.Get("test-peer")
+	if retrieved.State != StateSuspect {
+		t.Errorf("Expected peer to be suspect, got %s", retrieved.State)
+	}
+	if !retrieved.IsAlive {
+		t.Error("Expected suspect peer to still count as alive")
+	}
+
+	// Suspecting an already suspect peer is a no-op
+	if pl.MarkSuspect("test-peer", 2) {
+		t.Error("Expected MarkSuspect() on suspect peer to return false")
+	}
+
+	// Marking non-existent peer as suspect should not panic
+	if pl.MarkSuspect("non-existent", 0) {
+		t.Error("Expected MarkSuspect() on non-existent peer to return false")
+	}
+}
+
+func TestPeerList_Merge(t *testing.T) {
+	tests := []struct {
+		name        string
+		local       *Peer
+		remote      *Peer
+		wantChanged bool
+		wantState   State
+		wantInc     uint64
+	}{
+		{
+			name:        "unknown alive peer is added",
+			remote:      &Peer{ID: "p", State: StateAlive, Incarnation: 1},
+			wantChanged: true,
+			wantState:   StateAlive,
+			wantInc:     1,
+		},
+		{
+			name:        "peer without state is treated as alive",
+			remote:      &Peer{ID: "p"},
+			wantChanged: true,
+			wantState:   StateAlive,
+		},
+		{
+			name:   "unknown dead peer is ignored",
+			remote: &Peer{ID: "p", State: StateDead, Incarnation: 1},
+		},
+		{
+			name:        "suspect overrides alive with same incarnation",
+			local:       &Peer{ID: "p", Incarnation: 1},
+			remote:      &Peer{ID: "p", State: StateSuspect, Incarnation: 1},
+			wantChanged: true,
+			wantState:   StateSuspect,
+			wantInc:     1,
+		},
+		{
+			name:      "alive does not override suspect with same incarnation",
+			local:     &Peer{ID: "p", Incarnation: 1, State: StateSuspect},
+			remote:    &Peer{ID: "p", State: StateAlive, Incarnation: 1},
+			wantState: StateSuspect,
+			wantInc:   1,
+		},
+		{
+			name:        "alive with higher incarnation refutes suspect",
+			local:       &Peer{ID: "p", Incarnation: 1, State: StateSuspect},
+			remote:      &Peer{ID: "p", State: StateAlive, Incarnation: 2},
+			wantChanged: true,
+			wantState:   StateAlive,
+			wantInc:     2,
+		},
+		{
+			name:        "dead overrides suspect with same incarnation",
+			local:       &Peer{ID: "p", Incarnation: 1, State: StateSuspect},
+			remote:      &Peer{ID: "p", State: StateDead, Incarnation: 1},
+			wantChanged: true,
+			wantState:   StateDead,
+			wantInc:     1,
+		},
+		{
+			name:        "left overrides alive with same incarnation",
+			local:       &Peer{ID: "p", Incarnation: 1},
+			remote:      &Peer{ID: "p", State: StateLeft, Incarnation: 1},
+			wantChanged: true,
+			wantState:   StateLeft,
+			wantInc:     1,
+		},
+		{
+			name:      "left peer is not revived with the same incarnation",
+			local:     &Peer{ID: "p", Incarnation: 1, State: StateLeft},
+			remote:    &Peer{ID: "p", State: StateAlive, Incarnation: 1},
+			wantState: StateLeft,
+			wantInc:   1,
+		},
+		{
+			name:      "left peer is not revived by a suspect rumor",
+			local:     &Peer{ID: "p", Incarnation: 1, State: StateLeft},
+			remote:    &Peer{ID: "p", State: StateSuspect, Incarnation: 5},
+			wantState: StateLeft,
+			wantInc:   1,
+		},
+		{
+			name:        "left peer that rejoined is revived with a higher incarnation",
+			local:       &Peer{ID: "p", Incarnation: 1, State: StateLeft},
+			remote:      &Peer{ID: "p", State: StateAlive, Incarnation: 2},
+			wantChanged: true,
+			wantState:   StateAlive,
+			wantInc:     2,
+		},
+		{
+			name:      "stale incarnation is ignored",
+			local:     &Peer{ID: "p", Incarnation: 3},
+			remote:    &Peer{ID: "p", State: StateDead, Incarnation: 2},
+			wantState: StateAlive,
+			wantInc:   3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pl := NewPeerList()
+			if tt.local != nil {
+				state := tt.local.State
+				pl.Add(tt.local)
+				if state != "" {
+					pl.peers[tt.local.ID].setState(state)
+				}
+			}
+
+			if changed := pl.Merge(tt.remote); changed != tt.wantChanged {
+				t.Errorf("Merge() changed = %v, want %v", changed, tt.wantChanged)
+			}
+
+			p, exists := pl.Get("p")
+			if tt.wantState == "" {
+				if exists {
+					t.Errorf("Expected peer to not be added, got state %s", p.State)
+				}
+				return
+			}
+			if !exists {
+				t.Fatal("Expected peer to exist")
+			}
+			if p.State != tt.wantState {
+				t.Errorf("Expected state %s, got %s", tt.wantState, p.State)
+			}
+			if p.Incarnation != tt.wantInc {
+				t.Errorf("Expected incarnation %d, got %d", tt.wantInc, p.Incarnation)
+			}
+		})
+	}
+}
+
+func TestPeerList_MergeVersion(t *testing.T) {
+	pl := NewPeerList()
+	pl.Merge(&Peer{ID: "p", Address: "http://192.168.1.100:8080", Version: 2})
+
+	// Remote clocks are ignored; a newer LastSeen with an older version loses
+	pl.Merge(&Peer{ID: "p", Address: "http://10.0.0.1:8080", Version: 1, LastSeen: time.Now().Add(time.Hour)})
+	if p, _ := pl.Get("p"); p.Address != "http://192.168.1.100:8080" {
+		t.Errorf("Expected older version to be ignored, got address %s", p.Address)
+	}
+
+	// A newer version wins even with a LastSeen far in the past
+	if !pl.Merge(&Peer{ID: "p", Address: "http://192.168.1.200:8080", Version: 3, LastSeen: time.Now().Add(-time.Hour)}) {
+		t.Error("Expected newer version to change the peer")
+	}
+	p, _ := pl.Get("p")
+	if p.Address != "http://192.168.1.200:8080" {
+		t.Errorf("Expected newer version to update address, got %s", p.Address)
+	}
+	if p.Version != 3 {
+		t.Errorf("Expected version to be 3, got %d", p.Version)
+	}
+
+	// LastSeen is local time, not the sender's
+	if time.Since(p.LastSeen) > time.Minute {
+		t.Errorf("Expected LastSeen to be local time, got %v", p.LastSeen)
+	}
+
+	// Merging the same record twice is a no-op
+	if pl.Merge(&Peer{ID: "p", Address: "http://192.168.1.200:8080", Version: 3}) {
+		t.Error("Expected merging the same version to not change the peer")
+	}
+
+	// Key fingerprints are owner-set metadata as well
+	pl.Merge(&Peer{ID: "p", Address: "http://192.168.1.200:8080", Version: 4, Keys: []string{"aaaa"}})
+	if p, _ := pl.Get("p"); len(p.Keys) != 1 || p.Keys[0] != "aaaa" {
+		t.Errorf("Expected newer version to update keys, got %v", p.Keys)
+	}
+
+	// A peer learned through gossip starts with the owner's metadata
+	pl.Merge(&Peer{ID: "q", Address: "http://192.168.1.201:8080", Version: 2, Cluster: "staging",
+		Tags: map[string]string{"role": "db"}, Services: []Service{{ID: "pg", Name: "pg", Port: 5432}}, Keys: []string{"bbbb"}})
+	if q, _ := pl.Get("q"); q.Cluster != "staging" || !q.HasTag("role", "db") || len(q.Services) != 1 || len(q.Keys) != 1 {
+		t.Errorf("Expected a new peer to keep its metadata, got %+v", q)
+	}
+
+	// So are tags, and a newer version without tags clears them
+	pl.Merge(&Peer{ID: "p", Address: "http://192.168.1.200:8080", Version: 5, Tags: map[string]string{"role": "worker"}})
+	if p, _ := pl.Get("p"); !p.HasTag("role", "worker") {
+		t.Errorf("Expected newer version to update tags, got %v", p.Tags)
+	}
+	pl.Merge(&Peer{ID: "p", Address: "http://192.168.1.200:8080", Version: 6})
+	if p, _ := pl.Get("p"); len(p.Tags) != 0 {
+		t.Errorf("Expected newer version without tags to clear them, got %v", p.Tags)
+	}
+}
+
+func TestPeerList_SetOnChange(t *testing.T) {
+	pl := NewPeerList()
+
+	var changes []*Peer
+	pl.SetOnChange(func(p *Peer) {
+		changes = append(changes, p)
+	})
+
+	pl.Add(&Peer{ID: "p", Address: "http://192.168.1.100:8080"})
+	pl.MarkSuspect("p", 0)
+	pl.MarkSuspect("p", 0) // no change
+	pl.MarkDead("p", 0)
+	pl.MarkDead("p", 0) // no change
+	pl.Merge(&Peer{ID: "p", State: StateDead})
+
+	wantStates := []State{StateAlive, StateSuspect, StateDead}
+	if len(changes) != len(wantStates) {
+		t.Fatalf("Expected %d changes, got %d", len(wantStates), len(changes))
+	}
+	for i, want := range wantStates {
+		if changes[i].State != want {
+			t.Errorf("Expected change %d to be %s, got %s", i, want, changes[i].State)
+		}
+	}
+}
+
+func TestDigest_Supersedes(t *testing.T) {
+	tests := []struct {
+		name  string
+		d     Digest
+		other Digest
+		want  bool
+	}{
+		{"identical", Digest{Incarnation: 1, Version: 2, State: StateAlive}, Digest{Incarnation: 1, Version: 2, State: StateAlive}, false},
+		{"newer version", Digest{Version: 3}, Digest{Version: 2}, true},
+		{"older version", Digest{Version: 1}, Digest{Version: 2}, false},
+		{"higher incarnation", Digest{Incarnation: 2, Version: 1}, Digest{Incarnation: 1, Version: 1}, true},
+		{"stronger state", Digest{Incarnation: 1, State: StateDead}, Digest{Incarnation: 1, State: StateSuspect}, true},
+		{"weaker state", Digest{Incarnation: 1, State: StateAlive}, Digest{Incarnation: 1, State: StateSuspect}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.d.Supersedes(tt.other); got != tt.want {
+				t.Errorf("Supersedes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPeerList_GetByState(t *testing.T) {
+	pl := NewPeerList()
+	pl.Add(&Peer{ID: "alive-peer", Address: "http://192.168.1.100:8080"})
+	pl.Add(&Peer{ID: "suspect-peer", Address: "http://192.168.1.101:8080"})
+	pl.Add(&Peer{ID: "dead-peer", Address: "http://192.168.1.102:8080"})
+
+	pl.MarkSuspect("suspect-peer", 0)
+	pl.MarkSuspect("dead-peer", 0)
+	pl.MarkDead("dead-peer", 0)
+
+	suspects := pl.GetByState(StateSuspect)
+	if len(suspects) != 1 || suspects[0].ID != "suspect-peer" {
+		t.Errorf("Expected only suspect-peer to be suspect, got %v", suspects)
+	}
+
+	if pl.CountByState(StateAlive) != 1 {
+		t.Errorf("Expected 1 alive peer, got %d", pl.CountByState(StateAlive))
+	}
+	if pl.CountByState(StateDead) != 1 {
+		t.Errorf("Expected 1 dead peer, got %d", pl.CountByState(StateDead))
+	}
+
+	// Suspect peers still count as alive
+	if pl.CountAlive() != 2 {
+		t.Errorf("Expected alive count to be 2, got %d", pl.CountAlive())
+	}
+}
+
+func TestPeerList_Reap(t *testing.T) {
+	pl := NewPeerList()
+	pl.Add(&Peer{ID: "alive-peer", Address: "http://192.168.1.100:8080"})
+	pl.Add(&Peer{ID: "left-peer", Address: "http://192.168.1.101:8080"})
+	pl.Merge(&Peer{ID: "left-peer", State: StateLeft})
+
+	// Within the retention window nothing is reaped
+	if reaped := pl.Reap(StateLeft, time.Hour); len(reaped) != 0 {
+		t.Errorf("Expected nothing to be reaped, got %v", reaped)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	reaped := pl.Reap(StateLeft, 5*time.Millisecond)
+	if len(reaped) != 1 || reaped[0] != "left-peer" {
+		t.Errorf("Expected left-peer to be reaped, got %v", reaped)
+	}
+	if pl.Exists("left-peer") {
+		t.Error("Expected left-peer to be removed")
+	}
+	if !pl.Exists("alive-peer") {
+		t.Error("Expected alive-peer to be kept")
+	}
+	if pl.Reaped() != 1 {
+		t.Errorf("Expected reaped count to be 1, got %d", pl.Reaped())
+	}
+}
+
+func TestPeerList_ReapedPeerNotReinserted(t *testing.T) {
+	pl := NewPeerList()
+	pl.Add(&Peer{ID: "dead-peer", Address: "http://192.168.1.100:8080", Incarnation: 2})
+	pl.MarkSuspect("dead-peer", 2)
+	pl.MarkDead("dead-peer", 2)
+
+	time.Sleep(10 * time.Millisecond)
+	pl.Reap(StateDead, 5*time.Millisecond)
+
+	// Stale gossip with the old incarnation must not bring the peer back
+	if pl.Merge(&Peer{ID: "dead-peer", State: StateAlive, Incarnation: 2}) {
+		t.Error("Expected stale gossip to be ignored")
+	}
+	if pl.Exists("dead-peer") {
+		t.Fatal("Expected reaped peer to not be reinserted")
+	}
+
+	// A higher incarnation means the peer really is back
+	if !pl.Merge(&Peer{ID: "dead-peer", State: StateAlive, Incarnation: 3}) {
+		t.Error("Expected newer incarnation to be accepted")
+	}
+	if !pl.Exists("dead-peer") {
+		t.Error("Expected peer with newer incarnation to be reinserted")
+	}
+}
+
+func TestPeerList_ForgetRemoved(t *testing.T) {
+	pl := NewPeerList()
+	pl.Add(&Peer{ID: "dead-peer", Address: "http://192.168.1.100:8080"})
+	pl.MarkSuspect("dead-peer", 0)
+	pl.MarkDead("dead-peer", 0)
+
+	time.Sleep(10 * time.Millisecond)
+	pl.Reap(StateDead, 5*time.Millisecond)
+
+	pl.ForgetRemoved(time.Hour)
+	if pl.Merge(&Peer{ID: "dead-peer", State: StateAlive}) {
+		t.Fatal("Expected removal record to be kept within retention")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	pl.ForgetRemoved(5 * time.Millisecond)
+
+	if !pl.Merge(&Peer{ID: "dead-peer", State: StateAlive}) {
+		t.Error("Expected peer to be accepted once removal record is forgotten")
+	}
 }
 
 func TestPeerList_UpdateLastSeen(t *testing.T) {
@@ -284,7 +696,8 @@ func TestPeerList_CountAlive(t *testing.T) {
 	}
 
 	// Mark one peer as dead
-	pl.MarkDead("dead-peer")
+	pl.MarkSuspect("dead-peer", 0)
+	pl.MarkDead("dead-peer", 0)
 	if pl.CountAlive() != 2 {
 		t.Errorf("Expected alive count to be 2 after marking one dead, got %d", pl.CountAlive())
 	}
@@ -313,6 +726,69 @@ func TestPeerList_Exists(t *testing.T) {
 	}
 }
 
+func TestPeerList_Index(t *testing.T) {
+	pl := NewPeerList()
+	if pl.Index() != 1 {
+		t.Fatalf("Expected a new list to start at index 1, got %d", pl.Index())
+	}
+
+	pl.Add(&Peer{ID: "peer1", Address: "http://127.0.0.1:8081"})
+	pl.UpdateLastSeen("peer1")
+	pl.Merge(&Peer{ID: "peer1"})
+	if pl.Index() != 2 {
+		t.Errorf("Expected only the add to bump the index, got %d", pl.Index())
+	}
+
+	pl.MarkSuspect("peer1", 0)
+	pl.MarkDead("peer1", 0)
+	pl.Remove("peer1")
+	pl.Remove("peer1")
+	if pl.Index() != 5 {
+		t.Errorf("Expected index 5 after suspect, dead and remove, got %d", pl.Index())
+	}
+
+	pl.Add(&Peer{ID: "peer2", Address: "http://127.0.0.1:8082"})
+	pl.MarkSuspect("peer2", 0)
+	pl.MarkDead("peer2", 0)
+	time.Sleep(5 * time.Millisecond)
+	pl.Reap(StateDead, time.Millisecond)
+	if pl.Index() != 9 {
+		t.Errorf("Expected reaping to bump the index, got %d", pl.Index())
+	}
+}
+
+func TestPeerList_WaitForChange(t *testing.T) {
+	pl := NewPeerList()
+	index := pl.Index()
+
+	// An older index returns right away
+	if got := pl.WaitForChange(context.Background(), index-1); got != index {
+		t.Errorf("Expected index %d, got %d", index, got)
+	}
+
+	// The current index times out without a change
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if got := pl.WaitForChange(ctx, index); got != index {
+		t.Errorf("Expected index %d after the timeout, got %d", index, got)
+	}
+
+	// A change wakes up the waiter
+	done := make(chan uint64)
+	go func() { done <- pl.WaitForChange(context.Background(), index) }()
+	time.Sleep(10 * time.Millisecond)
+	pl.Add(&Peer{ID: "peer1", Address: "http://127.0.0.1:8081"})
+
+	select {
+	case got := <-done:
+		if got != index+1 {
+			t.Errorf("Expected index %d, got %d", index+1, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the waiter to wake up on a change")
+	}
+}
+
 func TestPeerList_Concurrency(t *testing.T) {
 	pl := NewPeerList()
 	const numGoroutines = 100
@@ -362,7 +838,8 @@ func TestPeerList_Concurrency(t *testing.T) {
 				if j%2 == 0 {
 					pl.UpdateLastSeen(peerID)
 				} else {
-					pl.MarkDead(peerID)
+					pl.MarkSuspect(peerID, 0)
+					pl.MarkDead(peerID, 0)
 				}
 			}
 		}(i)
@@ -0,0 +1,121 @@
+package peer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MaxServicesSize limits the JSON encoded size of a peer's services when
+// records are gossiped over UDP, so that a record still fits in a single
+// datagram. Over HTTP the size is not limited.
+const MaxServicesSize = 512
+
+// Health states of a service, as reported by its node's health check
+const (
+	HealthPassing  = "passing"
+	HealthCritical = "critical"
+)
+
+// Service is an application a node runs and advertises to the cluster
+type Service struct {
+	// ID identifies the service on its node and defaults to Name. Set it
+	// to run several instances of one service on a node.
+	ID   string   `json:"id"`
+	Name string   `json:"name"`
+	Port int      `json:"port"`
+	Tags []string `json:"tags,omitempty"`
+
+	// Health is the result of the service's health check, or empty if it
+	// has none
+	Health string `json:"health,omitempty"`
+}
+
+// Healthy reports whether the service has no health check or passes it
+func (svc Service) Healthy() bool {
+	return svc.Health == "" || svc.Health == HealthPassing
+}
+
+// ValidateServices checks that services can be gossiped: each needs a name,
+// a valid port and an ID unique on the node
+func ValidateServices(services []Service) error {
+	ids := make(map[string]bool, len(services))
+	for _, svc := range services {
+		if svc.Name == "" {
+			return fmt.Errorf("service name must not be empty")
+		}
+		if svc.Port <= 0 || svc.Port > 65535 {
+			return fmt.Errorf("service %s: port must be between 1 and 65535", svc.Name)
+		}
+		id := svc.ID
+		if id == "" {
+			id = svc.Name
+		}
+		if ids[id] {
+			return fmt.Errorf("duplicate service ID: %s", id)
+		}
+		ids[id] = true
+	}
+	return nil
+}
+
+// ValidateServicesSize checks that services stay within MaxServicesSize, as
+// required with the UDP transport
+func ValidateServicesSize(services []Service) error {
+	// Health is counted at its longest, since checks change it later
+	sized := make([]Service, len(services))
+	for i, svc := range services {
+		svc.Health = HealthCritical
+		sized[i] = svc
+	}
+	data, err := json.Marshal(sized)
+	if err != nil {
+		return err
+	}
+	if size := len(data); size > MaxServicesSize {
+		return fmt.Errorf("services must not exceed %d bytes, got %d", MaxServicesSize, size)
+	}
+	return nil
+}
+
+// normalize fills in the service ID and copies the tags
+func (svc Service) normalize() Service {
+	if svc.ID == "" {
+		svc.ID = svc.Name
+	}
+	svc.Tags = append([]string(nil), svc.Tags...)
+	return svc
+}
+
+// Instance is one healthy instance of a service on an alive member
+type Instance struct {
+	Node    *Peer
+	Service Service
+}
+
+// ServiceInstances returns the healthy instances of the named service on the
+// alive peers, ordered by node and service ID so answers are stable. Names
+// are matched ignoring case, as DNS does, so the HTTP and DNS interfaces
+// agree on which instances a service has.
+func ServiceInstances(peers []*Peer, name string) []Instance {
+	instances := make([]Instance, 0)
+	for _, p := range peers {
+		if p.State != StateAlive {
+			continue
+		}
+		for _, svc := range p.Services {
+			if strings.EqualFold(svc.Name, name) && svc.Healthy() {
+				instances = append(instances, Instance{Node: p, Service: svc})
+			}
+		}
+	}
+
+	sort.Slice(instances, func(i, j int) bool {
+		if instances[i].Node.ID != instances[j].Node.ID {
+			return instances[i].Node.ID < instances[j].Node.ID
+		}
+		return instances[i].Service.ID < instances[j].Service.ID
+	})
+	return instances
+}
@@ -0,0 +1,80 @@
+package peer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateServices(t *testing.T) {
+	tests := []struct {
+		name     string
+		services []Service
+		wantErr  bool
+	}{
+		{"no services", nil, false},
+		{"valid services", []Service{{Name: "web", Port: 80, Tags: []string{"v1"}}, {Name: "api", Port: 9090}}, false},
+		{"two instances", []Service{{ID: "web-1", Name: "web", Port: 8081}, {ID: "web-2", Name: "web", Port: 8082}}, false},
+		{"missing name", []Service{{Port: 80}}, true},
+		{"invalid port", []Service{{Name: "web", Port: 70000}}, true},
+		{"missing port", []Service{{Name: "web"}}, true},
+		{"duplicate ID", []Service{{Name: "web", Port: 80}, {ID: "web", Name: "api", Port: 81}}, true},
+		{"large", []Service{{Name: strings.Repeat("x", MaxServicesSize), Port: 80}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateServices(tt.services)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateServices() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateServicesSize(t *testing.T) {
+	if err := ValidateServicesSize([]Service{{Name: "web", Port: 80}, {Name: "api", Port: 9090}}); err != nil {
+		t.Errorf("Expected small services to fit, got %v", err)
+	}
+	if err := ValidateServicesSize([]Service{{Name: strings.Repeat("x", MaxServicesSize), Port: 80}}); err == nil {
+		t.Error("Expected services over the limit to fail")
+	}
+}
+
+func TestService_Healthy(t *testing.T) {
+	if !(Service{Name: "web"}).Healthy() {
+		t.Error("Expected a service without a check to be healthy")
+	}
+	if !(Service{Name: "web", Health: HealthPassing}).Healthy() {
+		t.Error("Expected a passing service to be healthy")
+	}
+	if (Service{Name: "web", Health: HealthCritical}).Healthy() {
+		t.Error("Expected a critical service not to be healthy")
+	}
+}
+
+func TestServiceInstances(t *testing.T) {
+	peers := []*Peer{
+		{ID: "node2", State: StateAlive, Services: []Service{
+			{ID: "web-2", Name: "web", Port: 8082},
+			{ID: "web-1", Name: "Web", Port: 8081},
+			{ID: "web-3", Name: "web", Port: 8083, Health: HealthCritical},
+			{ID: "db", Name: "db", Port: 5432},
+		}},
+		{ID: "node1", State: StateAlive, Services: []Service{{ID: "web", Name: "web", Port: 80, Health: HealthPassing}}},
+		{ID: "node3", State: StateSuspect, Services: []Service{{ID: "web", Name: "web", Port: 80}}},
+	}
+
+	instances := ServiceInstances(peers, "WEB")
+	if len(instances) != 3 {
+		t.Fatalf("Expected 3 healthy instances on alive nodes, got %+v", instances)
+	}
+	want := []string{"node1/web", "node2/web-1", "node2/web-2"}
+	for i, inst := range instances {
+		if got := inst.Node.ID + "/" + inst.Service.ID; got != want[i] {
+			t.Errorf("Expected instance %d to be %s, got %s", i, want[i], got)
+		}
+	}
+	if len(ServiceInstances(peers, "cache")) != 0 {
+		t.Error("Expected no instances of an unknown service")
+	}
+}
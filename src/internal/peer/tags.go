@@ -0,0 +1,51 @@
+package peer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// MaxTagsSize limits the JSON encoded size of a peer's tags, so that its
+// record still fits in a single UDP datagram
+const MaxTagsSize = 512
+
+// ValidateTags checks that tags can be gossiped and filtered on. Keys must
+// be non-empty and can't contain ':', which separates key and value in
+// tag filters.
+func ValidateTags(tags map[string]string) error {
+	for key := range tags {
+		if key == "" {
+			return fmt.Errorf("tag key must not be empty")
+		}
+		if strings.Contains(key, ":") {
+			return fmt.Errorf("tag key %q must not contain ':'", key)
+		}
+	}
+	data, err := json.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	if size := len(data); size > MaxTagsSize {
+		return fmt.Errorf("tags must not exceed %d bytes, got %d", MaxTagsSize, size)
+	}
+	return nil
+}
+
+// HasTag reports whether the peer has the tag key set to value
+func (p *Peer) HasTag(key, value string) bool {
+	v, ok := p.Tags[key]
+	return ok && v == value
+}
+
+func equalTags(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if v, ok := b[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+package peer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		tags    map[string]string
+		wantErr bool
+	}{
+		{"no tags", nil, false},
+		{"valid tags", map[string]string{"role": "worker", "zone": "rack3", "version": "1.4"}, false},
+		{"empty value", map[string]string{"canary": ""}, false},
+		{"empty key", map[string]string{"": "worker"}, true},
+		{"colon in key", map[string]string{"role:x": "worker"}, true},
+		{"colon in value", map[string]string{"endpoint": "10.0.0.1:8080"}, false},
+		{"too large", map[string]string{"blob": strings.Repeat("x", MaxTagsSize)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTags(tt.tags)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateTags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPeer_HasTag(t *testing.T) {
+	p := &Peer{ID: "p", Tags: map[string]string{"role": "worker", "canary": ""}}
+
+	if !p.HasTag("role", "worker") {
+		t.Error("Expected peer to have role=worker")
+	}
+	if p.HasTag("role", "db") {
+		t.Error("Expected peer not to have role=db")
+	}
+	if !p.HasTag("canary", "") {
+		t.Error("Expected peer to have the empty canary tag")
+	}
+	if p.HasTag("zone", "") {
+		t.Error("Expected a missing tag not to match an empty value")
+	}
+}
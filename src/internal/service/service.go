@@ -1,17 +1,29 @@
 package service
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
-	"net/http"
+	"math/rand"
+	"net"
+	"sync"
 	"time"
 
 	"github.com/rokzabukovec/clip/internal/config"
 	"github.com/rokzabukovec/clip/internal/discovery"
+	"github.com/rokzabukovec/clip/internal/dns"
+	"github.com/rokzabukovec/clip/internal/gossip"
 	"github.com/rokzabukovec/clip/internal/handlers"
+	"github.com/rokzabukovec/clip/internal/health"
+	"github.com/rokzabukovec/clip/internal/hooks"
+	"github.com/rokzabukovec/clip/internal/keyring"
+	"github.com/rokzabukovec/clip/internal/kv"
 	"github.com/rokzabukovec/clip/internal/peer"
+	"github.com/rokzabukovec/clip/internal/tlsutil"
+	"github.com/rokzabukovec/clip/internal/transport"
+	"github.com/rokzabukovec/clip/internal/userevent"
+	"github.com/rokzabukovec/clip/internal/webhook"
 	"github.com/rokzabukovec/clip/pkg/network"
 )
 
@@ -19,8 +31,22 @@ import (
 type Service struct {
 	config        *config.Config
 	peerList      *peer.PeerList
+	local         *peer.LocalNode
+	broadcasts    *gossip.Queue[*peer.Peer]
+	userEvents    *userevent.Log
+	eventQueue    *gossip.Queue[*peer.UserEvent]
+	kv            *kv.Store
+	kvQueue       *gossip.Queue[*kv.Entry]
 	discovery     *discovery.DiscoveryService
 	handlers      *handlers.Handler
+	transport     transport.Transport
+	udp           *transport.UDPTransport
+	dnsServer     *dns.Server
+	certs         *tlsutil.Reloader
+	keyring       *keyring.Keyring
+	checks        *health.Checker
+	hooks         *hooks.Runner
+	webhooks      *webhook.Dispatcher
 	stopChan      chan struct{}
 	advertiseAddr string
 }
@@ -49,7 +75,7 @@ func NewService(cfg *config.Config) *Service {
 		}
 	}
 
-	serviceAddr := fmt.Sprintf("http://%s:%d", advertiseAddr, cfg.Port)
+	serviceAddr := fmt.Sprintf("%s://%s:%d", cfg.Scheme(), advertiseAddr, cfg.Port)
 
 	discoveryService := discovery.NewDiscoveryService(
 		cfg.ID,
@@ -57,32 +83,163 @@ func NewService(cfg *config.Config) *Service {
 		cfg.Port,
 		cfg.BroadcastPort,
 		func(id, address string) {
-			// Callback when a peer is discovered via broadcast
-			p := &peer.Peer{
+			// Callback when a peer is discovered via broadcast. Known peers
+			// keep their state; only a suspected peer itself can clear it.
+			if peerList.Exists(id) {
+				peerList.UpdateLastSeen(id)
+				return
+			}
+			peerList.Add(&peer.Peer{
 				ID:      id,
 				Address: address,
-			}
-			peerList.Add(p)
+				Cluster: cfg.ClusterName,
+			})
 		},
 	)
+	discoveryService.SetClusterName(cfg.ClusterName)
 
 	handler := handlers.NewHandler(peerList, cfg.ID, func(p *peer.Peer) {
 		// Callback when a peer joins
 		log.Printf("Peer joined: %s at %s", p.ID, p.Address)
 	})
 
-	return &Service{
+	local := peer.NewLocalNode(cfg.ID, serviceAddr)
+	local.SetCluster(cfg.ClusterName)
+	local.SetTags(cfg.Tags)
+	if cfg.Transport == config.TransportUDP || cfg.Transport == config.TransportBoth {
+		local.LimitServicesSize()
+	}
+	checked := make(map[string]bool, len(cfg.Checks))
+	for _, check := range cfg.Checks {
+		checked[check.ServiceID] = true
+	}
+	for _, svc := range cfg.Services {
+		// Services with a check are critical until it first passes
+		if id := svc.ID; checked[id] || (id == "" && checked[svc.Name]) {
+			svc.Health = peer.HealthCritical
+		}
+		if err := local.RegisterService(svc); err != nil {
+			log.Printf("Failed to register service %s: %v", svc.Name, err)
+		}
+	}
+	checks := health.NewChecker(cfg.CheckInterval, cfg.CheckTimeout, func(id, status string) {
+		local.SetServiceHealth(id, status)
+	})
+	handler.SetChecker(checks)
+	handler.SetScriptChecks(cfg.EnableScriptChecks)
+	handler.SetLocalNode(local)
+	handler.SetClusterName(cfg.ClusterName)
+
+	// Every membership change, ours or learned from others, becomes a rumor
+	broadcasts := gossip.NewBroadcastQueue(cfg.RetransmitMult)
+	peerList.SetOnChange(broadcasts.Enqueue)
+	local.SetOnChange(broadcasts.Enqueue)
+
+	userEvents := userevent.NewLog(peer.NewEventBus(peer.DefaultEventHistory), userevent.DefaultBuffer)
+	handler.SetUserEvents(userEvents)
+	// The store takes writes once a push-pull has moved its clock past the
+	// cluster's, see syncKV
+	store := kv.NewStore(cfg.ID)
+	store.SetSynced(false)
+	handler.SetKV(store)
+
+	// Event and query handlers learn which node they run on from the
+	// environment
+	selfEnv := []string{
+		"CLIP_SELF_ID=" + cfg.ID,
+		"CLIP_SELF_ADDRESS=" + serviceAddr,
+		"CLIP_CLUSTER_NAME=" + cfg.ClusterName,
+	}
+	eventHandlers := hooks.NewRunner(cfg.EventHandlers, cfg.EventHandlerTimeout, selfEnv)
+	handler.SetQueryResponder(hooks.NewResponder(cfg.QueryHandlers, cfg.EventHandlerTimeout, selfEnv))
+	webhooks := webhook.NewDispatcher(cfg.Webhooks, cfg.WebhookSecret, cfg.WebhookQueueSize)
+	handler.SetWebhooks(webhooks)
+
+	s := &Service{
 		config:        cfg,
 		peerList:      peerList,
+		local:         local,
+		broadcasts:    broadcasts,
+		userEvents:    userEvents,
+		eventQueue:    gossip.NewEventQueue(cfg.RetransmitMult),
+		kv:            store,
+		kvQueue:       gossip.NewKVQueue(cfg.RetransmitMult),
 		discovery:     discoveryService,
 		handlers:      handler,
+		checks:        checks,
+		hooks:         eventHandlers,
+		webhooks:      webhooks,
 		stopChan:      make(chan struct{}),
 		advertiseAddr: advertiseAddr,
 	}
+
+	var dialTLS func(ctx context.Context, network, addr string) (net.Conn, error)
+	if cfg.TLSEnabled() {
+		s.certs = tlsutil.NewReloader(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSCAFile)
+		dialTLS = s.certs.DialTLSContext
+	}
+	httpTransport := transport.NewHTTPTransport(s.probeTimeout(), cfg.GossipInterval, dialTLS)
+	s.transport = httpTransport
+
+	// The keys are loaded in Start; until then an empty keyring drops
+	// every message instead of letting plaintext through
+	if cfg.EncryptionEnabled() {
+		s.keyring, _ = keyring.New()
+		discoveryService.SetKeyring(s.keyring)
+		handler.SetKeyring(s.keyring)
+		httpTransport.SetKeyring(s.keyring)
+		handler.SetKeyringFunc(s.keyringOp)
+	}
+	handler.SetProbeFunc(s.ping)
+	handler.SetQueryFunc(s.query)
+	userEvents.SetOnNew(s.spreadUserEvent)
+	store.SetOnChange(s.spreadKV)
+
+	return s
 }
 
 // Start starts the service
 func (s *Service) Start() error {
+	if s.certs != nil {
+		if err := s.certs.Reload(); err != nil {
+			return fmt.Errorf("failed to load TLS certificates: %w", err)
+		}
+		go s.certReloadLoop()
+	}
+
+	if s.keyring != nil {
+		if err := s.keyring.Load(s.config.KeyringFile, s.config.EncryptKey); err != nil {
+			return fmt.Errorf("failed to load keyring: %w", err)
+		}
+		s.local.SetKeys(s.keyring.Fingerprints())
+	}
+
+	if err := s.startTransport(); err != nil {
+		return err
+	}
+
+	if s.config.DNSPort > 0 {
+		server, err := dns.NewServer(s.config.BindAddress, s.config.DNSPort, s.handlers.FullState)
+		if err != nil {
+			return fmt.Errorf("failed to start DNS server: %w", err)
+		}
+		server.Start()
+		s.dnsServer = server
+	}
+
+	for _, check := range s.config.Checks {
+		if err := s.checks.Add(check); err != nil {
+			log.Printf("Failed to start health check for %s: %v", check.ServiceID, err)
+		}
+	}
+
+	if len(s.config.EventHandlers) > 0 {
+		s.hooks.Start(s.peerList.Events(), s.userEvents.Events())
+	}
+	if len(s.config.Webhooks) > 0 {
+		s.webhooks.Start(s.peerList.Events(), s.userEvents.Events())
+	}
+
 	// Start broadcast discovery for automatic peer detection on LAN
 	s.discovery.StartBroadcastListener()
 	go s.discovery.StartBroadcastAnnouncer()
@@ -95,17 +252,19 @@ func (s *Service) Start() error {
 	} else {
 		log.Printf("No seed nodes specified - relying on broadcast discovery")
 	}
+	s.syncKV()
 
-	go s.heartbeatLoop()
-	go s.healthCheckLoop()
+	go s.probeLoop()
 	go s.gossipLoop()
+	go s.pushPullLoop()
+	go s.reapLoop()
 
 	log.Printf("Service %s started (binding: %s:%d, advertising: %s:%d)",
 		s.config.ID, s.config.BindAddress, s.config.Port, s.advertiseAddr, s.config.Port)
 	return nil
 }
 
-// Stop stops the service
+// Stop announces that this node is leaving the cluster and stops the service
 func (s *Service) Stop() {
 	select {
 	case <-s.stopChan:
@@ -114,12 +273,162 @@ func (s *Service) Stop() {
 	default:
 		close(s.stopChan)
 	}
+	s.leave()
+	s.checks.Stop()
+	s.hooks.Stop()
+	s.webhooks.Stop()
 	s.discovery.Stop()
+	if s.udp != nil {
+		s.udp.Close()
+	}
+	if s.dnsServer != nil {
+		s.dnsServer.Close()
+	}
+}
+
+// startTransport sets up the transport selected in the config. UDP listens
+// on the same port number as the HTTP server.
+func (s *Service) startTransport() error {
+	if s.config.Transport != config.TransportUDP && s.config.Transport != config.TransportBoth {
+		return nil
+	}
+
+	udp, err := transport.NewUDPTransport(s.config.BindAddress, s.config.Port, s.probeTimeout(), s.handlers)
+	if err != nil {
+		return fmt.Errorf("failed to start UDP transport: %w", err)
+	}
+	if s.keyring != nil {
+		udp.SetKeyring(s.keyring)
+	}
+	udp.Start()
+	s.udp = udp
+
+	s.transport = transport.NewPacketTransport(udp, s.transport, s.config.Transport == config.TransportBoth)
+	return nil
+}
+
+// SetTransport replaces the transport used to talk to other peers, e.g.
+// with an in-memory one in tests. It must be called before Start.
+func (s *Service) SetTransport(t transport.Transport) {
+	s.transport = t
+}
+
+// leave tells all alive peers that this node is leaving, so they mark it
+// left instead of waiting for it to fail
+func (s *Service) leave() {
+	self := s.local.Leave()
+
+	var wg sync.WaitGroup
+	for _, p := range s.peerList.GetAlive() {
+		wg.Add(1)
+		go func(peer *peer.Peer) {
+			defer wg.Done()
+			if err := s.transport.Leave(peer.Address, self); err != nil {
+				log.Printf("Failed to send leave to %s: %v", peer.ID, err)
+			}
+		}(p)
+	}
+	wg.Wait()
+}
+
+// keyringOp sends a keyring change to all alive peers and collects the
+// errors of those that failed to apply it
+func (s *Service) keyringOp(req transport.KeyringRequest) map[string]error {
+	var mu sync.Mutex
+	errs := make(map[string]error)
+
+	var wg sync.WaitGroup
+	for _, p := range s.peerList.GetAlive() {
+		wg.Add(1)
+		go func(peer *peer.Peer) {
+			defer wg.Done()
+			if err := s.transport.KeyringOp(peer.Address, req); err != nil {
+				log.Printf("Failed to %s key on %s: %v", req.Op, peer.ID, err)
+				mu.Lock()
+				errs[peer.ID] = err
+				mu.Unlock()
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// query sends a query to the target members in parallel and collects
+// the responses that arrive before the query's timeout. This node answers
+// through its own handler.
+func (s *Service) query(req transport.QueryRequest, targets []*peer.Peer) []transport.QueryResponse {
+	// Buffered so that late answers don't block their senders; failed
+	// targets send nil so we stop waiting once everyone is accounted for
+	results := make(chan *transport.QueryResponse, len(targets))
+	for _, p := range targets {
+		go func(target *peer.Peer) {
+			var resp transport.QueryResponse
+			var err error
+			if target.ID == s.config.ID {
+				resp, err = s.handlers.Query(req)
+			} else {
+				resp, err = s.transport.Query(target.Address, req)
+			}
+			if err != nil {
+				log.Printf("Query %s to %s failed: %v", req.Name, target.ID, err)
+				results <- nil
+				return
+			}
+			resp.Node = target.ID
+			results <- &resp
+		}(p)
+	}
+
+	responses := make([]transport.QueryResponse, 0, len(targets))
+	deadline := time.After(req.Timeout)
+	for range targets {
+		select {
+		case resp := <-results:
+			if resp != nil {
+				responses = append(responses, *resp)
+			}
+		case <-deadline:
+			return responses
+		}
+	}
+	return responses
 }
 
 // GetFullAddress returns the full HTTP address for this service
 func (s *Service) GetFullAddress() string {
-	return fmt.Sprintf("http://%s:%d", s.advertiseAddr, s.config.Port)
+	return fmt.Sprintf("%s://%s:%d", s.config.Scheme(), s.advertiseAddr, s.config.Port)
+}
+
+// TLSConfig returns the TLS config the HTTP server must serve with, or nil
+// when TLS is disabled. Certificates are picked up on every handshake, so
+// the server keeps working across certificate reloads.
+func (s *Service) TLSConfig() *tls.Config {
+	if s.certs == nil {
+		return nil
+	}
+	return s.certs.ServerConfig()
+}
+
+// certReloadLoop periodically reloads the TLS files if they changed on disk
+func (s *Service) certReloadLoop() {
+	ticker := time.NewTicker(s.config.GossipInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			changed, err := s.certs.ReloadIfChanged()
+			if err != nil {
+				log.Printf("Failed to reload TLS certificates, keeping the current ones: %v", err)
+			} else if changed {
+				log.Printf("Reloaded TLS certificates")
+			}
+		case <-s.stopChan:
+			return
+		}
+	}
 }
 
 // GetHandlers returns the HTTP handlers
@@ -134,10 +443,7 @@ func (s *Service) GetPeerList() *peer.PeerList {
 
 // registerWithSeeds registers this service with seed nodes
 func (s *Service) registerWithSeeds() error {
-	thisPeer := &peer.Peer{
-		ID:      s.config.ID,
-		Address: s.GetFullAddress(),
-	}
+	thisPeer := s.local.Peer()
 
 	for _, seed := range s.config.SeedNodes {
 		if seed == s.GetFullAddress() {
@@ -156,136 +462,379 @@ func (s *Service) registerWithSeeds() error {
 
 // sendJoinRequest sends a join request to a peer
 func (s *Service) sendJoinRequest(peerAddr string, p *peer.Peer) error {
-	data, err := json.Marshal(p)
+	peers, err := s.transport.Join(peerAddr, p)
 	if err != nil {
 		return err
 	}
 
-	resp, err := http.Post(peerAddr+"/join", "application/json", bytes.NewBuffer(data))
+	s.handlers.MergePeers(peers)
+	return nil
+}
+
+// probeLoop runs one failure detection round every HeartbeatInterval
+func (s *Service) probeLoop() {
+	ticker := time.NewTicker(s.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.expireSuspects()
+			s.probeRandomPeer()
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// probeRandomPeer picks one random alive peer and probes it
+func (s *Service) probeRandomPeer() {
+	peers := s.peerList.GetAlive()
+	if len(peers) == 0 {
+		return
+	}
+
+	s.probePeer(peers[rand.Intn(len(peers))])
+}
+
+// probePeer probes a peer directly and, if that fails, asks up to
+// IndirectChecks other peers to probe it for us. The peer is only
+// suspected when neither the direct nor any indirect probe succeeds.
+func (s *Service) probePeer(target *peer.Peer) {
+	if err := s.ping(target.Address); err == nil {
+		s.peerList.UpdateLastSeen(target.ID)
+		return
+	}
+
+	if s.indirectPing(target) {
+		s.peerList.UpdateLastSeen(target.ID)
+		return
+	}
+
+	if s.peerList.MarkSuspect(target.ID, target.Incarnation) {
+		log.Printf("Peer %s marked as suspect (no direct or indirect probe response)", target.ID)
+	}
+}
+
+// expireSuspects marks peers dead that did not refute a suspicion within PeerTimeout
+func (s *Service) expireSuspects() {
+	now := time.Now()
+
+	for _, p := range s.peerList.GetByState(peer.StateSuspect) {
+		if now.Sub(p.StateChanged) > s.config.PeerTimeout && s.peerList.MarkDead(p.ID, p.Incarnation) {
+			log.Printf("Peer %s marked as dead (suspected for %v)", p.ID, now.Sub(p.StateChanged))
+		}
+	}
+}
+
+// ping sends a direct probe to the peer at the given address. The ack
+// carries the target's view of this node, which we refute if needed.
+func (s *Service) ping(address string) error {
+	view, err := s.transport.Ping(address, s.local.Peer())
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("join request failed with status: %d", resp.StatusCode)
+	if view != nil {
+		if incarnation, refuted := s.local.Refute(view); refuted {
+			log.Printf("Refuting %s rumor about this node, new incarnation: %d, version: %d", view.State, incarnation, s.local.Version())
+		}
 	}
+	return nil
+}
 
-	var peers []*peer.Peer
-	if err := json.NewDecoder(resp.Body).Decode(&peers); err != nil {
-		return err
+// indirectPing asks random helper peers to probe the target and reports
+// whether any of them got a response
+func (s *Service) indirectPing(target *peer.Peer) bool {
+	helpers := make([]*peer.Peer, 0)
+	for _, p := range s.peerList.GetAlive() {
+		if p.ID != target.ID {
+			helpers = append(helpers, p)
+		}
+	}
+	if len(helpers) == 0 || s.config.IndirectChecks == 0 {
+		return false
+	}
+
+	rand.Shuffle(len(helpers), func(i, j int) {
+		helpers[i], helpers[j] = helpers[j], helpers[i]
+	})
+	if len(helpers) > s.config.IndirectChecks {
+		helpers = helpers[:s.config.IndirectChecks]
+	}
+
+	req := transport.PingRequest{
+		TargetID:      target.ID,
+		TargetAddress: target.Address,
 	}
 
-	for _, peer := range peers {
-		if peer.ID != s.config.ID {
-			s.peerList.Add(peer)
+	acks := make(chan bool, len(helpers))
+	for _, h := range helpers {
+		go func(helper *peer.Peer) {
+			acks <- s.transport.PingReq(helper.Address, req) == nil
+		}(h)
+	}
+
+	for range helpers {
+		if <-acks {
+			return true
 		}
 	}
+	return false
+}
 
-	return nil
+// probeTimeout returns how long to wait for a direct probe response
+func (s *Service) probeTimeout() time.Duration {
+	if s.config.ProbeTimeout > 0 {
+		return s.config.ProbeTimeout
+	}
+	return s.config.HeartbeatInterval
 }
 
-// heartbeatLoop sends periodic heartbeats to known peers
-func (s *Service) heartbeatLoop() {
-	ticker := time.NewTicker(s.config.HeartbeatInterval)
+// reapLoop periodically removes dead peers, tombstones of peers that left
+// and tombstones of deleted keys
+func (s *Service) reapLoop() {
+	ticker := time.NewTicker(s.config.GossipInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			s.sendHeartbeats()
+			s.reapPeers()
+			s.reapKV()
 		case <-s.stopChan:
 			return
 		}
 	}
 }
 
-// sendHeartbeats sends heartbeats to all alive peers
-func (s *Service) sendHeartbeats() {
-	peers := s.peerList.GetAlive()
+// reapPeers removes peers that left more than TombstoneRetention ago and
+// peers that have been dead for more than DeadPeerRetention
+func (s *Service) reapPeers() {
+	for _, id := range s.peerList.Reap(peer.StateLeft, s.config.TombstoneRetention) {
+		log.Printf("Removed tombstone of peer %s", id)
+	}
+	for _, id := range s.peerList.Reap(peer.StateDead, s.config.DeadPeerRetention) {
+		log.Printf("Removed dead peer %s", id)
+	}
 
-	heartbeat := map[string]string{
-		"id":      s.config.ID,
-		"address": s.GetFullAddress(),
+	// Removal records only need to outlive stale gossip about the peer
+	retention := s.config.DeadPeerRetention
+	if s.config.TombstoneRetention > retention {
+		retention = s.config.TombstoneRetention
 	}
+	s.peerList.ForgetRemoved(retention)
+}
 
-	for _, p := range peers {
-		go func(peer *peer.Peer) {
-			data, _ := json.Marshal(heartbeat)
-			resp, err := http.Post(peer.Address+"/heartbeat", "application/json", bytes.NewBuffer(data))
-			if err != nil {
-				log.Printf("Failed to send heartbeat to %s: %v", peer.ID, err)
-				return
-			}
-			defer resp.Body.Close()
-		}(p)
+// reapKV removes tombstones of keys deleted more than KVTombstoneRetention
+// ago
+func (s *Service) reapKV() {
+	for _, key := range s.kv.Reap(s.config.KVTombstoneRetention) {
+		log.Printf("Removed tombstone of key %s", key)
 	}
 }
 
-// healthCheckLoop periodically checks peer health
-func (s *Service) healthCheckLoop() {
-	ticker := time.NewTicker(s.config.HeartbeatInterval)
+// gossipLoop periodically disseminates queued membership changes
+func (s *Service) gossipLoop() {
+	ticker := time.NewTicker(s.config.GossipInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			s.checkPeerHealth()
+			s.gossipWithPeers()
+			s.gossipUserEvents()
+			s.gossipKV()
 		case <-s.stopChan:
 			return
 		}
 	}
 }
 
-// checkPeerHealth marks peers as dead if they haven't been seen recently
-func (s *Service) checkPeerHealth() {
-	peers := s.peerList.GetAll()
-	now := time.Now()
+// gossipWithPeers sends the queued rumors to GossipFanout random alive peers
+func (s *Service) gossipWithPeers() {
+	peers := s.peerList.GetAlive()
+	if len(peers) == 0 {
+		return
+	}
 
-	for _, peer := range peers {
-		if now.Sub(peer.LastSeen) > s.config.PeerTimeout {
-			if peer.IsAlive {
-				log.Printf("Peer %s marked as dead (last seen: %v ago)", peer.ID, now.Sub(peer.LastSeen))
-				s.peerList.MarkDead(peer.ID)
-			}
-		}
+	rumors := s.broadcasts.Next(len(peers) + 1)
+	if len(rumors) == 0 {
+		return
+	}
+
+	for _, p := range s.randomPeers(peers, s.gossipFanout()) {
+		go func(peer *peer.Peer) {
+			s.transport.Broadcast(peer.Address, rumors)
+		}(p)
 	}
 }
 
-// gossipLoop periodically exchanges peer information with other peers
-func (s *Service) gossipLoop() {
-	ticker := time.NewTicker(s.config.GossipInterval)
+// spreadUserEvent queues a user event that is new to this node and sends
+// it on right away, so it doesn't wait a whole gossip interval at every hop
+func (s *Service) spreadUserEvent(e *peer.UserEvent) {
+	s.eventQueue.Enqueue(e)
+	go s.gossipUserEvents()
+}
+
+// gossipUserEvents sends the queued user events to GossipFanout random
+// alive peers
+func (s *Service) gossipUserEvents() {
+	peers := s.peerList.GetAlive()
+	if len(peers) == 0 {
+		return
+	}
+
+	events := s.eventQueue.Next(len(peers) + 1)
+	if len(events) == 0 {
+		return
+	}
+
+	for _, p := range s.randomPeers(peers, s.gossipFanout()) {
+		go func(peer *peer.Peer) {
+			s.transport.UserEvents(peer.Address, events)
+		}(p)
+	}
+}
+
+// spreadKV queues a key/value write, local or gossiped, to be passed on at
+// the next gossip interval
+func (s *Service) spreadKV(e *kv.Entry) {
+	s.kvQueue.Enqueue(e)
+}
+
+// gossipKV sends the queued key/value writes to GossipFanout random alive
+// peers
+func (s *Service) gossipKV() {
+	peers := s.peerList.GetAlive()
+	if len(peers) == 0 {
+		return
+	}
+
+	entries := s.kvQueue.Next(len(peers) + 1)
+	if len(entries) == 0 {
+		return
+	}
+
+	update := transport.KVUpdate{Cluster: s.config.ClusterName, Entries: entries}
+	for _, p := range s.randomPeers(peers, s.gossipFanout()) {
+		go func(peer *peer.Peer) {
+			s.transport.KVUpdate(peer.Address, update)
+		}(p)
+	}
+}
+
+// randomPeers returns up to n peers picked at random
+func (s *Service) randomPeers(peers []*peer.Peer, n int) []*peer.Peer {
+	shuffled := make([]*peer.Peer, len(peers))
+	copy(shuffled, peers)
+	rand.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	if len(shuffled) > n {
+		shuffled = shuffled[:n]
+	}
+	return shuffled
+}
+
+// gossipFanout returns how many peers each gossip round is sent to
+func (s *Service) gossipFanout() int {
+	if s.config.GossipFanout > 0 {
+		return s.config.GossipFanout
+	}
+	return 1
+}
+
+// pushPullLoop periodically runs a full state exchange with a random peer
+func (s *Service) pushPullLoop() {
+	interval := s.config.PushPullInterval
+	if interval <= 0 {
+		interval = s.config.GossipInterval
+	}
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			s.gossipWithPeers()
+			s.pushPullWithRandomPeer()
 		case <-s.stopChan:
 			return
 		}
 	}
 }
 
-// gossipWithPeers exchanges peer information with other peers
-func (s *Service) gossipWithPeers() {
+// pushPullWithRandomPeer sends our full state to one random alive peer and
+// merges the state it answers with, so both sides converge in a single round
+func (s *Service) pushPullWithRandomPeer() {
 	peers := s.peerList.GetAlive()
 	if len(peers) == 0 {
 		return
 	}
 
-	myPeers := s.peerList.GetAll()
+	target := peers[rand.Intn(len(peers))]
+	if err := s.pushPull(target.Address); err != nil {
+		log.Printf("Failed to push-pull with %s: %v", target.ID, err)
+	}
+}
 
-	for _, p := range peers {
-		go func(peer *peer.Peer) {
-			data, _ := json.Marshal(myPeers)
-			resp, err := http.Post(peer.Address+"/gossip", "application/json", bytes.NewBuffer(data))
-			if err != nil {
-				return
-			}
-			defer resp.Body.Close()
-		}(p)
+// syncKV catches the key/value store up with the cluster before it takes
+// local writes, so they are stamped past every write the cluster has seen.
+// A node that knows no peers when it starts is the first of its cluster and
+// takes writes right away; otherwise, if this push-pull fails, the store
+// takes writes after the first one in pushPullLoop succeeds.
+func (s *Service) syncKV() {
+	if s.peerList.CountAlive() == 0 {
+		s.kv.SetSynced(true)
+		return
+	}
+	s.pushPullWithRandomPeer()
+}
+
+// pushPull runs a digest-based state exchange with the peer at the given
+// address: we send a digest, merge the records and key/value entries it
+// answers with, and then push only the ones it asked for
+func (s *Service) pushPull(address string) error {
+	state := s.handlers.FullState()
+	digest := make([]peer.Digest, 0, len(state))
+	for _, p := range state {
+		digest = append(digest, p.Digest())
+	}
+
+	diff, err := s.transport.PushPull(address, transport.GossipRequest{
+		Cluster: s.config.ClusterName,
+		Digest:  digest,
+		KV:      s.kv.Digest(),
+	})
+	if err != nil {
+		return err
+	}
 
-		break
+	s.handlers.MergePeers(diff.Peers)
+	s.kv.Sync(diff.KV)
+	s.kv.SetSynced(true)
+
+	if len(diff.WantKV) > 0 {
+		update := transport.KVUpdate{Cluster: s.config.ClusterName, Entries: s.kv.Entries(diff.WantKV), Sync: true}
+		if err := s.transport.KVUpdate(address, update); err != nil {
+			return err
+		}
 	}
+
+	if len(diff.Want) == 0 {
+		return nil
+	}
+
+	wanted := make(map[string]bool, len(diff.Want))
+	for _, id := range diff.Want {
+		wanted[id] = true
+	}
+	updates := make([]*peer.Peer, 0, len(diff.Want))
+	for _, p := range s.handlers.FullState() {
+		if wanted[p.ID] {
+			updates = append(updates, p)
+		}
+	}
+
+	return s.transport.Push(address, updates)
 }
@@ -1,13 +1,29 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/rokzabukovec/clip/internal/handlers"
+	"github.com/rokzabukovec/clip/internal/health"
+	"github.com/rokzabukovec/clip/internal/hooks"
+	"github.com/rokzabukovec/clip/internal/peer"
 	"github.com/rokzabukovec/clip/internal/testutil"
+	"github.com/rokzabukovec/clip/internal/transport"
+	"github.com/rokzabukovec/clip/internal/webhook"
 )
 
 func TestNewService(t *testing.T) {
@@ -306,3 +322,1054 @@ func TestService_ConcurrentOperations(t *testing.T) {
 		<-done
 	}
 }
+
+func TestService_ProbePeer(t *testing.T) {
+	t.Run("direct probe succeeds", func(t *testing.T) {
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer target.Close()
+
+		svc := NewService(testutil.CreateTestConfig(t, "test-service"))
+		svc.peerList.Add(testutil.CreateTestPeer("target", target.URL))
+		p, _ := svc.peerList.Get("target")
+
+		svc.probePeer(p)
+
+		if p, _ := svc.peerList.Get("target"); !p.IsAlive {
+			t.Error("Expected reachable peer to stay alive")
+		}
+	})
+
+	t.Run("unreachable peer is suspected", func(t *testing.T) {
+		target := httptest.NewServer(http.NotFoundHandler())
+		target.Close()
+
+		svc := NewService(testutil.CreateTestConfig(t, "test-service"))
+		svc.peerList.Add(testutil.CreateTestPeer("target", target.URL))
+		p, _ := svc.peerList.Get("target")
+
+		svc.probePeer(p)
+
+		if p, _ := svc.peerList.Get("target"); p.State != peer.StateSuspect {
+			t.Errorf("Expected unreachable peer to be suspect, got %s", p.State)
+		}
+	})
+
+	t.Run("indirect probe keeps peer alive", func(t *testing.T) {
+		// The target refuses our direct probe, but a helper can still reach it
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer target.Close()
+
+		var pingReqs int32
+		helper := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/ping-req" {
+				atomic.AddInt32(&pingReqs, 1)
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer helper.Close()
+
+		svc := NewService(testutil.CreateTestConfig(t, "test-service"))
+		svc.peerList.Add(testutil.CreateTestPeer("target", target.URL))
+		svc.peerList.Add(testutil.CreateTestPeer("helper", helper.URL))
+		p, _ := svc.peerList.Get("target")
+
+		svc.probePeer(p)
+
+		if n := atomic.LoadInt32(&pingReqs); n != 1 {
+			t.Errorf("Expected 1 indirect probe request, got %d", n)
+		}
+		if p, _ := svc.peerList.Get("target"); p.State != peer.StateAlive {
+			t.Errorf("Expected peer to stay alive after successful indirect probe, got %s", p.State)
+		}
+	})
+}
+
+func TestService_ProbeRandomPeer(t *testing.T) {
+	svc := NewService(testutil.CreateTestConfig(t, "test-service"))
+
+	// Probing with no peers should be a no-op
+	svc.probeRandomPeer()
+
+	target := httptest.NewServer(http.NotFoundHandler())
+	target.Close()
+	svc.peerList.Add(&peer.Peer{ID: "target", Address: target.URL})
+
+	svc.probeRandomPeer()
+
+	if svc.peerList.CountByState(peer.StateSuspect) != 1 {
+		t.Errorf("Expected unreachable peer to be suspect, got %d suspects", svc.peerList.CountByState(peer.StateSuspect))
+	}
+}
+
+func TestService_ExpireSuspects(t *testing.T) {
+	cfg := testutil.CreateTestConfig(t, "test-service")
+	svc := NewService(cfg)
+
+	svc.peerList.Add(testutil.CreateTestPeer("suspect", "http://192.168.1.100:8080"))
+	svc.peerList.MarkSuspect("suspect", 0)
+
+	// Still within the suspicion timeout
+	svc.expireSuspects()
+	if p, _ := svc.peerList.Get("suspect"); p.State != peer.StateSuspect {
+		t.Fatalf("Expected peer to still be suspect, got %s", p.State)
+	}
+
+	time.Sleep(cfg.PeerTimeout + 50*time.Millisecond)
+	svc.expireSuspects()
+
+	if p, _ := svc.peerList.Get("suspect"); p.State != peer.StateDead {
+		t.Errorf("Expected peer to be dead after suspicion timeout, got %s", p.State)
+	}
+}
+
+func TestService_PingRefutesSuspicion(t *testing.T) {
+	// The target acks our probe but reports that it suspects us
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&peer.Peer{ID: "test-service", State: peer.StateSuspect, Incarnation: 4})
+	}))
+	defer target.Close()
+
+	svc := NewService(testutil.CreateTestConfig(t, "test-service"))
+
+	if err := svc.ping(target.URL); err != nil {
+		t.Fatalf("Expected ping to succeed, got error: %v", err)
+	}
+
+	if svc.local.Incarnation() != 5 {
+		t.Errorf("Expected incarnation to be bumped to 5, got %d", svc.local.Incarnation())
+	}
+}
+
+func TestService_StopAnnouncesLeave(t *testing.T) {
+	leaves := make(chan peer.Peer, 1)
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/leave" {
+			var p peer.Peer
+			json.NewDecoder(r.Body).Decode(&p)
+			leaves <- p
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer remote.Close()
+
+	svc := NewService(testutil.CreateTestConfig(t, "test-service"))
+	svc.peerList.Add(testutil.CreateTestPeer("remote", remote.URL))
+
+	svc.Stop()
+
+	select {
+	case p := <-leaves:
+		if p.ID != "test-service" || p.State != peer.StateLeft {
+			t.Errorf("Expected left record for test-service, got %s in state %s", p.ID, p.State)
+		}
+	default:
+		t.Fatal("Expected leave to be sent to alive peer")
+	}
+}
+
+func TestService_ReapPeers(t *testing.T) {
+	cfg := testutil.CreateTestConfig(t, "test-service")
+	svc := NewService(cfg)
+
+	svc.peerList.Add(testutil.CreateTestPeer("left-peer", "http://192.168.1.100:8080"))
+	svc.peerList.Merge(&peer.Peer{ID: "left-peer", State: peer.StateLeft})
+	svc.peerList.Add(testutil.CreateTestPeer("dead-peer", "http://192.168.1.101:8080"))
+	svc.peerList.MarkSuspect("dead-peer", 0)
+	svc.peerList.MarkDead("dead-peer", 0)
+	svc.peerList.Add(testutil.CreateTestPeer("alive-peer", "http://192.168.1.102:8080"))
+
+	svc.reapPeers()
+	if !svc.peerList.Exists("left-peer") || !svc.peerList.Exists("dead-peer") {
+		t.Fatal("Expected peers to be kept within retention period")
+	}
+
+	time.Sleep(cfg.DeadPeerRetention + 50*time.Millisecond)
+	svc.reapPeers()
+
+	if svc.peerList.Exists("left-peer") {
+		t.Error("Expected tombstone to be reaped after retention period")
+	}
+	if svc.peerList.Exists("dead-peer") {
+		t.Error("Expected dead peer to be reaped after retention period")
+	}
+	if !svc.peerList.Exists("alive-peer") {
+		t.Error("Expected alive peer to be kept")
+	}
+	if svc.peerList.Reaped() != 2 {
+		t.Errorf("Expected 2 reaped peers, got %d", svc.peerList.Reaped())
+	}
+}
+
+func TestService_PushPull(t *testing.T) {
+	remoteList := peer.NewPeerList()
+	remoteList.Add(testutil.CreateTestPeer("third", "http://192.168.1.102:8080"))
+	remoteHandler := handlers.NewHandler(remoteList, "remote", nil)
+	remoteHandler.SetLocalNode(peer.NewLocalNode("remote", "http://192.168.1.101:8080"))
+
+	remote := httptest.NewServer(remoteHandler.SetupRoutes())
+	defer remote.Close()
+
+	svc := NewService(testutil.CreateTestConfig(t, "test-service"))
+	svc.peerList.Add(testutil.CreateTestPeer("known", "http://192.168.1.103:8080"))
+
+	if err := svc.pushPull(remote.URL); err != nil {
+		t.Fatalf("Expected push-pull to succeed, got error: %v", err)
+	}
+
+	// We pulled the remote's view, including the remote itself
+	for _, id := range []string{"remote", "third", "known"} {
+		if !svc.peerList.Exists(id) {
+			t.Errorf("Expected %s to be known locally after push-pull", id)
+		}
+	}
+
+	// And pushed ours, including our own record
+	for _, id := range []string{"test-service", "known", "third"} {
+		if !remoteList.Exists(id) {
+			t.Errorf("Expected %s to be known remotely after push-pull", id)
+		}
+	}
+}
+
+func TestService_GossipWithPeers(t *testing.T) {
+	cfg := testutil.CreateTestConfig(t, "test-service")
+	cfg.GossipFanout = 2
+	svc := NewService(cfg)
+
+	var received int32
+	for i := 0; i < 4; i++ {
+		remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/broadcast" {
+				atomic.AddInt32(&received, 1)
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer remote.Close()
+		svc.peerList.Add(testutil.CreateTestPeer(fmt.Sprintf("peer%d", i), remote.URL))
+	}
+
+	// Adding peers queued rumors about them
+	if svc.broadcasts.Len() != 4 {
+		t.Fatalf("Expected 4 queued rumors, got %d", svc.broadcasts.Len())
+	}
+
+	svc.gossipWithPeers()
+
+	testutil.WaitForCondition(t, func() bool {
+		return atomic.LoadInt32(&received) == 2
+	}, time.Second, "rumors to reach fanout peers")
+
+	// Give any extra sends a chance to show up
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&received); n != 2 {
+		t.Errorf("Expected rumors to be sent to 2 peers, got %d", n)
+	}
+}
+
+func TestService_RumorsExpire(t *testing.T) {
+	cfg := testutil.CreateTestConfig(t, "test-service")
+	cfg.RetransmitMult = 1
+	svc := NewService(cfg)
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer target.Close()
+	svc.peerList.Add(testutil.CreateTestPeer("peer1", target.URL))
+
+	// With 2 nodes and a multiplier of 1 every rumor is sent once
+	svc.gossipWithPeers()
+
+	if svc.broadcasts.Len() != 0 {
+		t.Errorf("Expected rumors to be dropped after retransmit limit, got %d", svc.broadcasts.Len())
+	}
+}
+
+func newUDPTestService(t *testing.T, id, mode string) *Service {
+	t.Helper()
+	cfg := testutil.CreateTestConfig(t, id)
+	cfg.Transport = mode
+	svc := NewService(cfg)
+	if err := svc.startTransport(); err != nil {
+		t.Fatalf("Failed to start transport: %v", err)
+	}
+	t.Cleanup(func() {
+		if svc.udp != nil {
+			svc.udp.Close()
+		}
+	})
+	return svc
+}
+
+func TestService_UDPTransport(t *testing.T) {
+	a := newUDPTestService(t, "node-a", "udp")
+	b := newUDPTestService(t, "node-b", "udp")
+	if a.udp == nil || b.udp == nil {
+		t.Fatal("Expected UDP transport to be started")
+	}
+
+	// Probes go over UDP, and the target learns about the prober
+	target := testutil.CreateTestPeer("node-b", b.GetFullAddress())
+	a.peerList.Add(target)
+	a.probePeer(target)
+
+	if p, _ := a.peerList.Get("node-b"); p.State != peer.StateAlive {
+		t.Errorf("Expected node-b to stay alive, got %s", p.State)
+	}
+	testutil.WaitForPeerExists(t, b.peerList, "node-a", time.Second)
+
+	// Rumors go over UDP as well
+	a.peerList.Add(testutil.CreateTestPeer("node-c", "http://127.0.0.1:1"))
+	a.gossipWithPeers()
+	testutil.WaitForPeerExists(t, b.peerList, "node-c", time.Second)
+}
+
+func TestService_BothTransportFallsBackToHTTP(t *testing.T) {
+	// The remote only serves HTTP
+	remote := NewService(testutil.CreateTestConfig(t, "remote"))
+	server := httptest.NewServer(remote.handlers.SetupRoutes())
+	defer server.Close()
+
+	svc := newUDPTestService(t, "test-service", "both")
+	if err := svc.ping(server.URL); err != nil {
+		t.Fatalf("Expected ping to fall back to HTTP, got error: %v", err)
+	}
+	testutil.WaitForPeerExists(t, remote.peerList, "test-service", time.Second)
+}
+
+func TestService_StartTransportHTTP(t *testing.T) {
+	svc := newUDPTestService(t, "test-service", "http")
+	if svc.udp != nil {
+		t.Error("Expected no UDP socket with the http transport")
+	}
+}
+
+// newMemoryCluster creates services that talk over an in-memory network
+func newMemoryCluster(t *testing.T, ids ...string) ([]*Service, *transport.Network) {
+	t.Helper()
+	network := transport.NewNetwork()
+	services := make([]*Service, len(ids))
+	for i, id := range ids {
+		svc := NewService(testutil.CreateTestConfig(t, id))
+		svc.SetTransport(network.Transport())
+		network.Register(svc.GetFullAddress(), svc.GetHandlers())
+		services[i] = svc
+	}
+	return services, network
+}
+
+func TestService_MemoryCluster(t *testing.T) {
+	services, network := newMemoryCluster(t, "node1", "node2", "node3")
+	node1, node2, node3 := services[0], services[1], services[2]
+
+	// node2 and node3 join through node1
+	for _, svc := range []*Service{node2, node3} {
+		if err := svc.sendJoinRequest(node1.GetFullAddress(), svc.local.Peer()); err != nil {
+			t.Fatalf("Expected %s to join, got error: %v", svc.config.ID, err)
+		}
+	}
+	if node1.peerList.Count() != 2 {
+		t.Errorf("Expected node1 to know 2 peers, got %d", node1.peerList.Count())
+	}
+	if node2.peerList.Exists("node3") {
+		t.Error("Expected node2 not to know node3 before push-pull")
+	}
+
+	// A push-pull round tells node2 about node3
+	if err := node2.pushPull(node1.GetFullAddress()); err != nil {
+		t.Fatalf("Expected push-pull to succeed, got error: %v", err)
+	}
+	if !node2.peerList.Exists("node3") {
+		t.Error("Expected node2 to learn about node3 through push-pull")
+	}
+
+	// node3 crashes: neither node1 nor node2 (asked indirectly) can reach it
+	network.Unregister(node3.GetFullAddress())
+	target, _ := node1.peerList.Get("node3")
+	node1.probePeer(target)
+	if p, _ := node1.peerList.Get("node3"); p.State != peer.StateSuspect {
+		t.Errorf("Expected node3 to be suspect, got %s", p.State)
+	}
+
+	// node2 leaves gracefully
+	node2.leave()
+	if p, _ := node1.peerList.Get("node2"); p.State != peer.StateLeft {
+		t.Errorf("Expected node2 to be left, got %s", p.State)
+	}
+}
+
+func TestService_RejoinAfterLeave(t *testing.T) {
+	services, network := newMemoryCluster(t, "node1", "node2", "node3")
+	node1, node2, node3 := services[0], services[1], services[2]
+	for _, svc := range []*Service{node2, node3} {
+		if err := svc.sendJoinRequest(node1.GetFullAddress(), svc.local.Peer()); err != nil {
+			t.Fatalf("Expected %s to join, got error: %v", svc.config.ID, err)
+		}
+	}
+	if err := node2.pushPull(node1.GetFullAddress()); err != nil {
+		t.Fatalf("Expected push-pull to succeed, got error: %v", err)
+	}
+
+	// node2 leaves and restarts with the same ID, joining through node1
+	node2.leave()
+	network.Unregister(node2.GetFullAddress())
+	restarted := NewService(node2.config)
+	restarted.SetTransport(network.Transport())
+	network.Register(restarted.GetFullAddress(), restarted.GetHandlers())
+	if err := restarted.sendJoinRequest(node1.GetFullAddress(), restarted.local.Peer()); err != nil {
+		t.Fatalf("Expected the restarted node to join, got error: %v", err)
+	}
+
+	// node3 still has the left record; push-pull must not push the
+	// restarted node back to left, and the refuted record reaches node3
+	for i := 0; i < 2; i++ {
+		if err := restarted.pushPull(node3.GetFullAddress()); err != nil {
+			t.Fatalf("Expected push-pull to succeed, got error: %v", err)
+		}
+	}
+	if state := restarted.local.Peer().State; state != peer.StateAlive {
+		t.Errorf("Expected the restarted node to stay alive, got %s", state)
+	}
+	if restarted.local.Incarnation() == 0 {
+		t.Error("Expected the restarted node to refute the leave with a higher incarnation")
+	}
+	if p, _ := node3.peerList.Get("node2"); p.State != peer.StateAlive {
+		t.Errorf("Expected node3 to see the restarted node alive, got %s", p.State)
+	}
+}
+
+func TestService_RestartUpdatesMetadata(t *testing.T) {
+	services, network := newMemoryCluster(t, "node1", "node2")
+	node1, node2 := services[0], services[1]
+	node2.local.SetTags(map[string]string{"role": "web"})
+	node2.local.SetTags(map[string]string{"role": "worker"})
+	if err := node2.sendJoinRequest(node1.GetFullAddress(), node2.local.Peer()); err != nil {
+		t.Fatalf("Expected node2 to join, got error: %v", err)
+	}
+
+	// node2 crashes and comes back with fewer changes behind it, so its
+	// version starts below the one node1 holds
+	network.Unregister(node2.GetFullAddress())
+	restarted := NewService(node2.config)
+	restarted.SetTransport(network.Transport())
+	network.Register(restarted.GetFullAddress(), restarted.GetHandlers())
+	restarted.local.SetTags(map[string]string{"role": "db"})
+
+	for i := 0; i < 2; i++ {
+		if err := restarted.pushPull(node1.GetFullAddress()); err != nil {
+			t.Fatalf("Expected push-pull to succeed, got error: %v", err)
+		}
+	}
+	if p, _ := node1.peerList.Get("node2"); !p.HasTag("role", "db") {
+		t.Errorf("Expected node1 to take the restarted node's tags, got %v", p.Tags)
+	}
+}
+
+func TestService_TagsPropagate(t *testing.T) {
+	services, _ := newMemoryCluster(t, "node1", "node2", "node3")
+	node1, node2, node3 := services[0], services[1], services[2]
+	for _, svc := range []*Service{node2, node3} {
+		if err := svc.sendJoinRequest(node1.GetFullAddress(), svc.local.Peer()); err != nil {
+			t.Fatalf("Expected %s to join, got error: %v", svc.config.ID, err)
+		}
+	}
+
+	// node3 changes its tags at runtime; the rumor reaches node1 directly
+	// and node2 through push-pull
+	node3.local.SetTags(map[string]string{"role": "worker"})
+	node3.gossipWithPeers()
+	time.Sleep(50 * time.Millisecond)
+	if p, _ := node1.peerList.Get("node3"); !p.HasTag("role", "worker") {
+		t.Errorf("Expected node1 to learn node3's tags through gossip, got %v", p.Tags)
+	}
+
+	if err := node2.pushPull(node1.GetFullAddress()); err != nil {
+		t.Fatalf("Expected push-pull to succeed, got error: %v", err)
+	}
+	if p, _ := node2.peerList.Get("node3"); !p.HasTag("role", "worker") {
+		t.Errorf("Expected node2 to learn node3's tags through push-pull, got %v", p.Tags)
+	}
+}
+
+func TestService_UserEvents(t *testing.T) {
+	services, _ := newMemoryCluster(t, "node1", "node2", "node3")
+	node1 := services[0]
+	for _, svc := range services[1:] {
+		if err := svc.sendJoinRequest(node1.GetFullAddress(), svc.local.Peer()); err != nil {
+			t.Fatalf("Expected %s to join, got error: %v", svc.config.ID, err)
+		}
+	}
+	if err := services[1].pushPull(node1.GetFullAddress()); err != nil {
+		t.Fatalf("Expected push-pull to succeed, got error: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/event/deploy", strings.NewReader("v1.4.2"))
+	w := httptest.NewRecorder()
+	node1.handlers.SetupRoutes().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the event to be fired, got status %d: %s", w.Code, w.Body.String())
+	}
+
+	// userEventsOf returns the user events delivered on a node
+	userEventsOf := func(svc *Service) []*peer.UserEvent {
+		replay, _, cancel := svc.userEvents.Events().Subscribe(0)
+		cancel()
+		var events []*peer.UserEvent
+		for _, event := range replay {
+			if event.Type == peer.EventUser {
+				events = append(events, event.User)
+			}
+		}
+		return events
+	}
+
+	testutil.WaitForCondition(t, func() bool {
+		for _, svc := range services {
+			if len(userEventsOf(svc)) == 0 {
+				return false
+			}
+		}
+		return true
+	}, 2*time.Second, "the event to reach every node")
+
+	// Let the copies gossiped back and forth settle
+	time.Sleep(50 * time.Millisecond)
+	for _, svc := range services {
+		events := userEventsOf(svc)
+		if len(events) != 1 || events[0].Name != "deploy" || string(events[0].Payload) != "v1.4.2" || events[0].Origin != "node1" {
+			t.Errorf("Expected %s to deliver the event once, got %+v", svc.config.ID, events)
+		}
+		if svc.userEvents.Time() < events[0].LTime {
+			t.Errorf("Expected %s's clock to move past the event, got %d", svc.config.ID, svc.userEvents.Time())
+		}
+	}
+}
+
+func TestService_Query(t *testing.T) {
+	services, network := newMemoryCluster(t, "node1", "node2", "node3")
+	node1, node2, node3 := services[0], services[1], services[2]
+	for _, svc := range []*Service{node2, node3} {
+		if err := svc.sendJoinRequest(node1.GetFullAddress(), svc.local.Peer()); err != nil {
+			t.Fatalf("Expected %s to join, got error: %v", svc.config.ID, err)
+		}
+	}
+	node2.handlers.SetQueryResponder(hooks.NewResponder([]hooks.QueryHandler{
+		{Name: "whoami", Command: []string{"sh", "-c", `printf '%s %s' "$CLIP_SELF_ID" "$(cat)"`}},
+	}, time.Second, []string{"CLIP_SELF_ID=node2"}))
+	// node3 is gone but not yet detected as failed
+	network.Unregister(node3.GetFullAddress())
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/query/whoami?timeout=1s", strings.NewReader("ping"))
+	w := httptest.NewRecorder()
+	node1.handlers.SetupRoutes().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var result handlers.QueryResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if result.NumNodes != 3 || len(result.Responses) != 2 {
+		t.Fatalf("Expected 2 of 3 nodes to answer, got %+v", result)
+	}
+	// node1 has no handler for the query and only acknowledges it
+	if resp := result.Responses[0]; resp.Node != "node1" || resp.Payload != nil || resp.Error != "" {
+		t.Errorf("Expected an acknowledgement from node1, got %+v", resp)
+	}
+	if resp := result.Responses[1]; resp.Node != "node2" || string(resp.Payload) != "node2 ping" {
+		t.Errorf("Expected node2's handler to answer, got %+v", resp)
+	}
+	if len(result.NoResponse) != 1 || result.NoResponse[0] != "node3" {
+		t.Errorf("Expected node3 to be listed as not responding, got %v", result.NoResponse)
+	}
+}
+
+func TestService_KV(t *testing.T) {
+	services, network := newMemoryCluster(t, "node1", "node2", "node3")
+	node1, node2, node3 := services[0], services[1], services[2]
+	for _, svc := range []*Service{node2, node3} {
+		if err := svc.sendJoinRequest(node1.GetFullAddress(), svc.local.Peer()); err != nil {
+			t.Fatalf("Expected %s to join, got error: %v", svc.config.ID, err)
+		}
+	}
+	if err := node2.pushPull(node1.GetFullAddress()); err != nil {
+		t.Fatalf("Expected push-pull to succeed, got error: %v", err)
+	}
+	for _, svc := range services {
+		svc.syncKV()
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/v1/kv/feature/dark-mode", strings.NewReader("on"))
+	w := httptest.NewRecorder()
+	node1.handlers.SetupRoutes().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the key to be written, got status %d: %s", w.Code, w.Body.String())
+	}
+
+	// valueOn returns a node's value for a key, or "" if it is not set
+	valueOn := func(svc *Service, key string) string {
+		if e, ok := svc.kv.Get(key); ok {
+			return string(e.Value)
+		}
+		return ""
+	}
+	// gossipRound passes the queued writes on, as gossipLoop does
+	gossipRound := func() {
+		for _, svc := range services {
+			svc.gossipKV()
+		}
+	}
+	testutil.WaitForCondition(t, func() bool {
+		gossipRound()
+		for _, svc := range services {
+			if valueOn(svc, "feature/dark-mode") != "on" {
+				return false
+			}
+		}
+		return true
+	}, 2*time.Second, "the write to reach every node")
+
+	// node3 misses a delete and a write while it is cut off, and catches
+	// up in a single push-pull round
+	network.Unregister(node3.GetFullAddress())
+	node2.kv.Delete("feature/dark-mode")
+	node1.kv.Put("config/web", []byte("8080"))
+	testutil.WaitForCondition(t, func() bool {
+		gossipRound()
+		return valueOn(node1, "feature/dark-mode") == "" && valueOn(node2, "config/web") == "8080"
+	}, 2*time.Second, "the changes to reach node1 and node2")
+	if valueOn(node3, "feature/dark-mode") != "on" || valueOn(node3, "config/web") != "" {
+		t.Fatal("Expected node3 to miss the changes while cut off")
+	}
+
+	// What node3 learns through push-pull is not rumored again
+	for node3.kvQueue.Len() > 0 {
+		node3.kvQueue.Next(3)
+	}
+	network.Register(node3.GetFullAddress(), node3.GetHandlers())
+	if err := node3.pushPull(node1.GetFullAddress()); err != nil {
+		t.Fatalf("Expected push-pull to succeed, got error: %v", err)
+	}
+	if valueOn(node3, "feature/dark-mode") != "" || valueOn(node3, "config/web") != "8080" {
+		t.Errorf("Expected node3 to converge through push-pull, got %+v", node3.kv.List(""))
+	}
+	if n := node3.kvQueue.Len(); n != 0 {
+		t.Errorf("Expected node3 not to re-rumor synced writes, got %d queued", n)
+	}
+}
+
+func TestService_KVRestart(t *testing.T) {
+	services, network := newMemoryCluster(t, "node1", "node2")
+	node1, node2 := services[0], services[1]
+	node1.syncKV()
+	for i := 0; i < 5; i++ {
+		node1.kv.Put("flag", []byte("old"))
+	}
+
+	// A restarted node2 starts with an empty store and a clock at 0, so it
+	// refuses writes until it has caught up
+	node2 = NewService(node2.config)
+	node2.SetTransport(network.Transport())
+	network.Register(node2.GetFullAddress(), node2.GetHandlers())
+	if err := node2.sendJoinRequest(node1.GetFullAddress(), node2.local.Peer()); err != nil {
+		t.Fatalf("Expected node2 to join, got error: %v", err)
+	}
+
+	put := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/v1/kv/flag", strings.NewReader("new"))
+		w := httptest.NewRecorder()
+		node2.handlers.SetupRoutes().ServeHTTP(w, req)
+		return w
+	}
+	if w := put(); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status 503 before the store is synced, got %d", w.Code)
+	}
+
+	node2.syncKV()
+	if w := put(); w.Code != http.StatusOK {
+		t.Fatalf("Expected the write to succeed once synced, got status %d: %s", w.Code, w.Body.String())
+	}
+	if err := node1.pushPull(node2.GetFullAddress()); err != nil {
+		t.Fatalf("Expected push-pull to succeed, got error: %v", err)
+	}
+	for _, svc := range []*Service{node1, node2} {
+		if e, ok := svc.kv.Get("flag"); !ok || string(e.Value) != "new" {
+			t.Errorf("Expected the write after the restart to win on %s, got %+v", svc.config.ID, e)
+		}
+	}
+}
+
+func TestService_ServiceCatalog(t *testing.T) {
+	network := transport.NewNetwork()
+	cfg := testutil.CreateTestConfig(t, "node1")
+	cfg.Services = []peer.Service{{Name: "web", Port: 80}}
+	node1 := NewService(cfg)
+	node2 := NewService(testutil.CreateTestConfig(t, "node2"))
+	for _, svc := range []*Service{node1, node2} {
+		svc.SetTransport(network.Transport())
+		network.Register(svc.GetFullAddress(), svc.GetHandlers())
+	}
+
+	if err := node2.sendJoinRequest(node1.GetFullAddress(), node2.local.Peer()); err != nil {
+		t.Fatalf("Expected join to succeed, got error: %v", err)
+	}
+	if instances := node2.handlers.ServiceInstances("web"); len(instances) != 1 || instances[0].Node != "node1" {
+		t.Errorf("Expected node2 to learn node1's web service on join, got %+v", instances)
+	}
+
+	// A service registered at runtime reaches node1 through push-pull
+	node2.local.RegisterService(peer.Service{Name: "web", Port: 8080})
+	if err := node2.pushPull(node1.GetFullAddress()); err != nil {
+		t.Fatalf("Expected push-pull to succeed, got error: %v", err)
+	}
+	if instances := node1.handlers.ServiceInstances("web"); len(instances) != 2 {
+		t.Errorf("Expected 2 web instances on node1, got %+v", instances)
+	}
+}
+
+func TestService_DNS(t *testing.T) {
+	cfg := testutil.CreateTestConfig(t, "node1")
+	cfg.DNSPort = testutil.GetFreePort(t)
+	cfg.Services = []peer.Service{{Name: "web", Port: 80}}
+	svc := NewService(cfg)
+	if err := svc.Start(); err != nil {
+		t.Fatalf("Expected Start() to succeed, got error: %v", err)
+	}
+	defer svc.Stop()
+
+	r := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, fmt.Sprintf("127.0.0.1:%d", cfg.DNSPort))
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if addrs, err := r.LookupHost(ctx, "node1.node.clip."); err != nil || len(addrs) != 1 || addrs[0] != "127.0.0.1" {
+		t.Errorf("Expected node1 to resolve to 127.0.0.1, got %v, %v", addrs, err)
+	}
+	_, srvs, err := r.LookupSRV(ctx, "web", "tcp", "service.clip.")
+	if err != nil || len(srvs) != 1 || srvs[0].Target != "node1.node.clip." || srvs[0].Port != 80 {
+		t.Errorf("Expected an SRV record for the web service, got %+v, %v", srvs, err)
+	}
+}
+
+func TestService_EventHandlers(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "events")
+	cfg := testutil.CreateTestConfig(t, "node1")
+	cfg.EventHandlers = []hooks.Hook{{
+		Events:  []peer.EventType{peer.EventJoin},
+		Command: []string{"sh", "-c", `echo "$CLIP_EVENT $CLIP_SELF_ID" >> "$0"`, out},
+	}}
+	svc := NewService(cfg)
+	if err := svc.Start(); err != nil {
+		t.Fatalf("Expected Start() to succeed, got error: %v", err)
+	}
+	defer svc.Stop()
+
+	svc.peerList.Add(testutil.CreateTestPeer("node2", "http://127.0.0.1:1"))
+	testutil.WaitForCondition(t, func() bool {
+		data, _ := os.ReadFile(out)
+		return string(data) == "member-join node1\n"
+	}, 2*time.Second, "the join handler to run")
+}
+
+func TestService_Webhooks(t *testing.T) {
+	var signed atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(webhook.SignatureHeader) == webhook.Sign("s3cret", body) {
+			signed.Add(1)
+		}
+	}))
+	defer receiver.Close()
+
+	cfg := testutil.CreateTestConfig(t, "node1")
+	cfg.Webhooks = []webhook.Endpoint{{URL: receiver.URL, Events: []peer.EventType{peer.EventJoin}}}
+	cfg.WebhookSecret = "s3cret"
+	svc := NewService(cfg)
+	if err := svc.Start(); err != nil {
+		t.Fatalf("Expected Start() to succeed, got error: %v", err)
+	}
+	defer svc.Stop()
+
+	svc.peerList.Add(testutil.CreateTestPeer("node2", "http://127.0.0.1:1"))
+	testutil.WaitForCondition(t, func() bool {
+		status := svc.webhooks.Status()
+		return len(status) == 1 && status[0].Delivered == 1
+	}, 2*time.Second, "the join to be delivered")
+
+	if signed.Load() != 1 {
+		t.Errorf("Expected one signed delivery, got %d", signed.Load())
+	}
+}
+
+func TestService_HealthChecks(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer app.Close()
+
+	network := transport.NewNetwork()
+	cfg := testutil.CreateTestConfig(t, "node1")
+	cfg.Services = []peer.Service{{Name: "web", Port: 80}}
+	cfg.Checks = []health.Check{{ServiceID: "web", HTTP: app.URL}}
+	node1 := NewService(cfg)
+	node2 := NewService(testutil.CreateTestConfig(t, "node2"))
+	for _, svc := range []*Service{node1, node2} {
+		svc.SetTransport(network.Transport())
+		network.Register(svc.GetFullAddress(), svc.GetHandlers())
+	}
+
+	if instances := node1.handlers.ServiceInstances("web"); len(instances) != 0 {
+		t.Errorf("Expected web to be left out until its check passes, got %+v", instances)
+	}
+	if err := node1.Start(); err != nil {
+		t.Fatalf("Failed to start service: %v", err)
+	}
+	defer node1.Stop()
+
+	testutil.WaitForCondition(t, func() bool {
+		return len(node1.handlers.ServiceInstances("web")) == 1
+	}, time.Second, "web check to pass")
+
+	if err := node2.sendJoinRequest(node1.GetFullAddress(), node2.local.Peer()); err != nil {
+		t.Fatalf("Expected join to succeed, got error: %v", err)
+	}
+	if instances := node2.handlers.ServiceInstances("web"); len(instances) != 1 {
+		t.Errorf("Expected node2 to see the healthy instance, got %+v", instances)
+	}
+
+	// The failing check is gossiped and node2 stops returning the instance
+	healthy.Store(false)
+	testutil.WaitForCondition(t, func() bool {
+		return len(node1.handlers.ServiceInstances("web")) == 0
+	}, time.Second, "web check to fail")
+	if err := node2.pushPull(node1.GetFullAddress()); err != nil {
+		t.Fatalf("Expected push-pull to succeed, got error: %v", err)
+	}
+	if instances := node2.handlers.ServiceInstances("web"); len(instances) != 0 {
+		t.Errorf("Expected node2 to leave out the failing instance, got %+v", instances)
+	}
+}
+
+func TestService_SeparateClusters(t *testing.T) {
+	network := transport.NewNetwork()
+	newNode := func(id, cluster string) *Service {
+		cfg := testutil.CreateTestConfig(t, id)
+		cfg.ClusterName = cluster
+		svc := NewService(cfg)
+		svc.SetTransport(network.Transport())
+		network.Register(svc.GetFullAddress(), svc.GetHandlers())
+		return svc
+	}
+	staging1 := newNode("staging1", "staging")
+	staging2 := newNode("staging2", "staging")
+	dev := newNode("dev1", "dev")
+
+	if err := staging2.sendJoinRequest(staging1.GetFullAddress(), staging2.local.Peer()); err != nil {
+		t.Fatalf("Expected join within the cluster to succeed, got error: %v", err)
+	}
+	if err := dev.sendJoinRequest(staging1.GetFullAddress(), dev.local.Peer()); err == nil {
+		t.Error("Expected join to another cluster to fail")
+	}
+	if _, err := dev.transport.Ping(staging1.GetFullAddress(), dev.local.Peer()); err == nil {
+		t.Error("Expected a probe from another cluster to be rejected")
+	}
+	if err := dev.pushPull(staging1.GetFullAddress()); err == nil {
+		t.Error("Expected push-pull with another cluster to fail")
+	}
+
+	if staging1.peerList.Exists("dev1") || dev.peerList.Count() != 0 {
+		t.Error("Expected the clusters not to merge")
+	}
+	if err := staging1.pushPull(staging2.GetFullAddress()); err != nil {
+		t.Errorf("Expected push-pull within the cluster to succeed, got error: %v", err)
+	}
+}
+
+// newTLSTestService creates a service with a certificate from ca and serves
+// its handlers over HTTPS on the configured port
+func newTLSTestService(t *testing.T, ca *testutil.TestCA, id string) *Service {
+	t.Helper()
+	cfg := testutil.CreateTestConfig(t, id)
+	cfg.TLSCertFile, cfg.TLSKeyFile = ca.IssueCert(t, id)
+	cfg.TLSCAFile = ca.CertFile
+	svc := NewService(cfg)
+	if err := svc.certs.Reload(); err != nil {
+		t.Fatalf("Failed to load certificates: %v", err)
+	}
+
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := &http.Server{Handler: svc.GetHandlers().SetupRoutes(), TLSConfig: svc.TLSConfig()}
+	go server.ServeTLS(ln, "", "")
+	t.Cleanup(func() { server.Close() })
+	return svc
+}
+
+func TestService_MutualTLS(t *testing.T) {
+	ca := testutil.CreateTestCA(t)
+	node1 := newTLSTestService(t, ca, "node1")
+	node2 := newTLSTestService(t, ca, "node2")
+
+	if !strings.HasPrefix(node1.GetFullAddress(), "https://") {
+		t.Errorf("Expected an https address with TLS, got %s", node1.GetFullAddress())
+	}
+
+	if err := node2.sendJoinRequest(node1.GetFullAddress(), node2.local.Peer()); err != nil {
+		t.Fatalf("Expected join over mutual TLS to succeed, got error: %v", err)
+	}
+	p, ok := node1.peerList.Get("node2")
+	if !ok || p.Address != node2.GetFullAddress() {
+		t.Errorf("Expected node1 to know node2 at its https address, got %+v", p)
+	}
+	if err := node1.ping(node2.GetFullAddress()); err != nil {
+		t.Errorf("Expected probe over mutual TLS to succeed, got error: %v", err)
+	}
+
+	// A node whose certificate comes from another CA is turned away
+	rogue := newTLSTestService(t, testutil.CreateTestCA(t), "rogue")
+	if err := rogue.sendJoinRequest(node1.GetFullAddress(), rogue.local.Peer()); err == nil {
+		t.Error("Expected join from a node with an untrusted certificate to fail")
+	}
+	if node1.peerList.Exists("rogue") {
+		t.Error("Expected the rogue node not to be added")
+	}
+}
+
+func TestService_StartFailsWithoutCertificates(t *testing.T) {
+	cfg := testutil.CreateTestConfig(t, "test-service")
+	cfg.TLSCertFile = "missing.crt"
+	cfg.TLSKeyFile = "missing.key"
+	cfg.TLSCAFile = "missing-ca.crt"
+	svc := NewService(cfg)
+
+	if err := svc.Start(); err == nil {
+		svc.Stop()
+		t.Error("Expected Start to fail when certificates can't be loaded")
+	}
+	if svc.TLSConfig() == nil {
+		t.Error("Expected a server TLS config when TLS is enabled")
+	}
+}
+
+// newEncryptedTestService creates a service with the given encryption key
+// and serves its handlers on the configured port
+func newEncryptedTestService(t *testing.T, id, key string) *Service {
+	t.Helper()
+	cfg := testutil.CreateTestConfig(t, id)
+	cfg.EncryptKey = key
+	svc := NewService(cfg)
+	if err := svc.keyring.Load(cfg.KeyringFile, cfg.EncryptKey); err != nil {
+		t.Fatalf("Failed to load keyring: %v", err)
+	}
+	svc.local.SetKeys(svc.keyring.Fingerprints())
+
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", cfg.Port))
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := &http.Server{Handler: svc.GetHandlers().SetupRoutes()}
+	go server.Serve(ln)
+	t.Cleanup(func() { server.Close() })
+	return svc
+}
+
+func TestService_EncryptedGossip(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	node1 := newEncryptedTestService(t, "node1", key)
+	node2 := newEncryptedTestService(t, "node2", key)
+
+	if err := node2.sendJoinRequest(node1.GetFullAddress(), node2.local.Peer()); err != nil {
+		t.Fatalf("Expected encrypted join to succeed, got error: %v", err)
+	}
+	if err := node1.pushPull(node2.GetFullAddress()); err != nil {
+		t.Errorf("Expected encrypted push-pull to succeed, got error: %v", err)
+	}
+	if !node1.peerList.Exists("node2") || !node2.peerList.Exists("node1") {
+		t.Error("Expected both nodes to know each other")
+	}
+
+	// A node with another key can't inject itself
+	rogueKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+	rogue := newEncryptedTestService(t, "rogue", rogueKey)
+	if err := rogue.sendJoinRequest(node1.GetFullAddress(), rogue.local.Peer()); err == nil {
+		t.Error("Expected join with another key to fail")
+	}
+	if node1.peerList.Exists("rogue") {
+		t.Error("Expected the rogue node not to be added")
+	}
+	if node1.keyring.Failures() != 1 {
+		t.Errorf("Expected 1 dropped message, got %d", node1.keyring.Failures())
+	}
+}
+
+func TestService_KeyringRotation(t *testing.T) {
+	oldKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	newKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{3}, 32))
+	node1 := newEncryptedTestService(t, "node1", oldKey)
+	node2 := newEncryptedTestService(t, "node2", oldKey)
+
+	if err := node2.sendJoinRequest(node1.GetFullAddress(), node2.local.Peer()); err != nil {
+		t.Fatalf("Expected join to succeed, got error: %v", err)
+	}
+
+	routes := node1.GetHandlers().SetupRoutes()
+	for _, op := range []string{"install", "use", "remove"} {
+		key := newKey
+		if op == "remove" {
+			key = oldKey
+		}
+		body := fmt.Sprintf(`{"key":%q}`, key)
+		req := httptest.NewRequest(http.MethodPost, "/v1/keyring/"+op, strings.NewReader(body))
+		req.RemoteAddr = "127.0.0.1:51234"
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, req)
+
+		var resp handlers.KeyringResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		if w.Code != http.StatusOK || resp.NumNodes != 2 || resp.NumErrors != 0 {
+			t.Fatalf("Expected %s to succeed on 2 nodes, got %d: %+v", op, w.Code, resp)
+		}
+	}
+
+	for _, svc := range []*Service{node1, node2} {
+		if keys := svc.keyring.Fingerprints(); len(keys) != 1 || keys[0] != node2.local.Peer().Keys[0] {
+			t.Errorf("Expected %s to only hold the new key, got %v", svc.config.ID, keys)
+		}
+	}
+
+	// Traffic keeps flowing with the new key, and node1 learns node2's keys
+	if err := node1.pushPull(node2.GetFullAddress()); err != nil {
+		t.Fatalf("Expected push-pull after rotation to succeed, got error: %v", err)
+	}
+	status := node1.GetHandlers().KeyringStatus()
+	if status.NumNodes != 2 || len(status.Keys) != 1 || len(status.Keys[0].PrimaryOn) != 2 {
+		t.Errorf("Expected the new key to be primary on both nodes, got %+v", status)
+	}
+}
+
+func TestService_StartFailsWithoutKeys(t *testing.T) {
+	cfg := testutil.CreateTestConfig(t, "test-service")
+	cfg.KeyringFile = "missing-keyring.json"
+	svc := NewService(cfg)
+
+	if err := svc.Start(); err == nil {
+		svc.Stop()
+		t.Error("Expected Start to fail when the keyring can't be loaded")
+	}
+}
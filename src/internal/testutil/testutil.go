@@ -36,17 +36,27 @@ func CreateTestConfig(t *testing.T, id string) *config.Config {
 	broadcastPort := GetFreePort(t)
 
 	return &config.Config{
-		ID:                id,
-		BindAddress:       "127.0.0.1",
-		AdvertiseAddr:     "127.0.0.1",
-		Port:              port,
-		BroadcastPort:     broadcastPort,
-		BroadcastInterval: 100 * time.Millisecond, // Fast for testing
-		HeartbeatInterval: 50 * time.Millisecond,  // Fast for testing
-		PeerTimeout:       200 * time.Millisecond, // Fast for testing
-		GossipInterval:    100 * time.Millisecond, // Fast for testing
-		LogLevel:          "error",                // Reduce log noise in tests
-		LogFormat:         "text",
+		ID:                   id,
+		BindAddress:          "127.0.0.1",
+		AdvertiseAddr:        "127.0.0.1",
+		Port:                 port,
+		BroadcastPort:        broadcastPort,
+		BroadcastInterval:    100 * time.Millisecond, // Fast for testing
+		HeartbeatInterval:    50 * time.Millisecond,  // Fast for testing
+		PeerTimeout:          200 * time.Millisecond, // Fast for testing
+		GossipInterval:       100 * time.Millisecond, // Fast for testing
+		ProbeTimeout:         30 * time.Millisecond,  // Fast for testing
+		LogLevel:             "error",                // Reduce log noise in tests
+		LogFormat:            "text",
+		IndirectChecks:       3,
+		GossipFanout:         3,
+		RetransmitMult:       4,
+		PushPullInterval:     200 * time.Millisecond, // Fast for testing
+		TombstoneRetention:   200 * time.Millisecond, // Fast for testing
+		DeadPeerRetention:    200 * time.Millisecond, // Fast for testing
+		KVTombstoneRetention: 200 * time.Millisecond, // Fast for testing
+		CheckInterval:        50 * time.Millisecond,  // Fast for testing
+		CheckTimeout:         100 * time.Millisecond, // Fast for testing
 	}
 }
 
@@ -56,6 +66,7 @@ func CreateTestPeer(id, address string) *peer.Peer {
 		ID:      id,
 		Address: address,
 		IsAlive: true,
+		State:   peer.StateAlive,
 	}
 }
 
@@ -0,0 +1,163 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rokzabukovec/clip/internal/testutil"
+)
+
+func newReloader(t *testing.T, ca *testutil.TestCA, name string) *Reloader {
+	t.Helper()
+	certFile, keyFile := ca.IssueCert(t, name)
+	r := NewReloader(certFile, keyFile, ca.CertFile)
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Expected Reload to succeed, got: %v", err)
+	}
+	return r
+}
+
+func newTLSServer(t *testing.T, r *Reloader) *httptest.Server {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = r.ServerConfig()
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func get(r *Reloader, url string) error {
+	client := &http.Client{
+		Timeout:   time.Second,
+		Transport: &http.Transport{DialTLSContext: r.DialTLSContext},
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func TestReloader_Reload(t *testing.T) {
+	ca := testutil.CreateTestCA(t)
+	certFile, keyFile := ca.IssueCert(t, "node1")
+
+	if err := NewReloader(certFile, keyFile, "missing.crt").Reload(); err == nil {
+		t.Error("Expected Reload to fail for a missing CA file")
+	}
+	if err := NewReloader(certFile, ca.CertFile, ca.CertFile).Reload(); err == nil {
+		t.Error("Expected Reload to fail for a mismatched key")
+	}
+	if err := NewReloader(certFile, keyFile, keyFile).Reload(); err == nil {
+		t.Error("Expected Reload to fail for a CA file without certificates")
+	}
+}
+
+func TestReloader_MutualTLS(t *testing.T) {
+	ca := testutil.CreateTestCA(t)
+	server := newTLSServer(t, newReloader(t, ca, "server"))
+
+	if err := get(newReloader(t, ca, "client"), server.URL); err != nil {
+		t.Errorf("Expected a peer with a certificate from the CA to connect, got: %v", err)
+	}
+
+	other := testutil.CreateTestCA(t)
+	if err := get(newReloader(t, other, "rogue"), server.URL); err == nil {
+		t.Error("Expected a peer with a certificate from another CA to be rejected")
+	}
+
+	// A client that trusts the server but presents no certificate
+	anonymous := &http.Client{
+		Timeout:   time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	if resp, err := anonymous.Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Error("Expected a client without a certificate to be rejected")
+	}
+}
+
+func TestReloader_VerifiesDialedHost(t *testing.T) {
+	ca := testutil.CreateTestCA(t)
+	client := newReloader(t, ca, "client")
+
+	// Peers are dialed by IP address, which is not sent as SNI, so the
+	// certificate has to be checked against the address itself
+	certFile, keyFile := ca.IssueCertFor(t, "other", "localhost", "10.0.0.5")
+	other := NewReloader(certFile, keyFile, ca.CertFile)
+	if err := other.Reload(); err != nil {
+		t.Fatalf("Expected Reload to succeed, got: %v", err)
+	}
+	server := newTLSServer(t, other)
+	if err := get(client, server.URL); err == nil {
+		t.Error("Expected a certificate without the dialed IP address to be rejected")
+	}
+
+	server = newTLSServer(t, newReloader(t, ca, "server"))
+	if err := get(client, server.URL); err != nil {
+		t.Errorf("Expected a certificate for the dialed IP address to be accepted, got: %v", err)
+	}
+
+	if err := client.ClientConfig("").VerifyConnection(tls.ConnectionState{}); err == nil {
+		t.Error("Expected verification without a server name to fail")
+	}
+}
+
+func TestReloader_NotLoaded(t *testing.T) {
+	ca := testutil.CreateTestCA(t)
+	certFile, keyFile := ca.IssueCert(t, "server")
+	server := newTLSServer(t, NewReloader(certFile, keyFile, ca.CertFile))
+
+	if err := get(newReloader(t, ca, "client"), server.URL); err == nil {
+		t.Error("Expected handshakes to fail before certificates are loaded")
+	}
+}
+
+func TestReloader_ReloadIfChanged(t *testing.T) {
+	ca := testutil.CreateTestCA(t)
+	serverReloader := newReloader(t, ca, "server")
+	server := newTLSServer(t, serverReloader)
+	client := newReloader(t, ca, "client")
+
+	if changed, err := serverReloader.ReloadIfChanged(); changed || err != nil {
+		t.Errorf("Expected no reload for unchanged files, got %v (%v)", changed, err)
+	}
+
+	// Rotate the server to a certificate and CA bundle the client doesn't trust
+	other := testutil.CreateTestCA(t)
+	certFile, keyFile := other.IssueCert(t, "server")
+	for _, pair := range [][2]string{
+		{certFile, serverReloader.certFile},
+		{keyFile, serverReloader.keyFile},
+		{other.CertFile, serverReloader.caFile},
+	} {
+		data, err := os.ReadFile(pair[0])
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", pair[0], err)
+		}
+		if err := os.WriteFile(pair[1], data, 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", pair[1], err)
+		}
+		future := time.Now().Add(time.Minute)
+		os.Chtimes(pair[1], future, future)
+	}
+
+	changed, err := serverReloader.ReloadIfChanged()
+	if !changed || err != nil {
+		t.Fatalf("Expected certificates to be reloaded, got %v (%v)", changed, err)
+	}
+
+	if err := get(client, server.URL); err == nil {
+		t.Error("Expected the old CA to be rejected after reload without restarting the server")
+	}
+	if err := get(newReloader(t, other, "client2"), server.URL); err != nil {
+		t.Errorf("Expected the new CA to be accepted after reload, got: %v", err)
+	}
+}
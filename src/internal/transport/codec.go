@@ -0,0 +1,284 @@
+package transport
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+// Datagram layout:
+//
+//	magic (1 byte) | kind (1 byte) | sequence number (4 bytes) | count (uvarint) | peers
+//
+// and each peer is encoded as
+//
+//	id | address | state (1 byte) | incarnation (uvarint) | version (uvarint) | metadata
+//
+// with strings prefixed by their uvarint length. Metadata is the JSON
+// encoding of the peer's remaining owner-set fields, or empty if it has none.
+// User event messages carry events instead of peers, each as the JSON
+// encoding of the event.
+const (
+	magic      byte = 0xc1
+	headerSize      = 6
+
+	// maxPacketSize keeps datagrams below a typical path MTU, leaving room
+	// for the encryption overhead
+	maxPacketSize = 1400
+)
+
+// Message kinds
+const (
+	kindPing byte = iota + 1
+	kindAck
+	kindBroadcast
+	kindUserEvents
+)
+
+// states maps peer states to their wire codes
+var states = []peer.State{peer.StateAlive, peer.StateSuspect, peer.StateDead, peer.StateLeft}
+
+var errTruncated = errors.New("truncated packet")
+
+// metadata holds the peer fields that are encoded as JSON rather than in
+// the fixed binary layout
+type metadata struct {
+	Cluster  string            `json:"cluster,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Services []peer.Service    `json:"services,omitempty"`
+	Keys     []string          `json:"keys,omitempty"`
+}
+
+func (m metadata) empty() bool {
+	return m.Cluster == "" && len(m.Tags) == 0 && len(m.Services) == 0 && len(m.Keys) == 0
+}
+
+// message is a decoded datagram
+type message struct {
+	kind   byte
+	seq    uint32
+	peers  []*peer.Peer
+	events []*peer.UserEvent
+}
+
+// encode serializes a message into a datagram
+func encode(m message) []byte {
+	buf := make([]byte, headerSize, maxPacketSize)
+	buf[0] = magic
+	buf[1] = m.kind
+	binary.BigEndian.PutUint32(buf[2:headerSize], m.seq)
+
+	if m.kind == kindUserEvents {
+		buf = binary.AppendUvarint(buf, uint64(len(m.events)))
+		for _, e := range m.events {
+			buf = appendUserEvent(buf, e)
+		}
+		return buf
+	}
+
+	buf = binary.AppendUvarint(buf, uint64(len(m.peers)))
+	for _, p := range m.peers {
+		buf = appendPeer(buf, p)
+	}
+	return buf
+}
+
+// decode parses a datagram
+func decode(buf []byte) (message, error) {
+	var m message
+	if len(buf) < headerSize {
+		return m, errTruncated
+	}
+	if buf[0] != magic {
+		return m, fmt.Errorf("unknown packet magic: %#x", buf[0])
+	}
+	m.kind = buf[1]
+	m.seq = binary.BigEndian.Uint32(buf[2:headerSize])
+
+	r := &reader{buf: buf[headerSize:]}
+	count := r.uvarint()
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		if m.kind == kindUserEvents {
+			m.events = append(m.events, r.userEvent())
+		} else {
+			m.peers = append(m.peers, r.peer())
+		}
+	}
+	if r.err != nil {
+		return message{}, r.err
+	}
+	return m, nil
+}
+
+// split groups rumors into broadcast messages that each fit in a datagram
+func split(rumors []*peer.Peer) []message {
+	messages := make([]message, 0, 1)
+	current := message{kind: kindBroadcast}
+	size := headerSize + binary.MaxVarintLen64
+
+	for _, p := range rumors {
+		n := len(appendPeer(nil, p))
+		if len(current.peers) > 0 && size+n > maxPacketSize {
+			messages = append(messages, current)
+			current = message{kind: kindBroadcast}
+			size = headerSize + binary.MaxVarintLen64
+		}
+		current.peers = append(current.peers, p)
+		size += n
+	}
+	if len(current.peers) > 0 {
+		messages = append(messages, current)
+	}
+	return messages
+}
+
+// splitUserEvents groups user events into messages that each fit in a
+// datagram
+func splitUserEvents(events []*peer.UserEvent) []message {
+	messages := make([]message, 0, 1)
+	current := message{kind: kindUserEvents}
+	size := headerSize + binary.MaxVarintLen64
+
+	for _, e := range events {
+		n := len(appendUserEvent(nil, e))
+		if len(current.events) > 0 && size+n > maxPacketSize {
+			messages = append(messages, current)
+			current = message{kind: kindUserEvents}
+			size = headerSize + binary.MaxVarintLen64
+		}
+		current.events = append(current.events, e)
+		size += n
+	}
+	if len(current.events) > 0 {
+		messages = append(messages, current)
+	}
+	return messages
+}
+
+func appendUserEvent(buf []byte, e *peer.UserEvent) []byte {
+	data, _ := json.Marshal(e)
+	return appendString(buf, string(data))
+}
+
+func appendPeer(buf []byte, p *peer.Peer) []byte {
+	buf = appendString(buf, p.ID)
+	buf = appendString(buf, p.Address)
+	buf = append(buf, stateCode(p.State))
+	buf = binary.AppendUvarint(buf, p.Incarnation)
+	buf = binary.AppendUvarint(buf, p.Version)
+
+	meta := metadata{Cluster: p.Cluster, Tags: p.Tags, Services: p.Services, Keys: p.Keys}
+	if meta.empty() {
+		return binary.AppendUvarint(buf, 0)
+	}
+	data, _ := json.Marshal(meta)
+	return appendString(buf, string(data))
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// stateCode returns the wire code of a state; an unset state is alive
+func stateCode(state peer.State) byte {
+	for i, s := range states {
+		if s == state {
+			return byte(i)
+		}
+	}
+	return 0
+}
+
+// reader decodes fields from a packet, remembering the first error
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = errTruncated
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *reader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.buf) < 1 {
+		r.err = errTruncated
+		return 0
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b
+}
+
+func (r *reader) string() string {
+	n := r.uvarint()
+	if r.err != nil {
+		return ""
+	}
+	if uint64(len(r.buf)) < n {
+		r.err = errTruncated
+		return ""
+	}
+	s := string(r.buf[:n])
+	r.buf = r.buf[n:]
+	return s
+}
+
+func (r *reader) peer() *peer.Peer {
+	p := &peer.Peer{
+		ID:      r.string(),
+		Address: r.string(),
+	}
+	code := r.byte()
+	p.Incarnation = r.uvarint()
+	p.Version = r.uvarint()
+
+	if data := r.string(); data != "" && r.err == nil {
+		var meta metadata
+		if err := json.Unmarshal([]byte(data), &meta); err != nil {
+			r.err = fmt.Errorf("invalid peer metadata: %w", err)
+		}
+		p.Cluster = meta.Cluster
+		p.Tags = meta.Tags
+		p.Services = meta.Services
+		p.Keys = meta.Keys
+	}
+
+	if r.err == nil && int(code) >= len(states) {
+		r.err = fmt.Errorf("unknown peer state: %d", code)
+	}
+	if r.err != nil {
+		return nil
+	}
+	p.State = states[code]
+	p.IsAlive = p.State == peer.StateAlive || p.State == peer.StateSuspect
+	return p
+}
+
+func (r *reader) userEvent() *peer.UserEvent {
+	data := r.string()
+	if r.err != nil {
+		return nil
+	}
+	var e peer.UserEvent
+	if err := json.Unmarshal([]byte(data), &e); err != nil {
+		r.err = fmt.Errorf("invalid user event: %w", err)
+		return nil
+	}
+	return &e
+}
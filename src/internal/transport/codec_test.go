@@ -0,0 +1,177 @@
+package transport
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	peers := []*peer.Peer{
+		{ID: "peer1", Address: "http://127.0.0.1:8081", State: peer.StateAlive, Version: 1},
+		{ID: "peer2", Address: "http://127.0.0.1:8082", State: peer.StateSuspect, Incarnation: 3, Version: 7},
+		{ID: "peer3", Address: "http://127.0.0.1:8083", State: peer.StateLeft, Incarnation: 1, Version: 300},
+		{ID: "peer4", Address: "http://127.0.0.1:8084", State: peer.StateAlive, Version: 2, Keys: []string{"aaaa", "bbbb"}},
+		{ID: "peer5", Address: "http://127.0.0.1:8085", State: peer.StateAlive, Version: 1, Cluster: "staging", Tags: map[string]string{"role": "worker"},
+			Services: []peer.Service{{ID: "web", Name: "web", Port: 80, Tags: []string{"v1"}}}},
+	}
+
+	m, err := decode(encode(message{kind: kindBroadcast, seq: 42, peers: peers}))
+	if err != nil {
+		t.Fatalf("Expected decode to succeed, got: %v", err)
+	}
+
+	if m.kind != kindBroadcast || m.seq != 42 {
+		t.Errorf("Expected broadcast with seq 42, got kind %d seq %d", m.kind, m.seq)
+	}
+	if len(m.peers) != len(peers) {
+		t.Fatalf("Expected %d peers, got %d", len(peers), len(m.peers))
+	}
+	for i, p := range m.peers {
+		want := peers[i]
+		if p.ID != want.ID || p.Address != want.Address || p.State != want.State ||
+			p.Incarnation != want.Incarnation || p.Version != want.Version || len(p.Keys) != len(want.Keys) ||
+			p.Cluster != want.Cluster || len(p.Tags) != len(want.Tags) || len(p.Services) != len(want.Services) {
+			t.Errorf("Expected %+v, got %+v", want, p)
+		}
+	}
+	if !m.peers[1].IsAlive || m.peers[2].IsAlive {
+		t.Error("Expected IsAlive to follow the decoded state")
+	}
+}
+
+func TestEncodeDecode_Empty(t *testing.T) {
+	packet := encode(message{kind: kindAck, seq: 7})
+	if len(packet) != headerSize+1 {
+		t.Errorf("Expected empty ack to be %d bytes, got %d", headerSize+1, len(packet))
+	}
+
+	m, err := decode(packet)
+	if err != nil {
+		t.Fatalf("Expected decode to succeed, got: %v", err)
+	}
+	if m.kind != kindAck || m.seq != 7 || len(m.peers) != 0 {
+		t.Errorf("Unexpected message: %+v", m)
+	}
+}
+
+func TestDecode_Invalid(t *testing.T) {
+	valid := encode(message{kind: kindPing, seq: 1, peers: []*peer.Peer{
+		{ID: "peer1", Address: "http://127.0.0.1:8081", State: peer.StateAlive},
+	}})
+
+	badState := encode(message{kind: kindPing, seq: 1, peers: []*peer.Peer{{ID: "p"}}})
+	badState[len(badState)-4] = 9
+
+	tests := []struct {
+		name   string
+		packet []byte
+	}{
+		{"too short", []byte{magic, kindPing}},
+		{"wrong magic", append([]byte{0x00}, valid[1:]...)},
+		{"truncated peer", valid[:len(valid)-4]},
+		{"unknown state", badState},
+		{"invalid metadata", append(valid[:len(valid)-1], 2, '{', 'x')},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decode(tt.packet); err == nil {
+				t.Error("Expected decode to fail")
+			}
+		})
+	}
+}
+
+func TestEncodeDecode_UserEvents(t *testing.T) {
+	events := []*peer.UserEvent{
+		{ID: "a1", Name: "deploy", Payload: []byte("v1.4.2"), LTime: 12, Origin: "node1", Cluster: "staging"},
+		{ID: "b2", Name: "flush-cache", LTime: 13, Origin: "node2"},
+	}
+
+	m, err := decode(encode(message{kind: kindUserEvents, events: events}))
+	if err != nil {
+		t.Fatalf("Expected decode to succeed, got: %v", err)
+	}
+	if m.kind != kindUserEvents || len(m.events) != 2 || len(m.peers) != 0 {
+		t.Fatalf("Expected 2 user events, got %+v", m)
+	}
+	for i, e := range m.events {
+		want := events[i]
+		if e.ID != want.ID || e.Name != want.Name || string(e.Payload) != string(want.Payload) ||
+			e.LTime != want.LTime || e.Origin != want.Origin || e.Cluster != want.Cluster {
+			t.Errorf("Expected %+v, got %+v", want, e)
+		}
+	}
+
+	invalid := encode(message{kind: kindUserEvents})
+	invalid = append(invalid[:len(invalid)-1], 1, 2, '{', 'x')
+	if _, err := decode(invalid); err == nil {
+		t.Error("Expected an invalid user event to fail to decode")
+	}
+}
+
+func TestSplit(t *testing.T) {
+	rumors := make([]*peer.Peer, 100)
+	for i := range rumors {
+		rumors[i] = &peer.Peer{
+			ID:      fmt.Sprintf("peer-with-a-long-name-%d", i),
+			Address: fmt.Sprintf("http://192.168.100.%d:8080", i),
+			State:   peer.StateAlive,
+		}
+	}
+
+	messages := split(rumors)
+	if len(messages) < 2 {
+		t.Fatalf("Expected rumors to be split over several datagrams, got %d", len(messages))
+	}
+
+	total := 0
+	for _, m := range messages {
+		if size := len(encode(m)); size > maxPacketSize {
+			t.Errorf("Expected datagram to fit in %d bytes, got %d", maxPacketSize, size)
+		}
+		total += len(m.peers)
+	}
+	if total != len(rumors) {
+		t.Errorf("Expected %d rumors in total, got %d", len(rumors), total)
+	}
+
+	if len(split(nil)) != 0 {
+		t.Error("Expected no datagrams for no rumors")
+	}
+}
+
+func TestEncode_MaxMetadata(t *testing.T) {
+	tags := make(map[string]string)
+	for i := 0; len(tags) < 1000; i++ {
+		tags[fmt.Sprintf("k%d", i)] = ""
+		if peer.ValidateTags(tags) != nil {
+			delete(tags, fmt.Sprintf("k%d", i))
+			break
+		}
+	}
+
+	services := make([]peer.Service, 0)
+	for i := 0; len(services) < 1000; i++ {
+		services = append(services, peer.Service{ID: fmt.Sprintf("s%d", i), Name: "s", Port: 65535})
+		if peer.ValidateServicesSize(services) != nil {
+			services = services[:len(services)-1]
+			break
+		}
+	}
+
+	p := &peer.Peer{
+		ID:       "peer-with-a-long-name",
+		Address:  "https://192.168.100.100:8080",
+		State:    peer.StateAlive,
+		Cluster:  "staging",
+		Tags:     tags,
+		Services: services,
+		Keys:     []string{"0011223344556677", "8899aabbccddeeff"},
+	}
+	if size := len(encode(message{kind: kindBroadcast, peers: []*peer.Peer{p}})); size > maxPacketSize {
+		t.Errorf("Expected a record with the largest allowed tags and services to fit in %d bytes, got %d", maxPacketSize, size)
+	}
+}
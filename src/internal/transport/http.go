@@ -0,0 +1,191 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/rokzabukovec/clip/internal/keyring"
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+// EncryptedContentType marks request and response bodies that are encrypted
+// with the cluster keyring
+const EncryptedContentType = "application/vnd.clip.encrypted"
+
+// HTTPTransport sends messages as JSON to the peers' HTTP endpoints
+type HTTPTransport struct {
+	client      *http.Client // probes, rumors and leaves
+	relayClient *http.Client // indirect probes, which wait for a probe of their own
+	syncClient  *http.Client // joins and push-pull
+	keyring     *keyring.Keyring
+}
+
+// NewHTTPTransport creates an HTTP transport. Probes, rumors and leaves time
+// out after timeout, joins and push-pull exchanges after syncTimeout. With a
+// non-nil dialTLS, peers are reached over HTTPS through connections it
+// dials and verifies.
+func NewHTTPTransport(timeout, syncTimeout time.Duration, dialTLS func(ctx context.Context, network, addr string) (net.Conn, error)) *HTTPTransport {
+	roundTripper := http.DefaultTransport
+	if dialTLS != nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.DialTLSContext = dialTLS
+		roundTripper = t
+	}
+
+	return &HTTPTransport{
+		client:      &http.Client{Timeout: timeout, Transport: roundTripper},
+		relayClient: &http.Client{Timeout: 2 * timeout, Transport: roundTripper},
+		syncClient:  &http.Client{Timeout: syncTimeout, Transport: roundTripper},
+	}
+}
+
+// SetKeyring encrypts request bodies with the cluster keyring and decrypts
+// responses with it
+func (t *HTTPTransport) SetKeyring(k *keyring.Keyring) {
+	t.keyring = k
+}
+
+// Join posts this node's record to the peer's /join endpoint
+func (t *HTTPTransport) Join(address string, self *peer.Peer) ([]*peer.Peer, error) {
+	var peers []*peer.Peer
+	if err := t.call(t.syncClient, address+"/join", self, &peers); err != nil {
+		return nil, fmt.Errorf("join request failed: %w", err)
+	}
+	return peers, nil
+}
+
+// Ping posts this node's record to the peer's /heartbeat endpoint
+func (t *HTTPTransport) Ping(address string, self *peer.Peer) (*peer.Peer, error) {
+	resp, err := t.post(t.client, address+"/heartbeat", self)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("probe failed with status: %d", resp.StatusCode)
+	}
+
+	body, err := t.readBody(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var view *peer.Peer
+	if err := json.Unmarshal(body, &view); err != nil {
+		return nil, fmt.Errorf("invalid probe ack: %w", err)
+	}
+	return view, nil
+}
+
+// PingReq posts an indirect probe request to the peer's /ping-req endpoint
+func (t *HTTPTransport) PingReq(address string, req PingRequest) error {
+	return t.call(t.relayClient, address+"/ping-req", req, nil)
+}
+
+// Leave posts this node's left record to the peer's /leave endpoint
+func (t *HTTPTransport) Leave(address string, self *peer.Peer) error {
+	return t.call(t.client, address+"/leave", self, nil)
+}
+
+// Broadcast posts rumors to the peer's /broadcast endpoint
+func (t *HTTPTransport) Broadcast(address string, rumors []*peer.Peer) error {
+	return t.call(t.client, address+"/broadcast", rumors, nil)
+}
+
+// UserEvents posts user events to the peer's /user-events endpoint
+func (t *HTTPTransport) UserEvents(address string, events []*peer.UserEvent) error {
+	return t.call(t.client, address+"/user-events", events, nil)
+}
+
+// PushPull posts a digest to the peer's /gossip endpoint
+func (t *HTTPTransport) PushPull(address string, req GossipRequest) (GossipResponse, error) {
+	var diff GossipResponse
+	if err := t.call(t.syncClient, address+"/gossip", req, &diff); err != nil {
+		return GossipResponse{}, fmt.Errorf("gossip failed: %w", err)
+	}
+	return diff, nil
+}
+
+// Push posts the records a peer asked for to its /broadcast endpoint
+func (t *HTTPTransport) Push(address string, peers []*peer.Peer) error {
+	return t.call(t.syncClient, address+"/broadcast", peers, nil)
+}
+
+// KVUpdate posts key/value writes to the peer's /kv-updates endpoint
+func (t *HTTPTransport) KVUpdate(address string, update KVUpdate) error {
+	return t.call(t.client, address+"/kv-updates", update, nil)
+}
+
+// KeyringOp posts a keyring change to the peer's /keyring endpoint
+func (t *HTTPTransport) KeyringOp(address string, req KeyringRequest) error {
+	return t.call(t.client, address+"/keyring", req, nil)
+}
+
+// Query posts a query to the peer's /query endpoint and waits for the
+// answer for the query's timeout
+func (t *HTTPTransport) Query(address string, req QueryRequest) (QueryResponse, error) {
+	client := &http.Client{Timeout: req.Timeout, Transport: t.syncClient.Transport}
+	var resp QueryResponse
+	if err := t.call(client, address+"/query", req, &resp); err != nil {
+		return QueryResponse{}, fmt.Errorf("query failed: %w", err)
+	}
+	return resp, nil
+}
+
+// call posts body as JSON and decodes the response into out, if given
+func (t *HTTPTransport) call(client *http.Client, url string, body, out interface{}) error {
+	resp, err := t.post(client, url, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+
+	data, err := t.readBody(resp)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// post sends body as JSON, encrypted if a keyring is set
+func (t *HTTPTransport) post(client *http.Client, url string, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := "application/json"
+	if t.keyring != nil {
+		if data, err = t.keyring.Encrypt(data); err != nil {
+			return nil, err
+		}
+		contentType = EncryptedContentType
+	}
+	return client.Post(url, contentType, bytes.NewBuffer(data))
+}
+
+// readBody reads a response body, decrypting it if a keyring is set
+func (t *HTTPTransport) readBody(resp *http.Response) ([]byte, error) {
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if t.keyring == nil {
+		return data, nil
+	}
+	return t.keyring.Decrypt(data)
+}
@@ -0,0 +1,330 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rokzabukovec/clip/internal/keyring"
+	"github.com/rokzabukovec/clip/internal/kv"
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+func TestHTTPTransport_Ping(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/heartbeat" {
+			t.Errorf("Expected /heartbeat, got %s", r.URL.Path)
+		}
+		var from peer.Peer
+		json.NewDecoder(r.Body).Decode(&from)
+		from.State = peer.StateSuspect
+		json.NewEncoder(w).Encode(from)
+	}))
+	defer server.Close()
+
+	tr := NewHTTPTransport(time.Second, time.Second, nil)
+	view, err := tr.Ping(server.URL, &peer.Peer{ID: "local", State: peer.StateAlive})
+	if err != nil {
+		t.Fatalf("Expected ping to succeed, got: %v", err)
+	}
+	if view == nil || view.ID != "local" || view.State != peer.StateSuspect {
+		t.Errorf("Expected the remote view of us, got %+v", view)
+	}
+}
+
+func TestHTTPTransport_PingFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	tr := NewHTTPTransport(time.Second, time.Second, nil)
+	if _, err := tr.Ping(server.URL, &peer.Peer{ID: "local"}); err == nil {
+		t.Error("Expected ping to fail on a non-OK status")
+	}
+}
+
+func TestHTTPTransport_PingInvalidAck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("not json"))
+	}))
+	defer server.Close()
+
+	tr := NewHTTPTransport(time.Second, time.Second, nil)
+	if _, err := tr.Ping(server.URL, &peer.Peer{ID: "local"}); err == nil {
+		t.Error("Expected ping to fail on an ack that doesn't decode")
+	}
+}
+
+func TestHTTPTransport_Broadcast(t *testing.T) {
+	var received []*peer.Peer
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/broadcast" {
+			t.Errorf("Expected /broadcast, got %s", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	tr := NewHTTPTransport(time.Second, time.Second, nil)
+	rumors := []*peer.Peer{{ID: "peer1", State: peer.StateDead}}
+	if err := tr.Broadcast(server.URL, rumors); err != nil {
+		t.Fatalf("Expected broadcast to succeed, got: %v", err)
+	}
+	if len(received) != 1 || received[0].ID != "peer1" {
+		t.Errorf("Expected the rumor to arrive, got %+v", received)
+	}
+}
+
+func TestHTTPTransport_UserEvents(t *testing.T) {
+	var received []*peer.UserEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/user-events" {
+			t.Errorf("Expected /user-events, got %s", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	tr := NewHTTPTransport(time.Second, time.Second, nil)
+	events := []*peer.UserEvent{{ID: "a1", Name: "deploy", Payload: []byte("v1.4.2"), LTime: 3}}
+	if err := tr.UserEvents(server.URL, events); err != nil {
+		t.Fatalf("Expected user events to be sent, got: %v", err)
+	}
+	if len(received) != 1 || received[0].Name != "deploy" || string(received[0].Payload) != "v1.4.2" {
+		t.Errorf("Expected the user event to arrive, got %+v", received)
+	}
+}
+
+func TestHTTPTransport_Join(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/join" {
+			t.Errorf("Expected /join, got %s", r.URL.Path)
+		}
+		json.NewEncoder(w).Encode([]*peer.Peer{{ID: "seed", State: peer.StateAlive}})
+	}))
+	defer server.Close()
+
+	tr := NewHTTPTransport(time.Second, time.Second, nil)
+	peers, err := tr.Join(server.URL, &peer.Peer{ID: "local"})
+	if err != nil {
+		t.Fatalf("Expected join to succeed, got: %v", err)
+	}
+	if len(peers) != 1 || peers[0].ID != "seed" {
+		t.Errorf("Expected the seed's state, got %+v", peers)
+	}
+}
+
+func TestHTTPTransport_PingReq(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req PingRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.URL.Path != "/ping-req" || req.TargetID != "target" {
+			t.Errorf("Unexpected request to %s: %+v", r.URL.Path, req)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	tr := NewHTTPTransport(time.Second, time.Second, nil)
+	req := PingRequest{TargetID: "target", TargetAddress: "http://127.0.0.1:1"}
+	if err := tr.PingReq(server.URL, req); err != nil {
+		t.Errorf("Expected indirect probe to succeed, got: %v", err)
+	}
+
+	status = http.StatusGatewayTimeout
+	if err := tr.PingReq(server.URL, req); err == nil {
+		t.Error("Expected indirect probe to fail when the target did not respond")
+	}
+}
+
+func TestHTTPTransport_PushPull(t *testing.T) {
+	var pushed []*peer.Peer
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gossip":
+			var req GossipRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if len(req.Digest) != 1 {
+				t.Errorf("Expected a digest with 1 entry, got %+v", req.Digest)
+			}
+			json.NewEncoder(w).Encode(GossipResponse{
+				Peers: []*peer.Peer{{ID: "peer2", State: peer.StateAlive}},
+				Want:  []string{"peer1"},
+			})
+		case "/broadcast":
+			json.NewDecoder(r.Body).Decode(&pushed)
+		}
+	}))
+	defer server.Close()
+
+	tr := NewHTTPTransport(time.Second, time.Second, nil)
+	diff, err := tr.PushPull(server.URL, GossipRequest{Digest: []peer.Digest{{ID: "peer1"}}})
+	if err != nil {
+		t.Fatalf("Expected push-pull to succeed, got: %v", err)
+	}
+	if len(diff.Peers) != 1 || len(diff.Want) != 1 {
+		t.Errorf("Unexpected diff: %+v", diff)
+	}
+
+	if err := tr.Push(server.URL, []*peer.Peer{{ID: "peer1"}}); err != nil {
+		t.Fatalf("Expected push to succeed, got: %v", err)
+	}
+	if len(pushed) != 1 || pushed[0].ID != "peer1" {
+		t.Errorf("Expected the wanted record to be pushed, got %+v", pushed)
+	}
+}
+
+func TestHTTPTransport_KVUpdate(t *testing.T) {
+	var update KVUpdate
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/kv-updates" {
+			t.Errorf("Expected /kv-updates, got %s", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&update)
+	}))
+	defer server.Close()
+
+	tr := NewHTTPTransport(time.Second, time.Second, nil)
+	entries := []*kv.Entry{{Key: "flag", Value: []byte("on"), LTime: 3, Node: "node1"}}
+	if err := tr.KVUpdate(server.URL, KVUpdate{Cluster: "staging", Entries: entries}); err != nil {
+		t.Fatalf("Expected the update to succeed, got: %v", err)
+	}
+	if update.Cluster != "staging" || len(update.Entries) != 1 || string(update.Entries[0].Value) != "on" {
+		t.Errorf("Unexpected update: %+v", update)
+	}
+}
+
+func TestHTTPTransport_KeyringOp(t *testing.T) {
+	var req KeyringRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/keyring" {
+			t.Errorf("Expected /keyring, got %s", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Op == KeyringRemove {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	tr := NewHTTPTransport(time.Second, time.Second, nil)
+	if err := tr.KeyringOp(server.URL, KeyringRequest{Op: KeyringUse, Key: "k"}); err != nil {
+		t.Fatalf("Expected keyring change to succeed, got: %v", err)
+	}
+	if req.Op != KeyringUse || req.Key != "k" {
+		t.Errorf("Unexpected request: %+v", req)
+	}
+	if err := tr.KeyringOp(server.URL, KeyringRequest{Op: KeyringRemove, Key: "k"}); err == nil {
+		t.Error("Expected a rejected keyring change to fail")
+	}
+}
+
+func TestHTTPTransport_Query(t *testing.T) {
+	var req QueryRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/query" {
+			t.Errorf("Expected /query, got %s", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Name == "slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		json.NewEncoder(w).Encode(QueryResponse{Node: "peer1", Payload: []byte("up 3 days")})
+	}))
+	defer server.Close()
+
+	tr := NewHTTPTransport(time.Second, time.Second, nil)
+	resp, err := tr.Query(server.URL, QueryRequest{Name: "uptime", Origin: "node1", Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("Expected query to succeed, got: %v", err)
+	}
+	if req.Name != "uptime" || req.Timeout != time.Second {
+		t.Errorf("Unexpected request: %+v", req)
+	}
+	if resp.Node != "peer1" || string(resp.Payload) != "up 3 days" {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+
+	// The query's timeout applies rather than the transport's
+	if _, err := tr.Query(server.URL, QueryRequest{Name: "slow", Timeout: 50 * time.Millisecond}); err == nil {
+		t.Error("Expected a query answered after its timeout to fail")
+	}
+}
+
+func TestHTTPTransport_Leave(t *testing.T) {
+	var left peer.Peer
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/leave" {
+			t.Errorf("Expected /leave, got %s", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&left)
+	}))
+	defer server.Close()
+
+	tr := NewHTTPTransport(time.Second, time.Second, nil)
+	if err := tr.Leave(server.URL, &peer.Peer{ID: "local", State: peer.StateLeft}); err != nil {
+		t.Fatalf("Expected leave to succeed, got: %v", err)
+	}
+	if left.ID != "local" || left.State != peer.StateLeft {
+		t.Errorf("Expected our left record, got %+v", left)
+	}
+}
+
+func TestHTTPTransport_TLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&peer.Peer{ID: "local"})
+	}))
+	defer server.Close()
+
+	dialer := &tls.Dialer{Config: server.Client().Transport.(*http.Transport).TLSClientConfig}
+	tr := NewHTTPTransport(time.Second, time.Second, dialer.DialContext)
+	if _, err := tr.Ping(server.URL, &peer.Peer{ID: "local"}); err != nil {
+		t.Errorf("Expected ping over TLS to succeed, got: %v", err)
+	}
+
+	plain := NewHTTPTransport(time.Second, time.Second, nil)
+	if _, err := plain.Ping(server.URL, &peer.Peer{ID: "local"}); err == nil {
+		t.Error("Expected ping without the server's CA to fail")
+	}
+}
+
+func TestHTTPTransport_Encrypted(t *testing.T) {
+	k, _ := keyring.New(bytes.Repeat([]byte{1}, 32))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != EncryptedContentType {
+			t.Errorf("Expected encrypted content type, got %q", r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		data, err := k.Decrypt(body)
+		if err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		out, _ := k.Encrypt(data)
+		w.Write(out)
+	}))
+	defer server.Close()
+
+	tr := NewHTTPTransport(time.Second, time.Second, nil)
+	tr.SetKeyring(k)
+	view, err := tr.Ping(server.URL, &peer.Peer{ID: "local"})
+	if err != nil {
+		t.Fatalf("Expected encrypted ping to succeed, got: %v", err)
+	}
+	if view == nil || view.ID != "local" {
+		t.Errorf("Expected the echoed record, got %+v", view)
+	}
+
+	other, _ := keyring.New(bytes.Repeat([]byte{2}, 32))
+	tr.SetKeyring(other)
+	if _, err := tr.Ping(server.URL, &peer.Peer{ID: "local"}); err == nil {
+		t.Error("Expected ping with another key to fail")
+	}
+}
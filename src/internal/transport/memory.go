@@ -0,0 +1,186 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+// Network connects in-memory transports. Nodes register the address they
+// advertise, and messages to that address are delivered to their receiver
+// synchronously, which makes multi-node tests deterministic.
+type Network struct {
+	mu    sync.RWMutex
+	nodes map[string]Receiver
+}
+
+// NewNetwork creates an empty in-memory network
+func NewNetwork() *Network {
+	return &Network{
+		nodes: make(map[string]Receiver),
+	}
+}
+
+// Register delivers messages sent to address to receiver
+func (n *Network) Register(address string, receiver Receiver) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.nodes[address] = receiver
+}
+
+// Unregister makes address unreachable, as if the node had crashed
+func (n *Network) Unregister(address string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.nodes, address)
+}
+
+// Transport returns a transport that sends messages over this network
+func (n *Network) Transport() *MemoryTransport {
+	return &MemoryTransport{network: n}
+}
+
+func (n *Network) lookup(address string) (Receiver, error) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	receiver, ok := n.nodes[address]
+	if !ok {
+		return nil, fmt.Errorf("no route to %s", address)
+	}
+	return receiver, nil
+}
+
+// MemoryTransport delivers messages to receivers registered on a Network.
+// Messages are copied through their JSON encoding, so receivers see exactly
+// what they would over HTTP and never share memory with the sender.
+type MemoryTransport struct {
+	network *Network
+}
+
+// Join delivers a join request
+func (t *MemoryTransport) Join(address string, self *peer.Peer) ([]*peer.Peer, error) {
+	receiver, err := t.network.lookup(address)
+	if err != nil {
+		return nil, err
+	}
+	peers, err := receiver.Join(roundTrip(self))
+	if err != nil {
+		return nil, err
+	}
+	return roundTrip(peers), nil
+}
+
+// Ping delivers a probe and returns the ack's view of the sender
+func (t *MemoryTransport) Ping(address string, self *peer.Peer) (*peer.Peer, error) {
+	receiver, err := t.network.lookup(address)
+	if err != nil {
+		return nil, err
+	}
+	view, err := receiver.Heartbeat(roundTrip(self))
+	if err != nil {
+		return nil, err
+	}
+	return roundTrip(view), nil
+}
+
+// PingReq delivers an indirect probe request
+func (t *MemoryTransport) PingReq(address string, req PingRequest) error {
+	receiver, err := t.network.lookup(address)
+	if err != nil {
+		return err
+	}
+	return receiver.PingReq(req)
+}
+
+// Leave delivers a leave announcement
+func (t *MemoryTransport) Leave(address string, self *peer.Peer) error {
+	receiver, err := t.network.lookup(address)
+	if err != nil {
+		return err
+	}
+	receiver.Leave(roundTrip(self))
+	return nil
+}
+
+// Broadcast delivers rumors
+func (t *MemoryTransport) Broadcast(address string, rumors []*peer.Peer) error {
+	receiver, err := t.network.lookup(address)
+	if err != nil {
+		return err
+	}
+	receiver.MergePeers(roundTrip(rumors))
+	return nil
+}
+
+// UserEvents delivers user events
+func (t *MemoryTransport) UserEvents(address string, events []*peer.UserEvent) error {
+	receiver, err := t.network.lookup(address)
+	if err != nil {
+		return err
+	}
+	receiver.MergeUserEvents(roundTrip(events))
+	return nil
+}
+
+// PushPull delivers a digest and returns the records that differ
+func (t *MemoryTransport) PushPull(address string, req GossipRequest) (GossipResponse, error) {
+	receiver, err := t.network.lookup(address)
+	if err != nil {
+		return GossipResponse{}, err
+	}
+	resp, err := receiver.PushPull(roundTrip(req))
+	if err != nil {
+		return GossipResponse{}, err
+	}
+	return roundTrip(resp), nil
+}
+
+// Push delivers the records a peer asked for during push-pull
+func (t *MemoryTransport) Push(address string, peers []*peer.Peer) error {
+	return t.Broadcast(address, peers)
+}
+
+// KVUpdate delivers key/value writes
+func (t *MemoryTransport) KVUpdate(address string, update KVUpdate) error {
+	receiver, err := t.network.lookup(address)
+	if err != nil {
+		return err
+	}
+	return receiver.MergeKV(roundTrip(update))
+}
+
+// KeyringOp delivers a keyring change
+func (t *MemoryTransport) KeyringOp(address string, req KeyringRequest) error {
+	receiver, err := t.network.lookup(address)
+	if err != nil {
+		return err
+	}
+	return receiver.KeyringOp(req)
+}
+
+// Query delivers a query and returns the answer
+func (t *MemoryTransport) Query(address string, req QueryRequest) (QueryResponse, error) {
+	receiver, err := t.network.lookup(address)
+	if err != nil {
+		return QueryResponse{}, err
+	}
+	resp, err := receiver.Query(roundTrip(req))
+	if err != nil {
+		return QueryResponse{}, err
+	}
+	return roundTrip(resp), nil
+}
+
+// roundTrip copies v through its JSON encoding
+func roundTrip[T any](v T) T {
+	var out T
+	data, err := json.Marshal(v)
+	if err != nil {
+		return out
+	}
+	json.Unmarshal(data, &out)
+	return out
+}
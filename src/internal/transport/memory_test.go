@@ -0,0 +1,150 @@
+package transport
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rokzabukovec/clip/internal/kv"
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+// memoryReceiver is a Receiver that records every message
+type memoryReceiver struct {
+	fakeReceiver
+	joined     []*peer.Peer
+	left       []*peer.Peer
+	pingErr    error
+	state      []*peer.Peer
+	keyringOps []KeyringRequest
+	queries    []QueryRequest
+	kvUpdates  []KVUpdate
+}
+
+func (r *memoryReceiver) Join(p *peer.Peer) ([]*peer.Peer, error) {
+	r.joined = append(r.joined, p)
+	return r.state, nil
+}
+
+func (r *memoryReceiver) PingReq(req PingRequest) error {
+	return r.pingErr
+}
+
+func (r *memoryReceiver) Leave(p *peer.Peer) {
+	r.left = append(r.left, p)
+}
+
+func (r *memoryReceiver) KeyringOp(req KeyringRequest) error {
+	r.keyringOps = append(r.keyringOps, req)
+	return nil
+}
+
+func (r *memoryReceiver) PushPull(req GossipRequest) (GossipResponse, error) {
+	return GossipResponse{Peers: r.state, Want: []string{req.Digest[0].ID}}, nil
+}
+
+func (r *memoryReceiver) MergeKV(update KVUpdate) error {
+	r.kvUpdates = append(r.kvUpdates, update)
+	return nil
+}
+
+func (r *memoryReceiver) Query(req QueryRequest) (QueryResponse, error) {
+	r.queries = append(r.queries, req)
+	return QueryResponse{Node: "remote", Payload: []byte("up 3 days")}, nil
+}
+
+func TestMemoryTransport(t *testing.T) {
+	network := NewNetwork()
+	remote := &memoryReceiver{state: []*peer.Peer{{ID: "remote", State: peer.StateAlive}}}
+	remote.view = &peer.Peer{ID: "local", State: peer.StateSuspect}
+	network.Register("http://remote", remote)
+	tr := network.Transport()
+
+	self := &peer.Peer{ID: "local", Address: "http://local", State: peer.StateAlive}
+
+	peers, err := tr.Join("http://remote", self)
+	if err != nil || len(peers) != 1 || peers[0].ID != "remote" {
+		t.Errorf("Expected join to return the remote state, got %+v (%v)", peers, err)
+	}
+	if len(remote.joined) != 1 || remote.joined[0].ID != "local" {
+		t.Errorf("Expected remote to see the join, got %+v", remote.joined)
+	}
+
+	view, err := tr.Ping("http://remote", self)
+	if err != nil || view == nil || view.State != peer.StateSuspect {
+		t.Errorf("Expected the remote view of us, got %+v (%v)", view, err)
+	}
+
+	if err := tr.Broadcast("http://remote", []*peer.Peer{{ID: "peer1"}}); err != nil || remote.mergedCount() != 1 {
+		t.Errorf("Expected rumor to be delivered, got %d (%v)", remote.mergedCount(), err)
+	}
+
+	if err := tr.UserEvents("http://remote", []*peer.UserEvent{{ID: "a1", Name: "deploy"}}); err != nil || remote.eventCount() != 1 {
+		t.Errorf("Expected user event to be delivered, got %d (%v)", remote.eventCount(), err)
+	}
+
+	diff, err := tr.PushPull("http://remote", GossipRequest{Digest: []peer.Digest{{ID: "local"}}})
+	if err != nil || len(diff.Peers) != 1 || len(diff.Want) != 1 {
+		t.Errorf("Unexpected diff: %+v (%v)", diff, err)
+	}
+
+	remote.pingErr = errors.New("no ack")
+	if err := tr.PingReq("http://remote", PingRequest{TargetID: "x"}); err == nil {
+		t.Error("Expected the receiver's indirect probe error to be returned")
+	}
+
+	if err := tr.Leave("http://remote", self); err != nil || len(remote.left) != 1 {
+		t.Errorf("Expected leave to be delivered, got %d (%v)", len(remote.left), err)
+	}
+
+	update := KVUpdate{Cluster: "staging", Entries: []*kv.Entry{{Key: "flag", Value: []byte("on"), LTime: 1, Node: "local"}}}
+	if err := tr.KVUpdate("http://remote", update); err != nil || len(remote.kvUpdates) != 1 ||
+		string(remote.kvUpdates[0].Entries[0].Value) != "on" {
+		t.Errorf("Expected key/value writes to be delivered, got %+v (%v)", remote.kvUpdates, err)
+	}
+
+	if err := tr.KeyringOp("http://remote", KeyringRequest{Op: KeyringInstall, Key: "k"}); err != nil || len(remote.keyringOps) != 1 {
+		t.Errorf("Expected keyring change to be delivered, got %d (%v)", len(remote.keyringOps), err)
+	}
+
+	resp, err := tr.Query("http://remote", QueryRequest{Name: "uptime", Origin: "local"})
+	if err != nil || resp.Node != "remote" || string(resp.Payload) != "up 3 days" || len(remote.queries) != 1 {
+		t.Errorf("Expected the query to be answered, got %+v (%v)", resp, err)
+	}
+}
+
+func TestMemoryTransport_Rejected(t *testing.T) {
+	network := NewNetwork()
+	network.Register("http://remote", &memoryReceiver{fakeReceiver: fakeReceiver{heartbeatErr: errors.New("cluster mismatch")}})
+
+	if _, err := network.Transport().Ping("http://remote", &peer.Peer{ID: "local"}); err == nil {
+		t.Error("Expected the receiver's rejection to be returned")
+	}
+}
+
+func TestMemoryTransport_CopiesMessages(t *testing.T) {
+	network := NewNetwork()
+	remote := &memoryReceiver{}
+	network.Register("http://remote", remote)
+
+	self := &peer.Peer{ID: "local", State: peer.StateAlive}
+	network.Transport().Join("http://remote", self)
+
+	remote.joined[0].State = peer.StateDead
+	if self.State != peer.StateAlive {
+		t.Error("Expected the receiver to get a copy, not the sender's record")
+	}
+}
+
+func TestMemoryTransport_Unreachable(t *testing.T) {
+	network := NewNetwork()
+	network.Register("http://remote", &memoryReceiver{})
+	network.Unregister("http://remote")
+	tr := network.Transport()
+
+	if _, err := tr.Ping("http://remote", &peer.Peer{}); err == nil {
+		t.Error("Expected ping to an unregistered address to fail")
+	}
+	if err := tr.Broadcast("http://nowhere", nil); err == nil {
+		t.Error("Expected broadcast to an unknown address to fail")
+	}
+}
@@ -0,0 +1,199 @@
+package transport
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/rokzabukovec/clip/internal/kv"
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+// PingRequest asks a peer to probe a target on the sender's behalf
+type PingRequest struct {
+	TargetID      string `json:"target_id"`
+	TargetAddress string `json:"target_address"`
+}
+
+// GossipRequest opens a push-pull exchange with a digest of the sender's
+// state: its peers and its key/value entries
+type GossipRequest struct {
+	Cluster string        `json:"cluster,omitempty"`
+	Digest  []peer.Digest `json:"digest"`
+	KV      []kv.Digest   `json:"kv,omitempty"`
+}
+
+// GossipResponse carries the records and key/value entries the sender is
+// missing or has stale copies of, and the IDs of records and the keys the
+// receiver wants from the sender
+type GossipResponse struct {
+	Peers  []*peer.Peer `json:"peers"`
+	Want   []string     `json:"want"`
+	KV     []*kv.Entry  `json:"kv,omitempty"`
+	WantKV []string     `json:"want_kv,omitempty"`
+}
+
+// KVUpdate carries key/value writes, gossiped or asked for during
+// push-pull
+type KVUpdate struct {
+	Cluster string      `json:"cluster,omitempty"`
+	Entries []*kv.Entry `json:"entries"`
+
+	// Sync marks entries asked for during push-pull, which are not
+	// gossiped on
+	Sync bool `json:"sync,omitempty"`
+}
+
+// Keyring operations
+const (
+	KeyringInstall = "install"
+	KeyringUse     = "use"
+	KeyringRemove  = "remove"
+)
+
+// KeyringRequest asks a peer to change its encryption keyring
+type KeyringRequest struct {
+	Op  string `json:"op"`
+	Key string `json:"key"`
+}
+
+// QueryRequest asks a member to answer a query. The origin waits for the
+// answer for Timeout, and the member gives up on it after that as well.
+type QueryRequest struct {
+	Name    string        `json:"name"`
+	Payload []byte        `json:"payload,omitempty"`
+	Origin  string        `json:"origin"`
+	Cluster string        `json:"cluster,omitempty"`
+	Timeout time.Duration `json:"timeout"`
+}
+
+// QueryResponse is a member's answer to a query: the output of its query
+// handler, or the error the handler failed with. A member without a
+// handler for the query only acknowledges it.
+type QueryResponse struct {
+	Node    string `json:"node"`
+	Payload []byte `json:"payload,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// PacketTransport sends the small, frequent messages: probes, rumors and
+// user events. Peers are always addressed by the HTTP address they
+// advertise.
+type PacketTransport interface {
+	// Ping probes the peer at address with this node's record and returns
+	// the peer's view of this node from the ack, or nil if it has none
+	Ping(address string, self *peer.Peer) (*peer.Peer, error)
+
+	// Broadcast sends membership rumors to the peer at address
+	Broadcast(address string, rumors []*peer.Peer) error
+
+	// UserEvents sends user events to the peer at address
+	UserEvents(address string, events []*peer.UserEvent) error
+}
+
+// Transport carries all peer-to-peer messages
+type Transport interface {
+	PacketTransport
+
+	// Join announces this node to the peer at address and returns the
+	// peer's full state
+	Join(address string, self *peer.Peer) ([]*peer.Peer, error)
+
+	// PingReq asks the peer at address to probe a target for us and
+	// returns nil if the target answered
+	PingReq(address string, req PingRequest) error
+
+	// Leave tells the peer at address that this node is leaving
+	Leave(address string, self *peer.Peer) error
+
+	// PushPull sends a digest of our state to the peer at address and
+	// returns the records that differ
+	PushPull(address string, req GossipRequest) (GossipResponse, error)
+
+	// Push sends the records a peer asked for during push-pull
+	Push(address string, peers []*peer.Peer) error
+
+	// KVUpdate sends key/value writes to the peer at address
+	KVUpdate(address string, update KVUpdate) error
+
+	// KeyringOp asks the peer at address to change its keyring
+	KeyringOp(address string, req KeyringRequest) error
+
+	// Query asks the peer at address to answer a query and waits for the
+	// answer for at most the query's timeout
+	Query(address string, req QueryRequest) (QueryResponse, error)
+}
+
+// PacketReceiver handles probes and rumors that arrive over a transport.
+// Receivers return an error for messages they reject, e.g. from another
+// cluster.
+type PacketReceiver interface {
+	Heartbeat(from *peer.Peer) (*peer.Peer, error)
+	MergePeers(peers []*peer.Peer)
+	MergeUserEvents(events []*peer.UserEvent)
+}
+
+// Receiver handles every message that arrives over a transport
+type Receiver interface {
+	PacketReceiver
+	Join(p *peer.Peer) ([]*peer.Peer, error)
+	PingReq(req PingRequest) error
+	Leave(p *peer.Peer)
+	PushPull(req GossipRequest) (GossipResponse, error)
+	MergeKV(update KVUpdate) error
+	KeyringOp(req KeyringRequest) error
+	Query(req QueryRequest) (QueryResponse, error)
+}
+
+// packetTransport sends probes and rumors over a packet transport and
+// everything else over a stream transport
+type packetTransport struct {
+	Transport
+	packet   PacketTransport
+	fallback bool
+}
+
+// NewPacketTransport creates a transport that sends probes and rumors over
+// packet and all other messages over stream. With fallback set, probes and
+// rumors that fail over packet are retried over stream, so nodes can talk
+// to peers that only serve one of them.
+func NewPacketTransport(packet PacketTransport, stream Transport, fallback bool) Transport {
+	return &packetTransport{Transport: stream, packet: packet, fallback: fallback}
+}
+
+func (t *packetTransport) Ping(address string, self *peer.Peer) (*peer.Peer, error) {
+	view, err := t.packet.Ping(address, self)
+	if err == nil || !t.fallback {
+		return view, err
+	}
+	return t.Transport.Ping(address, self)
+}
+
+func (t *packetTransport) Broadcast(address string, rumors []*peer.Peer) error {
+	err := t.packet.Broadcast(address, rumors)
+	if err == nil || !t.fallback {
+		return err
+	}
+	return t.Transport.Broadcast(address, rumors)
+}
+
+func (t *packetTransport) UserEvents(address string, events []*peer.UserEvent) error {
+	err := t.packet.UserEvents(address, events)
+	if err == nil || !t.fallback {
+		return err
+	}
+	return t.Transport.UserEvents(address, events)
+}
+
+// hostPort extracts host:port from a peer's advertised HTTP address. The
+// UDP transport listens on the same port number as HTTP.
+func hostPort(address string) (string, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid peer address: %s", address)
+	}
+	return u.Host, nil
+}
@@ -0,0 +1,134 @@
+package transport
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+// stubTransport returns a fixed error and counts calls
+type stubTransport struct {
+	err   error
+	calls int
+}
+
+func (s *stubTransport) Join(address string, self *peer.Peer) ([]*peer.Peer, error) {
+	s.calls++
+	return nil, s.err
+}
+
+func (s *stubTransport) Ping(address string, self *peer.Peer) (*peer.Peer, error) {
+	s.calls++
+	return nil, s.err
+}
+
+func (s *stubTransport) PingReq(address string, req PingRequest) error {
+	s.calls++
+	return s.err
+}
+
+func (s *stubTransport) Leave(address string, self *peer.Peer) error {
+	s.calls++
+	return s.err
+}
+
+func (s *stubTransport) Broadcast(address string, rumors []*peer.Peer) error {
+	s.calls++
+	return s.err
+}
+
+func (s *stubTransport) UserEvents(address string, events []*peer.UserEvent) error {
+	s.calls++
+	return s.err
+}
+
+func (s *stubTransport) PushPull(address string, req GossipRequest) (GossipResponse, error) {
+	s.calls++
+	return GossipResponse{}, s.err
+}
+
+func (s *stubTransport) Push(address string, peers []*peer.Peer) error {
+	s.calls++
+	return s.err
+}
+
+func (s *stubTransport) KVUpdate(address string, update KVUpdate) error {
+	s.calls++
+	return s.err
+}
+
+func (s *stubTransport) KeyringOp(address string, req KeyringRequest) error {
+	s.calls++
+	return s.err
+}
+
+func (s *stubTransport) Query(address string, req QueryRequest) (QueryResponse, error) {
+	s.calls++
+	return QueryResponse{}, s.err
+}
+
+func TestPacketTransport(t *testing.T) {
+	packet := &stubTransport{}
+	stream := &stubTransport{}
+	tr := NewPacketTransport(packet, stream, false)
+
+	tr.Ping("http://peer", &peer.Peer{})
+	tr.Broadcast("http://peer", nil)
+	tr.UserEvents("http://peer", nil)
+	if packet.calls != 3 || stream.calls != 0 {
+		t.Errorf("Expected probes, rumors and user events over packet, got %d/%d calls", packet.calls, stream.calls)
+	}
+
+	tr.Join("http://peer", &peer.Peer{})
+	tr.PushPull("http://peer", GossipRequest{})
+	tr.Push("http://peer", nil)
+	tr.KVUpdate("http://peer", KVUpdate{})
+	tr.Query("http://peer", QueryRequest{})
+	if packet.calls != 3 || stream.calls != 5 {
+		t.Errorf("Expected everything else over stream, got %d/%d calls", packet.calls, stream.calls)
+	}
+
+	packet.err = errors.New("no ack")
+	if _, err := tr.Ping("http://peer", &peer.Peer{}); err == nil {
+		t.Error("Expected ping to fail without fallback")
+	}
+	if stream.calls != 5 {
+		t.Errorf("Expected no fallback to stream, got %d calls", stream.calls)
+	}
+}
+
+func TestPacketTransport_Fallback(t *testing.T) {
+	packet := &stubTransport{err: errors.New("no ack")}
+	stream := &stubTransport{}
+	tr := NewPacketTransport(packet, stream, true)
+
+	if _, err := tr.Ping("http://peer", &peer.Peer{}); err != nil {
+		t.Errorf("Expected ping to fall back, got: %v", err)
+	}
+	if err := tr.Broadcast("http://peer", nil); err != nil {
+		t.Errorf("Expected broadcast to fall back, got: %v", err)
+	}
+	if err := tr.UserEvents("http://peer", nil); err != nil {
+		t.Errorf("Expected user events to fall back, got: %v", err)
+	}
+	if packet.calls != 3 || stream.calls != 3 {
+		t.Errorf("Expected both transports to be tried, got %d/%d calls", packet.calls, stream.calls)
+	}
+
+	stream.err = errors.New("connection refused")
+	if _, err := tr.Ping("http://peer", &peer.Peer{}); err == nil {
+		t.Error("Expected ping to fail when both transports fail")
+	}
+}
+
+func TestHostPort(t *testing.T) {
+	host, err := hostPort("http://192.168.1.100:8080")
+	if err != nil || host != "192.168.1.100:8080" {
+		t.Errorf("Expected 192.168.1.100:8080, got %q (%v)", host, err)
+	}
+
+	if _, err := hostPort("192.168.1.100"); err == nil {
+		t.Error("Expected an address without a scheme to be rejected")
+	}
+}
@@ -0,0 +1,243 @@
+package transport
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rokzabukovec/clip/internal/keyring"
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+// UDPTransport sends probes, rumors and user events as compact binary
+// datagrams. A single socket is used for sending and receiving, so acks
+// come back to the port the probe was sent from.
+type UDPTransport struct {
+	conn     *net.UDPConn
+	receiver PacketReceiver
+	timeout  time.Duration
+	keyring  *keyring.Keyring
+
+	mu      sync.Mutex
+	seq     uint32
+	pending map[uint32]probe
+}
+
+// probe is a probe waiting for its ack. Acks are only taken from the
+// address the probe was sent to, so other hosts can't answer for it.
+type probe struct {
+	addr *net.UDPAddr
+	acks chan *peer.Peer
+}
+
+// NewUDPTransport binds a UDP socket on bindAddress:port. Probes that are
+// not acknowledged within timeout fail; incoming messages are passed to
+// receiver once Start is called.
+func NewUDPTransport(bindAddress string, port int, timeout time.Duration, receiver PacketReceiver) (*UDPTransport, error) {
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(bindAddress, fmt.Sprint(port)))
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sequence numbers start at random so acks can't be guessed
+	var seed [4]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &UDPTransport{
+		conn:     conn,
+		receiver: receiver,
+		timeout:  timeout,
+		seq:      binary.BigEndian.Uint32(seed[:]),
+		pending:  make(map[uint32]probe),
+	}, nil
+}
+
+// SetKeyring encrypts outgoing datagrams with the cluster keyring and drops
+// incoming ones that fail to decrypt. It must be called before Start.
+func (t *UDPTransport) SetKeyring(k *keyring.Keyring) {
+	t.keyring = k
+}
+
+// Start starts handling incoming datagrams
+func (t *UDPTransport) Start() {
+	go t.readLoop()
+}
+
+// Close closes the socket, which also stops the read loop
+func (t *UDPTransport) Close() error {
+	return t.conn.Close()
+}
+
+// LocalAddr returns the address the socket is bound to
+func (t *UDPTransport) LocalAddr() *net.UDPAddr {
+	return t.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// Ping sends a probe datagram and waits for the matching ack from the
+// probed address
+func (t *UDPTransport) Ping(address string, self *peer.Peer) (*peer.Peer, error) {
+	addr, err := t.resolve(address)
+	if err != nil {
+		return nil, err
+	}
+
+	acks := make(chan *peer.Peer, 1)
+	t.mu.Lock()
+	t.seq++
+	seq := t.seq
+	t.pending[seq] = probe{addr: addr, acks: acks}
+	t.mu.Unlock()
+
+	defer func() {
+		t.mu.Lock()
+		delete(t.pending, seq)
+		t.mu.Unlock()
+	}()
+
+	if err := t.send(message{kind: kindPing, seq: seq, peers: []*peer.Peer{self}}, addr); err != nil {
+		return nil, err
+	}
+
+	select {
+	case view := <-acks:
+		return view, nil
+	case <-time.After(t.timeout):
+		return nil, fmt.Errorf("no ack from %s within %v", address, t.timeout)
+	}
+}
+
+// Broadcast sends rumors in as many datagrams as needed. Delivery is not
+// acknowledged; lost rumors are repaired by retransmission and push-pull.
+func (t *UDPTransport) Broadcast(address string, rumors []*peer.Peer) error {
+	addr, err := t.resolve(address)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range split(rumors) {
+		if err := t.send(m, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UserEvents sends user events in as many datagrams as needed. Like rumors
+// they are not acknowledged; lost ones are covered by retransmission.
+func (t *UDPTransport) UserEvents(address string, events []*peer.UserEvent) error {
+	addr, err := t.resolve(address)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range splitUserEvents(events) {
+		if err := t.send(m, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send encodes a message, encrypts it if a keyring is set, and sends it
+func (t *UDPTransport) send(m message, addr *net.UDPAddr) error {
+	packet := encode(m)
+	if t.keyring != nil {
+		var err error
+		if packet, err = t.keyring.Encrypt(packet); err != nil {
+			return err
+		}
+	}
+	_, err := t.conn.WriteToUDP(packet, addr)
+	return err
+}
+
+func (t *UDPTransport) resolve(address string) (*net.UDPAddr, error) {
+	host, err := hostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	return net.ResolveUDPAddr("udp", host)
+}
+
+// readLoop handles incoming datagrams until the socket is closed
+func (t *UDPTransport) readLoop() {
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := t.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		packet := buf[:n]
+		if t.keyring != nil {
+			if packet, err = t.keyring.Decrypt(packet); err != nil {
+				log.Printf("Dropping packet from %s: %v", from, err)
+				continue
+			}
+		}
+
+		m, err := decode(packet)
+		if err != nil {
+			log.Printf("Dropping invalid packet from %s: %v", from, err)
+			continue
+		}
+		t.handle(m, from)
+	}
+}
+
+// handle dispatches a decoded message
+func (t *UDPTransport) handle(m message, from *net.UDPAddr) {
+	switch m.kind {
+	case kindPing:
+		if len(m.peers) != 1 {
+			return
+		}
+		// Rejected probes are not acked, so the sender sees a timeout
+		view, err := t.receiver.Heartbeat(m.peers[0])
+		if err != nil {
+			return
+		}
+		ack := message{kind: kindAck, seq: m.seq}
+		if view != nil {
+			ack.peers = []*peer.Peer{view}
+		}
+		t.send(ack, from)
+
+	case kindAck:
+		var view *peer.Peer
+		if len(m.peers) > 0 {
+			view = m.peers[0]
+		}
+		t.mu.Lock()
+		p, ok := t.pending[m.seq]
+		t.mu.Unlock()
+		if ok && p.addr.IP.Equal(from.IP) && p.addr.Port == from.Port {
+			select {
+			case p.acks <- view:
+			default:
+			}
+		}
+
+	case kindBroadcast:
+		t.receiver.MergePeers(m.peers)
+
+	case kindUserEvents:
+		t.receiver.MergeUserEvents(m.events)
+	}
+}
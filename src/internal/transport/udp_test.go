@@ -0,0 +1,257 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rokzabukovec/clip/internal/keyring"
+	"github.com/rokzabukovec/clip/internal/peer"
+	"github.com/rokzabukovec/clip/internal/testutil"
+)
+
+// fakeReceiver records what arrives over a transport
+type fakeReceiver struct {
+	mu         sync.Mutex
+	heartbeats []*peer.Peer
+	merged     []*peer.Peer
+	events     []*peer.UserEvent
+	view       *peer.Peer
+
+	heartbeatErr error
+}
+
+func (r *fakeReceiver) Heartbeat(from *peer.Peer) (*peer.Peer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.heartbeats = append(r.heartbeats, from)
+	return r.view, r.heartbeatErr
+}
+
+func (r *fakeReceiver) MergePeers(peers []*peer.Peer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.merged = append(r.merged, peers...)
+}
+
+func (r *fakeReceiver) MergeUserEvents(events []*peer.UserEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, events...)
+}
+
+func (r *fakeReceiver) eventCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.events)
+}
+
+func (r *fakeReceiver) mergedCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.merged)
+}
+
+func newTestUDPTransport(t *testing.T, receiver PacketReceiver) (*UDPTransport, string) {
+	t.Helper()
+	tr, err := NewUDPTransport("127.0.0.1", 0, 200*time.Millisecond, receiver)
+	if err != nil {
+		t.Fatalf("Failed to create UDP transport: %v", err)
+	}
+	tr.Start()
+	t.Cleanup(func() { tr.Close() })
+	return tr, fmt.Sprintf("http://%s", tr.LocalAddr())
+}
+
+func TestUDPTransport_Ping(t *testing.T) {
+	remote := &fakeReceiver{
+		view: &peer.Peer{ID: "local", State: peer.StateSuspect, Incarnation: 2},
+	}
+	_, remoteAddr := newTestUDPTransport(t, remote)
+	local, _ := newTestUDPTransport(t, &fakeReceiver{})
+
+	self := &peer.Peer{ID: "local", Address: "http://127.0.0.1:9000", State: peer.StateAlive, Version: 4}
+	view, err := local.Ping(remoteAddr, self)
+	if err != nil {
+		t.Fatalf("Expected ping to succeed, got: %v", err)
+	}
+
+	if view == nil || view.State != peer.StateSuspect || view.Incarnation != 2 {
+		t.Errorf("Expected the remote view of us in the ack, got %+v", view)
+	}
+
+	remote.mu.Lock()
+	defer remote.mu.Unlock()
+	if len(remote.heartbeats) != 1 || remote.heartbeats[0].ID != "local" || remote.heartbeats[0].Version != 4 {
+		t.Errorf("Expected remote to receive our record, got %+v", remote.heartbeats)
+	}
+}
+
+func TestUDPTransport_PingWithoutView(t *testing.T) {
+	_, remoteAddr := newTestUDPTransport(t, &fakeReceiver{})
+	local, _ := newTestUDPTransport(t, &fakeReceiver{})
+
+	view, err := local.Ping(remoteAddr, &peer.Peer{ID: "local"})
+	if err != nil {
+		t.Fatalf("Expected ping to succeed, got: %v", err)
+	}
+	if view != nil {
+		t.Errorf("Expected no view, got %+v", view)
+	}
+}
+
+func TestUDPTransport_PingRejected(t *testing.T) {
+	_, remoteAddr := newTestUDPTransport(t, &fakeReceiver{heartbeatErr: errors.New("cluster mismatch")})
+	local, _ := newTestUDPTransport(t, &fakeReceiver{})
+
+	if _, err := local.Ping(remoteAddr, &peer.Peer{ID: "local"}); err == nil {
+		t.Error("Expected a rejected ping not to be acked")
+	}
+}
+
+func TestUDPTransport_PingTimeout(t *testing.T) {
+	remote, remoteAddr := newTestUDPTransport(t, &fakeReceiver{})
+	remote.Close()
+	local, _ := newTestUDPTransport(t, &fakeReceiver{})
+
+	if _, err := local.Ping(remoteAddr, &peer.Peer{ID: "local"}); err == nil {
+		t.Error("Expected ping to a closed socket to fail")
+	}
+
+	if _, err := local.Ping("not a url", &peer.Peer{ID: "local"}); err == nil {
+		t.Error("Expected ping to an invalid address to fail")
+	}
+}
+
+func TestUDPTransport_PingIgnoresOtherSources(t *testing.T) {
+	target, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer target.Close()
+	spoofer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer spoofer.Close()
+	local, _ := newTestUDPTransport(t, &fakeReceiver{})
+
+	// Another host acks the probe first, then the target answers itself
+	go func() {
+		buf := make([]byte, 65536)
+		n, from, err := target.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		ping, err := decode(buf[:n])
+		if err != nil {
+			return
+		}
+		spoofed := message{kind: kindAck, seq: ping.seq, peers: []*peer.Peer{{ID: "local", State: peer.StateDead}}}
+		spoofer.WriteToUDP(encode(spoofed), from)
+		time.Sleep(20 * time.Millisecond)
+		target.WriteToUDP(encode(message{kind: kindAck, seq: ping.seq}), from)
+	}()
+
+	view, err := local.Ping("http://"+target.LocalAddr().String(), &peer.Peer{ID: "local"})
+	if err != nil {
+		t.Fatalf("Expected the target's ack to be accepted, got: %v", err)
+	}
+	if view != nil {
+		t.Errorf("Expected the ack from another address to be ignored, got view %+v", view)
+	}
+}
+
+func TestUDPTransport_Broadcast(t *testing.T) {
+	remote := &fakeReceiver{}
+	_, remoteAddr := newTestUDPTransport(t, remote)
+	local, _ := newTestUDPTransport(t, &fakeReceiver{})
+
+	rumors := make([]*peer.Peer, 60)
+	for i := range rumors {
+		rumors[i] = &peer.Peer{
+			ID:      fmt.Sprintf("peer-%d", i),
+			Address: fmt.Sprintf("http://192.168.1.%d:8080", i),
+			State:   peer.StateDead,
+		}
+	}
+
+	if err := local.Broadcast(remoteAddr, rumors); err != nil {
+		t.Fatalf("Expected broadcast to succeed, got: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for remote.mergedCount() < len(rumors) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := remote.mergedCount(); got != len(rumors) {
+		t.Errorf("Expected %d rumors to arrive, got %d", len(rumors), got)
+	}
+}
+
+func TestUDPTransport_UserEvents(t *testing.T) {
+	remote := &fakeReceiver{}
+	_, remoteAddr := newTestUDPTransport(t, remote)
+	local, _ := newTestUDPTransport(t, &fakeReceiver{})
+
+	// Big enough payloads that they need several datagrams
+	events := make([]*peer.UserEvent, 10)
+	for i := range events {
+		events[i] = &peer.UserEvent{ID: fmt.Sprintf("event-%d", i), Name: "deploy", Payload: bytes.Repeat([]byte("x"), 300), LTime: uint64(i)}
+	}
+
+	if err := local.UserEvents(remoteAddr, events); err != nil {
+		t.Fatalf("Expected user events to be sent, got: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for remote.eventCount() < len(events) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := remote.eventCount(); got != len(events) {
+		t.Errorf("Expected %d user events to arrive, got %d", len(events), got)
+	}
+}
+
+func TestUDPTransport_Encrypted(t *testing.T) {
+	k, _ := keyring.New(bytes.Repeat([]byte{1}, 32))
+	other, _ := keyring.New(bytes.Repeat([]byte{2}, 32))
+
+	newEncrypted := func(receiver PacketReceiver, k *keyring.Keyring) (*UDPTransport, string) {
+		tr, err := NewUDPTransport("127.0.0.1", 0, 200*time.Millisecond, receiver)
+		if err != nil {
+			t.Fatalf("Failed to create UDP transport: %v", err)
+		}
+		tr.SetKeyring(k)
+		tr.Start()
+		t.Cleanup(func() { tr.Close() })
+		return tr, fmt.Sprintf("http://%s", tr.LocalAddr())
+	}
+
+	remote := &fakeReceiver{}
+	_, remoteAddr := newEncrypted(remote, k)
+
+	local, _ := newEncrypted(&fakeReceiver{}, k)
+	if _, err := local.Ping(remoteAddr, &peer.Peer{ID: "local"}); err != nil {
+		t.Errorf("Expected ping with a shared key to succeed, got: %v", err)
+	}
+
+	rogue, _ := newEncrypted(&fakeReceiver{}, other)
+	if _, err := rogue.Ping(remoteAddr, &peer.Peer{ID: "rogue"}); err == nil {
+		t.Error("Expected ping with another key to be dropped")
+	}
+
+	plain, _ := newTestUDPTransport(t, &fakeReceiver{})
+	plain.Broadcast(remoteAddr, []*peer.Peer{{ID: "fake-peer", State: peer.StateAlive}})
+
+	testutil.WaitForCondition(t, func() bool {
+		return k.Failures() == 2
+	}, time.Second, "dropped packets to be counted")
+	if remote.mergedCount() != 0 {
+		t.Error("Expected no rumors to be merged from unauthenticated packets")
+	}
+}
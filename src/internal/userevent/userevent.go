@@ -0,0 +1,144 @@
+package userevent
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+// Limits
+const (
+	// DefaultBuffer is how many Lamport times of recent events are
+	// remembered to recognise duplicates. Events older than that are
+	// dropped, since they can't be told apart from ones already delivered.
+	DefaultBuffer = 512
+
+	// MaxSize limits the name plus payload of an event, so it fits in a
+	// single UDP datagram with room to spare
+	MaxSize = 512
+)
+
+// ErrTooLarge is returned for events whose name and payload exceed MaxSize
+var ErrTooLarge = fmt.Errorf("user event name and payload must not exceed %d bytes", MaxSize)
+
+// Log delivers user events to an event bus of their own exactly once, so
+// they don't push membership events out of the peer list's history. Each
+// event is identified by its ID and Lamport time; copies that arrive again
+// over other gossip paths are recognised and dropped.
+type Log struct {
+	clock peer.LamportClock
+	bus   *peer.EventBus
+	onNew func(e *peer.UserEvent)
+
+	mu   sync.Mutex
+	seen []slot
+}
+
+// slot holds the IDs of the events seen at one Lamport time
+type slot struct {
+	ltime uint64
+	ids   []string
+}
+
+// NewLog creates a log that publishes new events on bus and remembers the
+// last buffer Lamport times
+func NewLog(bus *peer.EventBus, buffer int) *Log {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	return &Log{
+		bus:  bus,
+		seen: make([]slot, buffer),
+	}
+}
+
+// Events returns the bus new events are published on
+func (l *Log) Events() *peer.EventBus {
+	return l.bus
+}
+
+// SetOnNew sets a callback for every new event, fired here or received
+// from a peer, e.g. to gossip it on. It must be set before events arrive.
+func (l *Log) SetOnNew(onNew func(e *peer.UserEvent)) {
+	l.onNew = onNew
+}
+
+// Time returns the current Lamport time of user events
+func (l *Log) Time() uint64 {
+	return l.clock.Time()
+}
+
+// Validate checks that an event has a name usable in a URL path and fits
+// in MaxSize
+func Validate(name string, payload []byte) error {
+	if name == "" || strings.Contains(name, "/") {
+		return errors.New("user event name must be non-empty and must not contain '/'")
+	}
+	if len(name)+len(payload) > MaxSize {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// Fire gives a new event an ID and the next Lamport time, and delivers it
+// like one received from a peer
+func (l *Log) Fire(e *peer.UserEvent) error {
+	if err := Validate(e.Name, e.Payload); err != nil {
+		return err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	e.ID = hex.EncodeToString(id)
+	e.LTime = l.clock.Increment()
+
+	l.Receive(e)
+	return nil
+}
+
+// Receive delivers an event and reports whether it was new. Invalid events,
+// duplicates and events older than the buffer are dropped, and only new
+// events move the clock.
+func (l *Log) Receive(e *peer.UserEvent) bool {
+	if Validate(e.Name, e.Payload) != nil || !l.record(e) {
+		return false
+	}
+	l.clock.Witness(e.LTime)
+
+	l.bus.PublishUser(e)
+	if l.onNew != nil {
+		l.onNew(e)
+	}
+	return true
+}
+
+// record remembers an event and reports whether it was not seen before
+func (l *Log) record(e *peer.UserEvent) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	size := uint64(len(l.seen))
+	if now := l.clock.Time(); now > size && e.LTime < now-size {
+		return false
+	}
+
+	s := &l.seen[e.LTime%size]
+	if s.ltime != e.LTime {
+		s.ltime = e.LTime
+		s.ids = nil
+	}
+	for _, id := range s.ids {
+		if id == e.ID {
+			return false
+		}
+	}
+	s.ids = append(s.ids, e.ID)
+	return true
+}
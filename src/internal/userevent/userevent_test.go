@@ -0,0 +1,98 @@
+package userevent
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		wantErr bool
+	}{
+		{"deploy", []byte("v1.4.2"), false},
+		{"flush-cache", nil, false},
+		{"", nil, true},
+		{"deploy/web", nil, true},
+		{"deploy", []byte(strings.Repeat("x", MaxSize)), true},
+	}
+
+	for _, tt := range tests {
+		if err := Validate(tt.name, tt.payload); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLog_Fire(t *testing.T) {
+	bus := peer.NewEventBus(peer.DefaultEventHistory)
+	_, events, cancel := bus.Subscribe(0)
+	defer cancel()
+
+	var spread []*peer.UserEvent
+	l := NewLog(bus, 0)
+	l.SetOnNew(func(e *peer.UserEvent) { spread = append(spread, e) })
+
+	e := &peer.UserEvent{Name: "deploy", Payload: []byte("v1.4.2"), Origin: "node1"}
+	if err := l.Fire(e); err != nil {
+		t.Fatalf("Expected Fire to succeed, got %v", err)
+	}
+	if e.ID == "" || e.LTime != 1 {
+		t.Errorf("Expected an ID and Lamport time 1, got %+v", e)
+	}
+
+	event := <-events
+	if event.Type != peer.EventUser || event.User != e {
+		t.Errorf("Expected the event to be published, got %+v", event)
+	}
+	if len(spread) != 1 || spread[0] != e {
+		t.Errorf("Expected the event to be passed on, got %+v", spread)
+	}
+
+	err := l.Fire(&peer.UserEvent{Name: "deploy", Payload: make([]byte, MaxSize)})
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestLog_Receive(t *testing.T) {
+	bus := peer.NewEventBus(peer.DefaultEventHistory)
+	l := NewLog(bus, 4)
+
+	e := &peer.UserEvent{ID: "a1", Name: "deploy", LTime: 7}
+	if !l.Receive(e) {
+		t.Fatal("Expected a new event to be delivered")
+	}
+	if l.Receive(&peer.UserEvent{ID: "a1", Name: "deploy", LTime: 7}) {
+		t.Error("Expected a duplicate to be dropped")
+	}
+	if l.Time() != 8 {
+		t.Errorf("Expected the clock to move past the event, got %d", l.Time())
+	}
+
+	// Another event fired at the same Lamport time elsewhere
+	if !l.Receive(&peer.UserEvent{ID: "b2", Name: "deploy", LTime: 7}) {
+		t.Error("Expected an event with another ID to be delivered")
+	}
+
+	// Events older than the buffer can't be checked for duplicates
+	if l.Receive(&peer.UserEvent{ID: "c3", Name: "deploy", LTime: 2}) {
+		t.Error("Expected an event older than the buffer to be dropped")
+	}
+
+	// Invalid events are dropped before they can move the clock
+	if l.Receive(&peer.UserEvent{ID: "d4", Name: "bad/name", LTime: 1000}) {
+		t.Error("Expected an invalid event to be dropped")
+	}
+	if l.Time() != 8 {
+		t.Errorf("Expected an invalid event not to move the clock, got %d", l.Time())
+	}
+
+	if bus.LastID() != 2 {
+		t.Errorf("Expected 2 events to be published, got %d", bus.LastID())
+	}
+}
@@ -0,0 +1,272 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+// Delivery defaults
+const (
+	// DefaultQueueSize is how many events may wait for delivery to one
+	// endpoint before the oldest are dropped
+	DefaultQueueSize = 1000
+
+	// maxAttempts is how often a delivery is tried before it is given up
+	maxAttempts = 8
+
+	// requestTimeout bounds a single delivery attempt
+	requestTimeout = 10 * time.Second
+)
+
+// Status is the delivery status of one endpoint, as shown in /status
+type Status struct {
+	URL         string     `json:"url"`
+	Queued      int        `json:"queued"`
+	Delivered   int        `json:"delivered"`
+	Failed      int        `json:"failed"`
+	Dropped     int        `json:"dropped"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+}
+
+// Dispatcher POSTs the events published on a peer list's event bus to
+// webhook endpoints. Each endpoint has its own bounded in-memory queue and
+// receives its events in order, one at a time. Failed deliveries are
+// retried with exponential backoff; events still queued when the agent
+// stops are lost.
+type Dispatcher struct {
+	endpoints []*endpoint
+	client    *http.Client
+
+	// backoff is the delay before the first retry; it doubles with every
+	// attempt up to maxBackoff
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// endpoint is an endpoint with its queue and delivery counters
+type endpoint struct {
+	Endpoint
+	queue chan peer.Event
+
+	mu     sync.Mutex
+	status Status
+}
+
+// NewDispatcher creates a dispatcher for the given endpoints. Endpoints
+// without a secret of their own sign with secret; if neither is set,
+// deliveries are not signed.
+func NewDispatcher(endpoints []Endpoint, secret string, queueSize int) *Dispatcher {
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		client:     &http.Client{Timeout: requestTimeout},
+		backoff:    time.Second,
+		maxBackoff: time.Minute,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	for _, e := range endpoints {
+		if e.Secret == "" {
+			e.Secret = secret
+		}
+		d.endpoints = append(d.endpoints, &endpoint{
+			Endpoint: e,
+			queue:    make(chan peer.Event, queueSize),
+			status:   Status{URL: e.URL},
+		})
+	}
+	return d
+}
+
+// Start starts delivering the membership events published on bus and the
+// user events published on users from now on
+func (d *Dispatcher) Start(bus, users *peer.EventBus) {
+	wantsUser := false
+	for _, e := range d.endpoints {
+		wantsUser = wantsUser || e.Handles(peer.EventUser)
+		d.wg.Add(1)
+		go d.deliverLoop(e)
+	}
+
+	d.follow(bus)
+	if wantsUser && users != nil {
+		d.follow(users)
+	}
+}
+
+// follow queues the events published on bus from now on
+func (d *Dispatcher) follow(bus *peer.EventBus) {
+	lastID := bus.LastID()
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		bus.Follow(d.ctx, lastID, d.enqueue)
+	}()
+}
+
+// Stop stops delivering events and abandons retries in progress
+func (d *Dispatcher) Stop() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+// Status returns the delivery status of every endpoint
+func (d *Dispatcher) Status() []Status {
+	statuses := make([]Status, 0, len(d.endpoints))
+	for _, e := range d.endpoints {
+		e.mu.Lock()
+		status := e.status
+		e.mu.Unlock()
+		status.Queued = len(e.queue)
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// enqueue queues an event for every endpoint that receives it
+func (d *Dispatcher) enqueue(event peer.Event) {
+	for _, e := range d.endpoints {
+		if e.Handles(event.Type) {
+			e.push(event)
+		}
+	}
+}
+
+// deliverLoop delivers the events queued for an endpoint until the
+// dispatcher stops
+func (d *Dispatcher) deliverLoop(e *endpoint) {
+	defer d.wg.Done()
+	for {
+		select {
+		case event := <-e.queue:
+			d.deliver(e, event)
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+// deliver POSTs an event to an endpoint, retrying with exponential backoff
+// until it is accepted, rejected outright, or out of attempts
+func (d *Dispatcher) deliver(e *endpoint, event peer.Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode event %d for webhook %s: %v", event.ID, e.URL, err)
+		return
+	}
+
+	backoff := d.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := d.post(e, event, body)
+		if err == nil {
+			e.record(func(s *Status) {
+				s.Delivered++
+				now := time.Now().UTC()
+				s.LastSuccess = &now
+			})
+			return
+		}
+
+		e.record(func(s *Status) { s.LastError = err.Error() })
+		if !retry || attempt == maxAttempts {
+			log.Printf("Giving up on delivering event %d to webhook %s after %d attempts: %v", event.ID, e.URL, attempt, err)
+			e.record(func(s *Status) { s.Failed++ })
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-d.ctx.Done():
+			return
+		}
+		backoff *= 2
+		if backoff > d.maxBackoff {
+			backoff = d.maxBackoff
+		}
+	}
+}
+
+// post makes one delivery attempt and reports whether a failure is worth
+// retrying. Network errors, 429 and 5xx responses are; other 4xx responses
+// mean the endpoint won't take the event.
+func (d *Dispatcher) post(e *endpoint, event peer.Event, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(event.Type))
+	req.Header.Set(DeliveryHeader, deliveryID(event))
+	if e.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(e.Secret, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+}
+
+// push queues an event, dropping the oldest queued event if the queue is
+// full
+func (e *endpoint) push(event peer.Event) {
+	for {
+		select {
+		case e.queue <- event:
+			return
+		default:
+		}
+
+		select {
+		case dropped := <-e.queue:
+			log.Printf("Webhook queue for %s is full, dropping event %d", e.URL, dropped.ID)
+			e.record(func(s *Status) { s.Dropped++ })
+		default:
+		}
+	}
+}
+
+// record updates the endpoint's status
+func (e *endpoint) record(update func(s *Status)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	update(&e.status)
+}
+
+// deliveryID identifies an event to receivers. User events have their own
+// bus, so their bus IDs overlap with those of membership events; they are
+// identified by the user event's ID instead.
+func deliveryID(event peer.Event) string {
+	if event.Type == peer.EventUser && event.User != nil {
+		return event.User.ID
+	}
+	return strconv.FormatUint(event.ID, 10)
+}
@@ -0,0 +1,219 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+// delivery is a request received by a recording endpoint
+type delivery struct {
+	header http.Header
+	body   []byte
+}
+
+// recordingServer returns a server that records deliveries and answers
+// them with the given status codes in turn, then with 200
+func recordingServer(t *testing.T, codes ...int) (*httptest.Server, func() []delivery) {
+	t.Helper()
+	var mu sync.Mutex
+	var deliveries []delivery
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		deliveries = append(deliveries, delivery{header: r.Header.Clone(), body: body})
+		code := http.StatusOK
+		if len(deliveries) <= len(codes) {
+			code = codes[len(deliveries)-1]
+		}
+		mu.Unlock()
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []delivery {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]delivery(nil), deliveries...)
+	}
+}
+
+// waitForStatus waits until the first endpoint's status satisfies done
+func waitForStatus(t *testing.T, d *Dispatcher, done func(s Status) bool) Status {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if status := d.Status()[0]; done(status) {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for webhook status, got %+v", d.Status()[0])
+	return Status{}
+}
+
+func TestDispatcher(t *testing.T) {
+	server, deliveries := recordingServer(t)
+	bus := peer.NewEventBus(peer.DefaultEventHistory)
+	d := NewDispatcher([]Endpoint{{URL: server.URL, Events: []peer.EventType{peer.EventJoin}}}, "secret", 0)
+	d.Start(bus, nil)
+	defer d.Stop()
+
+	bus.Publish(peer.EventJoin, &peer.Peer{ID: "peer1"})
+	bus.Publish(peer.EventSuspect, &peer.Peer{ID: "peer1"})
+	bus.Publish(peer.EventJoin, &peer.Peer{ID: "peer2"})
+
+	status := waitForStatus(t, d, func(s Status) bool { return s.Delivered == 2 })
+	if status.URL != server.URL || status.Failed != 0 || status.LastSuccess == nil {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+
+	got := deliveries()
+	if len(got) != 2 {
+		t.Fatalf("Expected only the joins to be delivered, got %d deliveries", len(got))
+	}
+	for i, want := range []string{"1", "3"} {
+		if id := got[i].header.Get(DeliveryHeader); id != want {
+			t.Errorf("Expected delivery %s, got %s", want, id)
+		}
+	}
+
+	first := got[0]
+	if first.header.Get(EventHeader) != string(peer.EventJoin) {
+		t.Errorf("Expected %s header %s, got %q", EventHeader, peer.EventJoin, first.header.Get(EventHeader))
+	}
+	if sig := first.header.Get(SignatureHeader); sig != Sign("secret", first.body) {
+		t.Errorf("Expected the body to be signed, got %q", sig)
+	}
+	var event peer.Event
+	if err := json.Unmarshal(first.body, &event); err != nil || event.ID != 1 || event.Peer.ID != "peer1" {
+		t.Errorf("Expected event 1 for peer1, got %s: %v", first.body, err)
+	}
+}
+
+func TestDispatcher_UserEvents(t *testing.T) {
+	all, allDeliveries := recordingServer(t)
+	user, userDeliveries := recordingServer(t)
+	bus := peer.NewEventBus(peer.DefaultEventHistory)
+	users := peer.NewEventBus(peer.DefaultEventHistory)
+	d := NewDispatcher([]Endpoint{
+		{URL: all.URL},
+		{URL: user.URL, Events: []peer.EventType{peer.EventUser}},
+	}, "", 0)
+	d.Start(bus, users)
+	defer d.Stop()
+
+	users.PublishUser(&peer.UserEvent{ID: "9f2c4e1a7b3d5c60", Name: "deploy"})
+	bus.Publish(peer.EventJoin, &peer.Peer{ID: "peer1"})
+
+	waitForStatus(t, d, func(s Status) bool { return s.Delivered == 1 })
+	deadline := time.Now().Add(time.Second)
+	for len(userDeliveries()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got := allDeliveries(); len(got) != 1 || got[0].header.Get(EventHeader) != string(peer.EventJoin) {
+		t.Errorf("Expected an endpoint without events to only get the join, got %d deliveries", len(got))
+	}
+	got := userDeliveries()
+	if len(got) != 1 || got[0].header.Get(EventHeader) != string(peer.EventUser) {
+		t.Fatalf("Expected the user event to reach the endpoint that lists it, got %d deliveries", len(got))
+	}
+	if id := got[0].header.Get(DeliveryHeader); id != "9f2c4e1a7b3d5c60" {
+		t.Errorf("Expected the user event's ID as the delivery, got %s", id)
+	}
+}
+
+func TestDispatcher_Unsigned(t *testing.T) {
+	server, deliveries := recordingServer(t)
+	bus := peer.NewEventBus(peer.DefaultEventHistory)
+	d := NewDispatcher([]Endpoint{{URL: server.URL}}, "", 0)
+	d.Start(bus, nil)
+	defer d.Stop()
+
+	bus.Publish(peer.EventJoin, &peer.Peer{ID: "peer1"})
+	waitForStatus(t, d, func(s Status) bool { return s.Delivered == 1 })
+
+	if sig := deliveries()[0].header.Get(SignatureHeader); sig != "" {
+		t.Errorf("Expected no signature without a secret, got %q", sig)
+	}
+}
+
+func TestDispatcher_Retries(t *testing.T) {
+	server, deliveries := recordingServer(t, http.StatusInternalServerError, http.StatusTooManyRequests)
+	bus := peer.NewEventBus(peer.DefaultEventHistory)
+	d := NewDispatcher([]Endpoint{{URL: server.URL}}, "", 0)
+	d.backoff = time.Millisecond
+	d.Start(bus, nil)
+	defer d.Stop()
+
+	bus.Publish(peer.EventJoin, &peer.Peer{ID: "peer1"})
+	status := waitForStatus(t, d, func(s Status) bool { return s.Delivered == 1 })
+
+	if len(deliveries()) != 3 {
+		t.Errorf("Expected 3 attempts, got %d", len(deliveries()))
+	}
+	if status.Failed != 0 || status.LastError == "" {
+		t.Errorf("Expected the last error to be kept after a retried delivery, got %+v", status)
+	}
+}
+
+func TestDispatcher_GivesUp(t *testing.T) {
+	t.Run("rejected", func(t *testing.T) {
+		server, deliveries := recordingServer(t, http.StatusBadRequest)
+		bus := peer.NewEventBus(peer.DefaultEventHistory)
+		d := NewDispatcher([]Endpoint{{URL: server.URL}}, "", 0)
+		d.backoff = time.Millisecond
+		d.Start(bus, nil)
+		defer d.Stop()
+
+		bus.Publish(peer.EventJoin, &peer.Peer{ID: "peer1"})
+		waitForStatus(t, d, func(s Status) bool { return s.Failed == 1 })
+
+		if len(deliveries()) != 1 {
+			t.Errorf("Expected a rejected delivery not to be retried, got %d attempts", len(deliveries()))
+		}
+	})
+
+	t.Run("out of attempts", func(t *testing.T) {
+		codes := make([]int, maxAttempts)
+		for i := range codes {
+			codes[i] = http.StatusServiceUnavailable
+		}
+		server, deliveries := recordingServer(t, codes...)
+		bus := peer.NewEventBus(peer.DefaultEventHistory)
+		d := NewDispatcher([]Endpoint{{URL: server.URL}}, "", 0)
+		d.backoff = time.Millisecond
+		d.Start(bus, nil)
+		defer d.Stop()
+
+		bus.Publish(peer.EventJoin, &peer.Peer{ID: "peer1"})
+		waitForStatus(t, d, func(s Status) bool { return s.Failed == 1 })
+
+		if len(deliveries()) != maxAttempts {
+			t.Errorf("Expected %d attempts, got %d", maxAttempts, len(deliveries()))
+		}
+	})
+}
+
+func TestDispatcher_QueueFull(t *testing.T) {
+	// Not started, so nothing leaves the queue
+	d := NewDispatcher([]Endpoint{{URL: "http://127.0.0.1:1/hooks"}}, "", 2)
+	for id := uint64(1); id <= 3; id++ {
+		d.enqueue(peer.Event{ID: id, Type: peer.EventJoin})
+	}
+
+	status := d.Status()[0]
+	if status.Queued != 2 || status.Dropped != 1 {
+		t.Errorf("Expected 2 queued and 1 dropped, got %+v", status)
+	}
+	if event := <-d.endpoints[0].queue; event.ID != 2 {
+		t.Errorf("Expected the oldest event to be dropped, got event %d first", event.ID)
+	}
+}
@@ -0,0 +1,83 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+// Headers set on every delivery
+const (
+	// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the body,
+	// keyed with the endpoint's secret. It is only set when there is one.
+	SignatureHeader = "X-Clip-Signature"
+	// EventHeader carries the event type
+	EventHeader = "X-Clip-Event"
+	// DeliveryHeader carries the event ID, which stays the same across
+	// retries so receivers can drop duplicates
+	DeliveryHeader = "X-Clip-Delivery"
+)
+
+// Endpoint is an HTTP endpoint that membership events are POSTed to. It
+// receives the listed event types, or all membership events if none are
+// listed; user events only reach endpoints that list them.
+type Endpoint struct {
+	URL    string           `json:"url"`
+	Secret string           `json:"secret,omitempty"`
+	Events []peer.EventType `json:"events,omitempty"`
+}
+
+// Validate checks that the endpoint has an HTTP URL and known event types
+func (e Endpoint) Validate() error {
+	u, err := url.Parse(e.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook must be an http:// or https:// URL, got %q", e.URL)
+	}
+	for _, t := range e.Events {
+		if !t.Valid() {
+			return fmt.Errorf("unknown event type for webhook %s: %s", e.URL, t)
+		}
+	}
+	return nil
+}
+
+// Handles reports whether the endpoint receives events of the given type
+func (e Endpoint) Handles(t peer.EventType) bool {
+	if len(e.Events) == 0 {
+		return t != peer.EventUser
+	}
+	for _, handled := range e.Events {
+		if handled == t {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseEndpoint parses an endpoint given as [type[,type...]=]url, e.g.
+// "member-join,member-leave=https://lb.example.com/hooks/clip"
+func ParseEndpoint(s string) (Endpoint, error) {
+	endpoint := Endpoint{URL: s}
+	if types, rest, ok := strings.Cut(s, "="); ok && !strings.Contains(types, "://") {
+		for _, t := range strings.Split(types, ",") {
+			endpoint.Events = append(endpoint.Events, peer.EventType(strings.TrimSpace(t)))
+		}
+		endpoint.URL = rest
+	}
+	if err := endpoint.Validate(); err != nil {
+		return Endpoint{}, err
+	}
+	return endpoint, nil
+}
+
+// Sign returns the signature of body for the SignatureHeader
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
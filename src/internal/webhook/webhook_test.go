@@ -0,0 +1,58 @@
+package webhook
+
+import (
+	"testing"
+
+	"github.com/rokzabukovec/clip/internal/peer"
+)
+
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		input   string
+		url     string
+		events  int
+		wantErr bool
+	}{
+		{"https://lb.example.com/hooks/clip", "https://lb.example.com/hooks/clip", 0, false},
+		{"http://lb.example.com/hooks?token=abc", "http://lb.example.com/hooks?token=abc", 0, false},
+		{"member-join,member-leave=https://lb.example.com/hooks", "https://lb.example.com/hooks", 2, false},
+		{"member-reap=https://lb.example.com/hooks", "", 0, true},
+		{"lb.example.com/hooks", "", 0, true},
+		{"ftp://lb.example.com/hooks", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			e, err := ParseEndpoint(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseEndpoint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (e.URL != tt.url || len(e.Events) != tt.events) {
+				t.Errorf("Expected %s with %d events, got %+v", tt.url, tt.events, e)
+			}
+		})
+	}
+}
+
+func TestEndpoint_Handles(t *testing.T) {
+	all := Endpoint{URL: "http://localhost/hooks"}
+	joins := Endpoint{URL: "http://localhost/hooks", Events: []peer.EventType{peer.EventJoin}}
+
+	if !all.Handles(peer.EventFailed) {
+		t.Error("Expected an endpoint without events to receive all membership events")
+	}
+	if all.Handles(peer.EventUser) {
+		t.Error("Expected an endpoint without events not to receive user events")
+	}
+	if !joins.Handles(peer.EventJoin) || joins.Handles(peer.EventFailed) {
+		t.Error("Expected an endpoint to receive only its own events")
+	}
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '{"id":1}' | openssl dgst -sha256 -hmac secret
+	want := "sha256=03def589620c813f198fd03d7967e292b163ef0435ebf43071ce0e9519763cb7"
+	if got := Sign("secret", []byte(`{"id":1}`)); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}